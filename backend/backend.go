@@ -18,16 +18,17 @@
 package backend
 
 import (
+	"context"
+	"github.com/kagucho/tsubonesystem3/backend/config"
 	"github.com/kagucho/tsubonesystem3/backend/db"
 	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0"
 	"github.com/kagucho/tsubonesystem3/backend/handler/file"
 	"github.com/kagucho/tsubonesystem3/backend/handler/private"
 	"github.com/kagucho/tsubonesystem3/backend/mail"
+	"github.com/kagucho/tsubonesystem3/backend/share"
 	"github.com/kagucho/tsubonesystem3/unchunked"
-	"github.com/kardianos/osext"
 	"log"
 	"net/http"
-	"path/filepath"
 )
 
 // Backend is the structure to hold the context of the backend.
@@ -35,26 +36,31 @@ type Backend struct {
 	unchunked.Unchunked
 	apiv0 apiv0.APIv0
 	db    db.DB
-}
 
-var share string
+	// config is the configuration given to backend.New.
+	config config.Config
 
-func init() {
-	executable, err := osext.ExecutableFolder()
-	if err != nil {
-		log.Panic(err)
-	}
+	drain *drain
 
-	share = filepath.Join(executable, `../share/tsubonesystem3`)
+	// The followings are replaced with Reload.
+	fileError file.Error
+	mail      mail.Mail
+	private   private.Private
+	file      file.File
 }
 
-// New returns a new backend.Backend.
-func New() (Backend, error) {
+// New returns a new backend.Backend with the given configuration.
+func New(config config.Config) (Backend, error) {
 	log.Print("TsuboneSystem3  Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>")
 	log.Print("This program comes with ABSOLUTELY NO WARRANTY.")
 	log.Print("This is free software, and you are welcome to redistribute it")
 	log.Print("under certain conditions; see `/license' for details.")
 
+	share, shareErr := share.New(config.Share)
+	if shareErr != nil {
+		return Backend{}, shareErr
+	}
+
 	fileError, fileErrorErr := file.NewError(share)
 	if fileErrorErr != nil {
 		return Backend{}, fileErrorErr
@@ -65,7 +71,7 @@ func New() (Backend, error) {
 		return Backend{}, mailErr
 	}
 
-	db, dbErr := db.New()
+	db, dbErr := db.New(config)
 	if dbErr != nil {
 		return Backend{}, dbErr
 	}
@@ -93,10 +99,104 @@ func New() (Backend, error) {
 	var mux http.ServeMux
 
 	mux.Handle(`/api/v0/`, http.StripPrefix(`/api/v0`, apiv0))
+	mux.Handle(`/api/v1/`, http.StripPrefix(`/api/v1`, apiv0.V1()))
+	mux.Handle(`/api/graphql`, http.StripPrefix(`/api`, apiv0.GraphQL()))
 	mux.Handle(`/private`, private)
 	mux.Handle(`/`, file)
 
-	return Backend{unchunked.New(mux.ServeHTTP), apiv0, db}, nil
+	return Backend{
+		unchunked.New(mux.ServeHTTP), apiv0, db, config,
+		&drain{stop: db.Events().Close},
+		fileError, mail, private, file,
+	}, nil
+}
+
+/*
+Reload parses the templates and the files in the share directory named by the
+given configuration again, and replaces the old ones only if all of them are
+parsed. The tokens and the connection to the database are kept.
+
+The share directory is the only reloadable item of the configuration; the
+changes of the other items are logged and ignored until restart.
+*/
+func (backend Backend) Reload(config config.Config) error {
+	for _, key := range config.Unreloadable(backend.config) {
+		log.Printf("%s changed; restart to apply", key)
+	}
+
+	share, err := share.New(config.Share)
+	if err != nil {
+		return err
+	}
+
+	fileError, err := file.NewError(share)
+	if err != nil {
+		return err
+	}
+
+	mail, err := mail.New(share)
+	if err != nil {
+		return err
+	}
+
+	private, err := private.New(share, backend.db, fileError)
+	if err != nil {
+		return err
+	}
+
+	backend.fileError.Replace(fileError)
+	backend.mail.Replace(mail)
+	backend.private.Replace(private)
+	backend.file.Replace(file.New(share, fileError))
+
+	return nil
+}
+
+/*
+ServeHTTP serves the request. It serves 503 Service Unavailable once Drain or
+Shutdown gets called.
+*/
+func (backend Backend) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !backend.drain.enter() {
+		/*
+			RFC 7230 - Hypertext Transfer Protocol (HTTP/1.1): Message Syntax and Routing
+			6.6.  Tear-down
+			https://tools.ietf.org/html/rfc7230#section-6.6
+		*/
+		writer.Header().Set(`Connection`, `close`)
+		backend.fileError.ServeError(writer, http.StatusServiceUnavailable)
+
+		return
+	}
+
+	defer backend.drain.leave()
+
+	backend.Unchunked.ServeHTTP(writer, request)
+}
+
+/*
+Drain stops serving requests and closes the event streams, but does not wait
+for the requests in flight. Register it with http.Server.RegisterOnShutdown so
+that http.Server.Shutdown does not wait for the streams until it times out.
+*/
+func (backend Backend) Drain() {
+	backend.drain.start()
+}
+
+/*
+Shutdown stops serving requests, closes the event streams, waits for the
+requests in flight, including the emails they are sending, until the given
+context is done, and then releases all resources with End. It returns the error
+of the context if it is done before the requests finish, but the resources are
+released anyway.
+*/
+func (backend Backend) Shutdown(ctx context.Context) error {
+	drainErr := backend.drain.wait(ctx)
+	if endErr := backend.End(); endErr != nil {
+		return endErr
+	}
+
+	return drainErr
 }
 
 /*
@@ -0,0 +1,249 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+/*
+Package config implements the runtime configuration.
+
+The configuration is read from a TOML file named by TSUBONESYSTEM_CONFIG
+environment variable if any, and then each item can be overridden by an
+environment variable whose name is TSUBONESYSTEM_ followed by the upper-cased
+key, for example TSUBONESYSTEM_DB_DSN for db_dsn. Items given by neither
+default to the constants in the configuration package.
+
+TOML: Tom's Obvious, Minimal Language
+https://toml.io/en/v1.0.0
+*/
+package config
+
+import (
+	"errors"
+	"github.com/BurntSushi/toml"
+	"github.com/go-sql-driver/mysql"
+	"github.com/kagucho/tsubonesystem3/configuration"
+	"github.com/kardianos/osext"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Config is a structure to hold the runtime configuration.
+type Config struct {
+	/*
+		DBDSN is the DSN which refers to the database. It should be
+		understood by go-sql-driver.
+		https://github.com/go-sql-driver/mysql#dsn-data-source-name
+	*/
+	DBDSN string `toml:"db_dsn"`
+
+	// DBPasswordKey is the key to encrypt passwords in the database.
+	DBPasswordKey string `toml:"db_password_key"`
+
+	/*
+		DBTimeout is the duration after which a query to the database
+		gets canceled. It is written as time.ParseDuration accepts, for
+		example "16s".
+	*/
+	DBTimeout time.Duration `toml:"db_timeout"`
+
+	/*
+		FcgiListenNet and FcgiListenAddress are the network and the
+		address which tsubonesystem3_fcgi command listens to. They should
+		be understood by net.Listen.
+	*/
+	FcgiListenNet     string `toml:"fcgi_listen_net"`
+	FcgiListenAddress string `toml:"fcgi_listen_address"`
+
+	// ListenAddress is the TCP address which tsubonesystem3 listens to.
+	ListenAddress string `toml:"listen_address"`
+
+	/*
+		ShutdownTimeout is the duration to wait for the requests in
+		flight when the frontends shut down. It defaults to
+		config.DefaultShutdownTimeout.
+	*/
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"`
+
+	/*
+		Share is the directory on the disk whose files override the
+		embedded share directory. It is optional.
+	*/
+	Share string `toml:"share"`
+}
+
+// Error is a structure to hold the error in an item of the configuration.
+type Error struct {
+	error
+	Key string
+}
+
+/*
+DefaultShutdownTimeout is the default of Config.ShutdownTimeout. It has no
+constant in the configuration package, which predates it.
+*/
+const DefaultShutdownTimeout = 30 * time.Second
+
+// EnvConfig is the name of the environment variable naming the file.
+const EnvConfig = `TSUBONESYSTEM_CONFIG`
+
+// envPrefix is the prefix of the environment variables overriding items.
+const envPrefix = `TSUBONESYSTEM_`
+
+var errEmpty = errors.New(`must not be empty`)
+var errNotPositive = errors.New(`must be positive`)
+var errUnknown = errors.New(`unknown key`)
+
+func (configError Error) Error() string {
+	return configError.Key + `: ` + configError.error.Error()
+}
+
+/*
+Default returns the configuration given by the constants in the configuration
+package. Share defaults to ../share/tsubonesystem3 relative to the executable,
+where the share directory used to be installed.
+*/
+func Default() Config {
+	config := Config{
+		DBDSN:             configuration.DBDSN,
+		DBPasswordKey:     configuration.DBPasswordKey,
+		DBTimeout:         configuration.DBTimeout,
+		FcgiListenNet:     configuration.FcgiListenNet,
+		FcgiListenAddress: configuration.FcgiListenAddress,
+		ListenAddress:     configuration.ListenAddress,
+		ShutdownTimeout:   DefaultShutdownTimeout,
+	}
+
+	if executable, err := osext.ExecutableFolder(); err == nil {
+		config.Share = filepath.Join(executable, `../share/tsubonesystem3`)
+	}
+
+	return config
+}
+
+/*
+Load returns the configuration read from the file named by
+TSUBONESYSTEM_CONFIG and the environment variables. It returns config.Error if
+an item is invalid or the file has an unknown key.
+*/
+func Load() (Config, error) {
+	return load(os.Getenv(EnvConfig), os.LookupEnv)
+}
+
+func load(file string, lookup func(string) (string, bool)) (Config, error) {
+	config := Default()
+
+	if file != `` {
+		metaData, err := toml.DecodeFile(file, &config)
+		if err != nil {
+			return Config{}, err
+		}
+
+		// A misspelled key would be silently ignored otherwise.
+		if undecoded := metaData.Undecoded(); len(undecoded) > 0 {
+			return Config{}, Error{errUnknown, undecoded[0].String()}
+		}
+	}
+
+	value := reflect.ValueOf(&config).Elem()
+	for index := 0; index < value.NumField(); index++ {
+		key := value.Type().Field(index).Tag.Get(`toml`)
+
+		text, present := lookup(envPrefix + strings.ToUpper(key))
+		if !present {
+			continue
+		}
+
+		if err := set(value.Field(index), text); err != nil {
+			return Config{}, Error{err, key}
+		}
+	}
+
+	return config, config.Validate()
+}
+
+func set(field reflect.Value, text string) error {
+	switch pointer := field.Addr().Interface().(type) {
+	case *time.Duration:
+		duration, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+
+		*pointer = duration
+
+	default:
+		field.SetString(text)
+	}
+
+	return nil
+}
+
+/*
+Unreloadable returns the keys of the items which differ from the running
+configuration but cannot be applied without restart. Only share is reloadable.
+*/
+func (config Config) Unreloadable(running Config) []string {
+	var keys []string
+
+	value := reflect.ValueOf(config)
+	runningValue := reflect.ValueOf(running)
+
+	for index := 0; index < value.NumField(); index++ {
+		key := value.Type().Field(index).Tag.Get(`toml`)
+		if key != `share` &&
+			value.Field(index).Interface() != runningValue.Field(index).Interface() {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Validate returns config.Error if an item of the configuration is invalid.
+func (config Config) Validate() error {
+	if _, err := mysql.ParseDSN(config.DBDSN); err != nil {
+		return Error{err, `db_dsn`}
+	}
+
+	if config.DBPasswordKey == `` {
+		return Error{errEmpty, `db_password_key`}
+	}
+
+	if config.DBTimeout <= 0 {
+		return Error{errNotPositive, `db_timeout`}
+	}
+
+	if config.ShutdownTimeout <= 0 {
+		return Error{errNotPositive, `shutdown_timeout`}
+	}
+
+	for _, item := range [...]struct {
+		key   string
+		value string
+	}{
+		{`fcgi_listen_net`, config.FcgiListenNet},
+		{`fcgi_listen_address`, config.FcgiListenAddress},
+		{`listen_address`, config.ListenAddress},
+	} {
+		if item.value == `` {
+			return Error{errEmpty, item.key}
+		}
+	}
+
+	return nil
+}
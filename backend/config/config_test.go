@@ -0,0 +1,171 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), `tsubonesystem3.toml`)
+	if err := ioutil.WriteFile(file, []byte(`db_dsn = "file@unix(/file.sock)/tsubonesystem"
+db_timeout = "8s"
+listen_address = "localhost:8001"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	unknown := filepath.Join(t.TempDir(), `unknown.toml`)
+	if err := ioutil.WriteFile(unknown, []byte(`db_timout = "8s"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := Default()
+
+	for _, test := range [...]struct {
+		description string
+		file        string
+		env         map[string]string
+		expected    func(*Config)
+		key         string
+	}{
+		{
+			`Default`, ``, nil, func(*Config) {}, ``,
+		},
+		{
+			`File`, file, nil,
+			func(config *Config) {
+				config.DBDSN = `file@unix(/file.sock)/tsubonesystem`
+				config.DBTimeout = 8 * time.Second
+				config.ListenAddress = `localhost:8001`
+			},
+			``,
+		},
+		{
+			`Environment`, file,
+			map[string]string{
+				`TSUBONESYSTEM_DB_TIMEOUT`: `4s`,
+				`TSUBONESYSTEM_SHARE`:      `/share`,
+			},
+			func(config *Config) {
+				config.DBDSN = `file@unix(/file.sock)/tsubonesystem`
+				config.DBTimeout = 4 * time.Second
+				config.ListenAddress = `localhost:8001`
+				config.Share = `/share`
+			},
+			``,
+		},
+		{
+			`UnknownKey`, unknown, nil, nil, `db_timout`,
+		},
+		{
+			`InvalidDuration`, ``,
+			map[string]string{`TSUBONESYSTEM_DB_TIMEOUT`: `forever`},
+			nil, `db_timeout`,
+		},
+		{
+			`NotPositiveDuration`, ``,
+			map[string]string{`TSUBONESYSTEM_DB_TIMEOUT`: `0s`},
+			nil, `db_timeout`,
+		},
+		{
+			`InvalidDSN`, ``,
+			map[string]string{`TSUBONESYSTEM_DB_DSN`: `invalid`},
+			nil, `db_dsn`,
+		},
+		{
+			`NotPositiveShutdownTimeout`, ``,
+			map[string]string{`TSUBONESYSTEM_SHUTDOWN_TIMEOUT`: `-1s`},
+			nil, `shutdown_timeout`,
+		},
+		{
+			`EmptyPasswordKey`, ``,
+			map[string]string{`TSUBONESYSTEM_DB_PASSWORD_KEY`: ``},
+			nil, `db_password_key`,
+		},
+		{
+			`EmptyListenAddress`, ``,
+			map[string]string{`TSUBONESYSTEM_LISTEN_ADDRESS`: ``},
+			nil, `listen_address`,
+		},
+	} {
+		test := test
+
+		t.Run(test.description, func(t *testing.T) {
+			t.Parallel()
+
+			config, err := load(test.file, func(key string) (string, bool) {
+				value, present := test.env[key]
+				return value, present
+			})
+
+			if test.key != `` {
+				if configErr, ok := err.(Error); !ok || configErr.Key != test.key {
+					t.Errorf(`expected config.Error with key %q, got %v`,
+						test.key, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := defaults
+			test.expected(&expected)
+			if config != expected {
+				t.Errorf(`expected %+v, got %+v`, expected, config)
+			}
+		})
+	}
+
+	t.Run(`AbsentFile`, func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := load(filepath.Join(t.TempDir(), `absent`),
+			func(string) (string, bool) { return ``, false }); err == nil {
+			t.Error(`expected an error, got nil`)
+		}
+	})
+}
+
+func TestUnreloadable(t *testing.T) {
+	t.Parallel()
+
+	running := Default()
+
+	changed := running
+	changed.Share = `/share`
+	if result := changed.Unreloadable(running); len(result) != 0 {
+		t.Errorf(`expected none, got %v`, result)
+	}
+
+	changed.DBTimeout++
+	changed.ListenAddress += `0`
+	result := changed.Unreloadable(running)
+	if len(result) != 2 || result[0] != `db_timeout` || result[1] != `listen_address` {
+		t.Errorf(`expected [db_timeout listen_address], got %v`, result)
+	}
+}
@@ -0,0 +1,280 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-sql-driver/mysql"
+	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"log"
+	"strconv"
+	"time"
+)
+
+/*
+AuditHash is a SHA-256 hash chaining db.AuditEntry. It is marshalled as a
+hexadecimal string, or null if it is empty.
+*/
+type AuditHash []byte
+
+// auditAttempts is the number of attempts to insert an entry of the audit log.
+const auditAttempts = 3
+
+/*
+AuditEntry is a structure representing a request recorded in the audit log.
+
+Hash is calculated from the other fields and Previous, which is Hash of the
+entry recorded just before. Therefore removing or modifying an entry breaks the
+chain of the following entries.
+*/
+type AuditEntry struct {
+	Actor    encoding.ZeroString `json:"actor"`
+	Address  string              `json:"address"`
+	Date     encoding.Time       `json:"date"`
+	Hash     AuditHash           `json:"hash"`
+	ID       uint32              `json:"id"`
+	Method   string              `json:"method"`
+	Previous AuditHash           `json:"previous"`
+	Route    string              `json:"route"`
+	Scope    encoding.ZeroString `json:"scope"`
+	Status   uint16              `json:"status"`
+	Target   encoding.ZeroString `json:"target"`
+}
+
+// AuditEntryResult is a structure representing a result of querying db.AuditEntry.
+type AuditEntryResult struct {
+	AuditEntry
+	Error error
+}
+
+// AuditEntryChan is a reciever of db.AuditEntryResult.
+type AuditEntryChan <-chan AuditEntryResult
+
+/*
+AuditFilter is a structure describing the entries to query. Zero values match
+any entry.
+*/
+type AuditFilter struct {
+	Actor string
+	Route string
+	Since time.Time
+	Until time.Time
+}
+
+/*
+MarshalJSON returns the JSON encoding of the hash.
+
+This implements an interface used in encoding/encoding.
+
+json - The Go Programming Language
+Example (CustomMarshalJSON)
+https://golang.org/pkg/encoding/json/#example__customMarshalJSON
+*/
+func (hash AuditHash) MarshalJSON() ([]byte, error) {
+	if len(hash) <= 0 {
+		return []byte(`null`), nil
+	}
+
+	return json.Marshal(hex.EncodeToString(hash))
+}
+
+/*
+MarshalJSON returns the JSON encoding of the remaining entries and closes the
+channel.
+
+This implements an interface used in encoding/encoding.
+
+json - The Go Programming Language
+Example (CustomMarshalJSON)
+https://golang.org/pkg/encoding/json/#example__customMarshalJSON
+*/
+func (entryChan AuditEntryChan) MarshalJSON() ([]byte, error) {
+	return encoding.MarshalJSONArray(func() (interface{}, error, bool) {
+		result, present := <-entryChan
+		return result.AuditEntry, result.Error, present
+	})
+}
+
+/*
+Calculate returns the hash of the entry chained to the given previous hash. It
+does not use Hash and Previous of the entry.
+*/
+func (entry AuditEntry) Calculate(previous AuditHash) AuditHash {
+	hash := sha256.New()
+	hash.Write(previous)
+
+	for _, field := range [...]string{
+		strconv.FormatInt(entry.Date.Unix(), 10),
+		string(entry.Actor),
+		string(entry.Scope),
+		entry.Method,
+		entry.Route,
+		string(entry.Target),
+		strconv.FormatUint(uint64(entry.Status), 10),
+		entry.Address,
+	} {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(field)))
+		hash.Write(length[:])
+		hash.Write([]byte(field))
+	}
+
+	return hash.Sum(nil)
+}
+
+/*
+Verify returns whether the entry is chained to the given previous hash and its
+hash is correct.
+*/
+func (entry AuditEntry) Verify(previous AuditHash) bool {
+	return bytes.Equal(entry.Previous, previous) &&
+		bytes.Equal(entry.Hash, entry.Calculate(previous))
+}
+
+/*
+InsertAudit records the given entry in the audit log, chaining it to the last
+recorded entry. ID, Hash and Previous of the given entry are ignored, and Date
+is truncated to seconds.
+
+The row of audit_lock is locked so that concurrent entries are chained one by
+one. The insertion will be retried if the database still detects a deadlock.
+*/
+func (db DB) InsertAudit(entry AuditEntry) error {
+	entry.Date = encoding.NewTime(entry.Date.Truncate(time.Second))
+
+	for attempt := 1; ; attempt++ {
+		err := db.transact(func(tx *sql.Tx) error {
+			var lock uint8
+			entry.Previous = nil
+
+			if err := tx.Stmt(db.stmts[stmtLockAudit]).QueryRow().Scan(&lock); err != nil {
+				return err
+			}
+
+			if err := tx.Stmt(db.stmts[stmtSelectAuditLastHash]).QueryRow().Scan((*[]byte)(&entry.Previous)); err != nil && err != sql.ErrNoRows {
+				return err
+			}
+
+			_, err := tx.Stmt(db.stmts[stmtInsertAudit]).Exec(
+				entry.Date.Time.Time, sql.NullString{
+					String: string(entry.Actor),
+					Valid:  entry.Actor != ``,
+				}, string(entry.Scope), entry.Method, entry.Route,
+				string(entry.Target), entry.Status, entry.Address,
+				[]byte(entry.Previous), []byte(entry.Calculate(entry.Previous)))
+
+			return err
+		})
+
+		// A transaction bound to db.DB belongs to the caller; never retry it.
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok &&
+			mysqlErr.Number == erLockDeadlock &&
+			attempt < auditAttempts && db.tx == nil {
+			continue
+		}
+
+		return err
+	}
+}
+
+/*
+QueryAudit returns db.AuditEntryChan representing the entries matching the
+given filter, in the order they were recorded.
+
+Resources will be holded until the channel gets closed or the given
+context gets done.
+*/
+func (db DB) QueryAudit(ctx context.Context, filter AuditFilter) AuditEntryChan {
+	resultChan := make(chan AuditEntryResult)
+	ctx, cancel := db.withTimeout(ctx)
+
+	go func() {
+		defer func() {
+			close(resultChan)
+			cancel()
+		}()
+
+		var since interface{}
+		var until interface{}
+
+		if !filter.Since.IsZero() {
+			since = filter.Since
+		}
+
+		if !filter.Until.IsZero() {
+			until = filter.Until
+		}
+
+		rows, err := db.stmt(stmtSelectAudit).QueryContext(ctx,
+			filter.Actor, filter.Actor, filter.Route, filter.Route,
+			since, since, until, until)
+		if err != nil {
+			select {
+			case resultChan <- AuditEntryResult{Error: err}:
+			case <-ctx.Done():
+			}
+
+			return
+		}
+
+		defer func() {
+			if err := rows.Close(); err != nil {
+				log.Print(err)
+			}
+		}()
+
+		for rows.Next() {
+			var actor sql.NullString
+			var date mysql.NullTime
+			var result AuditEntryResult
+
+			result.Error = rows.Scan(&result.ID, &actor, &result.Address,
+				&date, (*[]byte)(&result.Hash), &result.Method,
+				(*[]byte)(&result.Previous), &result.Route,
+				(*string)(&result.Scope), &result.Status,
+				(*string)(&result.Target))
+			result.Actor = encoding.ZeroString(actor.String)
+			result.Date = encoding.NewTime(date.Time)
+
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			if result.Error != nil {
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			select {
+			case resultChan <- AuditEntryResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return resultChan
+}
@@ -22,6 +22,7 @@ import (
 	"database/sql"
 	"github.com/go-sql-driver/mysql"
 	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"github.com/kagucho/tsubonesystem3/backend/event"
 	"log"
 )
 
@@ -34,6 +35,7 @@ type ClubCommon struct {
 type Club struct {
 	ClubCommon
 	Members ClubMemberChan `json:"members"`
+	Version Version        `json:"-"`
 }
 
 type ClubEntryCommon struct {
@@ -49,11 +51,6 @@ type ClubEntry struct {
 // ClubEntryChan is a reciever of db.ClubEntry.
 type ClubEntryChan <-chan ClubEntry
 
-type clubEntry struct {
-	ClubEntryCommon
-	members *[]string `json:"members"`
-}
-
 /*
 ClubMemberResult is a structure holding a result of querying the members
 belonging to a club.
@@ -99,31 +96,35 @@ func (memberChan ClubMemberChan) MarshalJSON() ([]byte, error) {
 }
 
 /*
-DeleteClub deletes a club identified by the given ID.
+DeleteClub deletes a club identified by the given ID and having the given
+version on behalf of the given operator.
 
-It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
-tell db.DB is bad.
+It returns db.ErrIncorrectIdentity if the given ID is incorrect, and
+db.ErrVersionMismatch if the club has another version. Other errors tell db.DB
+is bad.
 */
-func (db DB) DeleteClub(id string) error {
-	result, execErr := db.stmts[stmtDeleteClub].Exec(id)
-	if execErr != nil {
-		return execErr
-	}
+func (db DB) DeleteClub(operator, id string, version Precondition) error {
+	return db.publish(newEvent(event.ClubDeleted, id, nil), db.transact(func(tx *sql.Tx) error {
+		if err := db.checkVersion(tx, versionClub, id, version); err != nil {
+			return err
+		}
 
-	affected, affectedErr := result.RowsAffected()
-	if affectedErr != nil {
-		return affectedErr
-	}
+		old, err := db.snapshotClub(tx, id)
+		if err != nil {
+			return err
+		}
 
-	if affected <= 0 {
-		return ErrIncorrectIdentity
-	}
+		if _, err := tx.Stmt(db.stmts[stmtDeleteClub]).Exec(id); err != nil {
+			return err
+		}
 
-	return nil
+		return db.recordHistory(tx, operator, HistoryClub, id, old, nil)
+	}))
 }
 
 /*
-InsertClub inserts a club with the given properties.
+InsertClub inserts a club with the given properties on behalf of the given
+operator.
 
 It may return one of the following errors:
 db.ErrBadOmission tells the ID or name is omitted.
@@ -133,7 +134,7 @@ db.ErrInvalid tells some of the given properties is invalid.
 
 Other errors tell db.DB is bad.
 */
-func (db DB) InsertClub(id, name, chief string) error {
+func (db DB) InsertClub(operator, id, name, chief string) error {
 	if id == `` || name == `` {
 		return ErrBadOmission
 	}
@@ -142,26 +143,29 @@ func (db DB) InsertClub(id, name, chief string) error {
 		return ErrInvalid
 	}
 
-	_, err := db.stmts[stmtInsertClub].Exec(id, name, chief)
-
-	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-		switch mysqlErr.Number {
-		case erDupEntry:
-			return ErrDupEntry
+	return db.publish(newEvent(event.ClubCreated, id, nil), db.transact(func(tx *sql.Tx) error {
+		result, err := tx.Stmt(db.stmts[stmtInsertClub]).Exec(id, name, chief)
+		if err != nil {
+			return convertMySQLError(err)
+		}
 
-		case erDataTooLong:
-			fallthrough
-		case erTruncatedWrongValueForField:
-			return ErrInvalid
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
 
-		case erNoReferencedRow:
-			fallthrough
-		case erNoReferencedRow2:
+		// INSERT ... SELECT inserts nothing if the chief is missing.
+		if affected <= 0 {
 			return ErrIncorrectIdentity
 		}
-	}
 
-	return err
+		current, err := db.snapshotClub(tx, id)
+		if err != nil {
+			return err
+		}
+
+		return db.recordHistory(tx, operator, HistoryClub, id, nil, current)
+	}))
 }
 
 /*
@@ -170,26 +174,25 @@ QueryClub returns db.Club corresponding with the given ID.
 It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
 tell db.DB is bad.
 
-Resources will be holded until Members gets closed.
+Resources will be holded until Members gets closed or the given
+context gets done.
 */
-func (db DB) QueryClub(id string) (Club, error) {
+func (db DB) QueryClub(ctx context.Context, id string) (Club, error) {
 	var clubID uint8
 	var club Club
 
-	tx, err := db.sql.BeginTx(context.Background(),
-		&sql.TxOptions{
-			Isolation: sql.LevelSerializable,
-			ReadOnly:  true,
-		})
+	ctx, cancel := db.withTimeout(ctx)
+
+	tx, err := db.beginReadOnly(ctx)
 	if err != nil {
+		cancel()
 		return club, err
 	}
 
-	if err := tx.Stmt(db.stmts[stmtSelectClubByID]).QueryRow(id).Scan(
-		&clubID, &club.Name, &club.Chief); err != nil {
-		if err := tx.Commit(); err != nil {
-			log.Print(err)
-		}
+	if err := tx.Stmt(db.stmts[stmtSelectClubByID]).QueryRowContext(ctx, id).Scan(
+		&clubID, &club.Name, &club.Chief, &club.Version); err != nil {
+		db.endReadOnly(tx)
+		cancel()
 
 		if err == sql.ErrNoRows {
 			err = ErrIncorrectIdentity
@@ -203,15 +206,17 @@ func (db DB) QueryClub(id string) (Club, error) {
 	go func() {
 		defer func() {
 			close(members)
-
-			if err := tx.Commit(); err != nil {
-				log.Print(err)
-			}
+			db.endReadOnly(tx)
+			cancel()
 		}()
 
-		rows, err := tx.Stmt(db.stmts[stmtSelectMemberIDsByInternalClub]).Query(clubID)
+		rows, err := tx.Stmt(db.stmts[stmtSelectMemberIDsByInternalClub]).QueryContext(ctx, clubID)
 		if err != nil {
-			members <- ClubMemberResult{Error: err}
+			select {
+			case members <- ClubMemberResult{Error: err}:
+			case <-ctx.Done():
+			}
+
 			return
 		}
 
@@ -221,11 +226,23 @@ func (db DB) QueryClub(id string) (Club, error) {
 			var result ClubMemberResult
 			result.Error = rows.Scan(&result.ID)
 
-			members <- result
+			select {
+			case members <- result:
+			case <-ctx.Done():
+				return
+			}
+
 			if result.Error != nil {
 				return
 			}
 		}
+
+		if err := rows.Err(); err != nil {
+			select {
+			case members <- ClubMemberResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
 	}()
 
 	club.Members = members
@@ -239,9 +256,12 @@ QueryClubName returns the name of the club identified with the given ID.
 It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
 tell db.DB is bad.
 */
-func (db DB) QueryClubName(id string) (string, error) {
+func (db DB) QueryClubName(ctx context.Context, id string) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var name string
-	err := db.stmts[stmtSelectClubNameByID].QueryRow(id).Scan(&name)
+	err := db.stmt(stmtSelectClubNameByID).QueryRowContext(ctx, id).Scan(&name)
 	if err == sql.ErrNoRows {
 		err = ErrIncorrectIdentity
 	}
@@ -250,32 +270,32 @@ func (db DB) QueryClubName(id string) (string, error) {
 }
 
 /*
-QueryClubs returns db.ClubChan which represents all the clubs.
+QueryClubs returns db.ClubChan which represents the clubs in the order of their
+IDs. The clubs follow the given ID, which may be empty to start from the first,
+and are limited to the given number, which may be negative for no limit.
 
 A result recieved from the returned channel tells an error if db.DB is bad.
 
-Resources will be holded until the channel gets closed.
+Resources will be holded until the channel gets closed or the given
+context gets done.
 */
-func (db DB) QueryClubs() (ClubEntryChan, error) {
-	tx, txErr := db.sql.BeginTx(context.Background(),
-		&sql.TxOptions{
-			Isolation: sql.LevelSerializable,
-			ReadOnly:  true,
-		})
+func (db DB) QueryClubs(ctx context.Context, after string, limit int) (ClubEntryChan, error) {
+	queryCtx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	tx, txErr := db.beginReadOnly(queryCtx)
 	if txErr != nil {
 		return nil, txErr
 	}
 
-	defer func() {
-		if commitErr := tx.Commit(); commitErr != nil {
-			log.Print(commitErr)
-		}
-	}()
+	defer db.endReadOnly(tx)
 
-	entries := make(map[uint8]clubEntry)
+	var entries []ClubEntry
+	indices := make(map[uint8]int)
 
 	clubsErr := func() error {
-		rows, queryErr := db.stmts[stmtSelectClubs].Query()
+		rows, queryErr := tx.Stmt(db.stmts[stmtSelectClubs]).QueryContext(queryCtx,
+			after, sqlLimit(limit))
 		if queryErr != nil {
 			return queryErr
 		}
@@ -288,8 +308,7 @@ func (db DB) QueryClubs() (ClubEntryChan, error) {
 
 		for rows.Next() {
 			var dbID uint8
-			var entry ClubEntryCommon
-			var members []string
+			var entry ClubEntry
 
 			scanErr := rows.Scan(&dbID,
 				&entry.ID, &entry.Name, &entry.Chief)
@@ -297,17 +316,18 @@ func (db DB) QueryClubs() (ClubEntryChan, error) {
 				return scanErr
 			}
 
-			entries[dbID] = clubEntry{entry, &members}
+			indices[dbID] = len(entries)
+			entries = append(entries, entry)
 		}
 
-		return nil
+		return rows.Err()
 	}()
 	if clubsErr != nil {
 		return nil, clubsErr
 	}
 
 	membersErr := func() error {
-		rows, queryErr := db.stmts[stmtSelectClubInternalIDMemberID].Query()
+		rows, queryErr := tx.Stmt(db.stmts[stmtSelectClubInternalIDMemberID]).QueryContext(queryCtx)
 		if queryErr != nil {
 			return queryErr
 		}
@@ -327,11 +347,13 @@ func (db DB) QueryClubs() (ClubEntryChan, error) {
 				return scanErr
 			}
 
-			members := entries[dbID].members
-			*members = append(*members, memberID)
+			// The members of the clubs out of the page are ignored.
+			if index, present := indices[dbID]; present {
+				entries[index].Members = append(entries[index].Members, memberID)
+			}
 		}
 
-		return nil
+		return rows.Err()
 	}()
 	if membersErr != nil {
 		return nil, membersErr
@@ -343,9 +365,10 @@ func (db DB) QueryClubs() (ClubEntryChan, error) {
 		defer close(entryChan)
 
 		for _, entry := range entries {
-			entryChan <- ClubEntry{
-				entry.ClubEntryCommon,
-				*entry.members,
+			select {
+			case entryChan <- entry:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -354,17 +377,18 @@ func (db DB) QueryClubs() (ClubEntryChan, error) {
 }
 
 /*
-UpdateClub updates the club identified with the given ID, with the given
-properties.
+UpdateClub updates the club identified with the given ID and having the given
+version, with the given properties on behalf of the given operator.
 
 It may return one of the following errors:
 db.ErrIncorrectIdentity tells the given ID of the club or the one of the chief
 is incorrect.
 db.ErrInvalid tells some of the given properties is invalid.
+db.ErrVersionMismatch tells the club has another version.
 
 Other errors tell db.DB is bad.
 */
-func (db DB) UpdateClub(id, name, chief string) error {
+func (db DB) UpdateClub(operator, id, name, chief string, version Precondition) error {
 	arguments := append(make([]interface{}, 0, 3), sql.Named(`id`, id))
 
 	if name != `` {
@@ -375,33 +399,44 @@ func (db DB) UpdateClub(id, name, chief string) error {
 		arguments = append(arguments, sql.Named(`chief`, chief))
 	}
 
-	result, execErr := db.stmts[stmtUpdateClub].Exec(arguments...)
-	if execErr != nil {
-		if mysqlErr, ok := execErr.(*mysql.MySQLError); ok {
-			switch mysqlErr.Number {
-			case erDataTooLong:
-				fallthrough
-			case erTruncatedWrongValueForField:
-				return ErrInvalid
-
-			case erNoReferencedRow:
-				fallthrough
-			case erNoReferencedRow2:
-				return ErrIncorrectIdentity
-			}
+	return db.publish(newEvent(event.ClubUpdated, id, nil), db.transact(func(tx *sql.Tx) error {
+		if err := db.checkVersion(tx, versionClub, id, version); err != nil {
+			return err
 		}
 
-		return execErr
-	}
-
-	affected, affectedErr := result.RowsAffected()
-	if affectedErr != nil {
-		return affectedErr
-	}
-
-	if affected <= 0 {
-		return ErrIncorrectIdentity
-	}
-
-	return nil
+		return db.recordChange(tx, operator, HistoryClub, id,
+			func() (snapshot, error) {
+				return db.snapshotClub(tx, id)
+			}, func() error {
+				result, execErr := tx.Stmt(db.stmts[stmtUpdateClub]).Exec(arguments...)
+				if execErr != nil {
+					if mysqlErr, ok := execErr.(*mysql.MySQLError); ok {
+						switch mysqlErr.Number {
+						case erDataTooLong:
+							fallthrough
+						case erTruncatedWrongValueForField:
+							return ErrInvalid
+
+						case erNoReferencedRow:
+							fallthrough
+						case erNoReferencedRow2:
+							return ErrIncorrectIdentity
+						}
+					}
+
+					return execErr
+				}
+
+				affected, affectedErr := result.RowsAffected()
+				if affectedErr != nil {
+					return affectedErr
+				}
+
+				if affected <= 0 {
+					return ErrIncorrectIdentity
+				}
+
+				return nil
+			})
+	}))
 }
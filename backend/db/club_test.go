@@ -18,6 +18,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"testing"
 )
@@ -26,7 +27,7 @@ func (db DB) testQueryClub(t *testing.T) {
 	t.Run(`valid`, func(t *testing.T) {
 		t.Parallel()
 
-		detail, queryErr := db.QueryClub(`prog`)
+		detail, queryErr := db.QueryClub(context.Background(), `prog`)
 		if queryErr != nil {
 			t.Fatal(queryErr)
 		}
@@ -56,7 +57,7 @@ func (db DB) testQueryClub(t *testing.T) {
 	t.Run(`invalid`, func(t *testing.T) {
 		t.Parallel()
 
-		detail, err := db.QueryClub(``)
+		detail, err := db.QueryClub(context.Background(), ``)
 
 		if (detail != Club{}) {
 			t.Error(`invalid club; expected zero value, got `,
@@ -71,7 +72,7 @@ func (db DB) testQueryClub(t *testing.T) {
 }
 
 func (db DB) testQueryClubName(t *testing.T) {
-	if name, err := db.QueryClubName(`prog`); err != nil {
+	if name, err := db.QueryClubName(context.Background(), `prog`); err != nil {
 		t.Error(err)
 	} else if name != `Prog部` {
 		t.Errorf(`expected "Prog部", got %q`, name)
@@ -94,7 +95,7 @@ func (db DB) testQueryClubNames(t *testing.T) {
 func (db DB) testQueryClubs(t *testing.T) {
 	const expected = `[{"id":"prog","name":"Prog部","chief":{"id":"2ndDisplayID","mail":"","nickname":"2 !%_1\"#","realname":"$&\\%_2'(","tel":"000-000-002"}},{"id":"web","name":"Web部","chief":{"id":"1stDisplayID","mail":"1st@kagucho.net","nickname":"1 !\\%_1\"#","realname":"$&\\%_2'(","tel":"000-000-001"}}]`
 
-	result, err := db.QueryClubs().MarshalJSON()
+	result, err := db.QueryClubs(context.Background(), ``, -1).MarshalJSON()
 	if err != nil {
 		t.Error(err)
 	}
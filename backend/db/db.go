@@ -18,7 +18,7 @@
 /*
 Package db implements an abstraction for the database.
 
-String List
+# String List
 
 Some functions have a space-delimited (%x20) list as an argument. It is
 expected to be compatible with similar lists used in RFC 6749.
@@ -29,10 +29,17 @@ https://tools.ietf.org/html/rfc6749
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"github.com/kagucho/tsubonesystem3/configuration"
+	"github.com/go-sql-driver/mysql"
+	"github.com/kagucho/tsubonesystem3/backend/config"
+	"github.com/kagucho/tsubonesystem3/backend/event"
+	"github.com/kagucho/tsubonesystem3/backend/scope"
 	"log"
+	"math"
+	"strings"
+	"time"
 )
 
 /*
@@ -40,10 +47,24 @@ DB is a structure holding the connection to the database. It should be
 initialized with db.New.
 */
 type DB struct {
-	sql   *sql.DB
-	stmts [stmtNumber]*sql.Stmt
+	sql    *sql.DB
+	stmts  [stmtNumber]*sql.Stmt
+	events *event.Bus
+
+	// passwordKey and timeout are given by config.Config.
+	passwordKey []byte
+	timeout     time.Duration
+
+	// tx is the transaction bound by Transact if any.
+	tx *sql.Tx
+
+	// held is the events published in tx, delivered after it is committed.
+	held *[]event.Event
 }
 
+// eventCapacity is the number of the recent events kept for resumption.
+const eventCapacity = 256
+
 // ErrDupEntry is an error telling the entry is duplicate.
 var ErrDupEntry = errors.New(`duplicate entry`)
 
@@ -57,11 +78,12 @@ var ErrInvalid = errors.New(`invalid`)
 var ErrBadOmission = errors.New(`bad omission`)
 
 /*
-	MariaDB Error Codes - MariaDB Knowledge Base
-	https://mariadb.com/kb/en/mariadb/mariadb-error-codes/
+MariaDB Error Codes - MariaDB Knowledge Base
+https://mariadb.com/kb/en/mariadb/mariadb-error-codes/
 */
 const (
 	erDupEntry                    = 1062
+	erLockDeadlock                = 1213
 	erNoReferencedRow             = 1216
 	erRowIsReferenced             = 1217
 	erTruncatedWrongValueForField = 1366
@@ -72,12 +94,34 @@ const (
 	erSignalException             = 1644
 )
 
-// New returns a new db.DB. Resources will be holded until Close gets called.
-func New() (DB, error) {
+/*
+relation describes a table associating two kinds of entries, for example
+`recipients` associating mails and members.
+*/
+type relation struct {
+	table  string
+	owner  string
+	member string
+}
+
+var (
+	relationAttendances = relation{`attendances`, `party`, `member`}
+	relationClubMember  = relation{`club_member`, `member`, `club`}
+	relationRecipients  = relation{`recipients`, `mail`, `member`}
+)
+
+/*
+New returns a new db.DB with the given configuration. Resources will be holded
+until Close gets called.
+*/
+func New(config config.Config) (DB, error) {
 	var db DB
 	var err error
 
-	db.sql, err = sql.Open(`mysql`, configuration.DBDSN)
+	db.events = event.New(eventCapacity)
+	db.passwordKey = []byte(config.DBPasswordKey)
+	db.timeout = config.DBTimeout
+	db.sql, err = sql.Open(`mysql`, config.DBDSN)
 	if err != nil {
 		return db, err
 	}
@@ -110,6 +154,393 @@ func (db DB) Close() error {
 	return db.sql.Close()
 }
 
+/*
+Transact calls the given function with a copy of db.DB bound to a serializable
+transaction. The transaction will be committed if the function returns nil, and
+rolled back otherwise. The events of the changes will be delivered after the
+transaction is committed.
+
+The copy shares one connection among its methods. The function must close every
+channel and end every detail returned by the copy before calling another method.
+*/
+func (db DB) Transact(function func(Store) error) error {
+	var held []event.Event
+
+	if err := db.transact(func(tx *sql.Tx) error {
+		bound := db
+		bound.tx = tx
+		bound.held = &held
+
+		return function(bound)
+	}); err != nil {
+		return err
+	}
+
+	for _, published := range held {
+		db.publish(published, nil)
+	}
+
+	return nil
+}
+
+// Events returns event.Bus delivering the changes made with db.DB.
+func (db DB) Events() *event.Bus {
+	return db.events
+}
+
+/*
+publish publishes the given event if err is nil, and returns err. If db.DB is
+bound to a transaction, the event is held until the transaction is committed.
+*/
+func (db DB) publish(published event.Event, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if db.held != nil {
+		*db.held = append(*db.held, published)
+	} else {
+		db.events.Publish(published)
+	}
+
+	return nil
+}
+
+/*
+newEvent returns event.Event of the given type for the given target, relevant to
+the given audience and readable with the member scope.
+*/
+func newEvent(kind, target string, audience []string) event.Event {
+	return event.Event{
+		Type:     kind,
+		Target:   target,
+		Scope:    scope.Member,
+		Audience: audience,
+	}
+}
+
+/*
+listAudience returns the audience consisting of the members in the given
+space-separated list and the given members. It returns nil, which means
+everyone, if the list is empty as it is left unchanged.
+*/
+func listAudience(list string, members ...string) []string {
+	if list == `` {
+		return nil
+	}
+
+	return append(splitList(list), members...)
+}
+
+/*
+transact calls the given function in a serializable transaction. The
+transaction will be committed if the function returns nil, and rolled back
+otherwise. If db.DB is bound to a transaction, the function will be called in
+it instead.
+*/
+func (db DB) transact(function func(tx *sql.Tx) error) error {
+	if db.tx != nil {
+		return function(db.tx)
+	}
+
+	ctx, cancel := db.withTimeout(context.Background())
+	defer cancel()
+
+	tx, err := db.sql.BeginTx(ctx,
+		&sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+
+	if err := function(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Print(rollbackErr)
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+/*
+withTimeout returns a copy of the given context which will be done after
+config.Config.DBTimeout. The returned function must be called when the work
+with the context finishes.
+*/
+func (db DB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, db.timeout)
+}
+
+/*
+sqlLimit returns the value of LIMIT clause for the given limit, which is
+negative for no limit.
+*/
+func sqlLimit(limit int) int64 {
+	if limit < 0 {
+		return math.MaxInt64
+	}
+
+	return int64(limit)
+}
+
+/*
+beginReadOnly begins a read-only serializable transaction bound to the given
+context. The transaction will be rolled back when the context gets done. If
+db.DB is bound to a transaction, it returns the transaction instead.
+*/
+func (db DB) beginReadOnly(ctx context.Context) (*sql.Tx, error) {
+	if db.tx != nil {
+		return db.tx, nil
+	}
+
+	return db.sql.BeginTx(ctx,
+		&sql.TxOptions{
+			Isolation: sql.LevelSerializable,
+			ReadOnly:  true,
+		})
+}
+
+/*
+endReadOnly ends the given transaction begun with beginReadOnly. It ignores
+the transaction already rolled back because of its context.
+*/
+func (db DB) endReadOnly(tx *sql.Tx) {
+	if err := db.commitReadOnly(tx); err != nil && err != sql.ErrTxDone {
+		log.Print(err)
+	}
+}
+
+/*
+commitReadOnly commits the given transaction begun with beginReadOnly. It leaves
+the transaction bound to db.DB to its owner.
+*/
+func (db DB) commitReadOnly(tx *sql.Tx) error {
+	if tx == db.tx {
+		return nil
+	}
+
+	return tx.Commit()
+}
+
+/*
+stmt returns the prepared statement of the given index. It is bound to the
+transaction of db.DB if any.
+*/
+func (db DB) stmt(index int) *sql.Stmt {
+	if db.tx != nil {
+		return db.tx.Stmt(db.stmts[index])
+	}
+
+	return db.stmts[index]
+}
+
+/*
+convertMySQLError returns one of db.ErrDupEntry, db.ErrIncorrectIdentity, and
+db.ErrInvalid corresponding with the given error if any. Otherwise, it returns
+the given error.
+*/
+func convertMySQLError(err error) error {
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		switch mysqlErr.Number {
+		case erDataTooLong:
+			fallthrough
+		case erTruncatedWrongValueForField:
+			fallthrough
+		case erWrongValue:
+			return ErrInvalid
+
+		case erDupEntry:
+			return ErrDupEntry
+
+		case erNoReferencedRow:
+			fallthrough
+		case erNoReferencedRow2:
+			return ErrIncorrectIdentity
+		}
+	}
+
+	return err
+}
+
+// placeholders returns the given number of placeholders delimited with commas.
+func placeholders(placeholder string, number int) string {
+	return strings.TrimSuffix(strings.Repeat(placeholder+`,`, number), `,`)
+}
+
+/*
+queryInternalClubs returns the internal IDs of the clubs identified with the
+given IDs, in the same order.
+
+It returns db.ErrIncorrectIdentity if some of the IDs is incorrect.
+*/
+func queryInternalClubs(tx *sql.Tx, ids []string) ([]uint16, error) {
+	internalIDs, _, err := queryInternal(tx, "SELECT `display_id`, `id`, '' FROM `clubs` WHERE `display_id` IN ", ids)
+	return internalIDs, err
+}
+
+/*
+queryInternalMembers returns the internal IDs and the email addresses of the
+members identified with the given IDs, in the same order.
+
+It returns db.ErrIncorrectIdentity if some of the IDs is incorrect.
+*/
+func queryInternalMembers(tx *sql.Tx, ids []string) ([]uint16, []string, error) {
+	return queryInternal(tx, "SELECT `display_id`, `id`, `mail` FROM `members` WHERE NOT FIND_IN_SET('deleted', `flags`) AND `display_id` IN ", ids)
+}
+
+func queryInternal(tx *sql.Tx, query string, ids []string) ([]uint16, []string, error) {
+	if len(ids) <= 0 {
+		return nil, nil, nil
+	}
+
+	arguments := make([]interface{}, len(ids))
+	for index, id := range ids {
+		arguments[index] = id
+	}
+
+	rows, err := tx.Query(query+`(`+placeholders(`?`, len(ids))+`)`,
+		arguments...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	internalIDs := make(map[string]uint16, len(ids))
+	mails := make(map[string]string, len(ids))
+
+	for rows.Next() {
+		var id string
+		var internalID uint16
+		var mail string
+
+		if err := rows.Scan(&id, &internalID, &mail); err != nil {
+			return nil, nil, err
+		}
+
+		internalIDs[id] = internalID
+		mails[id] = mail
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	orderedIDs := make([]uint16, len(ids))
+	orderedMails := make([]string, len(ids))
+
+	for index, id := range ids {
+		internalID, present := internalIDs[id]
+		if !present {
+			return nil, nil, ErrIncorrectIdentity
+		}
+
+		orderedIDs[index] = internalID
+		orderedMails[index] = mails[id]
+	}
+
+	return orderedIDs, orderedMails, nil
+}
+
+// insert associates the given members with the given owner in a statement.
+func (relation relation) insert(tx *sql.Tx, owner uint16, members []uint16) error {
+	if len(members) <= 0 {
+		return nil
+	}
+
+	arguments := make([]interface{}, 0, len(members)*2)
+	for _, member := range members {
+		arguments = append(arguments, owner, member)
+	}
+
+	_, err := tx.Exec("INSERT INTO `"+relation.table+"` (`"+relation.owner+"`, `"+relation.member+"`) VALUES "+placeholders(`(?,?)`, len(members)),
+		arguments...)
+
+	return convertMySQLError(err)
+}
+
+/*
+replace replaces the members associated with the given owner. Associations
+already existing are kept as they are.
+*/
+func (relation relation) replace(tx *sql.Tx, owner uint16, members []uint16) error {
+	added := append([]uint16(nil), members...)
+
+	removed, err := func() ([]interface{}, error) {
+		rows, err := tx.Query("SELECT `"+relation.member+"` FROM `"+relation.table+"` WHERE `"+relation.owner+"`=?",
+			owner)
+		if err != nil {
+			return nil, err
+		}
+
+		defer func() {
+			if err := rows.Close(); err != nil {
+				log.Print(err)
+			}
+		}()
+
+		removed := []interface{}{owner}
+
+	Rows:
+		for rows.Next() {
+			var current uint16
+			if err := rows.Scan(&current); err != nil {
+				return nil, err
+			}
+
+			for index, member := range added {
+				if member == current {
+					added = append(added[:index], added[index+1:]...)
+					continue Rows
+				}
+			}
+
+			removed = append(removed, current)
+		}
+
+		return removed, rows.Err()
+	}()
+	if err != nil {
+		return err
+	}
+
+	if len(removed) > 1 {
+		if _, err := tx.Exec("DELETE FROM `"+relation.table+"` WHERE `"+relation.owner+"`=? AND `"+relation.member+"` IN ("+placeholders(`?`, len(removed)-1)+`)`,
+			removed...); err != nil {
+			return err
+		}
+	}
+
+	return relation.insert(tx, owner, added)
+}
+
+// splitList returns the unique items of the given string list.
+func splitList(list string) []string {
+	if list == `` {
+		return nil
+	}
+
+	items := make([]string, 0, strings.Count(list, ` `)+1)
+
+Split:
+	for _, item := range strings.Split(list, ` `) {
+		for _, existing := range items {
+			if existing == item {
+				continue Split
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
 func stringListToDBList(list string) (uint, []byte) {
 	count := uint(0)
 	bytes := []byte(list)
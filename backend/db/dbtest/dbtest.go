@@ -0,0 +1,142 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+/*
+Package dbtest provides db.Memory filled with a fixture equivalent to test.sql
+in the root of the repository, for the tests of the handlers. Like db.Memory,
+it is only built with the dbtest tag.
+
+The members are the following. The password of each member is the ordinal
+followed by "Password", for example "1stPassword".
+
+	+--------------+-----------+----------+----------+
+	| id           | nickname  | realname | entrance |
+	+--------------+-----------+----------+----------+
+	| 1stDisplayID | 1 !\%_1'# | $&\%_2'( |     1901 |
+	| 2ndDisplayID | 2 !%_1'#  | $&\%_2'( |     1901 |
+	| 3rdDisplayID | 3 !\%*1'# | $&\%_2'( |     1901 |
+	| 4thDisplayID | 4 !)_1'#  | $&\%_2'( |     1901 |
+	| 5thDisplayID | 5 !\%_1'# | $&%+2'(  |     1901 |
+	| 6thDisplayID | 6 !\%_1'# | $&\%+2'( |     2155 |
+	| 7thDisplayID | 7 !\%_1'# | $&,_2'(  |     1901 |
+	+--------------+-----------+----------+----------+
+
+7thDisplayID is an OB. Unlike test.sql, the nicknames have ' instead of " and
+every member has an email address because db.Memory validates them as
+db.DB.InsertMember does.
+
+The clubs are prog (Prog部, chief 2ndDisplayID) and web (Web部, chief
+1stDisplayID). 1stDisplayID belongs to both and 2ndDisplayID belongs to prog.
+
+The officers are president (局長, management and privacy) and vice (副局長,
+privacy), both of which are 1stDisplayID.
+
+The fixture is inserted without any operator, so its history has no operator.
+*/
+package dbtest
+
+import "github.com/kagucho/tsubonesystem3/backend/db"
+
+type member struct {
+	id          string
+	nickname    string
+	realname    string
+	entrance    int
+	affiliation string
+	gender      string
+	tel         string
+	clubs       string
+}
+
+var members = [...]member{
+	{`1stDisplayID`, `1 !\%_1'#`, `$&\%_2'(`, 1901, `理学部第一部 数理情報科学科`, `男`, `000-000-001`, `prog web`},
+	{`2ndDisplayID`, `2 !%_1'#`, `$&\%_2'(`, 1901, ``, `女`, `000-000-002`, `prog`},
+	{`3rdDisplayID`, `3 !\%*1'#`, `$&\%_2'(`, 1901, ``, ``, `000-000-003`, ``},
+	{`4thDisplayID`, `4 !)_1'#`, `$&\%_2'(`, 1901, ``, ``, ``, ``},
+	{`5thDisplayID`, `5 !\%_1'#`, `$&%+2'(`, 1901, ``, ``, ``, ``},
+	{`6thDisplayID`, `6 !\%_1'#`, `$&\%+2'(`, 2155, ``, ``, ``, ``},
+	{`7thDisplayID`, `7 !\%_1'#`, `$&,_2'(`, 1901, ``, ``, ``, ``},
+}
+
+var ordinals = [len(members)]string{`1st`, `2nd`, `3rd`, `4th`, `5th`, `6th`, `7th`}
+
+// New returns a new db.Memory filled with the fixture.
+func New() (*db.Memory, error) {
+	memory := db.NewMemory()
+
+	for index, member := range members {
+		if err := memory.InsertMember(``, member.id,
+			ordinals[index]+`@kagucho.net`, member.nickname); err != nil {
+			return nil, err
+		}
+
+		if err := memory.UpdateMember(``, member.id, false, false,
+			ordinals[index]+`Password`, member.affiliation, db.NoClubsUpdate,
+			member.entrance, member.gender, ``, ``,
+			member.realname, member.tel, db.AnyVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := memory.DeclareMemberOB(`7thDisplayID`); err != nil {
+		return nil, err
+	}
+
+	for _, club := range [...]struct {
+		id    string
+		name  string
+		chief string
+	}{
+		{`prog`, `Prog部`, `2ndDisplayID`},
+		{`web`, `Web部`, `1stDisplayID`},
+	} {
+		if err := memory.InsertClub(``, club.id, club.name, club.chief); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, member := range members {
+		if member.clubs == `` {
+			continue
+		}
+
+		if err := memory.UpdateMember(``, member.id, false, false, ``, ``,
+			member.clubs, 0, ``, ``, ``, ``, ``, db.AnyVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, officer := range [...]struct {
+		id     string
+		name   string
+		member string
+		scope  string
+	}{
+		{`president`, `局長`, `1stDisplayID`, `management privacy`},
+		{`vice`, `副局長`, `1stDisplayID`, `privacy`},
+	} {
+		if err := memory.InsertOfficer(``, officer.id, officer.name,
+			officer.member, officer.scope); err != nil {
+			return nil, err
+		}
+	}
+
+	return memory, nil
+}
@@ -0,0 +1,487 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+HistorySubject is a type representing a kind of records whose changes are
+recorded.
+*/
+type HistorySubject string
+
+// The values HistorySubject may have.
+const (
+	HistoryClub    HistorySubject = `club`
+	HistoryMember  HistorySubject = `member`
+	HistoryOfficer HistorySubject = `officer`
+	HistoryParty   HistorySubject = `party`
+)
+
+/*
+HistoryEntry is a structure representing a change of a field of a record.
+
+Old is empty if the record is inserted, and New is empty if the record is
+deleted. Both are always empty for a password.
+*/
+type HistoryEntry struct {
+	Date     encoding.Time       `json:"date"`
+	Field    string              `json:"field"`
+	New      encoding.ZeroString `json:"new"`
+	Old      encoding.ZeroString `json:"old"`
+	Operator encoding.ZeroString `json:"operator"`
+}
+
+/*
+HistoryEntryResult is a structure representing a result of querying
+db.HistoryEntry.
+*/
+type HistoryEntryResult struct {
+	HistoryEntry
+	Error error
+}
+
+// HistoryEntryChan is a reciever of db.HistoryEntryResult.
+type HistoryEntryChan <-chan HistoryEntryResult
+
+/*
+snapshot is a map from the names of fields to their values, representing the
+state of a record. A nil snapshot represents a record which does not exist.
+*/
+type snapshot map[string]string
+
+// snapshotAttendance is a structure representing an attendance in a snapshot.
+type snapshotAttendance struct {
+	member     string
+	attendance Attendance
+}
+
+// snapshotChange is a structure representing a change of a field.
+type snapshotChange struct {
+	field string
+	old   sql.NullString
+	new   sql.NullString
+}
+
+/*
+MarshalJSON returns the JSON encoding of the remaining entries and closes the
+channel.
+
+This implements an interface used in encoding/encoding.
+
+json - The Go Programming Language
+Example (CustomMarshalJSON)
+https://golang.org/pkg/encoding/json/#example__customMarshalJSON
+*/
+func (entryChan HistoryEntryChan) MarshalJSON() ([]byte, error) {
+	return encoding.MarshalJSONArray(func() (interface{}, error, bool) {
+		result, present := <-entryChan
+		return result.HistoryEntry, result.Error, present
+	})
+}
+
+/*
+QueryHistory returns db.HistoryEntryChan representing the changes of the record
+of the given subject identified by the given target, in the order they were
+made. The target is the ID of a club, a member or an officer, or the name of a
+party.
+
+Resources will be holded until the channel gets closed or the given
+context gets done.
+*/
+func (db DB) QueryHistory(ctx context.Context, subject HistorySubject, target string) HistoryEntryChan {
+	resultChan := make(chan HistoryEntryResult)
+	ctx, cancel := db.withTimeout(ctx)
+
+	go func() {
+		defer func() {
+			close(resultChan)
+			cancel()
+		}()
+
+		rows, err := db.stmt(stmtSelectHistory).QueryContext(ctx, string(subject), target)
+		if err != nil {
+			select {
+			case resultChan <- HistoryEntryResult{Error: err}:
+			case <-ctx.Done():
+			}
+
+			return
+		}
+
+		defer func() {
+			if err := rows.Close(); err != nil {
+				log.Print(err)
+			}
+		}()
+
+		for rows.Next() {
+			var date mysql.NullTime
+			var newValue sql.NullString
+			var oldValue sql.NullString
+			var result HistoryEntryResult
+
+			result.Error = rows.Scan(&date, &result.Field,
+				&newValue, &oldValue, (*string)(&result.Operator))
+			result.Date = encoding.NewTime(date.Time)
+			result.New = encoding.ZeroString(newValue.String)
+			result.Old = encoding.ZeroString(oldValue.String)
+
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			if result.Error != nil {
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			select {
+			case resultChan <- HistoryEntryResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+/*
+recordHistory records the changes from the old snapshot to the new one made by
+the given operator, and increments the versions of the changed records.
+*/
+func (db DB) recordHistory(tx *sql.Tx, operator string, subject HistorySubject, target string, old, current snapshot) error {
+	changes := old.diff(current)
+	if len(changes) <= 0 {
+		return nil
+	}
+
+	arguments := make([]interface{}, 0, len(changes)*6)
+	for _, change := range changes {
+		arguments = append(arguments, operator, string(subject), target,
+			change.field, change.old, change.new)
+	}
+
+	if _, err := tx.Exec("INSERT INTO `history` (`operator`, `subject`, `target`, `field`, `old`, `new`) VALUES "+placeholders(`(?,?,?,?,?,?)`, len(changes)),
+		arguments...); err != nil {
+		return err
+	}
+
+	for _, record := range changedRecords(subject, target, changes) {
+		if err := db.bumpVersion(tx, historyVersions[record.subject], record.target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+recordChange records the changes made by the given function to the record of
+the given subject identified by the given target. take should return the
+snapshot of the record.
+*/
+func (db DB) recordChange(tx *sql.Tx, operator string, subject HistorySubject, target string, take func() (snapshot, error), change func() error) error {
+	old, err := take()
+	if err != nil {
+		return err
+	}
+
+	if err := change(); err != nil {
+		return err
+	}
+
+	current, err := take()
+	if err != nil {
+		return err
+	}
+
+	return db.recordHistory(tx, operator, subject, target, old, current)
+}
+
+/*
+recordMemberChange records the changes made by the given function to the member
+identified by the given ID and internal ID.
+*/
+func (db DB) recordMemberChange(tx *sql.Tx, operator, id string, dbID uint16, change func() error) error {
+	return db.recordChange(tx, operator, HistoryMember, id,
+		func() (snapshot, error) {
+			return db.snapshotMember(tx, dbID)
+		}, change)
+}
+
+// snapshotClub returns the snapshot of the club identified by the given ID.
+func (db DB) snapshotClub(tx *sql.Tx, id string) (snapshot, error) {
+	var dbID uint8
+	var name string
+	var chief string
+	var version Version
+
+	if err := tx.Stmt(db.stmts[stmtSelectClubByID]).QueryRow(id).Scan(
+		&dbID, &name, &chief, &version); err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrIncorrectIdentity
+		}
+
+		return nil, err
+	}
+
+	return snapshot{`chief`: chief, `name`: name}, nil
+}
+
+/*
+snapshotMember returns the snapshot of the member identified by the given
+internal ID.
+*/
+func (db DB) snapshotMember(tx *sql.Tx, dbID uint16) (snapshot, error) {
+	var affiliation string
+	var confirmed, deleted, ob bool
+	var entrance uint16
+	var gender string
+	var mail string
+	var nickname string
+	var password []byte
+	var realname string
+	var tel string
+
+	if err := tx.Stmt(db.stmts[stmtSelectMemberSnapshotByInternalID]).QueryRow(dbID).Scan(
+		&affiliation, &confirmed, &deleted, &entrance, &gender, &mail,
+		&nickname, &ob, &password, &realname, &tel); err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrIncorrectIdentity
+		}
+
+		return nil, err
+	}
+
+	rows, err := tx.Stmt(db.stmts[stmtSelectClubsByInternalMember]).Query(dbID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	var clubs []string
+
+	for rows.Next() {
+		var chief uint16
+		var club string
+
+		if err := rows.Scan(&chief, &club); err != nil {
+			return nil, err
+		}
+
+		clubs = append(clubs, club)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return newMemberSnapshot(affiliation, clubs, confirmed, deleted, entrance,
+		gender, mail, nickname, ob, password, realname, tel), nil
+}
+
+// snapshotOfficer returns the snapshot of the officer identified by the given ID.
+func (db DB) snapshotOfficer(tx *sql.Tx, id string) (snapshot, error) {
+	var name string
+	var scope string
+	var member string
+	var version Version
+
+	if err := tx.Stmt(db.stmts[stmtSelectOfficerByID]).QueryRow(id).Scan(
+		&name, &scope, &member, &version); err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrIncorrectIdentity
+		}
+
+		return nil, err
+	}
+
+	return snapshot{`member`: member, `name`: name, `scope`: scope}, nil
+}
+
+// snapshotParty returns the snapshot of the party named with the given name.
+func (db DB) snapshotParty(tx *sql.Tx, name string) (snapshot, error) {
+	var creator sql.NullString
+	var details string
+	var dbID uint16
+	var due mysql.NullTime
+	var end mysql.NullTime
+	var inviteds string
+	var place string
+	var start mysql.NullTime
+	var version Version
+
+	if err := tx.Stmt(db.stmts[stmtSelectParty]).QueryRow(name).Scan(
+		&dbID, &creator, &start, &end, &place,
+		&inviteds, &due, &details, &version); err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrIncorrectIdentity
+		}
+
+		return nil, err
+	}
+
+	rows, err := tx.Stmt(db.stmts[stmtSelectAttendancesByInternalParty]).Query(dbID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	var attendances []snapshotAttendance
+
+	for rows.Next() {
+		var attendance snapshotAttendance
+
+		if err := rows.Scan(&attendance.member, (*uint16)(&attendance.attendance)); err != nil {
+			return nil, err
+		}
+
+		attendances = append(attendances, attendance)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return newPartySnapshot(attendances, creator.String, details, due.Time,
+		end.Time, inviteds, place, start.Time), nil
+}
+
+/*
+diff returns the changes from the snapshot to the given one. A field absent
+from a snapshot is regarded as empty, but its value will be recorded as NULL.
+*/
+func (old snapshot) diff(current snapshot) []snapshotChange {
+	fields := make([]string, 0, len(old)+len(current))
+
+	for field := range old {
+		fields = append(fields, field)
+	}
+
+	for field := range current {
+		if _, present := old[field]; !present {
+			fields = append(fields, field)
+		}
+	}
+
+	sort.Strings(fields)
+	changes := make([]snapshotChange, 0, len(fields))
+
+	for _, field := range fields {
+		oldValue, oldPresent := old[field]
+		newValue, newPresent := current[field]
+		if oldValue == newValue {
+			continue
+		}
+
+		change := snapshotChange{field: field}
+
+		// Never leak passwords, even if hashed.
+		if field != `password` {
+			change.old = sql.NullString{String: oldValue, Valid: oldPresent}
+			change.new = sql.NullString{String: newValue, Valid: newPresent}
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+func newMemberSnapshot(affiliation string, clubs []string, confirmed, deleted bool, entrance uint16, gender, mail, nickname string, ob bool, password []byte, realname, tel string) snapshot {
+	sortedClubs := append([]string(nil), clubs...)
+	sort.Strings(sortedClubs)
+
+	// A temporary member has a password filled with zero.
+	var passwordString string
+	for _, value := range password {
+		if value != 0 {
+			passwordString = string(password)
+			break
+		}
+	}
+
+	var entranceString string
+	if entrance != 0 {
+		entranceString = strconv.FormatUint(uint64(entrance), 10)
+	}
+
+	return snapshot{
+		`affiliation`: affiliation,
+		`clubs`:       strings.Join(sortedClubs, ` `),
+		`confirmed`:   strconv.FormatBool(confirmed),
+		`deleted`:     strconv.FormatBool(deleted),
+		`entrance`:    entranceString,
+		`gender`:      gender,
+		`mail`:        mail,
+		`nickname`:    nickname,
+		`ob`:          strconv.FormatBool(ob),
+		`password`:    passwordString,
+		`realname`:    realname,
+		`tel`:         tel,
+	}
+}
+
+func newPartySnapshot(attendances []snapshotAttendance, creator, details string, due, end time.Time, inviteds, place string, start time.Time) snapshot {
+	attendanceStrings := make([]string, len(attendances))
+	for index, attendance := range attendances {
+		attendanceStrings[index] = attendance.member + `:` +
+			strings.Trim(string(attendanceJSON[attendance.attendance]), `"`)
+	}
+
+	sort.Strings(attendanceStrings)
+
+	return snapshot{
+		`attendances`: strings.Join(attendanceStrings, ` `),
+		`creator`:     creator,
+		`details`:     details,
+		`due`:         snapshotTime(due),
+		`end`:         snapshotTime(end),
+		`inviteds`:    inviteds,
+		`place`:       place,
+		`start`:       snapshotTime(start),
+	}
+}
+
+func snapshotTime(value time.Time) string {
+	return value.UTC().Format(time.RFC3339)
+}
@@ -22,6 +22,7 @@ import (
 	"database/sql"
 	"github.com/go-sql-driver/mysql"
 	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"github.com/kagucho/tsubonesystem3/backend/event"
 	"log"
 )
 
@@ -49,11 +50,16 @@ type MailDetail struct {
 	MailCommon
 	Recipients RecipientChan `json:"recipients"`
 	Body       string        `json:"body"`
+	Version    Version       `json:"-"`
 }
 
-// MailEntry is a structure holding basic information about a email.
+/*
+MailEntry is a structure holding basic information about a email. ID is the
+internal ID, which is stable even if the subject changes.
+*/
 type MailEntry struct {
 	MailCommon
+	ID      uint16 `json:"-"`
 	Subject string `json:"subject"`
 }
 
@@ -102,7 +108,7 @@ func (entryChan MailEntryChan) MarshalJSON() ([]byte, error) {
 
 /*
 InsertMail inserts an email with the given properties and returns the nickname
-of From and the IDs of the recipients.
+of From and the email addresses of the recipients.
 
 It may return one of the following errors:
 db.ErrBadOmission tells recipients, to, subject, or body is omitted.
@@ -113,90 +119,91 @@ db.ErrInvalid tells one of the given properties is invalid.
 
 Other errors tell db.DB is bad.
 */
-func (db DB) InsertMail(recipients, from, to, subject, body string) (returnedFrom string, returnedMails []string, returnedErr error) {
-	var fromDBID uint16
+func (db DB) InsertMail(recipients, from, to, subject, body string) (string, []string, error) {
 	var fromNickname string
+	var recipientMails []string
 
 	if recipients == `` || to == `` || subject == `` || body == `` {
 		return ``, nil, ErrBadOmission
 	}
 
-	recipientsCount, recipientsDB := stringListToDBList(recipients)
-	recipientMails := make([]string, recipientsCount)
+	if err := db.transact(func(tx *sql.Tx) error {
+		var fromDBID uint16
+
+		if err := tx.Stmt(db.stmts[stmtSelectMemberInternalIDNicknameByID]).QueryRow(from).Scan(&fromDBID, &fromNickname); err != nil {
+			if err == sql.ErrNoRows {
+				err = ErrIncorrectIdentity
+			}
 
-	if nicknameErr := db.stmts[stmtSelectMemberInternalIDNicknameByID].QueryRow(from).Scan(&fromDBID, &fromNickname); nicknameErr != nil {
-		if nicknameErr == sql.ErrNoRows {
-			nicknameErr = ErrIncorrectIdentity
+			return err
 		}
 
-		return ``, nil, nicknameErr
-	}
+		recipientDBIDs, mails, err := queryInternalMembers(tx, splitList(recipients))
+		if err != nil {
+			return err
+		}
 
-	rows, mailErr := db.stmts[stmtCallInsertMail].Query(recipientsDB, recipientsCount, fromDBID, to, subject, body)
-	if mailErr != nil {
-		if mysqlErr, ok := mailErr.(*mysql.MySQLError); ok {
-			switch mysqlErr.Number {
-			case erDataTooLong:
-				fallthrough
-			case erTruncatedWrongValueForField:
-				return ``, nil, ErrInvalid
-
-			case erDupEntry:
-				return ``, nil, ErrDupEntry
-
-			case erNoReferencedRow:
-				fallthrough
-			case erNoReferencedRow2:
-				fallthrough
-			case erSignalException:
-				return ``, nil, ErrIncorrectIdentity
+		for _, mail := range mails {
+			if mail == `` {
+				return ErrIncorrectIdentity
 			}
 		}
 
-		return ``, nil, mailErr
-	}
-
-	defer func() {
-		if closeErr := rows.Close(); closeErr != nil {
-			log.Print(closeErr)
+		result, err := tx.Stmt(db.stmts[stmtInsertMail]).Exec(fromDBID, to, subject, body)
+		if err != nil {
+			return convertMySQLError(err)
 		}
-	}()
 
-	for rows.Next() {
-		var mail string
+		mailDBID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
 
-		if scanErr := rows.Scan(&mail); scanErr != nil {
-			return ``, nil, scanErr
+		if err := relationRecipients.insert(tx, uint16(mailDBID), recipientDBIDs); err != nil {
+			return err
 		}
 
-		recipientMails = append(recipientMails, mail)
+		recipientMails = mails
+
+		return nil
+	}); err != nil {
+		return ``, nil, err
 	}
 
+	db.publish(newEvent(event.MailCreated, subject, listAudience(recipients, from)), nil)
+
 	return fromNickname, recipientMails, nil
 }
 
 /*
-DeleteMail delets the email with the given subject.
+DeleteMail delets the email with the given subject and version.
 
-It returns db.ErrIncorrectIdentity if the given subject is incorrect. Other
-errors tell db.DB is bad.
+It returns db.ErrIncorrectIdentity if the given subject is incorrect, and
+db.ErrVersionMismatch if the email has another version. Other errors tell db.DB
+is bad.
 */
-func (db DB) DeleteMail(subject string) error {
-	result, execErr := db.stmts[stmtDeleteMail].Exec(subject)
-	if execErr != nil {
-		return execErr
-	}
+func (db DB) DeleteMail(subject string, version Precondition) error {
+	return db.publish(newEvent(event.MailDeleted, subject, nil), db.transact(func(tx *sql.Tx) error {
+		if err := db.checkVersion(tx, versionMail, subject, version); err != nil {
+			return err
+		}
 
-	affected, affectedErr := result.RowsAffected()
-	if affectedErr != nil {
-		return affectedErr
-	}
+		result, execErr := tx.Stmt(db.stmts[stmtDeleteMail]).Exec(subject)
+		if execErr != nil {
+			return execErr
+		}
 
-	if affected <= 0 {
-		return ErrIncorrectIdentity
-	}
+		affected, affectedErr := result.RowsAffected()
+		if affectedErr != nil {
+			return affectedErr
+		}
+
+		if affected <= 0 {
+			return ErrIncorrectIdentity
+		}
 
-	return nil
+		return nil
+	}))
 }
 
 /*
@@ -205,28 +212,27 @@ QueryMail returns db.MailDetail describing the email with the given subject.
 It returns db.ErrIncorrectIdentity if the given subject is incorrect. Other
 errors tell db.DB is bad.
 
-Resources will be holded until Recipients gets closed.
+Resources will be holded until Recipients gets closed or the given
+context gets done.
 */
-func (db DB) QueryMail(subject string) (MailDetail, error) {
+func (db DB) QueryMail(ctx context.Context, subject string) (MailDetail, error) {
 	var date mysql.NullTime
 	var dbID uint16
 	var from sql.NullString
 	var mail MailDetail
 
-	tx, err := db.sql.BeginTx(context.Background(),
-		&sql.TxOptions{
-			Isolation: sql.LevelSerializable,
-			ReadOnly:  true,
-		})
+	ctx, cancel := db.withTimeout(ctx)
+
+	tx, err := db.beginReadOnly(ctx)
 	if err != nil {
+		cancel()
 		return mail, err
 	}
 
-	if err := tx.Stmt(db.stmts[stmtSelectMailBySubject]).QueryRow(subject).Scan(
-		&dbID, &date, &from, &mail.To, &mail.Body); err != nil {
-		if err := tx.Commit(); err != nil {
-			log.Print(err)
-		}
+	if err := tx.Stmt(db.stmts[stmtSelectMailBySubject]).QueryRowContext(ctx, subject).Scan(
+		&dbID, &date, &from, &mail.To, &mail.Body, &mail.Version); err != nil {
+		db.endReadOnly(tx)
+		cancel()
 
 		if err == sql.ErrNoRows {
 			err = ErrIncorrectIdentity
@@ -240,15 +246,17 @@ func (db DB) QueryMail(subject string) (MailDetail, error) {
 	go func() {
 		defer func() {
 			close(recipientChan)
-
-			if err := tx.Commit(); err != nil {
-				log.Print(err)
-			}
+			db.endReadOnly(tx)
+			cancel()
 		}()
 
-		rows, err := tx.Stmt(db.stmts[stmtSelectRecipientsByInternalMail]).Query(dbID)
+		rows, err := tx.Stmt(db.stmts[stmtSelectRecipientsByInternalMail]).QueryContext(ctx, dbID)
 		if err != nil {
-			recipientChan <- RecipientResult{Error: err}
+			select {
+			case recipientChan <- RecipientResult{Error: err}:
+			case <-ctx.Done():
+			}
+
 			return
 		}
 
@@ -262,11 +270,23 @@ func (db DB) QueryMail(subject string) (MailDetail, error) {
 			var result RecipientResult
 			result.Error = rows.Scan(&result.Recipient)
 
-			recipientChan <- result
+			select {
+			case recipientChan <- result:
+			case <-ctx.Done():
+				return
+			}
+
 			if result.Error != nil {
 				return
 			}
 		}
+
+		if err := rows.Err(); err != nil {
+			select {
+			case recipientChan <- RecipientResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
 	}()
 
 	mail.Date = encoding.NewTime(date.Time)
@@ -277,21 +297,72 @@ func (db DB) QueryMail(subject string) (MailDetail, error) {
 }
 
 /*
-QueryMails returns a channel sending db.MailEntryResult of all mails.
+QueryMailID returns the internal ID of the email with the given subject.
+
+It returns db.ErrIncorrectIdentity if the given subject is incorrect. Other
+errors tell db.DB is bad.
+*/
+func (db DB) QueryMailID(ctx context.Context, subject string) (uint16, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var id uint16
+	err := db.stmt(stmtSelectMailInternalIDBySubject).QueryRowContext(ctx, subject).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = ErrIncorrectIdentity
+	}
+
+	return id, err
+}
+
+/*
+QueryMailSubject returns the subject of the email with the given internal ID.
+
+It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
+tell db.DB is bad.
+*/
+func (db DB) QueryMailSubject(ctx context.Context, id uint16) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var subject string
+	err := db.stmt(stmtSelectMailSubjectByInternalID).QueryRowContext(ctx, id).Scan(&subject)
+	if err == sql.ErrNoRows {
+		err = ErrIncorrectIdentity
+	}
+
+	return subject, err
+}
+
+/*
+QueryMails returns a channel sending db.MailEntryResult of the mails in the
+order of their internal IDs. The mails follow the given internal ID, which may
+be 0 to start from the first, and are limited to the given number, which may be
+negative for no limit.
 
 db.MailEntryResult will have an error if db.DB is bad.
 
-Resources will be holded until the channel gets closed.
+Resources will be holded until the channel gets closed or the given
+context gets done.
 */
-func (db DB) QueryMails() MailEntryChan {
+func (db DB) QueryMails(ctx context.Context, after uint16, limit int) MailEntryChan {
 	resultChan := make(chan MailEntryResult)
+	ctx, cancel := db.withTimeout(ctx)
 
 	go func() {
-		defer close(resultChan)
+		defer func() {
+			close(resultChan)
+			cancel()
+		}()
 
-		rows, err := db.stmts[stmtSelectMails].Query()
+		rows, err := db.stmt(stmtSelectMails).QueryContext(ctx,
+			after, sqlLimit(limit))
 		if err != nil {
-			resultChan <- MailEntryResult{Error: err}
+			select {
+			case resultChan <- MailEntryResult{Error: err}:
+			case <-ctx.Done():
+			}
+
 			return
 		}
 
@@ -306,85 +377,108 @@ func (db DB) QueryMails() MailEntryChan {
 			var from sql.NullString
 			var result MailEntryResult
 
-			result.Error = rows.Scan(&date, &from,
+			result.Error = rows.Scan(&result.ID, &date, &from,
 				&result.To, &result.Subject)
 			result.Date = encoding.NewTime(date.Time)
 			result.From = encoding.ZeroString(from.String)
 
-			resultChan <- result
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+				return
+			}
+
 			if result.Error != nil {
 				return
 			}
 		}
+
+		if err := rows.Err(); err != nil {
+			select {
+			case resultChan <- MailEntryResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
 	}()
 
 	return resultChan
 }
 
 /*
-UpdateMail updates the email with the given subject, with the given properties.
+UpdateMail updates the email with the given subject and version, with the given
+properties.
 
 It may return one of the following errors:
-db.ErrIncorrectIdentity tells the IDs of the recipients or from is incorrect.
+db.ErrIncorrectIdentity tells the subject, the IDs of the recipients, or from is
+incorrect.
 db.ErrInvalid tells some of the given properties is invalid.
+db.ErrVersionMismatch tells the email has another version.
 
 Other errors tell db.DB is bad.
 */
-func (db DB) UpdateMail(subject, recipients string, date encoding.Time, from, to, body string) error {
-	arguments := make([]interface{}, 7)
-	arguments[0] = subject
-
-	if recipients != `` {
-		arguments[2], arguments[1] = stringListToDBList(recipients)
-	}
+func (db DB) UpdateMail(subject, recipients string, date encoding.Time, from, to, body string, version Precondition) error {
+	arguments := make([]interface{}, 5)
 
 	if (date != encoding.Time{}) {
-		arguments[3] = date
-	}
-
-	if from != `` {
-		arguments[4] = from
+		arguments[0] = date.Generic()
 	}
 
 	if to != `` {
-		arguments[5] = to
+		arguments[2] = to
 	}
 
 	if body != `` {
-		arguments[6] = body
+		arguments[3] = body
 	}
 
-	result, execErr := db.stmts[stmtCallUpdateMail].Exec(arguments...)
-	if execErr != nil {
-		if mysqlErr, ok := execErr.(*mysql.MySQLError); ok {
-			switch mysqlErr.Number {
-			case erDataTooLong:
-				fallthrough
-			case erTruncatedWrongValueForField:
-				fallthrough
-			case erWrongValue:
-				return ErrInvalid
-
-			case erNoReferencedRow:
-				fallthrough
-			case erNoReferencedRow2:
-				fallthrough
-			case erSignalException:
-				return ErrIncorrectIdentity
+	return db.publish(newEvent(event.MailUpdated, subject, listAudience(recipients)), db.transact(func(tx *sql.Tx) error {
+		var mailDBID uint16
+
+		if err := db.checkVersion(tx, versionMail, subject, version); err != nil {
+			return err
+		}
+
+		if err := tx.Stmt(db.stmts[stmtSelectMailInternalIDBySubject]).QueryRow(subject).Scan(&mailDBID); err != nil {
+			if err == sql.ErrNoRows {
+				err = ErrIncorrectIdentity
 			}
+
+			return err
 		}
 
-		return execErr
-	}
+		if from != `` {
+			var fromDBID uint16
 
-	affected, affectedErr := result.RowsAffected()
-	if affectedErr != nil {
-		return affectedErr
-	}
+			if err := tx.Stmt(db.stmts[stmtSelectMemberInternalIDByID]).QueryRow(from).Scan(&fromDBID); err != nil {
+				if err == sql.ErrNoRows {
+					err = ErrIncorrectIdentity
+				}
 
-	if affected <= 0 {
-		return ErrIncorrectIdentity
-	}
+				return err
+			}
+
+			arguments[1] = fromDBID
+		}
+
+		arguments[4] = mailDBID
+
+		if _, err := tx.Stmt(db.stmts[stmtUpdateMail]).Exec(arguments...); err != nil {
+			return convertMySQLError(err)
+		}
+
+		if err := db.bumpVersion(tx, versionMail, subject); err != nil {
+			return err
+		}
+
+		if recipients == `` {
+			return nil
+		}
+
+		recipientDBIDs, _, err := queryInternalMembers(tx, splitList(recipients))
+		if err != nil {
+			return err
+		}
 
-	return nil
+		return relationRecipients.replace(tx, mailDBID, recipientDBIDs)
+	}))
 }
@@ -26,8 +26,8 @@ import (
 	"errors"
 	"github.com/go-sql-driver/mysql"
 	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"github.com/kagucho/tsubonesystem3/backend/event"
 	"github.com/kagucho/tsubonesystem3/backend/mail"
-	"github.com/kagucho/tsubonesystem3/configuration"
 	"golang.org/x/crypto/pbkdf2"
 	"log"
 	"strings"
@@ -58,8 +58,7 @@ type MemberClubChan <-chan MemberClubResult
 
 // MemberClubQuerier is a structure to provide a feature to query db.MemberClub.
 type MemberClubQuerier struct {
-	context *querierContext
-	stmt    *sql.Stmt
+	query func() MemberClubChan
 }
 
 /*
@@ -79,10 +78,13 @@ type MemberDetail struct {
 	MemberCommon
 	Clubs     MemberClubQuerier   `json:"clubs"`
 	Confirmed bool                `json:"confirmed"`
+	Deleted   bool                `json:"deleted"`
 	Gender    encoding.ZeroString `json:"gender"`
 	Mail      string              `json:"mail"`
 	Positions PositionQuerier     `json:"positions"`
 	Tel       encoding.ZeroString `json:"tel"`
+	Version   Version             `json:"-"`
+	end       func() error
 }
 
 // MemberEntry is a structure holding the basic information of a member.
@@ -125,6 +127,46 @@ type MemberMailResult struct {
 // MemberMailChan is a reciever of db.MemberMailResult.
 type MemberMailChan <-chan MemberMailResult
 
+/*
+MemberConfirmation is an unsigned integer which describes the acceptable
+confirmation of members for querying.
+*/
+type MemberConfirmation uint
+
+// These are flags for MemberConfirmation.
+const (
+	MemberConfirmed MemberConfirmation = 1 << iota
+	MemberUnconfirmed
+)
+
+/*
+MemberFilter is a structure describing the members to query. Zero values match
+any member. Affiliation, Nickname and Realname match members whose properties
+contain them. Club is the ID of a club the members belong to.
+*/
+type MemberFilter struct {
+	Affiliation  string
+	Club         string
+	Confirmation MemberConfirmation
+	Entrance     int
+	Nickname     string
+	Realname     string
+	Status       MemberStatus
+}
+
+// MemberOrder is a type describing the property to sort members with.
+type MemberOrder uint
+
+// The values MemberOrder may have.
+const (
+	MemberOrderID MemberOrder = iota
+	MemberOrderEntrance
+	MemberOrderNickname
+	MemberOrderRealname
+
+	memberOrderNumber
+)
+
 // MemberStatus is an unsigned integer which describes the acceptable status
 // of members for querying.
 type MemberStatus uint
@@ -135,6 +177,13 @@ const (
 	MemberStatusActive MemberStatus = 1 << iota
 )
 
+var memberOrderColumns = [...]string{
+	MemberOrderID:       "`display_id`",
+	MemberOrderEntrance: "`entrance`",
+	MemberOrderNickname: "`nickname`",
+	MemberOrderRealname: "`realname`",
+}
+
 type memberAttendance struct {
 	party     uint16
 	attending bool
@@ -152,11 +201,11 @@ type PositionChan <-chan PositionResult
 
 // PositionQuerier is a structure to provide a feature to query db.Position.
 type PositionQuerier struct {
-	context *querierContext
-	stmt    *sql.Stmt
+	query func() PositionChan
 }
 
 type querierContext struct {
+	ctx    context.Context
 	member uint16
 	mutex  sync.Mutex
 	tx     *sql.Tx
@@ -244,7 +293,8 @@ func (positionChan PositionChan) MarshalJSON() ([]byte, error) {
 }
 
 /*
-InsertMember inserts a member with the given properties.
+InsertMember inserts a member with the given properties on behalf of the given
+operator.
 
 It may return one of the following errors:
 db.ErrBadOmission tells the ID, email address, or nickname is omitted.
@@ -253,7 +303,7 @@ db.ErrInvalid tells some of the properties is invalid.
 
 Other errors tell db.DB is bad.
 */
-func (db DB) InsertMember(id, mail, nickname string) error {
+func (db DB) InsertMember(operator, id, mail, nickname string) error {
 	if id == `` || mail == `` || nickname == `` {
 		return ErrBadOmission
 	}
@@ -262,21 +312,24 @@ func (db DB) InsertMember(id, mail, nickname string) error {
 		return ErrInvalid
 	}
 
-	_, err := db.stmts[stmtInsertMember].Exec(id, mail, nickname)
+	return db.publish(newEvent(event.MemberCreated, id, nil), db.transact(func(tx *sql.Tx) error {
+		result, err := tx.Stmt(db.stmts[stmtInsertMember]).Exec(id, mail, nickname)
+		if err != nil {
+			return convertMySQLError(err)
+		}
 
-	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-		switch mysqlErr.Number {
-		case erDataTooLong:
-			fallthrough
-		case erTruncatedWrongValueForField:
-			return ErrInvalid
+		memberDBID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
 
-		case erDupEntry:
-			return ErrDupEntry
+		current, err := db.snapshotMember(tx, uint16(memberDBID))
+		if err != nil {
+			return err
 		}
-	}
 
-	return err
+		return db.recordHistory(tx, operator, HistoryMember, id, nil, current)
+	}))
 }
 
 /*
@@ -286,7 +339,7 @@ It returns db.ErrIncorrectIdentity if the ID is incorrect. Other errors tell
 db.DB is bad.
 */
 func (db DB) DeclareMemberOB(id string) error {
-	result, execErr := db.stmts[stmtDeclareMemberOB].Exec(id)
+	result, execErr := db.stmt(stmtDeclareMemberOB).Exec(id)
 	if execErr != nil {
 		return execErr
 	}
@@ -300,7 +353,7 @@ func (db DB) DeclareMemberOB(id string) error {
 		return ErrIncorrectIdentity
 	}
 
-	return nil
+	return db.publish(newEvent(event.MemberUpdated, id, nil), nil)
 }
 
 /*
@@ -311,7 +364,7 @@ It returns db.ErrIncorrectIdentity if the ID is incorrect. Other errors tell
 db.DB is bad.
 */
 func (db DB) ConfirmMember(id string) error {
-	result, execErr := db.stmts[stmtConfirmMember].Exec(id)
+	result, execErr := db.stmt(stmtConfirmMember).Exec(id)
 	if execErr != nil {
 		return execErr
 	}
@@ -325,35 +378,102 @@ func (db DB) ConfirmMember(id string) error {
 		return ErrIncorrectIdentity
 	}
 
-	return nil
+	return db.publish(newEvent(event.MemberConfirmed, id, nil), nil)
 }
 
 /*
-DeleteMember deletes the member identified by the given ID.
-
-It returns db.ErrMemberIsOfficer if the member is an officer. It returns
-db.ErrIncorrectIdentity if the ID is incorrect. Other errors tell db.DB is bad.
+DeleteMember archives the member identified by the given ID on behalf of the
+given operator. The archived member is hidden from the list of members and
+cannot log in, but the emails and parties referring to the member are kept
+intact. The member can be restored with RestoreMember and erased with
+PurgeMember.
+
+It returns db.ErrMemberIsOfficer if the member is an officer or a chief of a
+club. It returns db.ErrIncorrectIdentity if the ID is incorrect. It returns
+db.ErrVersionMismatch if the member does not have the given version. Other
+errors tell db.DB is bad.
 */
-func (db DB) DeleteMember(id string) error {
-	result, execErr := db.stmts[stmtDeleteMember].Exec(id)
-	if execErr != nil {
-		if mysqlErr, ok := execErr.(*mysql.MySQLError); ok && mysqlErr.Number == erRowIsReferenced || mysqlErr.Number == erRowIsReferenced2 {
+func (db DB) DeleteMember(operator, id string, version Precondition) error {
+	return db.publish(newEvent(event.MemberDeleted, id, nil), db.transact(func(tx *sql.Tx) error {
+		if err := db.checkVersion(tx, versionMember, id, version); err != nil {
+			return err
+		}
+
+		var memberDBID uint16
+
+		if err := tx.Stmt(db.stmts[stmtSelectMemberInternalIDByID]).QueryRow(id).Scan(&memberDBID); err != nil {
+			if err == sql.ErrNoRows {
+				err = ErrIncorrectIdentity
+			}
+
+			return err
+		}
+
+		var officer bool
+
+		if err := tx.Stmt(db.stmts[stmtSelectMemberIsOfficerByInternalID]).QueryRow(memberDBID, memberDBID).Scan(&officer); err != nil {
+			return err
+		}
+
+		if officer {
 			return ErrMemberIsOfficer
 		}
 
-		return execErr
-	}
+		return db.recordMemberChange(tx, operator, id, memberDBID, func() error {
+			_, err := tx.Stmt(db.stmts[stmtArchiveMember]).Exec(memberDBID)
+			return err
+		})
+	}))
+}
 
-	affected, affectedErr := result.RowsAffected()
-	if affectedErr != nil {
-		return affectedErr
-	}
+/*
+PurgeMember erases the archived member identified by the given ID on behalf of
+the given operator. The attendances, the recipients, the club memberships and
+the history of the member will be erased as well, leaving only the record of
+the purge in the history.
 
-	if affected <= 0 {
-		return ErrIncorrectIdentity
-	}
+It returns db.ErrIncorrectIdentity if the ID is incorrect or the member is not
+archived. Other errors tell db.DB is bad.
+*/
+func (db DB) PurgeMember(operator, id string) error {
+	return db.publish(newEvent(event.MemberDeleted, id, nil), db.transact(func(tx *sql.Tx) error {
+		var memberDBID uint16
 
-	return nil
+		if err := tx.Stmt(db.stmts[stmtSelectDeletedMemberInternalIDByID]).QueryRow(id).Scan(&memberDBID); err != nil {
+			if err == sql.ErrNoRows {
+				err = ErrIncorrectIdentity
+			}
+
+			return err
+		}
+
+		old, err := db.snapshotMember(tx, memberDBID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Stmt(db.stmts[stmtDeleteMember]).Exec(memberDBID); err != nil {
+			if mysqlErr, ok := err.(*mysql.MySQLError); ok && (mysqlErr.Number == erRowIsReferenced || mysqlErr.Number == erRowIsReferenced2) {
+				return ErrMemberIsOfficer
+			}
+
+			return err
+		}
+
+		if _, err := tx.Stmt(db.stmts[stmtDeleteHistory]).Exec(string(HistoryMember), id); err != nil {
+			return err
+		}
+
+		// The clubs lose the member as their membership gets erased.
+		for _, club := range strings.Fields(old[`clubs`]) {
+			if err := db.bumpVersion(tx, versionClub, club); err != nil {
+				return err
+			}
+		}
+
+		return db.recordHistory(tx, operator, HistoryMember, id,
+			snapshot{`deleted`: `true`}, nil)
+	}))
 }
 
 /*
@@ -374,29 +494,27 @@ Channels returned by Query or MarshalJSON of Clubs and Positions will block each
 other. For example, if you call Query of Clubs while a channel returned by
 another Query is left open, it will result in dead blocking.
 */
-func (db DB) QueryMemberDetail(id string) (MemberDetail, error) {
+func (db DB) QueryMemberDetail(ctx context.Context, id string) (MemberDetail, error) {
 	var dbFlags string
 	var dbMember uint16
 	var output MemberDetail
 
-	tx, err := db.sql.BeginTx(context.Background(),
-		&sql.TxOptions{
-			Isolation: sql.LevelSerializable,
-			ReadOnly:  true,
-		})
+	ctx, cancel := db.withTimeout(ctx)
+
+	tx, err := db.beginReadOnly(ctx)
 	if err != nil {
+		cancel()
 		return output, err
 	}
 
-	if err := tx.Stmt(db.stmts[stmtSelectMemberByID]).QueryRow(id).Scan(
+	if err := tx.Stmt(db.stmts[stmtSelectMemberByID]).QueryRowContext(ctx, id).Scan(
 		&dbMember, (*string)(&output.Affiliation),
 		(*uint16)(&output.Entrance), &dbFlags,
 		(*string)(&output.Gender), &output.Mail,
 		&output.Nickname, (*string)(&output.Realname),
-		(*string)(&output.Tel)); err != nil {
-		if err := tx.Commit(); err != nil {
-			log.Print(err)
-		}
+		(*string)(&output.Tel), &output.Version); err != nil {
+		db.endReadOnly(tx)
+		cancel()
 
 		if err == sql.ErrNoRows {
 			err = ErrIncorrectIdentity
@@ -412,12 +530,26 @@ func (db DB) QueryMemberDetail(id string) (MemberDetail, error) {
 
 		case `ob`:
 			output.OB = true
+
+		case `deleted`:
+			output.Deleted = true
 		}
 	}
 
-	querier := querierContext{tx: tx, member: dbMember}
-	output.Clubs = MemberClubQuerier{&querier, db.stmts[stmtSelectClubsByInternalMember]}
-	output.Positions = PositionQuerier{&querier, db.stmts[stmtSelectOfficerIDByMemberID]}
+	querier := querierContext{ctx: ctx, tx: tx, member: dbMember}
+
+	output.Clubs = MemberClubQuerier{func() MemberClubChan {
+		return querier.queryClubs(db.stmts[stmtSelectClubsByInternalMember])
+	}}
+
+	output.Positions = PositionQuerier{func() PositionChan {
+		return querier.queryPositions(db.stmts[stmtSelectOfficerIDByMemberID])
+	}}
+
+	output.end = func() error {
+		defer cancel()
+		return db.commitReadOnly(tx)
+	}
 
 	return output, nil
 }
@@ -429,10 +561,13 @@ ID.
 It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
 tell db.DB is bad.
 */
-func (db DB) QueryMemberGraph(id string) (MemberGraph, error) {
+func (db DB) QueryMemberGraph(ctx context.Context, id string) (MemberGraph, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var graph MemberGraph
 
-	err := db.stmts[stmtSelectMemberGraphByID].QueryRow(id).Scan(
+	err := db.stmt(stmtSelectMemberGraphByID).QueryRowContext(ctx, id).Scan(
 		(*string)(&graph.Gender), &graph.Nickname)
 	if err == sql.ErrNoRows {
 		err = ErrIncorrectIdentity
@@ -445,17 +580,26 @@ func (db DB) QueryMemberGraph(id string) (MemberGraph, error) {
 QueryMemberMails returns db.MemberMailChan representing the email addresses of
 all members.
 
-Resources will be holded until the channel gets closed.
+Resources will be holded until the channel gets closed or the given
+context gets done.
 */
-func (db DB) QueryMemberMails() MemberMailChan {
+func (db DB) QueryMemberMails(ctx context.Context) MemberMailChan {
 	resultChan := make(chan MemberMailResult)
+	ctx, cancel := db.withTimeout(ctx)
 
 	go func() {
-		defer close(resultChan)
+		defer func() {
+			close(resultChan)
+			cancel()
+		}()
 
-		rows, err := db.stmts[stmtSelectMemberIDMails].Query()
+		rows, err := db.stmt(stmtSelectMemberIDMails).QueryContext(ctx)
 		if err != nil {
-			resultChan <- MemberMailResult{Error: err}
+			select {
+			case resultChan <- MemberMailResult{Error: err}:
+			case <-ctx.Done():
+			}
+
 			return
 		}
 
@@ -469,11 +613,24 @@ func (db DB) QueryMemberMails() MemberMailChan {
 			var result MemberMailResult
 
 			result.Error = rows.Scan(&result.ID, &result.Mail)
-			resultChan <- result
+
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+				return
+			}
+
 			if result.Error != nil {
 				return
 			}
 		}
+
+		if err := rows.Err(); err != nil {
+			select {
+			case resultChan <- MemberMailResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
 	}()
 
 	return resultChan
@@ -486,10 +643,13 @@ ID.
 It returns ErrIncorrectIdentity if the ID is incorrect. Other errors tell db.DB
 is bad.
 */
-func (db DB) QueryMemberNickname(id string) (string, error) {
+func (db DB) QueryMemberNickname(ctx context.Context, id string) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var nickname string
 
-	err := db.stmts[stmtSelectMemberNicknameByID].QueryRow(id).Scan(&nickname)
+	err := db.stmt(stmtSelectMemberNicknameByID).QueryRowContext(ctx, id).Scan(&nickname)
 	if err == sql.ErrNoRows {
 		err = ErrIncorrectIdentity
 	}
@@ -504,8 +664,11 @@ given ID has not completed his registration.
 It returns db.ErrIncorrectIdentity if the ID is incorrect. Other errors tell
 db.DB is bad.
 */
-func (db DB) QueryMemberTmp(id string) (bool, error) {
-	rows, err := db.stmts[stmtSelectMemberPasswordByID].Query(id)
+func (db DB) QueryMemberTmp(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.stmt(stmtSelectMemberPasswordByID).QueryContext(ctx, id)
 	if err != nil {
 		return false, err
 	}
@@ -535,19 +698,91 @@ func (db DB) QueryMemberTmp(id string) (bool, error) {
 }
 
 /*
-QueryMembers returns db.MemberEntryChan which represents all members.
+QueryMembers returns db.MemberEntryChan which represents the members matching
+the given filter in the given order.
 
-Resources will be holded until the channel gets closed.
+It returns db.ErrInvalid if the given filter or order is invalid. Other errors
+tell db.DB is bad.
+
+Resources will be holded until the channel gets closed or the given
+context gets done.
 */
-func (db DB) QueryMembers() MemberEntryChan {
+func (db DB) QueryMembers(ctx context.Context, filter MemberFilter, order MemberOrder, descending bool) (MemberEntryChan, error) {
+	if order >= memberOrderNumber ||
+		filter.Status&^(MemberStatusOB|MemberStatusActive) != 0 ||
+		filter.Confirmation&^(MemberConfirmed|MemberUnconfirmed) != 0 {
+		return nil, ErrInvalid
+	}
+
+	arguments := append(make([]interface{}, 0, 8),
+		likePattern(filter.Affiliation), likePattern(filter.Nickname),
+		likePattern(filter.Realname))
+
+	if filter.Entrance == 0 {
+		arguments = append(arguments, nil)
+	} else {
+		arguments = append(arguments, filter.Entrance)
+	}
+
+	for _, flag := range [...]struct {
+		set   bool
+		unset bool
+	}{
+		{
+			filter.Status&MemberStatusOB != 0,
+			filter.Status&MemberStatusActive != 0,
+		}, {
+			filter.Confirmation&MemberConfirmed != 0,
+			filter.Confirmation&MemberUnconfirmed != 0,
+		},
+	} {
+		if flag.set == flag.unset {
+			arguments = append(arguments, nil)
+		} else {
+			arguments = append(arguments, flag.set)
+		}
+	}
+
+	if filter.Club == `` {
+		arguments = append(arguments, nil, nil)
+	} else {
+		arguments = append(arguments, filter.Club, filter.Club)
+	}
+
+	direction := ` ASC`
+	if descending {
+		direction = ` DESC`
+	}
+
 	resultChan := make(chan MemberEntryResult)
+	ctx, cancel := db.withTimeout(ctx)
 
 	go func() {
-		defer close(resultChan)
+		defer func() {
+			close(resultChan)
+			cancel()
+		}()
+
+		tx, err := db.beginReadOnly(ctx)
+		if err != nil {
+			select {
+			case resultChan <- MemberEntryResult{Error: err}:
+			case <-ctx.Done():
+			}
 
-		rows, err := db.stmts[stmtSelectMembers].Query()
+			return
+		}
+
+		defer db.endReadOnly(tx)
+
+		rows, err := tx.QueryContext(ctx, "SELECT `affiliation`, `display_id`, `entrance`, CAST(`flags` as int), `nickname`, `realname` FROM `members` WHERE NOT FIND_IN_SET('deleted', `flags`) AND `affiliation` LIKE ? AND `nickname` LIKE ? AND `realname` LIKE ? AND `entrance`=? IS NOT FALSE AND (`flags`&2!=0)=? IS NOT FALSE AND (`flags`&1!=0)=? IS NOT FALSE AND (? IS NULL OR `id` IN (SELECT `club_member`.`member` FROM `club_member` JOIN `clubs` ON `club_member`.`club`=`clubs`.`id` WHERE `clubs`.`display_id`=?)) ORDER BY "+memberOrderColumns[order]+direction+", `display_id`",
+			arguments...)
 		if err != nil {
-			resultChan <- MemberEntryResult{Error: err}
+			select {
+			case resultChan <- MemberEntryResult{Error: err}:
+			case <-ctx.Done():
+			}
+
 			return
 		}
 
@@ -561,19 +796,28 @@ func (db DB) QueryMembers() MemberEntryChan {
 				(*string)(&result.Affiliation), &result.ID,
 				(*uint16)(&result.Entrance), &flags,
 				&result.Nickname, (*string)(&result.Realname))
+			result.OB = flagsHasOB(flags)
 
-			if result.Error == nil {
-				result.OB = flagsHasOB(flags)
-				resultChan <- result
-			} else {
-				resultChan <- result
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+				return
+			}
 
+			if result.Error != nil {
 				return
 			}
 		}
+
+		if err := rows.Err(); err != nil {
+			select {
+			case resultChan <- MemberEntryResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
 	}()
 
-	return resultChan
+	return resultChan, nil
 }
 
 /*
@@ -583,25 +827,10 @@ conditions.
 It returns db.ErrInvalid if the given status is invalid. Other errors tell db.DB
 is bad.
 */
-func (db DB) QueryMembersCount(entrance int, nickname string, realname string,
+func (db DB) QueryMembersCount(ctx context.Context, entrance int, nickname string, realname string,
 	status MemberStatus) (uint16, error) {
-	pattern := func(raw string) string {
-		return strings.Join(
-			[]string{
-				`%`,
-				strings.Replace(
-					strings.Replace(
-						strings.Replace(
-							raw,
-							`\`, `\\`, -1),
-						`%`, `\%`, -1),
-					`_`, `\_`, -1),
-				`%`,
-			}, ``)
-	}
-
 	arguments := append(make([]interface{}, 0, 4),
-		pattern(nickname), pattern(realname))
+		likePattern(nickname), likePattern(realname))
 
 	if entrance == 0 {
 		arguments = append(arguments, nil)
@@ -628,31 +857,71 @@ func (db DB) QueryMembersCount(entrance int, nickname string, realname string,
 
 	var count uint16
 
-	err := db.stmts[stmtCountMembers].QueryRow(arguments...).Scan(&count)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	err := db.stmt(stmtCountMembers).QueryRowContext(ctx, arguments...).Scan(&count)
 
 	return count, err
 }
 
 /*
-UpdateMember updates a member identified with the given ID, with the given
-properties.
+RestoreMember restores the archived member identified by the given ID on
+behalf of the given operator.
+
+It returns db.ErrIncorrectIdentity if the ID is incorrect or the member is not
+archived. Other errors tell db.DB is bad.
+*/
+func (db DB) RestoreMember(operator, id string) error {
+	return db.publish(newEvent(event.MemberUpdated, id, nil), db.transact(func(tx *sql.Tx) error {
+		var memberDBID uint16
+
+		if err := tx.Stmt(db.stmts[stmtSelectDeletedMemberInternalIDByID]).QueryRow(id).Scan(&memberDBID); err != nil {
+			if err == sql.ErrNoRows {
+				err = ErrIncorrectIdentity
+			}
 
-It returns db.ErrIncorrectIdentity if the ID is incorrect. It returns
-db.ErrInvalid if some of the properties is invalid. Other errors tell db.DB is
-bad.
+			return err
+		}
+
+		return db.recordMemberChange(tx, operator, id, memberDBID, func() error {
+			_, err := tx.Stmt(db.stmts[stmtRestoreMember]).Exec(memberDBID)
+			return err
+		})
+	}))
+}
+
+// NoClubsUpdate is a string telling not to update the clubs.
+const NoClubsUpdate = "\000"
+
+/*
+UpdateMember updates a member identified with the given ID, with the given
+properties on behalf of the given operator. Updating the email address revokes
+the confirmation. clubs replaces the clubs of the member unless it is
+db.NoClubsUpdate; an empty list removes the member from all of them.
+
+It returns db.ErrIncorrectIdentity if the ID or one of the IDs of the clubs is
+incorrect. It returns db.ErrDupEntry if the nickname is duplicate. It returns
+db.ErrInvalid if some of the properties is invalid. It returns
+db.ErrVersionMismatch if the member does not have the given version. Other
+errors tell db.DB is bad.
 */
-func (db DB) UpdateMember(id string, confirm, ob bool, password, affiliation, clubs string, entrance int, gender, mail, nickname, realname, tel string) error {
-	andMask := 3
+func (db DB) UpdateMember(operator, id string, confirm, ob bool, password, affiliation, clubs string, entrance int, gender, mail, nickname, realname, tel string, version Precondition) error {
+	const (
+		flagConfirmed = 1 << iota
+		flagOB
+	)
+
+	andMask := flagConfirmed | flagOB
 	orMask := 0
-	arguments := make([]interface{}, 13)
-	arguments[0] = id
+	arguments := make([]interface{}, 11)
 
 	if confirm {
-		orMask |= 2
+		orMask |= flagConfirmed
 	}
 
 	if ob {
-		orMask |= 1
+		orMask |= flagOB
 	}
 
 	if password != `` {
@@ -660,28 +929,24 @@ func (db DB) UpdateMember(id string, confirm, ob bool, password, affiliation, cl
 			return ErrInvalid
 		}
 
-		dbPassword, err := makeDBPassword(password)
+		dbPassword, err := makeDBPassword(db.passwordKey, password)
 		if err != nil {
 			return err
 		}
 
-		arguments[3] = dbPassword
+		arguments[2] = dbPassword
 	}
 
 	if affiliation != `` {
-		arguments[4] = affiliation
-	}
-
-	if clubs != `` {
-		arguments[6], arguments[5] = stringListToDBList(clubs)
+		arguments[3] = affiliation
 	}
 
 	if entrance != 0 {
-		arguments[7] = entrance
+		arguments[4] = entrance
 	}
 
 	if gender != `` {
-		arguments[8] = gender
+		arguments[5] = gender
 	}
 
 	if mail != `` {
@@ -689,9 +954,9 @@ func (db DB) UpdateMember(id string, confirm, ob bool, password, affiliation, cl
 			return ErrInvalid
 		}
 
-		andMask = 1
+		andMask = flagOB
 		orMask &= andMask
-		arguments[9] = mail
+		arguments[6] = mail
 	}
 
 	if nickname != `` {
@@ -699,39 +964,54 @@ func (db DB) UpdateMember(id string, confirm, ob bool, password, affiliation, cl
 			return ErrInvalid
 		}
 
-		arguments[10] = nickname
+		arguments[7] = nickname
 	}
 
 	if realname != `` {
-		arguments[11] = realname
+		arguments[8] = realname
 	}
 
 	if tel != `` {
-		arguments[12] = tel
+		arguments[9] = tel
 	}
 
-	arguments[1] = andMask
-	arguments[2] = orMask
+	arguments[0] = andMask
+	arguments[1] = orMask
 
-	log.Print(arguments)
-	_, err := db.stmts[stmtCallUpdateMember].Exec(arguments...)
-	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-		switch mysqlErr.Number {
-		case erDataTooLong:
-			fallthrough
-		case erTruncatedWrongValueForField:
-			return ErrInvalid
+	return db.publish(newEvent(event.MemberUpdated, id, nil), db.transact(func(tx *sql.Tx) error {
+		if err := db.checkVersion(tx, versionMember, id, version); err != nil {
+			return err
+		}
 
-		case erNoReferencedRow:
-			fallthrough
-		case erNoReferencedRow2:
-			fallthrough
-		case erSignalException:
-			return ErrIncorrectIdentity
+		var memberDBID uint16
+
+		if err := tx.Stmt(db.stmts[stmtSelectMemberInternalIDByID]).QueryRow(id).Scan(&memberDBID); err != nil {
+			if err == sql.ErrNoRows {
+				err = ErrIncorrectIdentity
+			}
+
+			return err
 		}
-	}
 
-	return err
+		arguments[10] = memberDBID
+
+		return db.recordMemberChange(tx, operator, id, memberDBID, func() error {
+			if _, err := tx.Stmt(db.stmts[stmtUpdateMember]).Exec(arguments...); err != nil {
+				return convertMySQLError(err)
+			}
+
+			if clubs == NoClubsUpdate {
+				return nil
+			}
+
+			clubDBIDs, err := queryInternalClubs(tx, splitList(clubs))
+			if err != nil {
+				return err
+			}
+
+			return relationClubMember.replace(tx, memberDBID, clubDBIDs)
+		})
+	}))
 }
 
 /*
@@ -747,7 +1027,7 @@ func (db DB) UpdatePassword(id, currentPassword, newPassword string) error {
 	}
 
 	if err := func() error {
-		rows, err := db.stmts[stmtSelectMemberPasswordByID].Query(id)
+		rows, err := db.stmt(stmtSelectMemberPasswordByID).Query(id)
 		if err != nil {
 			return err
 		}
@@ -765,31 +1045,32 @@ func (db DB) UpdatePassword(id, currentPassword, newPassword string) error {
 			return ErrIncorrectIdentity
 		}
 
-		return verifyPassword(currentPassword, dbPassword)
+		return verifyPassword(db.passwordKey, currentPassword, dbPassword)
 	}(); err != nil {
 		return err
 	}
 
-	newDBPassword, hashErr := makeDBPassword(newPassword)
+	newDBPassword, hashErr := makeDBPassword(db.passwordKey, newPassword)
 	if hashErr != nil {
 		return hashErr
 	}
 
-	result, execErr := db.stmts[stmtUpdateMemberPassword].Exec(newDBPassword, id)
-	if execErr != nil {
-		return execErr
-	}
+	return db.transact(func(tx *sql.Tx) error {
+		var memberDBID uint16
 
-	affected, affectedErr := result.RowsAffected()
-	if affectedErr != nil {
-		return affectedErr
-	}
+		if err := tx.Stmt(db.stmts[stmtSelectMemberInternalIDByID]).QueryRow(id).Scan(&memberDBID); err != nil {
+			if err == sql.ErrNoRows {
+				err = ErrIncorrectIdentity
+			}
 
-	if affected <= 0 {
-		return ErrIncorrectIdentity
-	}
+			return err
+		}
 
-	return nil
+		return db.recordMemberChange(tx, id, id, memberDBID, func() error {
+			_, err := tx.Stmt(db.stmts[stmtUpdateMemberPassword]).Exec(newDBPassword, id)
+			return err
+		})
+	})
 }
 
 /*
@@ -799,17 +1080,95 @@ It will blocks any other operations sharing the context or the database until
 the channel gets closed.
 */
 func (querier MemberClubQuerier) Query() MemberClubChan {
+	return querier.query()
+}
+
+/*
+MarshalJSON returns the JSON encoding of the clubs of a member.
+
+This implements an interface used in encoding/encoding.
+
+json - The Go Programming Language
+Example (CustomMarshalJSON)
+https://golang.org/pkg/encoding/json/#example__customMarshalJSON
+*/
+func (querier MemberClubQuerier) MarshalJSON() ([]byte, error) {
+	return querier.Query().MarshalJSON()
+}
+
+// End ends the transaction and releases all resources.
+func (detail MemberDetail) End() error {
+	return detail.end()
+}
+
+/*
+Query returns db.PositionChan representing the positions of a member.
+
+It will blocks any other operations sharing the context or the database until
+the channel gets closed.
+*/
+func (querier PositionQuerier) Query() PositionChan {
+	return querier.query()
+}
+
+/*
+MarshalJSON returns the JSON encoding of the positions of a member.
+
+This implements an interface used in encoding/encoding.
+
+json - The Go Programming Language
+Example (CustomMarshalJSON)
+https://golang.org/pkg/encoding/json/#example__customMarshalJSON
+*/
+func (querier PositionQuerier) MarshalJSON() ([]byte, error) {
+	return querier.Query().MarshalJSON()
+}
+
+/*
+likePattern returns a pattern for LIKE operator matching strings containing
+the given string.
+*/
+func likePattern(raw string) string {
+	return strings.Join(
+		[]string{
+			`%`,
+			strings.Replace(
+				strings.Replace(
+					strings.Replace(
+						raw,
+						`\`, `\\`, -1),
+					`%`, `\%`, -1),
+				`_`, `\_`, -1),
+			`%`,
+		}, ``)
+}
+
+func flagsHasOB(flags string) bool {
+	for _, flag := range strings.Split(flags, `,`) {
+		if flag == `ob` {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (context *querierContext) queryClubs(stmt *sql.Stmt) MemberClubChan {
 	clubs := make(chan MemberClubResult)
 
 	go func() {
 		defer close(clubs)
 
-		querier.context.mutex.Lock()
-		defer querier.context.mutex.Unlock()
+		context.mutex.Lock()
+		defer context.mutex.Unlock()
 
-		rows, err := querier.context.tx.Stmt(querier.stmt).Query(querier.context.member)
+		rows, err := context.tx.Stmt(stmt).QueryContext(context.ctx, context.member)
 		if err != nil {
-			clubs <- MemberClubResult{Error: err}
+			select {
+			case clubs <- MemberClubResult{Error: err}:
+			case <-context.ctx.Done():
+			}
+
 			return
 		}
 
@@ -823,56 +1182,46 @@ func (querier MemberClubQuerier) Query() MemberClubChan {
 			var result MemberClubResult
 			var clubChief uint16
 			result.Error = rows.Scan(&clubChief, &result.ID)
-			if result.Error != nil {
-				clubs <- result
+			result.Chief = context.member == clubChief
+
+			select {
+			case clubs <- result:
+			case <-context.ctx.Done():
 				return
 			}
 
-			result.Chief = querier.context.member == clubChief
+			if result.Error != nil {
+				return
+			}
+		}
 
-			clubs <- result
+		if err := rows.Err(); err != nil {
+			select {
+			case clubs <- MemberClubResult{Error: err}:
+			case <-context.ctx.Done():
+			}
 		}
 	}()
 
 	return clubs
 }
 
-/*
-MarshalJSON returns the JSON encoding of the clubs of a member.
-
-This implements an interface used in encoding/encoding.
-
-json - The Go Programming Language
-Example (CustomMarshalJSON)
-https://golang.org/pkg/encoding/json/#example__customMarshalJSON
-*/
-func (querier MemberClubQuerier) MarshalJSON() ([]byte, error) {
-	return querier.Query().MarshalJSON()
-}
-
-// End ends the transaction and releases all resources.
-func (detail MemberDetail) End() error {
-	return detail.Clubs.context.tx.Commit()
-}
-
-/*
-Query returns db.PositionChan representing the positions of a member.
-
-It will blocks any other operations sharing the context or the database until
-the channel gets closed.
-*/
-func (querier PositionQuerier) Query() PositionChan {
+func (context *querierContext) queryPositions(stmt *sql.Stmt) PositionChan {
 	positions := make(chan PositionResult)
 
 	go func() {
 		defer close(positions)
 
-		querier.context.mutex.Lock()
-		defer querier.context.mutex.Unlock()
+		context.mutex.Lock()
+		defer context.mutex.Unlock()
 
-		rows, err := querier.context.tx.Stmt(querier.stmt).Query(querier.context.member)
+		rows, err := context.tx.Stmt(stmt).QueryContext(context.ctx, context.member)
 		if err != nil {
-			positions <- PositionResult{Error: err}
+			select {
+			case positions <- PositionResult{Error: err}:
+			case <-context.ctx.Done():
+			}
+
 			return
 		}
 
@@ -886,38 +1235,27 @@ func (querier PositionQuerier) Query() PositionChan {
 			var result PositionResult
 
 			result.Error = rows.Scan(&result.ID)
-			positions <- result
+
+			select {
+			case positions <- result:
+			case <-context.ctx.Done():
+				return
+			}
 
 			if result.Error != nil {
 				return
 			}
 		}
-	}()
-
-	return positions
-}
-
-/*
-MarshalJSON returns the JSON encoding of the positions of a member.
 
-This implements an interface used in encoding/encoding.
-
-json - The Go Programming Language
-Example (CustomMarshalJSON)
-https://golang.org/pkg/encoding/json/#example__customMarshalJSON
-*/
-func (querier PositionQuerier) MarshalJSON() ([]byte, error) {
-	return querier.Query().MarshalJSON()
-}
-
-func flagsHasOB(flags string) bool {
-	for _, flag := range strings.Split(flags, `,`) {
-		if flag == `ob` {
-			return true
+		if err := rows.Err(); err != nil {
+			select {
+			case positions <- PositionResult{Error: err}:
+			case <-context.ctx.Done():
+			}
 		}
-	}
+	}()
 
-	return false
+	return positions
 }
 
 /*
@@ -927,7 +1265,7 @@ func flagsHasOB(flags string) bool {
 	Designed to be compatible with ClientKey for future extensions.
 
 */
-func hashPassword(raw string, salt, prefix []byte) ([]byte, error) {
+func hashPassword(key []byte, raw string, salt, prefix []byte) ([]byte, error) {
 	/*
 		> SaltedPassword  := Hi(Normalize(password), salt, i)
 
@@ -955,7 +1293,7 @@ func hashPassword(raw string, salt, prefix []byte) ([]byte, error) {
 		https://tools.ietf.org/html/rfc5802#section-3
 		> ClientKey       := HMAC(SaltedPassword, "Client Key")
 	*/
-	hmacSHA512 := hmac.New(sha512.New, []byte(configuration.DBPasswordKey))
+	hmacSHA512 := hmac.New(sha512.New, key)
 
 	if _, err := hmacSHA512.Write(saltedPassword); err != nil {
 		return nil, err
@@ -964,18 +1302,18 @@ func hashPassword(raw string, salt, prefix []byte) ([]byte, error) {
 	return hmacSHA512.Sum(prefix), nil
 }
 
-func makeDBPassword(raw string) ([]byte, error) {
+func makeDBPassword(key []byte, raw string) ([]byte, error) {
 	salt := make([]byte, sha512.BlockSize, sha512.BlockSize+sha512.Size)
 
 	if _, err := rand.Read(salt); err != nil {
 		return nil, err
 	}
 
-	return hashPassword(raw, salt, salt)
+	return hashPassword(key, raw, salt, salt)
 }
 
-func verifyPassword(raw string, db []byte) error {
-	hashed, err := hashPassword(raw, db[:sha512.BlockSize], nil)
+func verifyPassword(key []byte, raw string, db []byte) error {
+	hashed, err := hashPassword(key, raw, db[:sha512.BlockSize], nil)
 	if err != nil {
 		return err
 	}
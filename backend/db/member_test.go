@@ -18,6 +18,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"strconv"
 	"testing"
@@ -139,7 +140,7 @@ func (db DB) testQueryMember(t *testing.T) {
 }
 
 func (db DB) testQueryMemberGraph(t *testing.T) {
-	graph, err := db.QueryMemberGraph(`1stDisplayID`)
+	graph, err := db.QueryMemberGraph(context.Background(), `1stDisplayID`)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -152,8 +153,12 @@ func (db DB) testQueryMemberGraph(t *testing.T) {
 
 func (db DB) testQueryMembers(t *testing.T) {
 	const expected = `[{"affiliation":"理学部第一部 数理情報科学科","entrance":1901,"id":"1stDisplayID","nickname":"1 !\\%_1\"#","ob":false,"realname":"$&\\%_2'("},{"entrance":1901,"id":"2ndDisplayID","nickname":"2 !%_1\"#","ob":false,"realname":"$&\\%_2'("},{"entrance":1901,"id":"3rdDisplayID","nickname":"3 !\\%*1\"#","ob":false,"realname":"$&\\%_2'("},{"entrance":1901,"id":"4thDisplayID","nickname":"4 !)_1\"#","ob":false,"realname":"$&\\%_2'("},{"entrance":1901,"id":"5thDisplayID","nickname":"5 !\\%_1\"#","ob":false,"realname":"$&%+2'("},{"entrance":2155,"id":"6thDisplayID","nickname":"6 !\\%_1\"#","ob":false,"realname":"$&\\%+2'("},{"entrance":1901,"id":"7thDisplayID","nickname":"7 !\\%_1\"#","ob":true,"realname":"$&,_2'("}]`
-	result, err := db.QueryMembers().MarshalJSON()
+	entries, err := db.QueryMembers(context.Background(), MemberFilter{}, MemberOrderID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
 
+	result, err := entries.MarshalJSON()
 	if err != nil {
 		t.Error(err)
 	}
@@ -262,7 +267,7 @@ func (db DB) testQueryMembersCount(t *testing.T) {
 
 		t.Run(test.description, func(t *testing.T) {
 			t.Parallel()
-			count, err := db.QueryMembersCount(
+			count, err := db.QueryMembersCount(context.Background(),
 				test.entrance, test.nickname, test.realname,
 				test.status)
 
@@ -0,0 +1,2516 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package db
+
+import (
+	"context"
+	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"github.com/kagucho/tsubonesystem3/backend/event"
+	"github.com/kagucho/tsubonesystem3/backend/scope"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Memory is a structure holding the information in memory instead of the
+database. It implements db.Store, mimicking the constraints of the database, so
+that the handlers can be exercised in process. It should be initialized with
+db.NewMemory.
+
+Memory is only built with the dbtest tag so that it never ships in the
+executables; run the tests with go test -tags dbtest.
+*/
+type Memory struct {
+	mutex      sync.Mutex
+	audit      []AuditEntry
+	clubs      []memoryClub
+	deliveries []Delivery
+	history    []memoryHistory
+	mails      []memoryMail
+	members    []memoryMember
+	officers   []memoryOfficer
+	parties    []memoryParty
+	webhooks   []Webhook
+
+	// lastMail, lastParty and the following are the IDs assigned last.
+	lastMail     uint16
+	lastParty    uint16
+	lastDelivery uint32
+	lastWebhook  uint16
+
+	events *event.Bus
+
+	// held is the events published in Transact, delivered after it succeeds.
+	held *[]event.Event
+}
+
+var _ Store = (*Memory)(nil)
+
+type memoryAttendance struct {
+	member     string
+	attendance Attendance
+}
+
+type memoryClub struct {
+	ClubEntryCommon
+	members []string
+	version Version
+}
+
+type memoryHistory struct {
+	subject HistorySubject
+	target  string
+	HistoryEntry
+}
+
+type memoryMail struct {
+	MailEntry
+	body       string
+	recipients []string
+	version    Version
+}
+
+type memoryMember struct {
+	MemberEntry
+	confirmed bool
+	deleted   bool
+	gender    string
+	mail      string
+	password  []byte
+	tel       string
+	version   Version
+}
+
+type memoryOfficer struct {
+	OfficerEntry
+	scope   string
+	version Version
+}
+
+type memoryParty struct {
+	PartyEntry
+	attendances []memoryAttendance
+	details     string
+	version     Version
+}
+
+/*
+memoryPasswordKey is the key to encrypt passwords in db.Memory. They never
+leave the process, so the key needs no secrecy.
+*/
+var memoryPasswordKey = []byte(`memory`)
+
+// NewMemory returns a new empty db.Memory.
+func NewMemory() *Memory {
+	return &Memory{events: event.New(eventCapacity)}
+}
+
+// Authenticate authenticates the member with the given credentials.
+func (memory *Memory) Authenticate(id, password string) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	return memory.authenticate(id, password)
+}
+
+/*
+ConfirmMember confirms the email address of the member identified by the given
+ID.
+*/
+func (memory *Memory) ConfirmMember(id string) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findMember(id)
+	if index < 0 {
+		return ErrIncorrectIdentity
+	}
+
+	memory.members[index].confirmed = true
+	memory.members[index].version++
+
+	memory.publish(newEvent(event.MemberConfirmed, id, nil))
+
+	return nil
+}
+
+// DeclareMemberOB declares the memeber identified by the given ID is now an OB.
+func (memory *Memory) DeclareMemberOB(id string) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findMember(id)
+	if index < 0 {
+		return ErrIncorrectIdentity
+	}
+
+	memory.members[index].OB = true
+	memory.members[index].version++
+
+	memory.publish(newEvent(event.MemberUpdated, id, nil))
+
+	return nil
+}
+
+/*
+DeleteClub deletes a club identified by the given ID and having the given
+version on behalf of the given operator.
+*/
+func (memory *Memory) DeleteClub(operator, id string, version Precondition) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findClub(id)
+	if index < 0 {
+		return version.missing()
+	}
+
+	if err := matchVersion(memory.clubs[index].version, version); err != nil {
+		return err
+	}
+
+	old := memory.snapshotClub(index)
+	memory.clubs = append(memory.clubs[:index], memory.clubs[index+1:]...)
+	memory.recordHistory(operator, HistoryClub, id, old, nil)
+
+	memory.publish(newEvent(event.ClubDeleted, id, nil))
+
+	return nil
+}
+
+// DeleteMail delets the email with the given subject and version.
+func (memory *Memory) DeleteMail(subject string, version Precondition) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findMail(subject)
+	if index < 0 {
+		return version.missing()
+	}
+
+	if err := matchVersion(memory.mails[index].version, version); err != nil {
+		return err
+	}
+
+	memory.mails = append(memory.mails[:index], memory.mails[index+1:]...)
+
+	memory.publish(newEvent(event.MailDeleted, subject, nil))
+
+	return nil
+}
+
+/*
+DeleteMember archives the member identified by the given ID and having the
+given version on behalf of the given operator.
+
+Like the database, it refuses to archive an officer or a chief of a club.
+*/
+func (memory *Memory) DeleteMember(operator, id string, version Precondition) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findActiveMember(id)
+	if index < 0 {
+		return version.missing()
+	}
+
+	if err := matchVersion(memory.members[index].version, version); err != nil {
+		return err
+	}
+
+	for _, officer := range memory.officers {
+		if officer.Member == id {
+			return ErrMemberIsOfficer
+		}
+	}
+
+	for _, club := range memory.clubs {
+		if club.Chief == id {
+			return ErrMemberIsOfficer
+		}
+	}
+
+	old := memory.snapshotMember(index)
+	memory.members[index].deleted = true
+	memory.recordHistory(operator, HistoryMember, id, old,
+		memory.snapshotMember(index))
+
+	memory.publish(newEvent(event.MemberDeleted, id, nil))
+
+	return nil
+}
+
+/*
+DeleteOfficer deletes an officer identified by the given ID and having the given
+version.
+
+It returns db.ErrOfficerSuicide if the operator would lose the management
+permission.
+*/
+func (memory *Memory) DeleteOfficer(operator, id string, version Precondition) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findOfficer(id)
+	if index < 0 {
+		return version.missing()
+	}
+
+	if err := matchVersion(memory.officers[index].version, version); err != nil {
+		return err
+	}
+
+	if memory.hasManagement(operator, -1, nil) &&
+		!memory.hasManagement(operator, index, nil) {
+		return ErrOfficerSuicide
+	}
+
+	old := memory.snapshotOfficer(index)
+	memory.officers = append(memory.officers[:index], memory.officers[index+1:]...)
+	memory.recordHistory(operator, HistoryOfficer, id, old, nil)
+
+	memory.publish(newEvent(event.OfficerDeleted, id, nil))
+
+	return nil
+}
+
+/*
+DeleteParty deletes a party named with the given name, having the given version
+and created by the member identified by the given ID.
+*/
+func (memory *Memory) DeleteParty(name, creator string, version Precondition) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findParty(name)
+	if index < 0 {
+		return version.missing()
+	}
+
+	if string(memory.parties[index].Creator) != creator {
+		return ErrIncorrectIdentity
+	}
+
+	if err := matchVersion(memory.parties[index].version, version); err != nil {
+		return err
+	}
+
+	old := memory.snapshotParty(index)
+	memory.parties = append(memory.parties[:index], memory.parties[index+1:]...)
+	memory.recordHistory(creator, HistoryParty, name, old, nil)
+
+	memory.publish(newEvent(event.PartyDeleted, name, nil))
+
+	return nil
+}
+
+/*
+DeleteWebhook deletes the webhook identified by the given ID, and its
+deliveries.
+*/
+func (memory *Memory) DeleteWebhook(id uint16) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findWebhook(id)
+	if index < 0 {
+		return ErrIncorrectIdentity
+	}
+
+	memory.webhooks = append(memory.webhooks[:index], memory.webhooks[index+1:]...)
+
+	deliveries := memory.deliveries[:0]
+	for _, delivery := range memory.deliveries {
+		if delivery.Webhook != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	memory.deliveries = deliveries
+
+	return nil
+}
+
+// Events returns event.Bus delivering the changes made with db.Memory.
+func (memory *Memory) Events() *event.Bus {
+	return memory.events
+}
+
+/*
+GetScope returns the scope of the member identified with the given
+credentials.
+*/
+func (memory *Memory) GetScope(id string, password string) (scope.Scope, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	if err := memory.authenticate(id, password); err != nil {
+		return scope.Scope{}, err
+	}
+
+	result := scope.Scope{}.Set(scope.User).Set(scope.Member)
+
+	for _, officer := range memory.officers {
+		if officer.Member != id {
+			continue
+		}
+
+		for _, flag := range strings.Split(officer.scope, `,`) {
+			switch flag {
+			case `management`:
+				result = result.Set(scope.Management)
+
+			case `privacy`:
+				result = result.Set(scope.Privacy)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+/*
+InsertAudit records the given entry in the audit log, chaining it to the last
+recorded entry.
+*/
+func (memory *Memory) InsertAudit(entry AuditEntry) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	entry.Date = encoding.NewTime(entry.Date.Truncate(time.Second))
+	entry.ID = uint32(len(memory.audit) + 1)
+	entry.Previous = nil
+
+	if len(memory.audit) > 0 {
+		entry.Previous = memory.audit[len(memory.audit)-1].Hash
+	}
+
+	entry.Hash = entry.Calculate(entry.Previous)
+	memory.audit = append(memory.audit, entry)
+
+	return nil
+}
+
+/*
+InsertClub inserts a club with the given properties on behalf of the given
+operator.
+*/
+func (memory *Memory) InsertClub(operator, id, name, chief string) error {
+	if id == `` || name == `` {
+		return ErrBadOmission
+	}
+
+	if !validateID(id) || !validateLength(id, 255) || !validateLength(name, 63) {
+		return ErrInvalid
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	for _, club := range memory.clubs {
+		if club.ID == id || club.Name == name {
+			return ErrDupEntry
+		}
+	}
+
+	if memory.findActiveMember(chief) < 0 {
+		return ErrIncorrectIdentity
+	}
+
+	memory.clubs = append(memory.clubs,
+		memoryClub{ClubEntryCommon{ClubCommon{name, chief}, id}, nil, 0})
+	memory.recordHistory(operator, HistoryClub, id, nil,
+		memory.snapshotClub(len(memory.clubs)-1))
+
+	memory.publish(newEvent(event.ClubCreated, id, nil))
+
+	return nil
+}
+
+/*
+InsertDelivery records a pending delivery of the given payload of the given type
+of event to the webhook identified by the given ID, and returns the ID of the
+delivery.
+*/
+func (memory *Memory) InsertDelivery(webhook uint16, kind, payload string) (uint32, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	if memory.findWebhook(webhook) < 0 {
+		return 0, ErrIncorrectIdentity
+	}
+
+	now := encoding.NewTime(time.Now().Truncate(time.Second))
+
+	memory.lastDelivery++
+	memory.deliveries = append(memory.deliveries, Delivery{
+		Date:    now,
+		Event:   kind,
+		ID:      memory.lastDelivery,
+		Next:    &now,
+		Payload: payload,
+		Webhook: webhook,
+	})
+
+	return memory.lastDelivery, nil
+}
+
+/*
+InsertMail inserts an email with the given properties and returns the nickname
+of From and the email addresses of the recipients.
+*/
+func (memory *Memory) InsertMail(recipients, from, to, subject, body string) (string, []string, error) {
+	if recipients == `` || to == `` || subject == `` || body == `` {
+		return ``, nil, ErrBadOmission
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	fromIndex := memory.findMember(from)
+	if fromIndex < 0 {
+		return ``, nil, ErrIncorrectIdentity
+	}
+
+	if !validateLength(to, 63) || !validateLength(subject, 63) ||
+		!validateLength(body, 8192) {
+		return ``, nil, ErrInvalid
+	}
+
+	if memory.findMail(subject) >= 0 {
+		return ``, nil, ErrDupEntry
+	}
+
+	ids, mails, ok := memory.resolveMembers(recipients)
+	if !ok {
+		return ``, nil, ErrIncorrectIdentity
+	}
+
+	for _, mail := range mails {
+		if mail == `` {
+			return ``, nil, ErrIncorrectIdentity
+		}
+	}
+
+	memory.lastMail++
+	memory.mails = append(memory.mails, memoryMail{
+		MailEntry{
+			MailCommon{encoding.NewTime(time.Now()), encoding.ZeroString(from), to},
+			memory.lastMail, subject,
+		}, body, ids, 1,
+	})
+
+	memory.publish(newEvent(event.MailCreated, subject, listAudience(recipients, from)))
+
+	return memory.members[fromIndex].Nickname, mails, nil
+}
+
+/*
+InsertMember inserts a member with the given properties on behalf of the given
+operator.
+*/
+func (memory *Memory) InsertMember(operator, id, mail, nickname string) error {
+	if id == `` || mail == `` || nickname == `` {
+		return ErrBadOmission
+	}
+
+	if !validateID(id) || !validateMemberMail(mail) || !validateMemberNickname(nickname) ||
+		!validateLength(id, 255) || !validateLength(mail, 255) ||
+		!validateLength(nickname, 63) {
+		return ErrInvalid
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	for _, member := range memory.members {
+		if member.ID == id || member.Nickname == nickname {
+			return ErrDupEntry
+		}
+	}
+
+	var member memoryMember
+	member.ID = id
+	member.mail = mail
+	member.Nickname = nickname
+	memory.members = append(memory.members, member)
+	memory.recordHistory(operator, HistoryMember, id, nil,
+		memory.snapshotMember(len(memory.members)-1))
+
+	memory.publish(newEvent(event.MemberCreated, id, nil))
+
+	return nil
+}
+
+/*
+InsertOfficer inserts an operator with the given properties on behalf of the
+given operator.
+*/
+func (memory *Memory) InsertOfficer(operator, id, name, member, scope string) error {
+	if id == `` || name == `` {
+		return ErrBadOmission
+	}
+
+	dbScope, ok := memoryScope(scope)
+	if !ok || !validateLength(id, 255) || !validateLength(name, 63) {
+		return ErrInvalid
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	for _, officer := range memory.officers {
+		if officer.ID == id || officer.Name == name {
+			return ErrDupEntry
+		}
+	}
+
+	if memory.findActiveMember(member) < 0 {
+		return ErrIncorrectIdentity
+	}
+
+	memory.officers = append(memory.officers, memoryOfficer{
+		OfficerEntry{OfficerName{id, name}, member}, dbScope, 0,
+	})
+	memory.recordHistory(operator, HistoryOfficer, id, nil,
+		memory.snapshotOfficer(len(memory.officers)-1))
+
+	memory.publish(newEvent(event.OfficerCreated, id, nil))
+
+	return nil
+}
+
+/*
+InsertParty inserts a party with the given properties and returns the email
+addresses of the invited members.
+*/
+func (memory *Memory) InsertParty(name, creator string, start, end encoding.Time, place string, due encoding.Time, invitedIDs, inviteds, details string) ([]string, error) {
+	if (name == `` || start == encoding.Time{} || end == encoding.Time{} || place == `` || due == encoding.Time{} || invitedIDs == `` || inviteds == `` || details == ``) {
+		return nil, ErrBadOmission
+	}
+
+	if !validateLength(name, 63) || !validateLength(place, 63) ||
+		!validateLength(inviteds, 63) || !validateLength(details, 8192) {
+		return nil, ErrInvalid
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	if memory.findParty(name) >= 0 {
+		return nil, ErrDupEntry
+	}
+
+	if memory.findActiveMember(creator) < 0 {
+		return nil, ErrIncorrectIdentity
+	}
+
+	ids, mails, ok := memory.resolveMembers(invitedIDs)
+	if !ok {
+		return nil, ErrIncorrectIdentity
+	}
+
+	attendances := make([]memoryAttendance, len(ids))
+	for index, id := range ids {
+		attendances[index] = memoryAttendance{id, AttendanceInvited}
+	}
+
+	memory.lastParty++
+	memory.parties = append(memory.parties, memoryParty{
+		PartyEntry{
+			PartyCommon{
+				encoding.ZeroString(creator),
+				start, end, place, inviteds, due,
+			},
+			memory.lastParty, name,
+		}, attendances, details, 0,
+	})
+	memory.recordHistory(creator, HistoryParty, name, nil,
+		memory.snapshotParty(len(memory.parties)-1))
+
+	invitedMails := make([]string, 0, len(mails))
+	for _, mail := range mails {
+		if mail != `` {
+			invitedMails = append(invitedMails, mail)
+		}
+	}
+
+	memory.publish(newEvent(event.PartyCreated, name, listAudience(invitedIDs, creator)))
+
+	return invitedMails, nil
+}
+
+// InsertWebhook inserts a webhook with the given properties and returns its ID.
+func (memory *Memory) InsertWebhook(webhookURL, secret, events string) (uint16, error) {
+	if webhookURL == `` || secret == `` || events == `` {
+		return 0, ErrBadOmission
+	}
+
+	if !validateWebhook(webhookURL, secret, events) {
+		return 0, ErrInvalid
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	memory.lastWebhook++
+	memory.webhooks = append(memory.webhooks,
+		Webhook{events, memory.lastWebhook, secret, webhookURL})
+
+	return memory.lastWebhook, nil
+}
+
+/*
+PurgeMember erases the archived member identified by the given ID on behalf of
+the given operator.
+
+Like the database, it clears the references from emails and parties, and the
+history of the member.
+*/
+func (memory *Memory) PurgeMember(operator, id string) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findMember(id)
+	if index < 0 || !memory.members[index].deleted {
+		return ErrIncorrectIdentity
+	}
+
+	for clubIndex := range memory.clubs {
+		club := &memory.clubs[clubIndex]
+		members := removeString(club.members, id)
+		if len(members) != len(club.members) {
+			club.version++
+		}
+
+		club.members = members
+	}
+
+	for mailIndex := range memory.mails {
+		mail := &memory.mails[mailIndex]
+		mail.recipients = removeString(mail.recipients, id)
+
+		if string(mail.From) == id {
+			mail.From = ``
+		}
+	}
+
+	for partyIndex := range memory.parties {
+		party := &memory.parties[partyIndex]
+		attendances := party.attendances[:0]
+
+		for _, attendance := range party.attendances {
+			if attendance.member != id {
+				attendances = append(attendances, attendance)
+			}
+		}
+
+		party.attendances = attendances
+
+		if string(party.Creator) == id {
+			party.Creator = ``
+		}
+	}
+
+	memory.members = append(memory.members[:index], memory.members[index+1:]...)
+
+	history := memory.history[:0]
+	for _, entry := range memory.history {
+		if entry.subject != HistoryMember || entry.target != id {
+			history = append(history, entry)
+		}
+	}
+
+	memory.history = history
+	memory.recordHistory(operator, HistoryMember, id,
+		snapshot{`deleted`: `true`}, nil)
+
+	memory.publish(newEvent(event.MemberDeleted, id, nil))
+
+	return nil
+}
+
+// QueryClub returns db.Club corresponding with the given ID.
+func (memory *Memory) QueryClub(ctx context.Context, id string) (Club, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findClub(id)
+	if index < 0 {
+		return Club{}, ErrIncorrectIdentity
+	}
+
+	club := memory.clubs[index]
+	clubMembers := append([]string(nil), club.members...)
+	members := make(chan ClubMemberResult)
+
+	go func() {
+		defer close(members)
+
+		for _, member := range clubMembers {
+			select {
+			case members <- ClubMemberResult{ID: member}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return Club{club.ClubCommon, members, club.version}, nil
+}
+
+// QueryClubName returns the name of the club identified with the given ID.
+func (memory *Memory) QueryClubName(ctx context.Context, id string) (string, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findClub(id)
+	if index < 0 {
+		return ``, ErrIncorrectIdentity
+	}
+
+	return memory.clubs[index].Name, nil
+}
+
+/*
+QueryClubs returns db.ClubChan which represents the clubs in the order of their
+IDs, following the given ID and limited to the given number.
+*/
+func (memory *Memory) QueryClubs(ctx context.Context, after string, limit int) (ClubEntryChan, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	var entries []ClubEntry
+	for _, club := range memory.clubs {
+		if club.ID > after {
+			entries = append(entries, ClubEntry{
+				club.ClubEntryCommon,
+				append([]string(nil), club.members...),
+			})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	if limit >= 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	entryChan := make(chan ClubEntry)
+
+	go func() {
+		defer close(entryChan)
+
+		for _, entry := range entries {
+			select {
+			case entryChan <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return entryChan, nil
+}
+
+/*
+QueryAudit returns db.AuditEntryChan representing the entries matching the
+given filter.
+*/
+func (memory *Memory) QueryAudit(ctx context.Context, filter AuditFilter) AuditEntryChan {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	var entries []AuditEntry
+	for _, entry := range memory.audit {
+		if (filter.Actor == `` || string(entry.Actor) == filter.Actor) &&
+			(filter.Route == `` || entry.Route == filter.Route) &&
+			(filter.Since.IsZero() || !entry.Date.Before(filter.Since)) &&
+			(filter.Until.IsZero() || entry.Date.Before(filter.Until)) {
+			entries = append(entries, entry)
+		}
+	}
+
+	resultChan := make(chan AuditEntryResult)
+
+	go func() {
+		defer close(resultChan)
+
+		for _, entry := range entries {
+			select {
+			case resultChan <- AuditEntryResult{AuditEntry: entry}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+/*
+QueryDeliveries returns db.DeliveryChan representing the deliveries matching
+the given filter.
+*/
+func (memory *Memory) QueryDeliveries(ctx context.Context, filter DeliveryFilter) DeliveryChan {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	var deliveries []Delivery
+	for _, delivery := range memory.deliveries {
+		if (filter.Webhook == 0 || delivery.Webhook == filter.Webhook) &&
+			(!filter.Pending || delivery.Next != nil) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	resultChan := make(chan DeliveryResult)
+
+	go func() {
+		defer close(resultChan)
+
+		for _, delivery := range deliveries {
+			select {
+			case resultChan <- DeliveryResult{Delivery: delivery}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+// QueryDelivery returns db.Delivery identified by the given ID.
+func (memory *Memory) QueryDelivery(ctx context.Context, id uint32) (Delivery, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findDelivery(id)
+	if index < 0 {
+		return Delivery{}, ErrIncorrectIdentity
+	}
+
+	return memory.deliveries[index], nil
+}
+
+/*
+QueryHistory returns db.HistoryEntryChan representing the changes of the record
+of the given subject identified by the given target.
+*/
+func (memory *Memory) QueryHistory(ctx context.Context, subject HistorySubject, target string) HistoryEntryChan {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	var entries []HistoryEntry
+	for _, history := range memory.history {
+		if history.subject == subject && history.target == target {
+			entries = append(entries, history.HistoryEntry)
+		}
+	}
+
+	resultChan := make(chan HistoryEntryResult)
+
+	go func() {
+		defer close(resultChan)
+
+		for _, entry := range entries {
+			select {
+			case resultChan <- HistoryEntryResult{HistoryEntry: entry}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+// QueryMail returns db.MailDetail describing the email with the given subject.
+func (memory *Memory) QueryMail(ctx context.Context, subject string) (MailDetail, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findMail(subject)
+	if index < 0 {
+		return MailDetail{}, ErrIncorrectIdentity
+	}
+
+	mail := memory.mails[index]
+	recipients := append([]string(nil), mail.recipients...)
+	recipientChan := make(chan RecipientResult)
+
+	go func() {
+		defer close(recipientChan)
+
+		for _, recipient := range recipients {
+			select {
+			case recipientChan <- RecipientResult{Recipient: recipient}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return MailDetail{mail.MailCommon, recipientChan, mail.body, mail.version}, nil
+}
+
+// QueryMailID returns the internal ID of the email with the given subject.
+func (memory *Memory) QueryMailID(ctx context.Context, subject string) (uint16, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findMail(subject)
+	if index < 0 {
+		return 0, ErrIncorrectIdentity
+	}
+
+	return memory.mails[index].ID, nil
+}
+
+// QueryMailSubject returns the subject of the email with the given internal ID.
+func (memory *Memory) QueryMailSubject(ctx context.Context, id uint16) (string, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	for _, mail := range memory.mails {
+		if mail.ID == id {
+			return mail.Subject, nil
+		}
+	}
+
+	return ``, ErrIncorrectIdentity
+}
+
+/*
+QueryMails returns a channel sending db.MailEntryResult of the mails in the
+order of their internal IDs, following the given internal ID and limited to the
+given number.
+*/
+func (memory *Memory) QueryMails(ctx context.Context, after uint16, limit int) MailEntryChan {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	var mails []MailEntry
+	for _, mail := range memory.mails {
+		if mail.ID > after {
+			mails = append(mails, mail.MailEntry)
+		}
+	}
+
+	sort.Slice(mails, func(i, j int) bool {
+		return mails[i].ID < mails[j].ID
+	})
+
+	if limit >= 0 && len(mails) > limit {
+		mails = mails[:limit]
+	}
+
+	resultChan := make(chan MailEntryResult)
+
+	go func() {
+		defer close(resultChan)
+
+		for _, mail := range mails {
+			select {
+			case resultChan <- MailEntryResult{MailEntry: mail}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+/*
+QueryMemberDetail returns db.MemberDetail of the member identified with the
+given ID.
+
+Unlike db.DB, the clubs and positions are captured when it is called, and End
+does nothing.
+*/
+func (memory *Memory) QueryMemberDetail(ctx context.Context, id string) (MemberDetail, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findMember(id)
+	if index < 0 {
+		return MemberDetail{}, ErrIncorrectIdentity
+	}
+
+	member := memory.members[index]
+	clubs := make([]MemberClub, 0, len(memory.clubs))
+	positions := make([]string, 0, len(memory.officers))
+
+	for _, club := range memory.clubs {
+		for _, clubMember := range club.members {
+			if clubMember == id {
+				clubs = append(clubs, MemberClub{club.Chief == id, club.ID})
+				break
+			}
+		}
+	}
+
+	for _, officer := range memory.officers {
+		if officer.Member == id {
+			positions = append(positions, officer.ID)
+		}
+	}
+
+	return MemberDetail{
+		MemberCommon: member.MemberCommon,
+		Clubs: MemberClubQuerier{func() MemberClubChan {
+			clubChan := make(chan MemberClubResult)
+
+			go func() {
+				defer close(clubChan)
+
+				for _, club := range clubs {
+					select {
+					case clubChan <- MemberClubResult{MemberClub: club}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+
+			return clubChan
+		}},
+		Confirmed: member.confirmed,
+		Deleted:   member.deleted,
+		Gender:    encoding.ZeroString(member.gender),
+		Mail:      member.mail,
+		Positions: PositionQuerier{func() PositionChan {
+			positionChan := make(chan PositionResult)
+
+			go func() {
+				defer close(positionChan)
+
+				for _, position := range positions {
+					select {
+					case positionChan <- PositionResult{ID: position}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+
+			return positionChan
+		}},
+		Tel:     encoding.ZeroString(member.tel),
+		Version: member.version,
+		end:     func() error { return nil },
+	}, nil
+}
+
+/*
+QueryMemberGraph returns db.MemberGraph of the member identified with the given
+ID.
+*/
+func (memory *Memory) QueryMemberGraph(ctx context.Context, id string) (MemberGraph, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findMember(id)
+	if index < 0 {
+		return MemberGraph{}, ErrIncorrectIdentity
+	}
+
+	member := memory.members[index]
+
+	return MemberGraph{member.gender, member.Nickname}, nil
+}
+
+/*
+QueryMemberMails returns db.MemberMailChan representing the email addresses of
+all members.
+*/
+func (memory *Memory) QueryMemberMails(ctx context.Context) MemberMailChan {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	results := make([]MemberMailResult, 0, len(memory.members))
+	for _, member := range memory.members {
+		if !member.deleted {
+			results = append(results,
+				MemberMailResult{ID: member.ID, Mail: member.mail})
+		}
+	}
+
+	resultChan := make(chan MemberMailResult)
+
+	go func() {
+		defer close(resultChan)
+
+		for _, result := range results {
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+/*
+QueryMemberNickname returns the nickname of the member identified by the given
+ID.
+*/
+func (memory *Memory) QueryMemberNickname(ctx context.Context, id string) (string, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findMember(id)
+	if index < 0 {
+		return ``, ErrIncorrectIdentity
+	}
+
+	return memory.members[index].Nickname, nil
+}
+
+/*
+QueryMemberTmp returns a Boolean telling whether the member identified by the
+given ID has not completed his registration.
+*/
+func (memory *Memory) QueryMemberTmp(ctx context.Context, id string) (bool, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findActiveMember(id)
+	if index < 0 {
+		return false, ErrIncorrectIdentity
+	}
+
+	return memory.members[index].password == nil, nil
+}
+
+/*
+QueryMembers returns db.MemberEntryChan which represents the members matching
+the given filter in the given order.
+*/
+func (memory *Memory) QueryMembers(ctx context.Context, filter MemberFilter, order MemberOrder, descending bool) (MemberEntryChan, error) {
+	if order >= memberOrderNumber ||
+		filter.Status&^(MemberStatusOB|MemberStatusActive) != 0 ||
+		filter.Confirmation&^(MemberConfirmed|MemberUnconfirmed) != 0 {
+		return nil, ErrInvalid
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	entries := make([]MemberEntry, 0, len(memory.members))
+	for _, member := range memory.members {
+		if member.deleted ||
+			!strings.Contains(string(member.Affiliation), filter.Affiliation) ||
+			!strings.Contains(member.Nickname, filter.Nickname) ||
+			!strings.Contains(string(member.Realname), filter.Realname) ||
+			(filter.Entrance != 0 && int(member.Entrance) != filter.Entrance) {
+			continue
+		}
+
+		if filter.Status != 0 &&
+			(member.OB && filter.Status&MemberStatusOB == 0 ||
+				!member.OB && filter.Status&MemberStatusActive == 0) {
+			continue
+		}
+
+		if filter.Confirmation != 0 &&
+			(member.confirmed && filter.Confirmation&MemberConfirmed == 0 ||
+				!member.confirmed && filter.Confirmation&MemberUnconfirmed == 0) {
+			continue
+		}
+
+		if filter.Club != `` && !memory.hasClubMember(filter.Club, member.ID) {
+			continue
+		}
+
+		entries = append(entries, member.MemberEntry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		var less bool
+		var greater bool
+
+		switch order {
+		case MemberOrderID:
+			less = entries[i].ID < entries[j].ID
+			greater = entries[i].ID > entries[j].ID
+
+		case MemberOrderEntrance:
+			less = entries[i].Entrance < entries[j].Entrance
+			greater = entries[i].Entrance > entries[j].Entrance
+
+		case MemberOrderNickname:
+			less = entries[i].Nickname < entries[j].Nickname
+			greater = entries[i].Nickname > entries[j].Nickname
+
+		case MemberOrderRealname:
+			less = entries[i].Realname < entries[j].Realname
+			greater = entries[i].Realname > entries[j].Realname
+		}
+
+		if descending {
+			less, greater = greater, less
+		}
+
+		if less || greater {
+			return less
+		}
+
+		return entries[i].ID < entries[j].ID
+	})
+
+	resultChan := make(chan MemberEntryResult)
+
+	go func() {
+		defer close(resultChan)
+
+		for _, entry := range entries {
+			select {
+			case resultChan <- MemberEntryResult{MemberEntry: entry}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resultChan, nil
+}
+
+/*
+QueryMembersCount returns the number of the members who matches the given
+conditions.
+*/
+func (memory *Memory) QueryMembersCount(ctx context.Context, entrance int, nickname string, realname string, status MemberStatus) (uint16, error) {
+	switch status {
+	case 0:
+		return 0, nil
+
+	case MemberStatusOB, MemberStatusActive, MemberStatusOB | MemberStatusActive:
+
+	default:
+		return 0, ErrInvalid
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	var count uint16
+
+	for _, member := range memory.members {
+		if member.deleted ||
+			!strings.Contains(member.Nickname, nickname) ||
+			!strings.Contains(string(member.Realname), realname) ||
+			(entrance != 0 && int(member.Entrance) != entrance) {
+			continue
+		}
+
+		if member.OB && status&MemberStatusOB == 0 ||
+			!member.OB && status&MemberStatusActive == 0 {
+			continue
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+/*
+QueryOfficerDetail returns db.OfficerDetail of the officer identified with the
+given ID.
+*/
+func (memory *Memory) QueryOfficerDetail(ctx context.Context, id string) (OfficerDetail, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findOfficer(id)
+	if index < 0 {
+		return OfficerDetail{}, ErrIncorrectIdentity
+	}
+
+	officer := memory.officers[index]
+
+	return OfficerDetail{
+		officer.Member, officer.Name, strings.Split(officer.scope, `,`),
+		officer.version,
+	}, nil
+}
+
+// QueryOfficerName returns the name of the officer identified with the given ID.
+func (memory *Memory) QueryOfficerName(ctx context.Context, id string) (string, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findOfficer(id)
+	if index < 0 {
+		return ``, ErrIncorrectIdentity
+	}
+
+	return memory.officers[index].Name, nil
+}
+
+/*
+QueryOfficerNames returns db.OfficerNameChan representing the names of all
+officers.
+*/
+func (memory *Memory) QueryOfficerNames(ctx context.Context) OfficerNameChan {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	names := make([]OfficerName, len(memory.officers))
+	for index, officer := range memory.officers {
+		names[index] = officer.OfficerName
+	}
+
+	resultChan := make(chan OfficerNameResult)
+
+	go func() {
+		defer close(resultChan)
+
+		for _, name := range names {
+			select {
+			case resultChan <- OfficerNameResult{OfficerName: name}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+/*
+QueryOfficers returns db.OfficerEntryChan which represents the officers in the
+order of their IDs, following the given ID and limited to the given number.
+*/
+func (memory *Memory) QueryOfficers(ctx context.Context, after string, limit int) OfficerEntryChan {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	var entries []OfficerEntry
+	for _, officer := range memory.officers {
+		if officer.ID > after {
+			entries = append(entries, officer.OfficerEntry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	if limit >= 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	resultChan := make(chan OfficerEntryResult)
+
+	go func() {
+		defer close(resultChan)
+
+		for _, entry := range entries {
+			select {
+			case resultChan <- OfficerEntryResult{OfficerEntry: entry}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+/*
+QueryParties returns db.PartyUserChan representing the parties in the order of
+their internal IDs, following the given internal ID and limited to the given
+number.
+*/
+func (memory *Memory) QueryParties(ctx context.Context, user string, after uint16, limit int) PartyUserChan {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	var parties []PartyUser
+	for _, party := range memory.parties {
+		if party.ID <= after {
+			continue
+		}
+
+		partyUser := PartyUser{PartyEntry: party.PartyEntry}
+
+		for _, attendance := range party.attendances {
+			if attendance.member == user {
+				partyUser.User = attendance.attendance
+				break
+			}
+		}
+
+		parties = append(parties, partyUser)
+	}
+
+	sort.Slice(parties, func(i, j int) bool {
+		return parties[i].ID < parties[j].ID
+	})
+
+	if limit >= 0 && len(parties) > limit {
+		parties = parties[:limit]
+	}
+
+	resultChan := make(chan PartyUserResult)
+
+	go func() {
+		defer close(resultChan)
+
+		for _, party := range parties {
+			select {
+			case resultChan <- PartyUserResult{PartyUser: party}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+// QueryPartyID returns the internal ID of the party with the given name.
+func (memory *Memory) QueryPartyID(ctx context.Context, name string) (uint16, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findParty(name)
+	if index < 0 {
+		return 0, ErrIncorrectIdentity
+	}
+
+	return memory.parties[index].ID, nil
+}
+
+// QueryPartyName returns the name of the party with the given internal ID.
+func (memory *Memory) QueryPartyName(ctx context.Context, id uint16) (string, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	for _, party := range memory.parties {
+		if party.ID == id {
+			return party.Name, nil
+		}
+	}
+
+	return ``, ErrIncorrectIdentity
+}
+
+// QueryParty queries details of a party identified by the given name.
+func (memory *Memory) QueryParty(ctx context.Context, name string) (PartyDetail, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findParty(name)
+	if index < 0 {
+		return PartyDetail{}, ErrIncorrectIdentity
+	}
+
+	party := memory.parties[index]
+	attendances := append([]memoryAttendance(nil), party.attendances...)
+	attendanceChan := make(chan PartyAttendanceResult)
+
+	go func() {
+		defer close(attendanceChan)
+
+		for _, attendance := range attendances {
+			select {
+			case attendanceChan <- PartyAttendanceResult{
+				Member:     attendance.member,
+				Attendance: attendance.attendance,
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return PartyDetail{party.PartyCommon, party.details, attendanceChan, party.version}, nil
+}
+
+// QueryWebhook returns db.Webhook identified by the given ID.
+func (memory *Memory) QueryWebhook(ctx context.Context, id uint16) (Webhook, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findWebhook(id)
+	if index < 0 {
+		return Webhook{}, ErrIncorrectIdentity
+	}
+
+	return memory.webhooks[index], nil
+}
+
+// QueryWebhooks returns db.WebhookChan representing all the webhooks.
+func (memory *Memory) QueryWebhooks(ctx context.Context) WebhookChan {
+	memory.mutex.Lock()
+	webhooks := append([]Webhook(nil), memory.webhooks...)
+	memory.mutex.Unlock()
+
+	resultChan := make(chan WebhookResult)
+
+	go func() {
+		defer close(resultChan)
+
+		for _, webhook := range webhooks {
+			select {
+			case resultChan <- WebhookResult{Webhook: webhook}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+/*
+RestoreMember restores the archived member identified by the given ID on behalf
+of the given operator.
+*/
+func (memory *Memory) RestoreMember(operator, id string) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findMember(id)
+	if index < 0 || !memory.members[index].deleted {
+		return ErrIncorrectIdentity
+	}
+
+	old := memory.snapshotMember(index)
+	memory.members[index].deleted = false
+	memory.recordHistory(operator, HistoryMember, id, old,
+		memory.snapshotMember(index))
+
+	memory.publish(newEvent(event.MemberUpdated, id, nil))
+
+	return nil
+}
+
+/*
+Transact calls the given function with db.Memory. The changes made by the
+function will be undone if it returns an error, and its events will be
+delivered only if it succeeds.
+
+Unlike db.DB, the function is not isolated from the other goroutines. The
+entries of the audit log are kept as the log is append-only.
+*/
+func (memory *Memory) Transact(function func(Store) error) error {
+	var held []event.Event
+
+	memory.mutex.Lock()
+	restore := memory.checkpoint()
+	memory.held = &held
+	memory.mutex.Unlock()
+
+	err := function(memory)
+
+	memory.mutex.Lock()
+	memory.held = nil
+	if err != nil {
+		restore()
+	}
+	memory.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	for _, published := range held {
+		memory.events.Publish(published)
+	}
+
+	return nil
+}
+
+/*
+UpdateAttendance updates attendance of a member identified by the given ID for
+the party identified by the given name and having the given version.
+*/
+func (memory *Memory) UpdateAttendance(attending bool, party, member string, version Precondition) error {
+	attendance := AttendanceDeclined
+	if attending {
+		attendance = AttendanceAccepted
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findParty(party)
+	if index < 0 {
+		return version.missing()
+	}
+
+	if err := matchVersion(memory.parties[index].version, version); err != nil {
+		return err
+	}
+
+	old := memory.snapshotParty(index)
+	attendances := memory.parties[index].attendances
+	for attendanceIndex := range attendances {
+		if attendances[attendanceIndex].member == member {
+			attendances[attendanceIndex].attendance = attendance
+			memory.recordHistory(member, HistoryParty, party, old,
+				memory.snapshotParty(index))
+			memory.publish(newEvent(event.AttendanceUpdated, party, nil))
+
+			return nil
+		}
+	}
+
+	return ErrIncorrectIdentity
+}
+
+/*
+UpdateClub updates the club identified with the given ID and having the given
+version, with the given properties on behalf of the given operator.
+*/
+func (memory *Memory) UpdateClub(operator, id, name, chief string, version Precondition) error {
+	if !validateLength(name, 63) {
+		return ErrInvalid
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findClub(id)
+	if index < 0 {
+		return version.missing()
+	}
+
+	if err := matchVersion(memory.clubs[index].version, version); err != nil {
+		return err
+	}
+
+	club := memory.clubs[index]
+
+	if name != `` {
+		for otherIndex, other := range memory.clubs {
+			if otherIndex != index && other.Name == name {
+				return ErrDupEntry
+			}
+		}
+
+		club.Name = name
+	}
+
+	if chief != `` {
+		if memory.findActiveMember(chief) < 0 {
+			return ErrIncorrectIdentity
+		}
+
+		club.Chief = chief
+	}
+
+	old := memory.snapshotClub(index)
+	memory.clubs[index] = club
+	memory.recordHistory(operator, HistoryClub, id, old,
+		memory.snapshotClub(index))
+
+	memory.publish(newEvent(event.ClubUpdated, id, nil))
+
+	return nil
+}
+
+/*
+UpdateDelivery records an attempt of the delivery identified by the given ID,
+which got the given status and error message. The delivery will be attempted
+again at the given time unless it is zero.
+*/
+func (memory *Memory) UpdateDelivery(id uint32, status uint16, message string, next time.Time) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findDelivery(id)
+	if index < 0 {
+		return ErrIncorrectIdentity
+	}
+
+	if len(message) > 255 {
+		message = message[:255]
+	}
+
+	delivery := &memory.deliveries[index]
+	if delivery.Attempts < 255 {
+		delivery.Attempts++
+	}
+
+	delivery.Status = status
+	delivery.Error = message
+	delivery.Next = nil
+
+	if !next.IsZero() {
+		encoded := encoding.NewTime(next.Truncate(time.Second))
+		delivery.Next = &encoded
+	}
+
+	return nil
+}
+
+/*
+UpdateMail updates the email with the given subject and version, with the given
+properties.
+*/
+func (memory *Memory) UpdateMail(subject, recipients string, date encoding.Time, from, to, body string, version Precondition) error {
+	if !validateLength(to, 63) || !validateLength(body, 8192) {
+		return ErrInvalid
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findMail(subject)
+	if index < 0 {
+		return version.missing()
+	}
+
+	if err := matchVersion(memory.mails[index].version, version); err != nil {
+		return err
+	}
+
+	mail := memory.mails[index]
+
+	if recipients != `` {
+		ids, _, ok := memory.resolveMembers(recipients)
+		if !ok {
+			return ErrIncorrectIdentity
+		}
+
+		mail.recipients = ids
+	}
+
+	if (date != encoding.Time{}) {
+		mail.Date = date
+	}
+
+	if from != `` {
+		if memory.findActiveMember(from) < 0 {
+			return ErrIncorrectIdentity
+		}
+
+		mail.From = encoding.ZeroString(from)
+	}
+
+	if to != `` {
+		mail.To = to
+	}
+
+	if body != `` {
+		mail.body = body
+	}
+
+	mail.version++
+	memory.mails[index] = mail
+
+	memory.publish(newEvent(event.MailUpdated, subject, listAudience(recipients)))
+
+	return nil
+}
+
+/*
+UpdateMember updates a member identified with the given ID and having the given
+version, with the given properties on behalf of the given operator.
+
+Changing the email address revokes its confirmation.
+*/
+func (memory *Memory) UpdateMember(operator, id string, confirm, ob bool, password, affiliation, clubs string, entrance int, gender, mail, nickname, realname, tel string, version Precondition) error {
+	if !validateLength(affiliation, 63) || !validateLength(gender, 63) ||
+		!validateLength(mail, 255) || !validateLength(nickname, 63) ||
+		!validateLength(realname, 63) || !validateLength(tel, 255) ||
+		entrance != 0 && (entrance < EntranceMin || entrance > EntranceMax) {
+		return ErrInvalid
+	}
+
+	if mail != `` && !validateMemberMail(mail) {
+		return ErrInvalid
+	}
+
+	if nickname != `` && !validateMemberNickname(nickname) {
+		return ErrInvalid
+	}
+
+	var dbPassword []byte
+	if password != `` {
+		if !validateMemberPassword(password) {
+			return ErrInvalid
+		}
+
+		var err error
+		dbPassword, err = makeDBPassword(memoryPasswordKey, password)
+		if err != nil {
+			return err
+		}
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findActiveMember(id)
+	if index < 0 {
+		return version.missing()
+	}
+
+	if err := matchVersion(memory.members[index].version, version); err != nil {
+		return err
+	}
+
+	old := memory.snapshotMember(index)
+	member := memory.members[index]
+
+	if confirm {
+		member.confirmed = true
+	}
+
+	if ob {
+		member.OB = true
+	}
+
+	if dbPassword != nil {
+		member.password = dbPassword
+	}
+
+	if affiliation != `` {
+		member.Affiliation = encoding.ZeroString(affiliation)
+	}
+
+	var clubIndices []int
+	if clubs != NoClubsUpdate {
+		for _, club := range splitList(clubs) {
+			clubIndex := memory.findClub(club)
+			if clubIndex < 0 {
+				return ErrIncorrectIdentity
+			}
+
+			clubIndices = append(clubIndices, clubIndex)
+		}
+	}
+
+	if entrance != 0 {
+		member.Entrance = encoding.ZeroUint16(entrance)
+	}
+
+	if gender != `` {
+		member.gender = gender
+	}
+
+	if mail != `` {
+		member.mail = mail
+		member.confirmed = false
+	}
+
+	if nickname != `` {
+		for otherIndex, other := range memory.members {
+			if otherIndex != index && other.Nickname == nickname {
+				return ErrDupEntry
+			}
+		}
+
+		member.Nickname = nickname
+	}
+
+	if realname != `` {
+		member.Realname = encoding.ZeroString(realname)
+	}
+
+	if tel != `` {
+		member.tel = tel
+	}
+
+	if clubs != NoClubsUpdate {
+		for clubIndex := range memory.clubs {
+			club := &memory.clubs[clubIndex]
+			club.members = removeString(club.members, id)
+		}
+
+		for _, clubIndex := range clubIndices {
+			club := &memory.clubs[clubIndex]
+			club.members = append(club.members, id)
+		}
+	}
+
+	memory.members[index] = member
+	memory.recordHistory(operator, HistoryMember, id, old,
+		memory.snapshotMember(index))
+
+	memory.publish(newEvent(event.MemberUpdated, id, nil))
+
+	return nil
+}
+
+/*
+UpdateOfficer updates the officer identified by the given ID and having the
+given version, with the given properties.
+
+It returns db.ErrOfficerSuicide if the operator would lose the management
+permission.
+*/
+func (memory *Memory) UpdateOfficer(operator, id, name, member, scope string, version Precondition) error {
+	if !validateLength(name, 63) {
+		return ErrInvalid
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findOfficer(id)
+	if index < 0 {
+		return version.missing()
+	}
+
+	if err := matchVersion(memory.officers[index].version, version); err != nil {
+		return err
+	}
+
+	officer := memory.officers[index]
+
+	if name != `` {
+		for otherIndex, other := range memory.officers {
+			if otherIndex != index && other.Name == name {
+				return ErrDupEntry
+			}
+		}
+
+		officer.Name = name
+	}
+
+	if member != `` {
+		if memory.findMember(member) < 0 {
+			return ErrIncorrectIdentity
+		}
+
+		officer.Member = member
+	}
+
+	if scope != NoScopeUpdate {
+		var ok bool
+
+		officer.scope, ok = memoryScope(scope)
+		if !ok {
+			return ErrInvalid
+		}
+	}
+
+	if memory.hasManagement(operator, -1, nil) &&
+		!memory.hasManagement(operator, index, &officer) {
+		return ErrOfficerSuicide
+	}
+
+	old := memory.snapshotOfficer(index)
+	memory.officers[index] = officer
+	memory.recordHistory(operator, HistoryOfficer, id, old,
+		memory.snapshotOfficer(index))
+
+	memory.publish(newEvent(event.OfficerUpdated, id, nil))
+
+	return nil
+}
+
+/*
+UpdateParty updates the party identified by the given name, having the given
+version and created by the member identified by the given ID, with the given
+properties.
+
+The attendances of the members kept invited are preserved.
+*/
+func (memory *Memory) UpdateParty(name, creator string, start, end encoding.Time, place string, due encoding.Time, inviteds, invitedIDs, details string, version Precondition) error {
+	if !validateLength(place, 63) || !validateLength(inviteds, 63) ||
+		!validateLength(details, 8192) {
+		return ErrInvalid
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	index := memory.findParty(name)
+	if index < 0 {
+		return version.missing()
+	}
+
+	if string(memory.parties[index].Creator) != creator {
+		return ErrIncorrectIdentity
+	}
+
+	if err := matchVersion(memory.parties[index].version, version); err != nil {
+		return err
+	}
+
+	party := memory.parties[index]
+
+	if (start != encoding.Time{}) {
+		party.Start = start
+	}
+
+	if (end != encoding.Time{}) {
+		party.End = end
+	}
+
+	if place != `` {
+		party.Place = place
+	}
+
+	if (due != encoding.Time{}) {
+		party.Due = due
+	}
+
+	if inviteds != `` {
+		party.Inviteds = inviteds
+	}
+
+	if invitedIDs != `` {
+		ids, _, ok := memory.resolveMembers(invitedIDs)
+		if !ok {
+			return ErrIncorrectIdentity
+		}
+
+		attendances := make([]memoryAttendance, len(ids))
+
+	Invited:
+		for attendanceIndex, id := range ids {
+			for _, attendance := range party.attendances {
+				if attendance.member == id {
+					attendances[attendanceIndex] = attendance
+					continue Invited
+				}
+			}
+
+			attendances[attendanceIndex] = memoryAttendance{id, AttendanceInvited}
+		}
+
+		party.attendances = attendances
+	}
+
+	if details != `` {
+		party.details = details
+	}
+
+	old := memory.snapshotParty(index)
+	memory.parties[index] = party
+	memory.recordHistory(creator, HistoryParty, name, old,
+		memory.snapshotParty(index))
+
+	memory.publish(newEvent(event.PartyUpdated, name, listAudience(invitedIDs, creator)))
+
+	return nil
+}
+
+// UpdatePassword updates.the password of the member identified by the given ID.
+func (memory *Memory) UpdatePassword(id, currentPassword, newPassword string) error {
+	if !validateMemberPassword(newPassword) {
+		return ErrInvalid
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	if err := memory.authenticate(id, currentPassword); err != nil {
+		return err
+	}
+
+	dbPassword, err := makeDBPassword(memoryPasswordKey, newPassword)
+	if err != nil {
+		return err
+	}
+
+	index := memory.findMember(id)
+	old := memory.snapshotMember(index)
+	memory.members[index].password = dbPassword
+	memory.recordHistory(id, HistoryMember, id, old,
+		memory.snapshotMember(index))
+
+	return nil
+}
+
+func (memory *Memory) authenticate(id, password string) error {
+	index := memory.findActiveMember(id)
+	if index < 0 || memory.members[index].password == nil {
+		return ErrIncorrectIdentity
+	}
+
+	return verifyPassword(memoryPasswordKey, password, memory.members[index].password)
+}
+
+/*
+checkpoint returns a function restoring the records except the audit log and
+the deliveries, which are recorded out of transactions.
+*/
+func (memory *Memory) checkpoint() func() {
+	clubs := make([]memoryClub, len(memory.clubs))
+	for index, club := range memory.clubs {
+		club.members = append([]string(nil), club.members...)
+		clubs[index] = club
+	}
+
+	history := append([]memoryHistory(nil), memory.history...)
+
+	mails := make([]memoryMail, len(memory.mails))
+	for index, mail := range memory.mails {
+		mail.recipients = append([]string(nil), mail.recipients...)
+		mails[index] = mail
+	}
+
+	members := append([]memoryMember(nil), memory.members...)
+	officers := append([]memoryOfficer(nil), memory.officers...)
+
+	parties := make([]memoryParty, len(memory.parties))
+	for index, party := range memory.parties {
+		party.attendances = append([]memoryAttendance(nil), party.attendances...)
+		parties[index] = party
+	}
+
+	webhooks := append([]Webhook(nil), memory.webhooks...)
+
+	lastMail := memory.lastMail
+	lastParty := memory.lastParty
+	lastWebhook := memory.lastWebhook
+
+	return func() {
+		memory.clubs = clubs
+		memory.history = history
+		memory.mails = mails
+		memory.members = members
+		memory.officers = officers
+		memory.parties = parties
+		memory.webhooks = webhooks
+		memory.lastMail = lastMail
+		memory.lastParty = lastParty
+		memory.lastWebhook = lastWebhook
+	}
+}
+
+/*
+publish publishes the given event, or holds it if db.Memory is in Transact. The
+mutex must be locked.
+*/
+func (memory *Memory) publish(published event.Event) {
+	if memory.held != nil {
+		*memory.held = append(*memory.held, published)
+	} else {
+		memory.events.Publish(published)
+	}
+}
+
+func (memory *Memory) findActiveMember(id string) int {
+	index := memory.findMember(id)
+	if index >= 0 && memory.members[index].deleted {
+		return -1
+	}
+
+	return index
+}
+
+func (memory *Memory) findClub(id string) int {
+	for index, club := range memory.clubs {
+		if club.ID == id {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func (memory *Memory) findDelivery(id uint32) int {
+	for index, delivery := range memory.deliveries {
+		if delivery.ID == id {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func (memory *Memory) findMail(subject string) int {
+	for index, mail := range memory.mails {
+		if mail.Subject == subject {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func (memory *Memory) findMember(id string) int {
+	for index, member := range memory.members {
+		if member.ID == id {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func (memory *Memory) findOfficer(id string) int {
+	for index, officer := range memory.officers {
+		if officer.ID == id {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func (memory *Memory) findParty(name string) int {
+	for index, party := range memory.parties {
+		if party.Name == name {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func (memory *Memory) findWebhook(id uint16) int {
+	for index, webhook := range memory.webhooks {
+		if webhook.ID == id {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func (memory *Memory) hasClubMember(club, member string) bool {
+	index := memory.findClub(club)
+	if index < 0 {
+		return false
+	}
+
+	for _, clubMember := range memory.clubs[index].members {
+		if clubMember == member {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+hasManagement returns whether the member identified by the given ID has the
+management permission when the officer at the given index is replaced with the
+given one. A nil replacement means the officer is removed. Give a negative
+index to test the current state.
+*/
+func (memory *Memory) hasManagement(member string, index int, replacement *memoryOfficer) bool {
+	for current, officer := range memory.officers {
+		if current == index {
+			if replacement == nil {
+				continue
+			}
+
+			officer = *replacement
+		}
+
+		if officer.Member != member {
+			continue
+		}
+
+		for _, flag := range strings.Split(officer.scope, `,`) {
+			if flag == `management` {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/*
+resolveMembers returns the IDs and email addresses of the members listed in
+the given string list. The returned Boolean is false if some of the IDs is
+incorrect.
+*/
+func (memory *Memory) resolveMembers(list string) ([]string, []string, bool) {
+	ids := splitList(list)
+	mails := make([]string, len(ids))
+
+	for index, id := range ids {
+		member := memory.findActiveMember(id)
+		if member < 0 {
+			return nil, nil, false
+		}
+
+		mails[index] = memory.members[member].mail
+	}
+
+	return ids, mails, true
+}
+
+/*
+recordHistory records the changes from the old snapshot to the new one made by
+the given operator, and increments the versions of the changed records.
+*/
+func (memory *Memory) recordHistory(operator string, subject HistorySubject, target string, old, current snapshot) {
+	date := encoding.NewTime(time.Now())
+	changes := old.diff(current)
+	if len(changes) <= 0 {
+		return
+	}
+
+	for _, change := range changes {
+		memory.history = append(memory.history, memoryHistory{
+			subject, target,
+			HistoryEntry{
+				date, change.field,
+				encoding.ZeroString(change.new.String),
+				encoding.ZeroString(change.old.String),
+				encoding.ZeroString(operator),
+			},
+		})
+	}
+
+	for _, record := range changedRecords(subject, target, changes) {
+		if version := memory.version(record); version != nil {
+			*version++
+		}
+	}
+}
+
+/*
+version returns the pointer to the version of the given record, or nil if the
+record is missing.
+*/
+func (memory *Memory) version(record versionedRecord) *Version {
+	switch record.subject {
+	case HistoryClub:
+		if index := memory.findClub(record.target); index >= 0 {
+			return &memory.clubs[index].version
+		}
+
+	case HistoryMember:
+		if index := memory.findMember(record.target); index >= 0 {
+			return &memory.members[index].version
+		}
+
+	case HistoryOfficer:
+		if index := memory.findOfficer(record.target); index >= 0 {
+			return &memory.officers[index].version
+		}
+
+	case HistoryParty:
+		if index := memory.findParty(record.target); index >= 0 {
+			return &memory.parties[index].version
+		}
+	}
+
+	return nil
+}
+
+func (memory *Memory) snapshotClub(index int) snapshot {
+	club := memory.clubs[index]
+	return snapshot{`chief`: club.Chief, `name`: club.Name}
+}
+
+func (memory *Memory) snapshotMember(index int) snapshot {
+	member := memory.members[index]
+
+	var clubs []string
+	for _, club := range memory.clubs {
+		for _, clubMember := range club.members {
+			if clubMember == member.ID {
+				clubs = append(clubs, club.ID)
+				break
+			}
+		}
+	}
+
+	return newMemberSnapshot(string(member.Affiliation), clubs,
+		member.confirmed, member.deleted, uint16(member.Entrance),
+		member.gender, member.mail, member.Nickname, member.OB,
+		member.password, string(member.Realname), member.tel)
+}
+
+func (memory *Memory) snapshotOfficer(index int) snapshot {
+	officer := memory.officers[index]
+
+	return snapshot{
+		`member`: officer.Member,
+		`name`:   officer.Name,
+		`scope`:  officer.scope,
+	}
+}
+
+func (memory *Memory) snapshotParty(index int) snapshot {
+	party := memory.parties[index]
+
+	attendances := make([]snapshotAttendance, len(party.attendances))
+	for attendanceIndex, attendance := range party.attendances {
+		attendances[attendanceIndex] = snapshotAttendance(attendance)
+	}
+
+	return newPartySnapshot(attendances, string(party.Creator),
+		party.details, party.Due.Generic(), party.End.Generic(),
+		party.Inviteds, party.Place, party.Start.Generic())
+}
+
+/*
+memoryScope returns the scope in the representation of the database. The
+returned Boolean is false if some of the flags is unknown.
+*/
+func memoryScope(list string) (string, bool) {
+	management := false
+	privacy := false
+
+	for _, flag := range splitList(list) {
+		switch flag {
+		case `management`:
+			management = true
+
+		case `privacy`:
+			privacy = true
+
+		default:
+			return ``, false
+		}
+	}
+
+	flags := make([]string, 0, 2)
+
+	if management {
+		flags = append(flags, `management`)
+	}
+
+	if privacy {
+		flags = append(flags, `privacy`)
+	}
+
+	return strings.Join(flags, `,`), true
+}
+
+func removeString(slice []string, removing string) []string {
+	result := slice[:0]
+
+	for _, value := range slice {
+		if value != removing {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
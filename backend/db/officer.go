@@ -18,10 +18,12 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"github.com/go-sql-driver/mysql"
 	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"github.com/kagucho/tsubonesystem3/backend/event"
 	"log"
 	"strings"
 )
@@ -46,9 +48,10 @@ type OfficerNameChan <-chan OfficerNameResult
 
 // OfficerDetail is a structure holding details of an officer
 type OfficerDetail struct {
-	Member string   `json:"member"`
-	Name   string   `json:"name"`
-	Scope  []string `json:"scope"`
+	Member  string   `json:"member"`
+	Name    string   `json:"name"`
+	Scope   []string `json:"scope"`
+	Version Version  `json:"-"`
 }
 
 // OfficerEntry is a structure holding basic information of an officer.
@@ -78,6 +81,23 @@ management permission.
 */
 var ErrOfficerSuicide = errors.New(`removing operator's own management permission`)
 
+/*
+MarshalJSON returns the JSON encoding of the remaining names and closes the
+channel.
+
+This implements an interface used in encoding/encoding.
+
+json - The Go Programming Language
+Example (CustomMarshalJSON)
+https://golang.org/pkg/encoding/json/#example__customMarshalJSON
+*/
+func (nameChan OfficerNameChan) MarshalJSON() ([]byte, error) {
+	return encoding.MarshalJSONArray(func() (interface{}, error, bool) {
+		result, present := <-nameChan
+		return result.OfficerName, result.Error, present
+	})
+}
+
 /*
 MarshalJSON returns the JSON encoding of the remaining entries and closes the
 channel.
@@ -100,33 +120,46 @@ DeleteOfficer deletes an officer identified by the given ID.
 
 It returns db.ErrIncorrectIdentity if the given ID of the operator or the
 officer is incorrect. It returns db.ErrOfficerSuicide if the operation is
-expected to remove the management permission of the operator. Other errors tell
-db.DB is bad.
+expected to remove the management permission of the operator. It returns
+db.ErrVersionMismatch if the officer does not have the given version. Other
+errors tell db.DB is bad.
 */
-func (db DB) DeleteOfficer(operator, id string) error {
-	result, execErr := db.stmts[stmtCallDeleteOfficer].Exec(operator, id)
-	if execErr != nil {
-		if mysqlErr, ok := execErr.(*mysql.MySQLError); ok && mysqlErr.Number == erSignalException {
-			return ErrOfficerSuicide
+func (db DB) DeleteOfficer(operator, id string, version Precondition) error {
+	return db.publish(newEvent(event.OfficerDeleted, id, nil), db.transact(func(tx *sql.Tx) error {
+		if err := db.checkVersion(tx, versionOfficer, id, version); err != nil {
+			return err
 		}
 
-		return execErr
-	}
+		old, err := db.snapshotOfficer(tx, id)
+		if err != nil {
+			return err
+		}
 
-	affected, affectedErr := result.RowsAffected()
-	if affectedErr != nil {
-		return affectedErr
-	}
+		result, execErr := tx.Stmt(db.stmts[stmtCallDeleteOfficer]).Exec(operator, id)
+		if execErr != nil {
+			if mysqlErr, ok := execErr.(*mysql.MySQLError); ok && mysqlErr.Number == erSignalException {
+				return ErrOfficerSuicide
+			}
 
-	if affected <= 0 {
-		return ErrIncorrectIdentity
-	}
+			return execErr
+		}
+
+		affected, affectedErr := result.RowsAffected()
+		if affectedErr != nil {
+			return affectedErr
+		}
 
-	return nil
+		if affected <= 0 {
+			return ErrIncorrectIdentity
+		}
+
+		return db.recordHistory(tx, operator, HistoryOfficer, id, old, nil)
+	}))
 }
 
 /*
-InsertOfficer inserts an operator with the given properties.
+InsertOfficer inserts an operator with the given properties on behalf of the
+given operator.
 
 It may return one of the following errors:
 db.ErrBadOmission tells the ID or name is omitted.
@@ -136,32 +169,36 @@ db.ErrInvalid tells the name of the scope is invalid.
 
 Other errors tell db.DB is bad.
 */
-func (db DB) InsertOfficer(id, name, member, scope string) error {
-	_, scopeBytes := stringListToDBList(scope)
-	_, err := db.stmts[stmtInsertOfficer].Exec(id, name, scopeBytes, member)
-
+func (db DB) InsertOfficer(operator, id, name, member, scope string) error {
 	if id == `` || name == `` {
 		return ErrBadOmission
 	}
 
-	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-		switch mysqlErr.Number {
-		case erDataTooLong:
-			fallthrough
-		case erTruncatedWrongValueForField:
-			return ErrInvalid
+	_, scopeBytes := stringListToDBList(scope)
 
-		case erDupEntry:
-			return ErrDupEntry
+	return db.publish(newEvent(event.OfficerCreated, id, nil), db.transact(func(tx *sql.Tx) error {
+		result, err := tx.Stmt(db.stmts[stmtInsertOfficer]).Exec(id, name, scopeBytes, member)
+		if err != nil {
+			return convertMySQLError(err)
+		}
 
-		case erNoReferencedRow:
-			fallthrough
-		case erNoReferencedRow2:
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		// INSERT ... SELECT inserts nothing if the member is missing.
+		if affected <= 0 {
 			return ErrIncorrectIdentity
 		}
-	}
 
-	return err
+		current, err := db.snapshotOfficer(tx, id)
+		if err != nil {
+			return err
+		}
+
+		return db.recordHistory(tx, operator, HistoryOfficer, id, nil, current)
+	}))
 }
 
 /*
@@ -171,12 +208,15 @@ given ID.
 It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
 tell db.DB is bad.
 */
-func (db DB) QueryOfficerDetail(id string) (OfficerDetail, error) {
+func (db DB) QueryOfficerDetail(ctx context.Context, id string) (OfficerDetail, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var detail OfficerDetail
 	var scope string
 
-	err := db.stmts[stmtSelectOfficerByID].QueryRow(id).Scan(
-		&detail.Name, &scope, &detail.Member)
+	err := db.stmt(stmtSelectOfficerByID).QueryRowContext(ctx, id).Scan(
+		&detail.Name, &scope, &detail.Member, &detail.Version)
 	if err == sql.ErrNoRows {
 		return detail, ErrIncorrectIdentity
 	} else if err != nil {
@@ -194,9 +234,12 @@ QueryOfficerName returns the name of the officer identified with the given ID.
 It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
 tell db.DB is bad.
 */
-func (db DB) QueryOfficerName(id string) (string, error) {
+func (db DB) QueryOfficerName(ctx context.Context, id string) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var name string
-	err := db.stmts[stmtSelectOfficerNameByID].QueryRow(id).Scan(&name)
+	err := db.stmt(stmtSelectOfficerNameByID).QueryRowContext(ctx, id).Scan(&name)
 	if err == sql.ErrNoRows {
 		err = ErrIncorrectIdentity
 	}
@@ -208,17 +251,26 @@ func (db DB) QueryOfficerName(id string) (string, error) {
 QueryOfficerNames returns db.OfficerNameChan representing the names of all
 officers.
 
-Resources will be holded until the channel gets closed.
+Resources will be holded until the channel gets closed or the given
+context gets done.
 */
-func (db DB) QueryOfficerNames() OfficerNameChan {
+func (db DB) QueryOfficerNames(ctx context.Context) OfficerNameChan {
 	resultChan := make(chan OfficerNameResult)
+	ctx, cancel := db.withTimeout(ctx)
 
 	go func() {
-		defer close(resultChan)
+		defer func() {
+			close(resultChan)
+			cancel()
+		}()
 
-		rows, err := db.stmts[stmtSelectOfficerIDNames].Query()
+		rows, err := db.stmt(stmtSelectOfficerIDNames).QueryContext(ctx)
 		if err != nil {
-			resultChan <- OfficerNameResult{Error: err}
+			select {
+			case resultChan <- OfficerNameResult{Error: err}:
+			case <-ctx.Done():
+			}
+
 			return
 		}
 
@@ -232,30 +284,55 @@ func (db DB) QueryOfficerNames() OfficerNameChan {
 			var result OfficerNameResult
 			result.Error = rows.Scan(&result.ID, &result.Name)
 
-			resultChan <- result
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+				return
+			}
+
 			if result.Error != nil {
 				return
 			}
 		}
+
+		if err := rows.Err(); err != nil {
+			select {
+			case resultChan <- OfficerNameResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
 	}()
 
 	return resultChan
 }
 
 /*
-QueryOfficers returns db.OfficerEntryChan which represents all the officers.
+QueryOfficers returns db.OfficerEntryChan which represents the officers in the
+order of their IDs. The officers follow the given ID, which may be empty to
+start from the first, and are limited to the given number, which may be
+negative for no limit.
 
-Resources will be holded until the channel gets closed.
+Resources will be holded until the channel gets closed or the given
+context gets done.
 */
-func (db DB) QueryOfficers() OfficerEntryChan {
+func (db DB) QueryOfficers(ctx context.Context, after string, limit int) OfficerEntryChan {
 	resultChan := make(chan OfficerEntryResult)
+	ctx, cancel := db.withTimeout(ctx)
 
 	go func() {
-		defer close(resultChan)
+		defer func() {
+			close(resultChan)
+			cancel()
+		}()
 
-		rows, err := db.stmts[stmtSelectOfficers].Query()
+		rows, err := db.stmt(stmtSelectOfficers).QueryContext(ctx,
+			after, sqlLimit(limit))
 		if err != nil {
-			resultChan <- OfficerEntryResult{Error: err}
+			select {
+			case resultChan <- OfficerEntryResult{Error: err}:
+			case <-ctx.Done():
+			}
+
 			return
 		}
 
@@ -271,11 +348,23 @@ func (db DB) QueryOfficers() OfficerEntryChan {
 			result.Error = rows.Scan(
 				&result.ID, &result.Name, &result.Member)
 
-			resultChan <- result
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+				return
+			}
+
 			if result.Error != nil {
 				return
 			}
 		}
+
+		if err := rows.Err(); err != nil {
+			select {
+			case resultChan <- OfficerEntryResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
 	}()
 
 	return resultChan
@@ -289,10 +378,11 @@ It may return one of the following properties:
 db.ErrDupEntry tells the name is duplicate.
 db.ErrIncorrectIdentity tells the ID of the officer or member is incorrect.
 db.ErrInvalid tells some of the properties is invalid.
+db.ErrVersionMismatch tells the officer does not have the given version.
 
 Other errors tell db.DB is bad.
 */
-func (db DB) UpdateOfficer(operator, id, name, member, scope string) error {
+func (db DB) UpdateOfficer(operator, id, name, member, scope string, version Precondition) error {
 	arguments := make([]interface{}, 5)
 	arguments[0] = operator
 	arguments[1] = id
@@ -309,39 +399,50 @@ func (db DB) UpdateOfficer(operator, id, name, member, scope string) error {
 		_, arguments[4] = stringListToDBList(scope)
 	}
 
-	result, execErr := db.stmts[stmtCallUpdateOfficer].Exec(arguments...)
-	if execErr != nil {
-		if mysqlErr, ok := execErr.(*mysql.MySQLError); ok {
-			switch mysqlErr.Number {
-			case erDataTooLong:
-				fallthrough
-			case erTruncatedWrongValueForField:
-				return ErrInvalid
-
-			case erDupEntry:
-				return ErrDupEntry
-
-			case erSignalException:
-				return ErrOfficerSuicide
-
-			case erNoReferencedRow:
-				fallthrough
-			case erNoReferencedRow2:
-				return ErrIncorrectIdentity
-			}
+	return db.publish(newEvent(event.OfficerUpdated, id, nil), db.transact(func(tx *sql.Tx) error {
+		if err := db.checkVersion(tx, versionOfficer, id, version); err != nil {
+			return err
 		}
 
-		return execErr
-	}
-
-	affected, affectedErr := result.RowsAffected()
-	if affectedErr != nil {
-		return affectedErr
-	}
-
-	if affected <= 0 {
-		return ErrIncorrectIdentity
-	}
-
-	return nil
+		return db.recordChange(tx, operator, HistoryOfficer, id,
+			func() (snapshot, error) {
+				return db.snapshotOfficer(tx, id)
+			}, func() error {
+				result, execErr := tx.Stmt(db.stmts[stmtCallUpdateOfficer]).Exec(arguments...)
+				if execErr != nil {
+					if mysqlErr, ok := execErr.(*mysql.MySQLError); ok {
+						switch mysqlErr.Number {
+						case erDataTooLong:
+							fallthrough
+						case erTruncatedWrongValueForField:
+							return ErrInvalid
+
+						case erDupEntry:
+							return ErrDupEntry
+
+						case erSignalException:
+							return ErrOfficerSuicide
+
+						case erNoReferencedRow:
+							fallthrough
+						case erNoReferencedRow2:
+							return ErrIncorrectIdentity
+						}
+					}
+
+					return execErr
+				}
+
+				affected, affectedErr := result.RowsAffected()
+				if affectedErr != nil {
+					return affectedErr
+				}
+
+				if affected <= 0 {
+					return ErrIncorrectIdentity
+				}
+
+				return nil
+			})
+	}))
 }
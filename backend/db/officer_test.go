@@ -18,6 +18,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"reflect"
 	"testing"
@@ -55,7 +56,7 @@ func (db DB) testQueryOfficer(t *testing.T) {
 }
 
 func (db DB) testQueryOfficerName(t *testing.T) {
-	if name, err := db.QueryOfficerName(`president`); err != nil {
+	if name, err := db.QueryOfficerName(context.Background(), `president`); err != nil {
 		t.Error(err)
 	} else if name != `局長` {
 		t.Errorf(`expected "局長", got %q`, name)
@@ -64,7 +65,7 @@ func (db DB) testQueryOfficerName(t *testing.T) {
 
 func (db DB) testQueryOfficers(t *testing.T) {
 	const expected = `[{"id":"president","member":{"id":"1stDisplayID","mail":"1st@kagucho.net","nickname":"1 !\\%_1\"#","realname":"$&\\%_2'(","tel":"000-000-001"},"name":"局長"},{"id":"vice","member":{"id":"1stDisplayID","mail":"1st@kagucho.net","nickname":"1 !\\%_1\"#","realname":"$&\\%_2'(","tel":"000-000-001"},"name":"副局長"}]`
-	result, err := db.QueryOfficers().MarshalJSON()
+	result, err := db.QueryOfficers(context.Background(), ``, -1).MarshalJSON()
 
 	if err != nil {
 		t.Error(err)
@@ -22,6 +22,7 @@ import (
 	"database/sql"
 	"github.com/go-sql-driver/mysql"
 	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"github.com/kagucho/tsubonesystem3/backend/event"
 	"log"
 )
 
@@ -48,9 +49,13 @@ type PartyCommon struct {
 	Due      encoding.Time       `json:"due"`
 }
 
-// PartyEntry is a strucutre holding basic information about a party.
+/*
+PartyEntry is a strucutre holding basic information about a party. ID is the
+internal ID, which is stable even if the name changes.
+*/
 type PartyEntry struct {
 	PartyCommon
+	ID   uint16 `json:"-"`
 	Name string `json:"name"`
 }
 
@@ -72,6 +77,7 @@ type PartyDetail struct {
 	PartyCommon
 	Details     string              `json:"details"`
 	Attendances PartyAttendanceChan `json:"attendances"`
+	Version     Version             `json:"-"`
 }
 
 /*
@@ -178,33 +184,46 @@ func (nameChan PartyNameChan) MarshalJSON() ([]byte, error) {
 }
 
 /*
-DeleteParty deletes a party.
+DeleteParty deletes a party. The change will be recorded as made by the creator.
 
 It returns db.ErrIncorrectIdentity if any party named with the given name and
-created by the member identified by the given ID does not exist. Other errors
+created by the member identified by the given ID does not exist. It returns
+db.ErrVersionMismatch if the party does not have the given version. Other errors
 tell db.DB is bad.
 */
-func (db DB) DeleteParty(name, creator string) error {
-	result, execErr := db.stmts[stmtDeleteParty].Exec(name, creator)
-	if execErr != nil {
-		return execErr
-	}
+func (db DB) DeleteParty(name, creator string, version Precondition) error {
+	return db.publish(newEvent(event.PartyDeleted, name, nil), db.transact(func(tx *sql.Tx) error {
+		if err := db.checkVersion(tx, versionParty, name, version); err != nil {
+			return err
+		}
 
-	affected, affectedErr := result.RowsAffected()
-	if affectedErr != nil {
-		return affectedErr
-	}
+		old, err := db.snapshotParty(tx, name)
+		if err != nil {
+			return err
+		}
 
-	if affected <= 0 {
-		return ErrIncorrectIdentity
-	}
+		result, err := tx.Stmt(db.stmts[stmtDeleteParty]).Exec(name, creator)
+		if err != nil {
+			return err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
 
-	return nil
+		if affected <= 0 {
+			return ErrIncorrectIdentity
+		}
+
+		return db.recordHistory(tx, creator, HistoryParty, name, old, nil)
+	}))
 }
 
 /*
 InsertParty inserts a party with the given properties and returns the email
-addresses of the invited members.
+addresses of the invited members. The change will be recorded as made by the
+creator.
 
 It may return one of the following errors:
 db.ErrBadOmission tells some of the parameters is omitted.
@@ -214,72 +233,88 @@ members is incorrect.
 db.ErrInvalid tells some of the properties is invalid.
 */
 func (db DB) InsertParty(name, creator string, start, end encoding.Time, place string, due encoding.Time, invitedIDs, inviteds, details string) ([]string, error) {
+	var invitedMails []string
+
 	if (name == `` || start == encoding.Time{} || end == encoding.Time{} || place == `` || due == encoding.Time{} || invitedIDs == `` || inviteds == `` || details == ``) {
 		return nil, ErrBadOmission
 	}
 
-	invitedsNumber, invitedsDB := stringListToDBList(invitedIDs)
-	invitedMails := make([]string, 0, invitedsNumber)
+	if err := db.transact(func(tx *sql.Tx) error {
+		var creatorDBID uint16
 
-	rows, err := db.stmts[stmtCallInsertParty].Query(name, creator,
-		start.Generic(), end.Generic(), place, due.Generic(),
-		inviteds, invitedsDB, invitedsNumber, details)
-	if err != nil {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			switch mysqlErr.Number {
-			case erDataTooLong:
-				fallthrough
-			case erTruncatedWrongValueForField:
-				fallthrough
-			case erWrongValue:
-				return nil, ErrInvalid
-
-			case erDupEntry:
-				return nil, ErrDupEntry
-
-			case erNoReferencedRow:
-				fallthrough
-			case erNoReferencedRow2:
-				fallthrough
-			case erSignalException:
-				return nil, ErrIncorrectIdentity
+		if err := tx.Stmt(db.stmts[stmtSelectMemberInternalIDByID]).QueryRow(creator).Scan(&creatorDBID); err != nil {
+			if err == sql.ErrNoRows {
+				err = ErrIncorrectIdentity
 			}
+
+			return err
 		}
 
-		return nil, err
-	}
+		invitedDBIDs, mails, err := queryInternalMembers(tx, splitList(invitedIDs))
+		if err != nil {
+			return err
+		}
 
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Print(err)
+		result, err := tx.Stmt(db.stmts[stmtInsertParty]).Exec(name,
+			creatorDBID, start.Generic(), end.Generic(), place,
+			due.Generic(), inviteds, details)
+		if err != nil {
+			return convertMySQLError(err)
 		}
-	}()
 
-	for rows.Next() {
-		var mail string
+		partyDBID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
 
-		if err := rows.Scan(&mail); err != nil {
-			return nil, err
+		if err := relationAttendances.insert(tx, uint16(partyDBID), invitedDBIDs); err != nil {
+			return err
 		}
 
-		if mail != `` {
-			invitedMails = append(invitedMails, mail)
+		current, err := db.snapshotParty(tx, name)
+		if err != nil {
+			return err
+		}
+
+		if err := db.recordHistory(tx, creator, HistoryParty, name, nil, current); err != nil {
+			return err
 		}
+
+		invitedMails = make([]string, 0, len(mails))
+		for _, mail := range mails {
+			if mail != `` {
+				invitedMails = append(invitedMails, mail)
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 
+	db.publish(newEvent(event.PartyCreated, name, listAudience(invitedIDs, creator)), nil)
+
 	return invitedMails, nil
 }
 
 /*
-QueryParties returns db.PartyUserChan representing all parties.
+QueryParties returns db.PartyUserChan representing the parties in the order of
+their internal IDs. The parties follow the given internal ID, which may be 0 to
+start from the first, and are limited to the given number, which may be
+negative for no limit.
 
-Resources will be holded until the channel gets closed.
+Resources will be holded until the channel gets closed or the given
+context gets done.
 */
-func (db DB) QueryParties(user string) PartyUserChan {
+func (db DB) QueryParties(ctx context.Context, user string, after uint16, limit int) PartyUserChan {
 	resultChan := make(chan PartyUserResult)
+	ctx, cancel := db.withTimeout(ctx)
 
 	go func() {
-		defer close(resultChan)
+		defer func() {
+			close(resultChan)
+			cancel()
+		}()
 
 		var result PartyUserResult
 		var tx *sql.Tx
@@ -289,21 +324,23 @@ func (db DB) QueryParties(user string) PartyUserChan {
 			can be improved with API changes in database/sql. See
 			mysql_store_result in MySQL Connect/C.
 		*/
-		parties := make(map[uint16]PartyEntry)
+		var parties []PartyEntry
 
-		tx, result.Error = db.sql.BeginTx(context.Background(),
-			&sql.TxOptions{
-				Isolation: sql.LevelSerializable,
-				ReadOnly:  true,
-			})
+		tx, result.Error = db.beginReadOnly(ctx)
 		if result.Error != nil {
-			resultChan <- result
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+			}
 
 			return
 		}
 
+		defer db.endReadOnly(tx)
+
 		result.Error = func() error {
-			rows, err := tx.Stmt(db.stmts[stmtSelectParties]).Query()
+			rows, err := tx.Stmt(db.stmts[stmtSelectParties]).QueryContext(ctx,
+				after, sqlLimit(limit))
 			if err != nil {
 				return err
 			}
@@ -334,27 +371,26 @@ func (db DB) QueryParties(user string) PartyUserChan {
 				party.Start = encoding.NewTime(start.Time)
 				party.End = encoding.NewTime(end.Time)
 				party.Due = encoding.NewTime(due.Time)
+				party.ID = id
 
-				parties[id] = party
+				parties = append(parties, party)
 			}
 
-			return nil
+			return rows.Err()
 		}()
 		if result.Error != nil {
-			resultChan <- result
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+			}
 
 			return
 		}
 
-		/*
-			TODO: This is also bad of Golang... Go 1.8 cannot
-			efficiently read-and-modify items in map, so prepare
-			another map.
-		*/
 		attendances := make(map[uint16]Attendance, len(parties))
 
 		result.Error = func() error {
-			rows, err := tx.Stmt(db.stmts[stmtSelectAttendancesByMember]).Query(user)
+			rows, err := tx.Stmt(db.stmts[stmtSelectAttendancesByMember]).QueryContext(ctx, user)
 			if err != nil {
 				return err
 			}
@@ -376,36 +412,82 @@ func (db DB) QueryParties(user string) PartyUserChan {
 				attendances[id] = Attendance(attendance)
 			}
 
-			return nil
+			return rows.Err()
 		}()
 		if result.Error != nil {
-			resultChan <- result
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+			}
 
 			return
 		}
 
-		for id, party := range parties {
+		for _, party := range parties {
 			partyUser := PartyUser{PartyEntry: party}
 
-			if attendance, ok := attendances[id]; ok {
+			if attendance, ok := attendances[party.ID]; ok {
 				partyUser.User = attendance
 			}
 
-			resultChan <- PartyUserResult{PartyUser: partyUser}
+			select {
+			case resultChan <- PartyUserResult{PartyUser: partyUser}:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
 	return resultChan
 }
 
+/*
+QueryPartyID returns the internal ID of the party with the given name.
+
+It returns db.ErrIncorrectIdentity if the given name is incorrect. Other errors
+tell db.DB is bad.
+*/
+func (db DB) QueryPartyID(ctx context.Context, name string) (uint16, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var id uint16
+	err := db.stmt(stmtSelectPartyInternalIDByName).QueryRowContext(ctx, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = ErrIncorrectIdentity
+	}
+
+	return id, err
+}
+
+/*
+QueryPartyName returns the name of the party with the given internal ID.
+
+It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
+tell db.DB is bad.
+*/
+func (db DB) QueryPartyName(ctx context.Context, id uint16) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var name string
+	err := db.stmt(stmtSelectPartyNameByInternalID).QueryRowContext(ctx, id).Scan(&name)
+	if err == sql.ErrNoRows {
+		err = ErrIncorrectIdentity
+	}
+
+	return name, err
+}
+
 /*
 QueryParty queries details of a party identified by the given name.
 
 It returns db.ErrIncorrectIdentity if the name is incorrect.
 
-Resources will be holded until Attendances gets closed.
+Resources will be holded until Attendances gets closed or the given
+context gets done.
 */
-func (db DB) QueryParty(name string) (PartyDetail, error) {
+func (db DB) QueryParty(ctx context.Context, name string) (PartyDetail, error) {
 	var party PartyDetail
 	var creator sql.NullString
 	var start mysql.NullTime
@@ -413,21 +495,19 @@ func (db DB) QueryParty(name string) (PartyDetail, error) {
 	var due mysql.NullTime
 	var id uint16
 
-	tx, err := db.sql.BeginTx(context.Background(),
-		&sql.TxOptions{
-			Isolation: sql.LevelSerializable,
-			ReadOnly:  true,
-		})
+	ctx, cancel := db.withTimeout(ctx)
+
+	tx, err := db.beginReadOnly(ctx)
 	if err != nil {
+		cancel()
 		return party, err
 	}
 
-	if err := tx.Stmt(db.stmts[stmtSelectParty]).QueryRow(name).Scan(
+	if err := tx.Stmt(db.stmts[stmtSelectParty]).QueryRowContext(ctx, name).Scan(
 		&id, &creator, &start, &end, &party.Place,
-		&party.Inviteds, &due, &party.Details); err != nil {
-		if err := tx.Commit(); err != nil {
-			log.Print(err)
-		}
+		&party.Inviteds, &due, &party.Details, &party.Version); err != nil {
+		db.endReadOnly(tx)
+		cancel()
 
 		if err == sql.ErrNoRows {
 			return party, ErrIncorrectIdentity
@@ -441,15 +521,17 @@ func (db DB) QueryParty(name string) (PartyDetail, error) {
 	go func() {
 		defer func() {
 			close(attendances)
-
-			if err := tx.Commit(); err != nil {
-				log.Print(err)
-			}
+			db.endReadOnly(tx)
+			cancel()
 		}()
 
-		rows, err := tx.Stmt(db.stmts[stmtSelectAttendancesByInternalParty]).Query(id)
+		rows, err := tx.Stmt(db.stmts[stmtSelectAttendancesByInternalParty]).QueryContext(ctx, id)
 		if err != nil {
-			attendances <- PartyAttendanceResult{Error: err}
+			select {
+			case attendances <- PartyAttendanceResult{Error: err}:
+			case <-ctx.Done():
+			}
+
 			return
 		}
 
@@ -463,11 +545,23 @@ func (db DB) QueryParty(name string) (PartyDetail, error) {
 			var result PartyAttendanceResult
 			result.Error = rows.Scan(&result.Member, (*uint16)(&result.Attendance))
 
-			attendances <- result
+			select {
+			case attendances <- result:
+			case <-ctx.Done():
+				return
+			}
+
 			if result.Error != nil {
 				return
 			}
 		}
+
+		if err := rows.Err(); err != nil {
+			select {
+			case attendances <- PartyAttendanceResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
 	}()
 
 	party.Creator = encoding.ZeroString(creator.String)
@@ -481,107 +575,122 @@ func (db DB) QueryParty(name string) (PartyDetail, error) {
 
 /*
 UpdateParty updates the party identified by the given name and created by the
-member identified by the given ID, with the given properties.
+member identified by the given ID, with the given properties. The change will be
+recorded as made by the creator.
 
 It may return one of the following errors:
 ErrIncorrectIdentity tells the name of the party or the ID of the creator or
 one of the invited members is incorrect.
 ErrInvalid tells some of the properties is invalid.
+ErrVersionMismatch tells the party does not have the given version.
 
 Other errors tell db.DB is bad.
 */
-func (db DB) UpdateParty(name, creator string, start, end encoding.Time, place string, due encoding.Time, inviteds, invitedIDs, details string) error {
-	arguments := make([]interface{}, 10)
-	arguments[0] = name
-	arguments[1] = creator
+func (db DB) UpdateParty(name, creator string, start, end encoding.Time, place string, due encoding.Time, inviteds, invitedIDs, details string, version Precondition) error {
+	arguments := make([]interface{}, 7)
 
 	if (start != encoding.Time{}) {
-		arguments[2] = start.Generic()
+		arguments[0] = start.Generic()
 	}
 
 	if (end != encoding.Time{}) {
-		arguments[3] = end.Generic()
+		arguments[1] = end.Generic()
 	}
 
 	if place != `` {
-		arguments[4] = place
+		arguments[2] = place
 	}
 
 	if (due != encoding.Time{}) {
-		arguments[5] = due.Generic()
+		arguments[3] = due.Generic()
 	}
 
 	if inviteds != `` {
-		arguments[8] = inviteds
-	}
-
-	if invitedIDs != `` {
-		arguments[7], arguments[6] = stringListToDBList(invitedIDs)
+		arguments[4] = inviteds
 	}
 
 	if details != `` {
-		arguments[9] = details
+		arguments[5] = details
 	}
 
-	result, execErr := db.stmts[stmtCallUpdateParty].Exec(arguments...)
-	if execErr != nil {
-		if mysqlErr, ok := execErr.(*mysql.MySQLError); ok {
-			switch mysqlErr.Number {
-			case erDataTooLong:
-				fallthrough
-			case erTruncatedWrongValueForField:
-				return ErrInvalid
-
-			case erNoReferencedRow:
-				fallthrough
-			case erNoReferencedRow2:
-				fallthrough
-			case erSignalException:
-				return ErrIncorrectIdentity
+	return db.publish(newEvent(event.PartyUpdated, name, listAudience(invitedIDs, creator)), db.transact(func(tx *sql.Tx) error {
+		if err := db.checkVersion(tx, versionParty, name, version); err != nil {
+			return err
+		}
+
+		var partyDBID uint16
+
+		if err := tx.Stmt(db.stmts[stmtSelectPartyInternalIDByNameCreator]).QueryRow(name, creator).Scan(&partyDBID); err != nil {
+			if err == sql.ErrNoRows {
+				err = ErrIncorrectIdentity
 			}
+
+			return err
 		}
 
-		return execErr
-	}
+		arguments[6] = partyDBID
 
-	affected, affectedErr := result.RowsAffected()
-	if affectedErr != nil {
-		return affectedErr
-	}
+		return db.recordChange(tx, creator, HistoryParty, name,
+			func() (snapshot, error) {
+				return db.snapshotParty(tx, name)
+			}, func() error {
+				if _, err := tx.Stmt(db.stmts[stmtUpdateParty]).Exec(arguments...); err != nil {
+					return convertMySQLError(err)
+				}
 
-	if affected <= 0 {
-		return ErrIncorrectIdentity
-	}
+				if invitedIDs == `` {
+					return nil
+				}
 
-	return nil
+				invitedDBIDs, _, err := queryInternalMembers(tx, splitList(invitedIDs))
+				if err != nil {
+					return err
+				}
+
+				return relationAttendances.replace(tx, partyDBID, invitedDBIDs)
+			})
+	}))
 }
 
 /*
 UpdateAttendance updates attendance of a member identified by the given ID for
-the party identified by the given name.
+the party identified by the given name and having the given version. The change
+will be recorded as made by the member.
 
-It returns ErrIncorrectIdentity if the given ID or name is incorrect. Other
-errors tell db.DB is bad.
+It returns ErrIncorrectIdentity if the given ID or name is incorrect, and
+db.ErrVersionMismatch if the party has another version. Other errors tell db.DB
+is bad.
 */
-func (db DB) UpdateAttendance(attending bool, party, member string) error {
+func (db DB) UpdateAttendance(attending bool, party, member string, version Precondition) error {
 	attendance := 2
 	if attending {
 		attendance = 3
 	}
 
-	result, execErr := db.stmts[stmtUpdateAttendance].Exec(attendance, party, member)
-	if execErr != nil {
-		return execErr
-	}
+	return db.publish(newEvent(event.AttendanceUpdated, party, nil), db.transact(func(tx *sql.Tx) error {
+		if err := db.checkVersion(tx, versionParty, party, version); err != nil {
+			return err
+		}
 
-	affected, affectedErr := result.RowsAffected()
-	if affectedErr != nil {
-		return affectedErr
-	}
+		return db.recordChange(tx, member, HistoryParty, party,
+			func() (snapshot, error) {
+				return db.snapshotParty(tx, party)
+			}, func() error {
+				result, execErr := tx.Stmt(db.stmts[stmtUpdateAttendance]).Exec(attendance, party, member)
+				if execErr != nil {
+					return execErr
+				}
 
-	if affected != 1 {
-		return ErrIncorrectIdentity
-	}
+				affected, affectedErr := result.RowsAffected()
+				if affectedErr != nil {
+					return affectedErr
+				}
 
-	return nil
+				if affected != 1 {
+					return ErrIncorrectIdentity
+				}
+
+				return nil
+			})
+	}))
 }
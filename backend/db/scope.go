@@ -27,7 +27,7 @@ import (
 )
 
 func (db DB) Authenticate(id, password string) error {
-	rows, queryErr := db.stmts[stmtSelectMemberPasswordByID].Query(id)
+	rows, queryErr := db.stmt(stmtSelectMemberPasswordByID).Query(id)
 	if queryErr != nil {
 		return queryErr
 	}
@@ -45,7 +45,7 @@ func (db DB) Authenticate(id, password string) error {
 		return ErrIncorrectIdentity
 	}
 
-	return verifyPassword(password, dbPassword)
+	return verifyPassword(db.passwordKey, password, dbPassword)
 }
 
 /*
@@ -60,7 +60,7 @@ func (db DB) GetScope(id string, password string) (scope.Scope, error) {
 	var dbID uint16
 
 	if passwordErr := func() error {
-		rows, queryErr := db.stmts[stmtSelectMemberInternalIDPasswordByID].Query(id)
+		rows, queryErr := db.stmt(stmtSelectMemberInternalIDPasswordByID).Query(id)
 		if queryErr != nil {
 			return queryErr
 		}
@@ -78,7 +78,7 @@ func (db DB) GetScope(id string, password string) (scope.Scope, error) {
 			return ErrIncorrectIdentity
 		}
 
-		if verifyPassword(password, dbPassword) == nil {
+		if verifyPassword(db.passwordKey, password, dbPassword) == nil {
 			return nil
 		}
 
@@ -88,12 +88,12 @@ func (db DB) GetScope(id string, password string) (scope.Scope, error) {
 			return ErrIncorrectIdentity
 		}
 
-		newPassword, newPasswordErr := makeDBPassword(password)
+		newPassword, newPasswordErr := makeDBPassword(db.passwordKey, password)
 		if newPasswordErr != nil {
 			return newPasswordErr
 		}
 
-		result, execErr := db.stmts[stmtUpdateMemberPassword].Exec(newPassword, id)
+		result, execErr := db.stmt(stmtUpdateMemberPassword).Exec(newPassword, id)
 		if execErr != nil {
 			return execErr
 		}
@@ -114,7 +114,7 @@ func (db DB) GetScope(id string, password string) (scope.Scope, error) {
 
 	result = result.Set(scope.User).Set(scope.Member)
 
-	rows, queryErr := db.stmts[stmtSelectOfficerScopeByInternalMember].Query(dbID)
+	rows, queryErr := db.stmt(stmtSelectOfficerScopeByInternalMember).Query(dbID)
 	if queryErr != nil {
 		return result, queryErr
 	}
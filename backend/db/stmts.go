@@ -26,109 +26,177 @@ package db
 	done;
 */
 const (
-	stmtCallDeleteOfficer = iota
-	stmtCallInsertMail
-	stmtCallInsertParty
-	stmtCallUpdateMail
-	stmtCallUpdateMember
+	stmtArchiveMember = iota
+	stmtCallDeleteOfficer
 	stmtCallUpdateOfficer
-	stmtCallUpdateParty
 	stmtConfirmMember
 	stmtCountMembers
 	stmtDeclareMemberOB
 	stmtDeleteClub
+	stmtDeleteHistory
 	stmtDeleteMail
 	stmtDeleteMember
 	stmtDeleteParty
+	stmtDeleteWebhook
+	stmtInsertAudit
 	stmtInsertClub
+	stmtInsertDelivery
+	stmtInsertMail
 	stmtInsertMember
 	stmtInsertOfficer
+	stmtInsertParty
+	stmtInsertWebhook
+	stmtLockAudit
+	stmtRestoreMember
 	stmtSelectAttendancesByInternalParty
 	stmtSelectAttendancesByMember
+	stmtSelectAudit
+	stmtSelectAuditLastHash
 	stmtSelectClubByID
 	stmtSelectClubIDInternalMembers
 	stmtSelectClubInternalIDMemberID
 	stmtSelectClubNameByID
+	stmtSelectClubVersionByID
 	stmtSelectClubs
 	stmtSelectClubsByInternalMember
+	stmtSelectDeletedMemberInternalIDByID
+	stmtSelectDeliveries
+	stmtSelectDeliveryByID
+	stmtSelectHistory
 	stmtSelectMailBySubject
+	stmtSelectMailInternalIDBySubject
+	stmtSelectMailSubjectByInternalID
+	stmtSelectMailVersionBySubject
 	stmtSelectMails
 	stmtSelectMemberByID
 	stmtSelectMemberGraphByID
 	stmtSelectMemberIDMails
 	stmtSelectMemberIDsByInternalClub
+	stmtSelectMemberInternalIDByID
 	stmtSelectMemberInternalIDPasswordByID
 	stmtSelectMemberInternalIDNicknameByID
+	stmtSelectMemberIsOfficerByInternalID
 	stmtSelectMemberNicknameByID
 	stmtSelectMemberPasswordByID
 	stmtSelectMemberRoles
-	stmtSelectMembers
+	stmtSelectMemberSnapshotByInternalID
+	stmtSelectMemberVersionByID
 	stmtSelectOfficerByID
 	stmtSelectOfficerIDByMemberID
 	stmtSelectOfficerIDNames
 	stmtSelectOfficerNameByID
 	stmtSelectOfficerScopeByInternalMember
+	stmtSelectOfficerVersionByID
 	stmtSelectOfficers
 	stmtSelectParties
 	stmtSelectParty
+	stmtSelectPartyInternalIDByName
+	stmtSelectPartyInternalIDByNameCreator
+	stmtSelectPartyNameByInternalID
+	stmtSelectPartyVersionByName
 	stmtSelectRecipientsByInternalMail
+	stmtSelectWebhookByID
+	stmtSelectWebhooks
 	stmtUpdateAttendance
 	stmtUpdateClub
+	stmtUpdateClubVersion
+	stmtUpdateDelivery
+	stmtUpdateMail
+	stmtUpdateMailVersion
+	stmtUpdateMember
 	stmtUpdateMemberPassword
+	stmtUpdateMemberVersion
+	stmtUpdateOfficerVersion
+	stmtUpdateParty
+	stmtUpdatePartyVersion
 
 	stmtNumber
 )
 
 var stmtQueries = [...]string{
+	stmtArchiveMember:                      "UPDATE `members` SET `flags`=`flags`|4 WHERE `id`=?",
 	stmtCallDeleteOfficer:                  "CALL `delete_officer`(?, ?)",
-	stmtCallInsertMail:                     "CALL `insert_mail`(?, ?, ?, ?, ?, ?)",
-	stmtCallInsertParty:                    "CALL `insert_party`(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-	stmtCallUpdateMail:                     "CALL `update_mail(?, ?, ?, ?, ?, ?, ?)",
-	stmtCallUpdateMember:                   "CALL `update_member`(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 	stmtCallUpdateOfficer:                  "CALL `update_officer`(?, ?, ?, ?, ?)",
-	stmtCallUpdateParty:                    "CALL `update_party`(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-	stmtConfirmMember:                      "UPDATE `members` SET `flags`=`flags`|1 WHERE `display_id`=?",
-	stmtCountMembers:                       "SELECT COUNT(*) FROM `members` WHERE `nickname` LIKE ? AND `realname` LIKE ? AND `entrance`=? IS NOT FALSE AND FIND_IN_SET('ob', `flags`)=? IS NOT FALSE",
-	stmtDeclareMemberOB:                    "UPDATE `members` SET `flags`=`flags`|2 WHERE `display_id`=?",
+	stmtConfirmMember:                      "UPDATE `members` SET `flags`=`flags`|1, `version`=`version`+1 WHERE `display_id`=?",
+	stmtCountMembers:                       "SELECT COUNT(*) FROM `members` WHERE `nickname` LIKE ? AND `realname` LIKE ? AND `entrance`=? IS NOT FALSE AND FIND_IN_SET('ob', `flags`)=? IS NOT FALSE AND NOT FIND_IN_SET('deleted', `flags`)",
+	stmtDeclareMemberOB:                    "UPDATE `members` SET `flags`=`flags`|2, `version`=`version`+1 WHERE `display_id`=?",
 	stmtDeleteClub:                         "DELETE FROM `clubs` WHERE `display_id`=?",
+	stmtDeleteHistory:                      "DELETE FROM `history` WHERE `subject`=? AND `target`=?",
 	stmtDeleteMail:                         "DELETE FROM `mails` WHERE `subject`=?",
-	stmtDeleteMember:                       "DELETE FROM `members` WHERE `display_id`=?",
+	stmtDeleteMember:                       "DELETE FROM `members` WHERE `id`=?",
 	stmtDeleteParty:                        "DELETE `parties` FROM `parties` JOIN `members` ON `parties`.`creator`=`members`.`display_id` WHERE `parties`.`name`=? AND `members`.`display_id`=?",
-	stmtInsertClub:                         "INSERT `clubs` (`display_id`, `name`, `chief`) SELECT ?, ?, `id` FROM `members` WHERE `display_id`=?",
+	stmtDeleteWebhook:                      "DELETE FROM `webhooks` WHERE `id`=?",
+	stmtInsertAudit:                        "INSERT `audit` (`date`, `actor`, `scope`, `method`, `route`, `target`, `status`, `address`, `previous`, `hash`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	stmtInsertClub:                         "INSERT `clubs` (`display_id`, `name`, `chief`) SELECT ?, ?, `id` FROM `members` WHERE `display_id`=? AND NOT FIND_IN_SET('deleted', `flags`)",
+	stmtInsertDelivery:                     "INSERT `deliveries` (`webhook`, `event`, `payload`, `date`, `next`) VALUES (?, ?, ?, ?, ?)",
+	stmtInsertMail:                         "INSERT `mails` (`from`, `to`, `subject`, `body`) VALUES (?, ?, ?, ?)",
 	stmtInsertMember:                       "INSERT `members` (`display_id`, `mail`, `nickname`) VALUES (?, ?, ?)",
-	stmtInsertOfficer:                      "INSERT `officers` (`display_id`, `name`, `scope`, `member`) SELECT ?, ?, ?, `id` FROM `members` WHERE `display_id`=?",
+	stmtInsertOfficer:                      "INSERT `officers` (`display_id`, `name`, `scope`, `member`) SELECT ?, ?, ?, `id` FROM `members` WHERE `display_id`=? AND NOT FIND_IN_SET('deleted', `flags`)",
+	stmtInsertParty:                        "INSERT `parties` (`name`, `creator`, `start`, `end`, `place`, `due`, `inviteds`, `details`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+	stmtInsertWebhook:                      "INSERT `webhooks` (`url`, `secret`, `events`) VALUES (?, ?, ?)",
+	stmtLockAudit:                          "SELECT `id` FROM `audit_lock` WHERE `id`=1 FOR UPDATE",
+	stmtRestoreMember:                      "UPDATE `members` SET `flags`=`flags`&~4 WHERE `id`=?",
 	stmtSelectAttendancesByInternalParty:   "SELECT `members`.`display_id`, CAST(`attendances`.`attendance` as int) FROM `members` JOIN `attendances` ON `members`.`id`=`attendances`.`member` WHERE `attendances`.`party`=?",
 	stmtSelectAttendancesByMember:          "SELECT `attendances`.`party`, CAST(`attendances`.`attendance` as int) FROM `members` JOIN `attendances` ON `members`.`id`=`attendances`.`member` WHERE `members`.`display_id`=?",
-	stmtSelectClubByID:                     "SELECT `clubs`.`id`, `clubs`.`name`, `members`.`display_id` FROM `clubs` JOIN `members` ON `clubs`.`chief`=`members`.`id` WHERE `clubs`.`display_id`=?",
+	stmtSelectAudit:                        "SELECT `id`, `actor`, `address`, `date`, `hash`, `method`, `previous`, `route`, `scope`, `status`, `target` FROM `audit` WHERE (?='' OR `actor`=?) AND (?='' OR `route`=?) AND (? IS NULL OR `date`>=?) AND (? IS NULL OR `date`<?) ORDER BY `id`",
+	stmtSelectAuditLastHash:                "SELECT `hash` FROM `audit` ORDER BY `id` DESC LIMIT 1",
+	stmtSelectClubByID:                     "SELECT `clubs`.`id`, `clubs`.`name`, `members`.`display_id`, `clubs`.`version` FROM `clubs` JOIN `members` ON `clubs`.`chief`=`members`.`id` WHERE `clubs`.`display_id`=?",
 	stmtSelectClubIDInternalMembers:        "SELECT `clubs`.`display_id`, `club_member`.`member` FROM `clubs` JOIN `club_member` ON `clubs`.`id`=`club_member`.`club`",
 	stmtSelectClubInternalIDMemberID:       "SELECT `club_member`.`club`, `members`.`display_id` FROM `club_member` JOIN `members` ON `club_member`.`member`=`members`.`id`",
 	stmtSelectClubNameByID:                 "SELECT `name` FROM `clubs` WHERE `display_id`=?",
-	stmtSelectClubs:                        "SELECT `clubs`.`id`, `clubs`.`display_id`, `clubs`.`name`, `members`.`display_id` FROM `clubs` JOIN `members` ON `clubs`.`chief`=`members`.`id`",
+	stmtSelectClubVersionByID:              "SELECT `version` FROM `clubs` WHERE `display_id`=?",
+	stmtSelectClubs:                        "SELECT `clubs`.`id`, `clubs`.`display_id`, `clubs`.`name`, `members`.`display_id` FROM `clubs` JOIN `members` ON `clubs`.`chief`=`members`.`id` WHERE `clubs`.`display_id`>? ORDER BY `clubs`.`display_id` LIMIT ?",
 	stmtSelectClubsByInternalMember:        "SELECT `clubs`.`chief`, `clubs`.`display_id` FROM `club_member` JOIN `clubs` ON `club_member`.`club`=`clubs`.`id` WHERE `club_member`.`member`=?",
-	stmtSelectMailBySubject:                "SELECT `mails`.`id`, `mails`.`date`, `members`.`display_id`, `mails`.`to`, `mails`.`body` FROM `mails` LEFT JOIN `members` ON `mails`.`from`=`members`.`id` WHERE `mails`.`subject`=?",
-	stmtSelectMails:                        "SELECT `mails`.`date`, `members`.`display_id`, `mails`.`to`, `mails`.`subject` FROM `mails` LEFT JOIN `members` ON `mails`.`from`=`members`.`id`",
-	stmtSelectMemberByID:                   "SELECT `id`, `affiliation`, `entrance`, CAST(`flags` as int), `gender`, `mail`, `nickname`, `realname`, `tel` FROM `members` WHERE `display_id`=?",
+	stmtSelectDeletedMemberInternalIDByID:  "SELECT `id` FROM `members` WHERE `display_id`=? AND FIND_IN_SET('deleted', `flags`)",
+	stmtSelectDeliveries:                   "SELECT `id`, `webhook`, `event`, `payload`, `date`, `attempts`, `status`, `error`, `next` FROM `deliveries` WHERE (?=0 OR `webhook`=?) AND (NOT ? OR `next` IS NOT NULL) ORDER BY `id`",
+	stmtSelectDeliveryByID:                 "SELECT `id`, `webhook`, `event`, `payload`, `date`, `attempts`, `status`, `error`, `next` FROM `deliveries` WHERE `id`=?",
+	stmtSelectHistory:                      "SELECT `date`, `field`, `new`, `old`, `operator` FROM `history` WHERE `subject`=? AND `target`=? ORDER BY `id`",
+	stmtSelectMailBySubject:                "SELECT `mails`.`id`, `mails`.`date`, `members`.`display_id`, `mails`.`to`, `mails`.`body`, `mails`.`version` FROM `mails` LEFT JOIN `members` ON `mails`.`from`=`members`.`id` WHERE `mails`.`subject`=?",
+	stmtSelectMailInternalIDBySubject:      "SELECT `id` FROM `mails` WHERE `subject`=?",
+	stmtSelectMailSubjectByInternalID:      "SELECT `subject` FROM `mails` WHERE `id`=?",
+	stmtSelectMailVersionBySubject:         "SELECT `version` FROM `mails` WHERE `subject`=?",
+	stmtSelectMails:                        "SELECT `mails`.`id`, `mails`.`date`, `members`.`display_id`, `mails`.`to`, `mails`.`subject` FROM `mails` LEFT JOIN `members` ON `mails`.`from`=`members`.`id` WHERE `mails`.`id`>? ORDER BY `mails`.`id` LIMIT ?",
+	stmtSelectMemberByID:                   "SELECT `id`, `affiliation`, `entrance`, CAST(`flags` as int), `gender`, `mail`, `nickname`, `realname`, `tel`, `version` FROM `members` WHERE `display_id`=?",
 	stmtSelectMemberGraphByID:              "SELECT `gender`, `nickname` FROM `members` WHERE `display_id`=?",
-	stmtSelectMemberIDsByInternalClub:        "SELECT `members`.`display_id` FROM `club_member` JOIN `members` ON `club_member`.`member`=`members`.`id` WHERE `club`=?",
-	stmtSelectMemberIDMails:                "SELECT `display_id`, `mail` FROM `members`",
-	stmtSelectMemberInternalIDPasswordByID: "SELECT `id`, `password` FROM `members` WHERE `display_id`=?",
+	stmtSelectMemberIDsByInternalClub:      "SELECT `members`.`display_id` FROM `club_member` JOIN `members` ON `club_member`.`member`=`members`.`id` WHERE `club`=?",
+	stmtSelectMemberIDMails:                "SELECT `display_id`, `mail` FROM `members` WHERE NOT FIND_IN_SET('deleted', `flags`)",
+	stmtSelectMemberInternalIDByID:         "SELECT `id` FROM `members` WHERE `display_id`=? AND NOT FIND_IN_SET('deleted', `flags`)",
+	stmtSelectMemberInternalIDPasswordByID: "SELECT `id`, `password` FROM `members` WHERE `display_id`=? AND NOT FIND_IN_SET('deleted', `flags`)",
 	stmtSelectMemberInternalIDNicknameByID: "SELECT `id`, `nickname` FROM `members` WHERE `display_id`=?",
+	stmtSelectMemberIsOfficerByInternalID:  "SELECT EXISTS(SELECT * FROM `officers` WHERE `member`=?) OR EXISTS(SELECT * FROM `clubs` WHERE `chief`=?)",
 	stmtSelectMemberNicknameByID:           "SELECT `nickname` FROM `members` WHERE `display_id`=?",
-	stmtSelectMemberPasswordByID:           "SELECT `password` FROM `members` WHERE `display_id`=?",
+	stmtSelectMemberPasswordByID:           "SELECT `password` FROM `members` WHERE `display_id`=? AND NOT FIND_IN_SET('deleted', `flags`)",
 	stmtSelectMemberRoles:                  "SELECT `display_id`, CAST(`flags` as int), `id`, `nickname` FROM `members`",
-	stmtSelectMembers:                      "SELECT `affiliation`, `display_id`, `entrance`, CAST(`flags` as int), `nickname`, `realname` FROM `members`",
-	stmtSelectOfficerByID:                  "SELECT `officers`.`name`, `officers`.`scope`, `members`.`display_id` FROM `officers` JOIN `members` ON `officers`.`member`=`members`.`id` WHERE `officers`.`display_id`=?",
+	stmtSelectMemberSnapshotByInternalID:   "SELECT `affiliation`, FIND_IN_SET('confirmed', `flags`)>0, FIND_IN_SET('deleted', `flags`)>0, `entrance`, `gender`, `mail`, `nickname`, FIND_IN_SET('ob', `flags`)>0, `password`, `realname`, `tel` FROM `members` WHERE `id`=?",
+	stmtSelectMemberVersionByID:            "SELECT `version` FROM `members` WHERE `display_id`=?",
+	stmtSelectOfficerByID:                  "SELECT `officers`.`name`, `officers`.`scope`, `members`.`display_id`, `officers`.`version` FROM `officers` JOIN `members` ON `officers`.`member`=`members`.`id` WHERE `officers`.`display_id`=?",
 	stmtSelectOfficerIDByMemberID:          "SELECT `display_id` FROM `officers` WHERE `member`=?",
 	stmtSelectOfficerIDNames:               "SELECT `display_id`, `name` FROM `officers`",
 	stmtSelectOfficerNameByID:              "SELECT `name` FROM `officers` WHERE `display_id`=?",
 	stmtSelectOfficerScopeByInternalMember: "SELECT `scope` FROM `officers` WHERE `member`=?",
-	stmtSelectOfficers:                     "SELECT `officers`.`display_id`, `officers`.`name`, `members`.`display_id` FROM `officers` JOIN `members` ON `officers`.`member`=`members`.`id`",
-	stmtSelectParties:                      "SELECT `parties`.`id`, `parties`.`name`, `members`.`display_id`, `parties`.`start`, `parties`.`end`, `parties`.`place`, `parties`.`inviteds`, `parties`.`due` FROM `parties` LEFT JOIN `members` ON `parties`.`creator`=`members`.`id`",
-	stmtSelectParty:                        "SELECT `parties`.`id`, `members`.`display_id`, `parties`.`start`, `parties`.`end`, `parties`.`place`, `parties`.`inviteds`, `parties`.`due`, `parties`.`details` FROM `parties` LEFT JOIN `members` ON `parties`.`creator`=`members`.`id` WHERE `parties`.`name`=?",
+	stmtSelectOfficerVersionByID:           "SELECT `version` FROM `officers` WHERE `display_id`=?",
+	stmtSelectOfficers:                     "SELECT `officers`.`display_id`, `officers`.`name`, `members`.`display_id` FROM `officers` JOIN `members` ON `officers`.`member`=`members`.`id` WHERE `officers`.`display_id`>? ORDER BY `officers`.`display_id` LIMIT ?",
+	stmtSelectParties:                      "SELECT `parties`.`id`, `parties`.`name`, `members`.`display_id`, `parties`.`start`, `parties`.`end`, `parties`.`place`, `parties`.`inviteds`, `parties`.`due` FROM `parties` LEFT JOIN `members` ON `parties`.`creator`=`members`.`id` WHERE `parties`.`id`>? ORDER BY `parties`.`id` LIMIT ?",
+	stmtSelectParty:                        "SELECT `parties`.`id`, `members`.`display_id`, `parties`.`start`, `parties`.`end`, `parties`.`place`, `parties`.`inviteds`, `parties`.`due`, `parties`.`details`, `parties`.`version` FROM `parties` LEFT JOIN `members` ON `parties`.`creator`=`members`.`id` WHERE `parties`.`name`=?",
+	stmtSelectPartyInternalIDByName:        "SELECT `id` FROM `parties` WHERE `name`=?",
+	stmtSelectPartyInternalIDByNameCreator: "SELECT `parties`.`id` FROM `parties` JOIN `members` ON `parties`.`creator`=`members`.`id` WHERE `parties`.`name`=? AND `members`.`display_id`=?",
+	stmtSelectPartyNameByInternalID:        "SELECT `name` FROM `parties` WHERE `id`=?",
+	stmtSelectPartyVersionByName:           "SELECT `version` FROM `parties` WHERE `name`=?",
 	stmtSelectRecipientsByInternalMail:     "SELECT `members`.`display_id` FROM `members` JOIN `recipients` ON `members`.`id`=`recipients`.`member` WHERE `recipients`.`mail`=?",
+	stmtSelectWebhookByID:                  "SELECT `url`, `secret`, `events` FROM `webhooks` WHERE `id`=?",
+	stmtSelectWebhooks:                     "SELECT `id`, `url`, `secret`, `events` FROM `webhooks` ORDER BY `id`",
 	stmtUpdateAttendance:                   "UPDATE `attendances` JOIN `parties` ON `attendances`.`party`=`parties`.`id` JOIN `members` ON `attendances`.`member`=`members`.`id` SET `attendances`.`attendance`=? WHERE `parties`.`name`=? AND `members`.`display_id`=?",
-	stmtUpdateClub:                         "UPDATE `clubs` SET `name`=IFNULL(@name, `name`), `chief`=IF(@chief, (SELECT `id` FROM `members` WHERE `display_id`=@chief), `chief`) WHERE `display_id`=@id",
+	stmtUpdateClub:                         "UPDATE `clubs` SET `name`=IFNULL(@name, `name`), `chief`=IF(@chief, (SELECT `id` FROM `members` WHERE `display_id`=@chief AND NOT FIND_IN_SET('deleted', `flags`)), `chief`) WHERE `display_id`=@id",
+	stmtUpdateClubVersion:                  "UPDATE `clubs` SET `version`=`version`+1 WHERE `display_id`=?",
+	stmtUpdateDelivery:                     "UPDATE `deliveries` SET `attempts`=LEAST(`attempts`+1, 255), `status`=?, `error`=?, `next`=? WHERE `id`=?",
+	stmtUpdateMail:                         "UPDATE `mails` SET `date`=IFNULL(?, `date`), `from`=IFNULL(?, `from`), `to`=IFNULL(?, `to`), `body`=IFNULL(?, `body`) WHERE `id`=?",
+	stmtUpdateMailVersion:                  "UPDATE `mails` SET `version`=`version`+1 WHERE `subject`=?",
+	stmtUpdateMember:                       "UPDATE `members` SET `flags`=`flags`&?|?, `password`=IFNULL(?, `password`), `affiliation`=IFNULL(?, `affiliation`), `entrance`=IFNULL(?, `entrance`), `gender`=IFNULL(?, `gender`), `mail`=IFNULL(?, `mail`), `nickname`=IFNULL(?, `nickname`), `realname`=IFNULL(?, `realname`), `tel`=IFNULL(?, `tel`) WHERE `id`=?",
 	stmtUpdateMemberPassword:               "UPDATE `members` SET `password`=? WHERE `display_id`=?",
+	stmtUpdateMemberVersion:                "UPDATE `members` SET `version`=`version`+1 WHERE `display_id`=?",
+	stmtUpdateOfficerVersion:               "UPDATE `officers` SET `version`=`version`+1 WHERE `display_id`=?",
+	stmtUpdateParty:                        "UPDATE `parties` SET `start`=IFNULL(?, `start`), `end`=IFNULL(?, `end`), `place`=IFNULL(?, `place`), `due`=IFNULL(?, `due`), `inviteds`=IFNULL(?, `inviteds`), `details`=IFNULL(?, `details`) WHERE `id`=?",
+	stmtUpdatePartyVersion:                 "UPDATE `parties` SET `version`=`version`+1 WHERE `name`=?",
 }
 
 func (db *DB) prepareStmts() error {
@@ -0,0 +1,96 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package db
+
+import (
+	"context"
+	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"github.com/kagucho/tsubonesystem3/backend/event"
+	"github.com/kagucho/tsubonesystem3/backend/scope"
+	"time"
+)
+
+/*
+Store is an interface to store the information managed by TsuboneSystem.
+
+db.DB implements it with the database. db.Memory implements it in memory so
+that the handlers can be exercised without any database. See the methods of
+db.DB for the details of each method.
+*/
+type Store interface {
+	Authenticate(id, password string) error
+	ConfirmMember(id string) error
+	DeclareMemberOB(id string) error
+	DeleteClub(operator, id string, version Precondition) error
+	DeleteMail(subject string, version Precondition) error
+	DeleteMember(operator, id string, version Precondition) error
+	DeleteOfficer(operator, id string, version Precondition) error
+	DeleteParty(name, creator string, version Precondition) error
+	DeleteWebhook(id uint16) error
+	Events() *event.Bus
+	GetScope(id string, password string) (scope.Scope, error)
+	InsertAudit(entry AuditEntry) error
+	InsertClub(operator, id, name, chief string) error
+	InsertDelivery(webhook uint16, kind, payload string) (uint32, error)
+	InsertMail(recipients, from, to, subject, body string) (string, []string, error)
+	InsertMember(operator, id, mail, nickname string) error
+	InsertOfficer(operator, id, name, member, scope string) error
+	InsertParty(name, creator string, start, end encoding.Time, place string, due encoding.Time, invitedIDs, inviteds, details string) ([]string, error)
+	InsertWebhook(url, secret, events string) (uint16, error)
+	PurgeMember(operator, id string) error
+	QueryAudit(ctx context.Context, filter AuditFilter) AuditEntryChan
+	QueryClub(ctx context.Context, id string) (Club, error)
+	QueryClubName(ctx context.Context, id string) (string, error)
+	QueryClubs(ctx context.Context, after string, limit int) (ClubEntryChan, error)
+	QueryDeliveries(ctx context.Context, filter DeliveryFilter) DeliveryChan
+	QueryDelivery(ctx context.Context, id uint32) (Delivery, error)
+	QueryHistory(ctx context.Context, subject HistorySubject, target string) HistoryEntryChan
+	QueryMail(ctx context.Context, subject string) (MailDetail, error)
+	QueryMailID(ctx context.Context, subject string) (uint16, error)
+	QueryMailSubject(ctx context.Context, id uint16) (string, error)
+	QueryMails(ctx context.Context, after uint16, limit int) MailEntryChan
+	QueryMemberDetail(ctx context.Context, id string) (MemberDetail, error)
+	QueryMemberGraph(ctx context.Context, id string) (MemberGraph, error)
+	QueryMemberMails(ctx context.Context) MemberMailChan
+	QueryMemberNickname(ctx context.Context, id string) (string, error)
+	QueryMemberTmp(ctx context.Context, id string) (bool, error)
+	QueryMembers(ctx context.Context, filter MemberFilter, order MemberOrder, descending bool) (MemberEntryChan, error)
+	QueryMembersCount(ctx context.Context, entrance int, nickname string, realname string, status MemberStatus) (uint16, error)
+	QueryOfficerDetail(ctx context.Context, id string) (OfficerDetail, error)
+	QueryOfficerName(ctx context.Context, id string) (string, error)
+	QueryOfficerNames(ctx context.Context) OfficerNameChan
+	QueryOfficers(ctx context.Context, after string, limit int) OfficerEntryChan
+	QueryParties(ctx context.Context, user string, after uint16, limit int) PartyUserChan
+	QueryParty(ctx context.Context, name string) (PartyDetail, error)
+	QueryPartyID(ctx context.Context, name string) (uint16, error)
+	QueryPartyName(ctx context.Context, id uint16) (string, error)
+	QueryWebhook(ctx context.Context, id uint16) (Webhook, error)
+	QueryWebhooks(ctx context.Context) WebhookChan
+	RestoreMember(operator, id string) error
+	Transact(function func(Store) error) error
+	UpdateAttendance(attending bool, party, member string, version Precondition) error
+	UpdateClub(operator, id, name, chief string, version Precondition) error
+	UpdateDelivery(id uint32, status uint16, message string, next time.Time) error
+	UpdateMail(subject, recipients string, date encoding.Time, from, to, body string, version Precondition) error
+	UpdateMember(operator, id string, confirm, ob bool, password, affiliation, clubs string, entrance int, gender, mail, nickname, realname, tel string, version Precondition) error
+	UpdateOfficer(operator, id, name, member, scope string, version Precondition) error
+	UpdateParty(name, creator string, start, end encoding.Time, place string, due encoding.Time, inviteds, invitedIDs, details string, version Precondition) error
+	UpdatePassword(id, currentPassword, newPassword string) error
+}
+
+var _ Store = DB{}
@@ -0,0 +1,93 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package db
+
+import (
+	"crypto/sha512"
+	"unicode/utf8"
+)
+
+/*
+Property is a structure describing the constraints of a kind of properties of
+records.
+*/
+type Property struct {
+	max      int
+	validate func(string) bool
+}
+
+// The kinds of properties of records.
+var (
+	// PropertyID is the ID of a club, a member, or an officer.
+	PropertyID = Property{255, validateID}
+
+	// PropertyName is a short text such as a name or a subject.
+	PropertyName = Property{63, nil}
+
+	// PropertyText is a long text such as a body or details.
+	PropertyText = Property{8192, nil}
+
+	// PropertyMail is the email address of a member encoded in ASCII.
+	PropertyMail = Property{255, validateMemberMail}
+
+	// PropertyNickname is the nickname of a member.
+	PropertyNickname = Property{63, validateMemberNickname}
+
+	// PropertyPassword is the password of a member.
+	PropertyPassword = Property{sha512.BlockSize, validateMemberPassword}
+
+	// PropertyTel is the telephone number of a member.
+	PropertyTel = Property{255, validateTel}
+
+	// PropertyWebhookEvents is the space-separated types of events of a webhook.
+	PropertyWebhookEvents = Property{255, validateWebhookEvents}
+
+	// PropertyWebhookSecret is the secret signing the payloads of a webhook.
+	PropertyWebhookSecret = Property{255, nil}
+
+	// PropertyWebhookURL is the absolute HTTP(S) URL of a webhook.
+	PropertyWebhookURL = Property{255, validateWebhookURL}
+)
+
+// The range of entrance years, which are the limits of YEAR(4) of MariaDB.
+const (
+	EntranceMin = 1901
+	EntranceMax = 2155
+)
+
+/*
+TooLong returns whether the given value is longer than the property can be.
+The length is counted in characters.
+*/
+func (property Property) TooLong(value string) bool {
+	return !validateLength(value, property.max)
+}
+
+// MaxLength returns the maximum length of the property in characters.
+func (property Property) MaxLength() int {
+	return property.max
+}
+
+// Valid returns whether the given value is valid except the length.
+func (property Property) Valid(value string) bool {
+	return property.validate == nil || property.validate(value)
+}
+
+func validateLength(value string, max int) bool {
+	return utf8.RuneCountInString(value) <= max
+}
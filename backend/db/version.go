@@ -0,0 +1,204 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+/*
+Version is a number telling the revision of a record. It increases whenever the
+record or another record included in its representation changes.
+*/
+type Version uint32
+
+/*
+Precondition is a condition on the version of a record to change it. Initialize
+with db.AnyVersion, db.PresentVersion, or db.OneOfVersions.
+*/
+type Precondition struct {
+	versions []Version
+	present  bool
+}
+
+/*
+AnyVersion is db.Precondition telling not to check the version of a record. A
+change of a missing record fails with db.ErrIncorrectIdentity as usual.
+*/
+var AnyVersion = Precondition{}
+
+/*
+PresentVersion is db.Precondition satisfied by any version of a present record.
+A change of a missing record fails with db.ErrVersionMismatch.
+*/
+var PresentVersion = Precondition{present: true}
+
+/*
+OneOfVersions returns db.Precondition satisfied by a present record having one
+of the given versions.
+*/
+func OneOfVersions(versions ...Version) Precondition {
+	return Precondition{append([]Version{}, versions...), true}
+}
+
+/*
+missing returns the error telling the record is missing under the precondition,
+which is db.ErrVersionMismatch if the precondition requires the record to be
+present, and db.ErrIncorrectIdentity otherwise.
+*/
+func (precondition Precondition) missing() error {
+	if precondition.present {
+		return ErrVersionMismatch
+	}
+
+	return ErrIncorrectIdentity
+}
+
+// ErrVersionMismatch is an error telling the record has another version.
+var ErrVersionMismatch = errors.New(`version mismatch`)
+
+/*
+versionStmts is a structure holding the statements to select and increment the
+version of a kind of records.
+*/
+type versionStmts struct {
+	selecting int
+	updating  int
+}
+
+/*
+versionedRecord is a structure identifying a record whose version gets
+incremented.
+*/
+type versionedRecord struct {
+	subject HistorySubject
+	target  string
+}
+
+var (
+	versionClub    = versionStmts{stmtSelectClubVersionByID, stmtUpdateClubVersion}
+	versionMail    = versionStmts{stmtSelectMailVersionBySubject, stmtUpdateMailVersion}
+	versionMember  = versionStmts{stmtSelectMemberVersionByID, stmtUpdateMemberVersion}
+	versionOfficer = versionStmts{stmtSelectOfficerVersionByID, stmtUpdateOfficerVersion}
+	versionParty   = versionStmts{stmtSelectPartyVersionByName, stmtUpdatePartyVersion}
+)
+
+// historyVersions associates the subjects of history with their versions.
+var historyVersions = map[HistorySubject]versionStmts{
+	HistoryClub:    versionClub,
+	HistoryMember:  versionMember,
+	HistoryOfficer: versionOfficer,
+	HistoryParty:   versionParty,
+}
+
+/*
+checkVersion checks the version of the record identified by the given key.
+
+It returns the error given by the missing method of the precondition if the
+record is missing, and db.ErrVersionMismatch if the record has a version not
+satisfying the precondition. It does nothing if the precondition is
+db.AnyVersion.
+*/
+func (db DB) checkVersion(tx *sql.Tx, stmts versionStmts, key string, version Precondition) error {
+	if !version.present {
+		return nil
+	}
+
+	var current Version
+	if err := tx.Stmt(db.stmts[stmts.selecting]).QueryRow(key).Scan(&current); err != nil {
+		if err == sql.ErrNoRows {
+			err = version.missing()
+		}
+
+		return err
+	}
+
+	return matchVersion(current, version)
+}
+
+/*
+matchVersion returns db.ErrVersionMismatch if the current version does not
+satisfy the given precondition.
+*/
+func matchVersion(current Version, version Precondition) error {
+	if version.versions == nil {
+		return nil
+	}
+
+	for _, satisfying := range version.versions {
+		if satisfying == current {
+			return nil
+		}
+	}
+
+	return ErrVersionMismatch
+}
+
+// bumpVersion increments the version of the record identified by the given key.
+func (db DB) bumpVersion(tx *sql.Tx, stmts versionStmts, key string) error {
+	_, err := tx.Stmt(db.stmts[stmts.updating]).Exec(key)
+	return err
+}
+
+/*
+changedRecords returns the records whose representations are affected by the
+given changes of the record of the given subject identified by the given target.
+
+They are the record itself, the clubs a member joins or leaves, and the members
+who get or lose the position of a chief or an officer.
+*/
+func changedRecords(subject HistorySubject, target string, changes []snapshotChange) []versionedRecord {
+	records := []versionedRecord{{subject, target}}
+
+	var field string
+	var related HistorySubject
+
+	switch subject {
+	case HistoryClub:
+		field = `chief`
+		related = HistoryMember
+
+	case HistoryMember:
+		field = `clubs`
+		related = HistoryClub
+
+	case HistoryOfficer:
+		field = `member`
+		related = HistoryMember
+
+	default:
+		return records
+	}
+
+	for _, change := range changes {
+		if change.field != field {
+			continue
+		}
+
+		for _, value := range [...]sql.NullString{change.old, change.new} {
+			for _, relatedTarget := range strings.Fields(value.String) {
+				records = append(records,
+					versionedRecord{related, relatedTarget})
+			}
+		}
+	}
+
+	return records
+}
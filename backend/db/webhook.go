@@ -0,0 +1,473 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"github.com/kagucho/tsubonesystem3/backend/event"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+Webhook is a structure describing a subscription of an outgoing webhook.
+
+Events is the space-separated list of the types of the events delivered to URL.
+Secret is the key signing the payloads, and never marshalled.
+*/
+type Webhook struct {
+	Events string `json:"events"`
+	ID     uint16 `json:"id"`
+	Secret string `json:"-"`
+	URL    string `json:"url"`
+}
+
+// WebhookResult is a structure representing a result of querying db.Webhook.
+type WebhookResult struct {
+	Webhook
+	Error error
+}
+
+// WebhookChan is a reciever of db.WebhookResult.
+type WebhookChan <-chan WebhookResult
+
+/*
+Delivery is a structure describing a delivery of an event to a webhook.
+
+Status is the HTTP status code responded by the last attempt, or 0 if it did
+not get any response. Error describes the failure of the last attempt. Next is
+the time of the next attempt, or nil if the delivery is not pending.
+*/
+type Delivery struct {
+	Attempts uint8          `json:"attempts"`
+	Date     encoding.Time  `json:"date"`
+	Error    string         `json:"error"`
+	Event    string         `json:"event"`
+	ID       uint32         `json:"id"`
+	Next     *encoding.Time `json:"next"`
+	Payload  string         `json:"payload"`
+	Status   uint16         `json:"status"`
+	Webhook  uint16         `json:"webhook"`
+}
+
+// DeliveryResult is a structure representing a result of querying db.Delivery.
+type DeliveryResult struct {
+	Delivery
+	Error error
+}
+
+// DeliveryChan is a reciever of db.DeliveryResult.
+type DeliveryChan <-chan DeliveryResult
+
+/*
+DeliveryFilter is a structure describing the deliveries to query. Zero values
+match any delivery. Pending matches the deliveries which will be attempted
+again.
+*/
+type DeliveryFilter struct {
+	Pending bool
+	Webhook uint16
+}
+
+/*
+MarshalJSON returns the JSON encoding of the remaining webhooks and closes the
+channel.
+
+This implements an interface used in encoding/encoding.
+
+json - The Go Programming Language
+Example (CustomMarshalJSON)
+https://golang.org/pkg/encoding/json/#example__customMarshalJSON
+*/
+func (webhookChan WebhookChan) MarshalJSON() ([]byte, error) {
+	return encoding.MarshalJSONArray(func() (interface{}, error, bool) {
+		result, present := <-webhookChan
+		return result.Webhook, result.Error, present
+	})
+}
+
+/*
+MarshalJSON returns the JSON encoding of the remaining deliveries and closes
+the channel.
+
+This implements an interface used in encoding/encoding.
+
+json - The Go Programming Language
+Example (CustomMarshalJSON)
+https://golang.org/pkg/encoding/json/#example__customMarshalJSON
+*/
+func (deliveryChan DeliveryChan) MarshalJSON() ([]byte, error) {
+	return encoding.MarshalJSONArray(func() (interface{}, error, bool) {
+		result, present := <-deliveryChan
+		return result.Delivery, result.Error, present
+	})
+}
+
+// Subscribes returns whether the webhook subscribes the given type of events.
+func (webhook Webhook) Subscribes(kind string) bool {
+	for _, subscribed := range strings.Fields(webhook.Events) {
+		if subscribed == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+DeleteWebhook deletes the webhook identified by the given ID, and its
+deliveries.
+
+It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
+tell db.DB is bad.
+*/
+func (db DB) DeleteWebhook(id uint16) error {
+	result, err := db.stmt(stmtDeleteWebhook).Exec(id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected <= 0 {
+		return ErrIncorrectIdentity
+	}
+
+	return nil
+}
+
+/*
+InsertDelivery records a pending delivery of the given payload of the given type
+of event to the webhook identified by the given ID, and returns the ID of the
+delivery.
+
+It returns db.ErrIncorrectIdentity if the given ID of the webhook is incorrect.
+Other errors tell db.DB is bad.
+*/
+func (db DB) InsertDelivery(webhook uint16, kind, payload string) (uint32, error) {
+	now := time.Now().Truncate(time.Second)
+
+	result, err := db.stmt(stmtInsertDelivery).Exec(webhook, kind, payload, now, now)
+	if err != nil {
+		return 0, convertMySQLError(err)
+	}
+
+	id, err := result.LastInsertId()
+
+	return uint32(id), err
+}
+
+/*
+InsertWebhook inserts a webhook with the given properties and returns its ID.
+
+It may return one of the following errors:
+db.ErrBadOmission tells some of the given properties is omitted.
+db.ErrInvalid tells some of the given properties is invalid.
+
+Other errors tell db.DB is bad.
+*/
+func (db DB) InsertWebhook(webhookURL, secret, events string) (uint16, error) {
+	if webhookURL == `` || secret == `` || events == `` {
+		return 0, ErrBadOmission
+	}
+
+	if !validateWebhook(webhookURL, secret, events) {
+		return 0, ErrInvalid
+	}
+
+	result, err := db.stmt(stmtInsertWebhook).Exec(webhookURL, secret, events)
+	if err != nil {
+		return 0, convertMySQLError(err)
+	}
+
+	id, err := result.LastInsertId()
+
+	return uint16(id), err
+}
+
+/*
+QueryDelivery returns db.Delivery identified by the given ID.
+
+It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
+tell db.DB is bad.
+*/
+func (db DB) QueryDelivery(ctx context.Context, id uint32) (Delivery, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	delivery, err := scanDelivery(db.stmt(stmtSelectDeliveryByID).QueryRowContext(ctx, id))
+	if err == sql.ErrNoRows {
+		err = ErrIncorrectIdentity
+	}
+
+	return delivery, err
+}
+
+/*
+QueryDeliveries returns db.DeliveryChan representing the deliveries matching
+the given filter, in the order they were recorded.
+
+Resources will be holded until the channel gets closed or the given
+context gets done.
+*/
+func (db DB) QueryDeliveries(ctx context.Context, filter DeliveryFilter) DeliveryChan {
+	resultChan := make(chan DeliveryResult)
+	ctx, cancel := db.withTimeout(ctx)
+
+	go func() {
+		defer func() {
+			close(resultChan)
+			cancel()
+		}()
+
+		rows, err := db.stmt(stmtSelectDeliveries).QueryContext(ctx,
+			filter.Webhook, filter.Webhook, filter.Pending)
+		if err != nil {
+			select {
+			case resultChan <- DeliveryResult{Error: err}:
+			case <-ctx.Done():
+			}
+
+			return
+		}
+
+		defer func() {
+			if err := rows.Close(); err != nil {
+				log.Print(err)
+			}
+		}()
+
+		for rows.Next() {
+			var result DeliveryResult
+			result.Delivery, result.Error = scanDelivery(rows)
+
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			if result.Error != nil {
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			select {
+			case resultChan <- DeliveryResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+/*
+QueryWebhook returns db.Webhook identified by the given ID.
+
+It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
+tell db.DB is bad.
+*/
+func (db DB) QueryWebhook(ctx context.Context, id uint16) (Webhook, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	webhook := Webhook{ID: id}
+	err := db.stmt(stmtSelectWebhookByID).QueryRowContext(ctx, id).Scan(
+		&webhook.URL, &webhook.Secret, &webhook.Events)
+	if err == sql.ErrNoRows {
+		err = ErrIncorrectIdentity
+	}
+
+	return webhook, err
+}
+
+/*
+QueryWebhooks returns db.WebhookChan representing all the webhooks.
+
+Resources will be holded until the channel gets closed or the given
+context gets done.
+*/
+func (db DB) QueryWebhooks(ctx context.Context) WebhookChan {
+	resultChan := make(chan WebhookResult)
+	ctx, cancel := db.withTimeout(ctx)
+
+	go func() {
+		defer func() {
+			close(resultChan)
+			cancel()
+		}()
+
+		rows, err := db.stmt(stmtSelectWebhooks).QueryContext(ctx)
+		if err != nil {
+			select {
+			case resultChan <- WebhookResult{Error: err}:
+			case <-ctx.Done():
+			}
+
+			return
+		}
+
+		defer func() {
+			if err := rows.Close(); err != nil {
+				log.Print(err)
+			}
+		}()
+
+		for rows.Next() {
+			var result WebhookResult
+			result.Error = rows.Scan(&result.ID, &result.URL,
+				&result.Secret, &result.Events)
+
+			select {
+			case resultChan <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			if result.Error != nil {
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			select {
+			case resultChan <- WebhookResult{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+/*
+UpdateDelivery records an attempt of the delivery identified by the given ID,
+which got the given status and error message. The delivery will be attempted
+again at the given time unless it is zero.
+
+It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
+tell db.DB is bad.
+*/
+func (db DB) UpdateDelivery(id uint32, status uint16, message string, next time.Time) error {
+	var nullableNext interface{}
+	if !next.IsZero() {
+		nullableNext = next.Truncate(time.Second)
+	}
+
+	if len(message) > 255 {
+		message = message[:255]
+	}
+
+	result, err := db.stmt(stmtUpdateDelivery).Exec(status, message, nullableNext, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected <= 0 {
+		return ErrIncorrectIdentity
+	}
+
+	return nil
+}
+
+/*
+scanDelivery scans a row of the columns `id`, `webhook`, `event`, `payload`,
+`date`, `attempts`, `status`, `error` and `next` of `deliveries`.
+*/
+func scanDelivery(row interface {
+	Scan(...interface{}) error
+}) (Delivery, error) {
+	var date mysql.NullTime
+	var delivery Delivery
+	var next mysql.NullTime
+
+	if err := row.Scan(&delivery.ID, &delivery.Webhook, &delivery.Event,
+		&delivery.Payload, &date, &delivery.Attempts, &delivery.Status,
+		&delivery.Error, &next); err != nil {
+		return delivery, err
+	}
+
+	delivery.Date = encoding.NewTime(date.Time)
+	if next.Valid {
+		encoded := encoding.NewTime(next.Time)
+		delivery.Next = &encoded
+	}
+
+	return delivery, nil
+}
+
+// validateWebhook returns whether the given properties of a webhook are valid.
+func validateWebhook(webhookURL, secret, events string) bool {
+	for _, validated := range [...]struct {
+		property Property
+		value    string
+	}{
+		{PropertyWebhookURL, webhookURL},
+		{PropertyWebhookSecret, secret},
+		{PropertyWebhookEvents, events},
+	} {
+		if validated.property.TooLong(validated.value) ||
+			!validated.property.Valid(validated.value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+validateWebhookEvents returns whether the given space-separated list consists
+of the known types of events.
+*/
+func validateWebhookEvents(events string) bool {
+	fields := strings.Fields(events)
+	if len(fields) <= 0 {
+		return false
+	}
+
+	for _, field := range fields {
+		if !event.Known(field) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateWebhookURL returns whether the given URL is an absolute HTTP(S) URL.
+func validateWebhookURL(webhookURL string) bool {
+	parsed, err := url.Parse(webhookURL)
+
+	return err == nil && (parsed.Scheme == `http` || parsed.Scheme == `https`) &&
+		parsed.Host != ``
+}
@@ -0,0 +1,95 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package backend
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+drain is a structure counting the requests in flight so that they can finish
+before the resources are released. stop is called when it starts draining to
+stop the requests which never finish by themselves, like streams; it may be nil.
+*/
+type drain struct {
+	mutex    sync.RWMutex
+	draining bool
+	group    sync.WaitGroup
+	stop     func()
+}
+
+/*
+enter registers a request in flight. It returns false if drain.start has been
+called; the request must not be served then. drain.leave must be called when
+the request finishes if it returns true.
+*/
+func (drain *drain) enter() bool {
+	drain.mutex.RLock()
+	defer drain.mutex.RUnlock()
+
+	if drain.draining {
+		return false
+	}
+
+	drain.group.Add(1)
+
+	return true
+}
+
+// leave unregisters a request registered with drain.enter.
+func (drain *drain) leave() {
+	drain.group.Done()
+}
+
+/*
+start stops accepting requests and calls drain.stop unless it has been called.
+It does not wait for the requests in flight.
+*/
+func (drain *drain) start() {
+	drain.mutex.Lock()
+	draining := drain.draining
+	drain.draining = true
+	drain.mutex.Unlock()
+
+	if !draining && drain.stop != nil {
+		drain.stop()
+	}
+}
+
+/*
+wait calls drain.start and waits for the requests in flight. It returns the
+error of the context if it is done before they finish.
+*/
+func (drain *drain) wait(ctx context.Context) error {
+	drain.start()
+
+	done := make(chan struct{})
+	go func() {
+		drain.group.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
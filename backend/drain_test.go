@@ -0,0 +1,125 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"github.com/kagucho/tsubonesystem3/backend/event"
+	"github.com/kagucho/tsubonesystem3/unchunked"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	t.Parallel()
+
+	t.Run(`Finished`, func(t *testing.T) {
+		t.Parallel()
+
+		var tested drain
+		if !tested.enter() {
+			t.Fatal(`expected true, got false`)
+		}
+
+		left := make(chan struct{})
+		go func() {
+			time.Sleep(time.Millisecond)
+			close(left)
+			tested.leave()
+		}()
+
+		if err := tested.wait(context.Background()); err != nil {
+			t.Error(err)
+		}
+
+		select {
+		case <-left:
+		default:
+			t.Error(`wait returned before the request left`)
+		}
+
+		if tested.enter() {
+			t.Error(`expected false after wait, got true`)
+		}
+	})
+
+	t.Run(`Timeout`, func(t *testing.T) {
+		t.Parallel()
+
+		var tested drain
+		if !tested.enter() {
+			t.Fatal(`expected true, got false`)
+		}
+
+		defer tested.leave()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+
+		if err := tested.wait(ctx); err != context.DeadlineExceeded {
+			t.Errorf(`expected %v, got %v`, context.DeadlineExceeded, err)
+		}
+	})
+}
+
+func TestDrainOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	bus := event.New(0)
+	tested := Backend{
+		Unchunked: unchunked.New(func(writer http.ResponseWriter, request *http.Request) {
+			subscription := bus.Subscribe()
+			defer bus.Unsubscribe(subscription)
+
+			writer.WriteHeader(http.StatusOK)
+			writer.(http.Flusher).Flush()
+
+			for published := range subscription.Events() {
+				fmt.Fprintf(writer, "id: %d\n\n", published.ID)
+				writer.(http.Flusher).Flush()
+			}
+		}),
+		drain: &drain{stop: bus.Close},
+	}
+
+	server := httptest.NewUnstartedServer(tested)
+	server.Config.RegisterOnShutdown(tested.Drain)
+	server.Start()
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := server.Config.Shutdown(ctx); err != nil {
+		t.Error(err)
+	}
+
+	if err := tested.drain.wait(ctx); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+/*
+Package event implements an in-process bus of the events telling changes of
+the records.
+*/
+package event
+
+import (
+	"sync"
+	"time"
+)
+
+// The types of events.
+const (
+	AttendanceUpdated = `attendance.updated`
+	ClubCreated       = `club.created`
+	ClubDeleted       = `club.deleted`
+	ClubUpdated       = `club.updated`
+	MailCreated       = `mail.created`
+	MailDeleted       = `mail.deleted`
+	MailUpdated       = `mail.updated`
+	MemberConfirmed   = `member.confirmed`
+	MemberCreated     = `member.created`
+	MemberDeleted     = `member.deleted`
+	MemberUpdated     = `member.updated`
+	OfficerCreated    = `officer.created`
+	OfficerDeleted    = `officer.deleted`
+	OfficerUpdated    = `officer.updated`
+	PartyCreated      = `party.created`
+	PartyDeleted      = `party.deleted`
+	PartyUpdated      = `party.updated`
+)
+
+/*
+Event is a structure describing a change of a record.
+
+ID is assigned by event.Bus. Target is the ID of the changed record, or the
+subject of a mail or the name of a party. Scope is the index of the scope
+required to receive the event. Audience is the IDs of the members the event is
+relevant to, or nil if it is relevant to everyone.
+*/
+type Event struct {
+	ID       uint64
+	Type     string
+	Target   string
+	Scope    uint
+	Audience []string
+}
+
+/*
+Bus is a structure delivering events to subscriptions. It keeps recent events
+so that a subscriber can resume after reconnecting. It should be initialized
+with event.New.
+*/
+type Bus struct {
+	mutex       sync.Mutex
+	last        uint64
+	recent      []Event
+	capacity    int
+	closed      bool
+	subscribers map[*Subscription]struct{}
+}
+
+/*
+Subscription is a structure holding events delivered to a subscriber. Its
+channel is closed when the subscriber falls behind so much that the buffer is
+full, it unsubscribes, or the bus is closed.
+*/
+type Subscription struct {
+	events chan Event
+}
+
+// subscriptionBuffer is the number of events buffered for a subscription.
+const subscriptionBuffer = 64
+
+/*
+New returns a new event.Bus keeping the given number of recent events.
+
+The IDs start from the current time in nanoseconds so that an ID given before
+a restart is told too old instead of being confused with a new one.
+*/
+func New(capacity int) *Bus {
+	return &Bus{
+		last:        uint64(time.Now().UnixNano()),
+		capacity:    capacity,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Known returns whether the given string is one of the types of events.
+func Known(kind string) bool {
+	switch kind {
+	case AttendanceUpdated,
+		ClubCreated, ClubDeleted, ClubUpdated,
+		MailCreated, MailDeleted, MailUpdated,
+		MemberConfirmed, MemberCreated, MemberDeleted, MemberUpdated,
+		OfficerCreated, OfficerDeleted, OfficerUpdated,
+		PartyCreated, PartyDeleted, PartyUpdated:
+		return true
+	}
+
+	return false
+}
+
+// AudienceIncludes returns whether the event is relevant to the given member.
+func (published Event) AudienceIncludes(member string) bool {
+	if published.Audience == nil {
+		return true
+	}
+
+	for _, candidate := range published.Audience {
+		if candidate == member {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Publish assigns an ID to the given event and delivers it to the subscriptions.
+It does not block; a subscription whose buffer is full will be closed.
+*/
+func (bus *Bus) Publish(published Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.last++
+	published.ID = bus.last
+
+	if bus.capacity > 0 {
+		if len(bus.recent) >= bus.capacity {
+			bus.recent = append(bus.recent[:0], bus.recent[1:]...)
+		}
+
+		bus.recent = append(bus.recent, published)
+	}
+
+	for subscription := range bus.subscribers {
+		select {
+		case subscription.events <- published:
+
+		default:
+			close(subscription.events)
+			delete(bus.subscribers, subscription)
+		}
+	}
+}
+
+// Subscribe returns a new subscription delivering the events published later.
+func (bus *Bus) Subscribe() *Subscription {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	return bus.subscribe()
+}
+
+/*
+SubscribeAfter returns a new subscription delivering the events published later,
+and the recent events following the one with the given ID. It returns false if
+some of the events following it are no longer kept or the ID is unknown.
+*/
+func (bus *Bus) SubscribeAfter(id uint64) (*Subscription, []Event, bool) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if id > bus.last {
+		return bus.subscribe(), nil, false
+	}
+
+	if id < bus.last {
+		if len(bus.recent) == 0 || id+1 < bus.recent[0].ID {
+			return bus.subscribe(), nil, false
+		}
+	}
+
+	var missed []Event
+	for _, recent := range bus.recent {
+		if recent.ID > id {
+			missed = append(missed, recent)
+		}
+	}
+
+	return bus.subscribe(), missed, true
+}
+
+/*
+Unsubscribe removes the given subscription and closes its channel unless it is
+already closed.
+*/
+func (bus *Bus) Unsubscribe(subscription *Subscription) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if _, present := bus.subscribers[subscription]; present {
+		close(subscription.events)
+		delete(bus.subscribers, subscription)
+	}
+}
+
+/*
+Close closes the channels of all the subscriptions so that the subscribers
+return. The subscriptions made later are closed from the beginning.
+*/
+func (bus *Bus) Close() {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.closed = true
+
+	for subscription := range bus.subscribers {
+		close(subscription.events)
+		delete(bus.subscribers, subscription)
+	}
+}
+
+// Closed returns whether event.Bus.Close has been called.
+func (bus *Bus) Closed() bool {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	return bus.closed
+}
+
+func (bus *Bus) subscribe() *Subscription {
+	subscription := &Subscription{make(chan Event, subscriptionBuffer)}
+	if bus.closed {
+		close(subscription.events)
+		return subscription
+	}
+
+	bus.subscribers[subscription] = struct{}{}
+
+	return subscription
+}
+
+// Events returns the channel delivering the events.
+func (subscription *Subscription) Events() <-chan Event {
+	return subscription.events
+}
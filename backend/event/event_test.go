@@ -0,0 +1,140 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package event
+
+import "testing"
+
+func TestBus(t *testing.T) {
+	t.Parallel()
+
+	bus := New(2)
+
+	subscription := bus.Subscribe()
+	defer bus.Unsubscribe(subscription)
+
+	for _, target := range [...]string{`a`, `b`, `c`} {
+		bus.Publish(Event{Type: MemberUpdated, Target: target})
+	}
+
+	var ids [3]uint64
+	for index, expected := range [...]string{`a`, `b`, `c`} {
+		published := <-subscription.Events()
+		if published.Target != expected {
+			t.Errorf(`expected %q, got %q`, expected, published.Target)
+		}
+
+		ids[index] = published.ID
+	}
+
+	if ids[1] != ids[0]+1 || ids[2] != ids[1]+1 {
+		t.Errorf(`expected sequential IDs, got %v`, ids)
+	}
+
+	for _, test := range [...]struct {
+		description string
+		id          uint64
+		targets     []string
+		resumed     bool
+	}{
+		{`kept`, ids[0], []string{`b`, `c`}, true},
+		{`latest`, ids[2], nil, true},
+		{`lost`, ids[0] - 1, nil, false},
+		{`unknown`, ids[2] + 1, nil, false},
+	} {
+		resumed, missed, ok := bus.SubscribeAfter(test.id)
+		bus.Unsubscribe(resumed)
+
+		if ok != test.resumed {
+			t.Errorf(`%s: expected %v, got %v`,
+				test.description, test.resumed, ok)
+		}
+
+		if len(missed) != len(test.targets) {
+			t.Errorf(`%s: expected %v, got %v`,
+				test.description, test.targets, missed)
+			continue
+		}
+
+		for index, published := range missed {
+			if published.Target != test.targets[index] {
+				t.Errorf(`%s: expected %v, got %v`,
+					test.description, test.targets, missed)
+			}
+		}
+	}
+}
+
+func TestBusOverflow(t *testing.T) {
+	t.Parallel()
+
+	bus := New(0)
+	subscription := bus.Subscribe()
+
+	for count := 0; count <= subscriptionBuffer; count++ {
+		bus.Publish(Event{Type: MemberUpdated})
+	}
+
+	for count := 0; count < subscriptionBuffer; count++ {
+		<-subscription.Events()
+	}
+
+	if _, present := <-subscription.Events(); present {
+		t.Error(`expected the subscription closed`)
+	}
+
+	bus.Unsubscribe(subscription)
+}
+
+func TestAudienceIncludes(t *testing.T) {
+	t.Parallel()
+
+	if !(Event{}).AudienceIncludes(`a`) {
+		t.Error(`expected everyone included in nil audience`)
+	}
+
+	published := Event{Audience: []string{`a`, `b`}}
+	if !published.AudienceIncludes(`b`) || published.AudienceIncludes(`c`) {
+		t.Errorf(`unexpected audience: %v`, published.Audience)
+	}
+}
+
+func TestBusClose(t *testing.T) {
+	t.Parallel()
+
+	bus := New(1)
+	subscription := bus.Subscribe()
+	bus.Close()
+
+	if _, present := <-subscription.Events(); present {
+		t.Error(`expected the subscription closed`)
+	}
+
+	bus.Unsubscribe(subscription)
+
+	if !bus.Closed() {
+		t.Error(`expected true, got false`)
+	}
+
+	later := bus.Subscribe()
+	if _, present := <-later.Events(); present {
+		t.Error(`expected the later subscription closed`)
+	}
+
+	bus.Unsubscribe(later)
+	bus.Publish(Event{Type: MemberUpdated})
+}
@@ -15,7 +15,10 @@
 	along with this program.  If not, see <http://www.gnu.org/licenses/>.
 */
 
-// Package apiv0 implements API v0.
+/*
+Package apiv0 implements API v0, and API v1 and the GraphQL endpoint which
+share its handlers and infrastructure.
+*/
 package apiv0
 
 import (
@@ -23,17 +26,18 @@ import (
 	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/token/backend"
 	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
 	"github.com/kagucho/tsubonesystem3/backend/mail"
+	"github.com/kagucho/tsubonesystem3/backend/webhook"
 	"github.com/kagucho/tsubonesystem3/safehttp"
 	"log"
 	"net/http"
 	"runtime/debug"
-	"strings"
 )
 
 type shared struct {
-	DB    db.DB
-	Mail  mail.Mail
-	Token backend.Backend // FIXME: why exported?
+	DB      db.Store
+	Mail    mail.Mail
+	Token   backend.Backend // FIXME: why exported?
+	Webhook *webhook.Dispatcher
 }
 
 /*
@@ -42,7 +46,9 @@ with apiv0.New.
 */
 type APIv0 struct {
 	shared       shared
-	routes       routeSlice
+	routes       router
+	v1           router
+	graphQL      router
 	tokenServer  *tokenServer
 }
 
@@ -50,18 +56,27 @@ type APIv0 struct {
 New returns a new apiv0.APIv0. End must be called before disposing returned
 apiv0.APIv0.
 */
-func New(db db.DB, mail mail.Mail) (APIv0, error) {
+func New(db db.Store, mail mail.Mail) (APIv0, error) {
 	token, err := backend.New()
 	if err != nil {
 		return APIv0{}, err
 	}
 
 	apiv0 := APIv0{
-		shared: shared{db, mail, token},
+		shared: shared{db, mail, token, webhook.New(db)},
 		tokenServer: newTokenServer(),
 	}
 
 	apiv0.routes = apiv0.newRoutes()
+	apiv0.v1 = apiv0.newV1Routes()
+	apiv0.graphQL = newRouter(newRoute(`/graphql`, methodMux{
+		map[string]handlerFunc{
+			`GET`:  graphQLServeHTTP,
+			`POST`: graphQLServeHTTP,
+		},
+		[]field{{`Accept-Ranges`, `none`}},
+		nil,
+	}))
 
 	return apiv0, nil
 }
@@ -74,28 +89,70 @@ unexpected result.
 */
 func (apiv0 APIv0) End() {
 	apiv0.tokenServer.end()
+	apiv0.shared.Webhook.End()
 }
 
 // ServeHTTP serves API v0 via HTTP.
 func (apiv0 APIv0) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	safeWriter := safehttp.NewWriter(writer)
+	apiv0.serve(writer, request, apiv0.routes, ``)
+}
 
-	defer safeWriter.Recover(func() {
-		util.ServeErrorDefault(writer, http.StatusInternalServerError)
+// V1 returns http.Handler serving API v1, sharing the context with API v0.
+func (apiv0 APIv0) V1() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		apiv0.serve(writer, request, apiv0.v1, `/v1`)
+	})
+}
 
-		log.Print(recover())
-		debug.PrintStack()
+/*
+GraphQL returns http.Handler serving the GraphQL endpoint at /graphql, sharing
+the context with API v0.
+*/
+func (apiv0 APIv0) GraphQL() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		apiv0.serve(writer, request, apiv0.graphQL, ``)
 	})
+}
 
-	index := apiv0.routes.search(request.URL.Path)
-	if index > len(apiv0.routes) || apiv0.routes[index].prefix != request.URL.Path {
-		index--
-		if index < 0 || !strings.HasPrefix(request.URL.Path, apiv0.routes[index].prefix) || request.URL.Path[len(apiv0.routes[index].prefix)] != '/' {
-			util.ServeErrorDefault(&safeWriter, http.StatusNotFound)
-			return
+/*
+serve serves the request with the given routes. The given prefix is prepended
+to the route recorded in the audit log to tell the version.
+*/
+func (apiv0 APIv0) serve(writer http.ResponseWriter, request *http.Request, routes router, prefix string) {
+	safeWriter := safehttp.NewWriter(writer)
+	bound, request := newAudit(request)
+
+	defer func() {
+		safeWriter.Recover(func() {
+			util.ServeErrorDefault(writer, http.StatusInternalServerError)
+
+			log.Print(recover())
+			debug.PrintStack()
+		})
+
+		// Recover responds with the wrapped writer, leaving the status 0.
+		status := safeWriter.Status()
+		if status == 0 {
+			status = http.StatusInternalServerError
 		}
+
+		bound.record(apiv0.shared.DB, status)
+	}()
+
+	dispatch(&safeWriter, request, bound, routes, prefix, apiv0.shared)
+}
+
+/*
+dispatch serves the request with the handler of the route matching with it,
+and tells the route to the given audit.
+*/
+func dispatch(writer http.ResponseWriter, request *http.Request, bound *audit, routes router, prefix string, shared shared) {
+	route, params, found := routes.search(request.URL)
+	if !found {
+		util.ServeErrorDefault(writer, http.StatusNotFound)
+		return
 	}
 
-	request.URL.Path = strings.TrimPrefix(request.URL.Path, apiv0.routes[index].prefix)
-	apiv0.routes[index].handler.serveHTTP(&safeWriter, request, apiv0.shared)
+	bound.route(prefix+route.template, params.target())
+	route.handler.serveHTTP(writer, withRouteParams(request, params), shared)
 }
@@ -1,3 +1,6 @@
+//go:build dbtest
+// +build dbtest
+
 /*
 	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
 
@@ -18,108 +21,179 @@
 package apiv0
 
 import (
-	"encoding/json"
 	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/db/dbtest"
 	"github.com/kagucho/tsubonesystem3/backend/mail"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 )
 
-func (apiv0 Apiv0) TestServeHTTP(t *testing.T) {
-	var token string
+/*
+testAPIv0 is apiv0.APIv0 backed by db.Memory and mail.Recorder. Initialize
+with newTestAPIv0 and call End before disposing it.
+*/
+type testAPIv0 struct {
+	APIv0
+	memory   *db.Memory
+	recorder *mail.Recorder
+}
 
-	t.Run(`unauthorized`, func(t *testing.T) {
-		t.Parallel()
+func newTestAPIv0(t *testing.T) testAPIv0 {
+	memory, err := dbtest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		recorder := httptest.NewRecorder()
-		request := httptest.NewRequest(`GET`, `http://kagucho.net/club/list`, nil)
-		apiv0.ServeHTTP(recorder, request)
+	mail, recorder, err := mail.NewRecorder(os.DirFS(`test`))
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		if recorder.Code != http.StatusUnauthorized {
-			t.Errorf(`expected %v, got %v`,
-				http.StatusUnauthorized, recorder.Code)
-		}
-	})
+	apiv0, err := New(memory, mail)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	if !t.Run(`public`, func(t *testing.T) {
-		recorder := httptest.NewRecorder()
+	return testAPIv0{apiv0, memory, recorder}
+}
 
-		request := httptest.NewRequest(`POST`, `http://kagucho.net/token`,
-			strings.NewReader(`grant_type=password&username=1stDisplayID&password=1stPassword`))
+// authorization returns the value of Authorization field for the given claim.
+func (apiv0 testAPIv0) authorization(t *testing.T, sub, scope string) string {
+	token, err := apiv0.shared.Token.IssueAccess(sub, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		request.Header[`Content-Type`] =
-			[]string{`application/x-www-form-urlencoded`}
+	return `Bearer ` + token
+}
 
-		apiv0.ServeHTTP(recorder, request)
+// serve serves the given request with API v0 and returns the recorded response.
+func (apiv0 testAPIv0) serve(method, path, authorization, body string) *httptest.ResponseRecorder {
+	return testServe(apiv0, method, path, authorization, body)
+}
 
-		response := struct {
-			AccessToken string `json:"access_token"`
-		}{}
+/*
+testServe serves the given request with the given handler and returns the
+recorded response. body is sent as application/x-www-form-urlencoded if it is
+not empty.
+*/
+func testServe(handler http.Handler, method, path, authorization, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != `` {
+		reader = strings.NewReader(body)
+	}
 
-		decoder := json.NewDecoder(recorder.Body)
-		if err := decoder.Decode(&response); err != nil {
-			t.Fatal(err)
-		}
+	request := httptest.NewRequest(method, `https://kagucho.net`+path, reader)
+	if body != `` {
+		request.Header.Set(`Content-Type`, `application/x-www-form-urlencoded`)
+	}
 
-		token = `Bearer ` + response.AccessToken
-	}) {
-		t.FailNow()
+	if authorization != `` {
+		request.Header.Set(`Authorization`, authorization)
 	}
 
-	t.Run(`private`, func(t *testing.T) {
-		t.Parallel()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
 
-		recorder := httptest.NewRecorder()
-		request := httptest.NewRequest(
-			`GET`, `http://kagucho.net/member/detail`, nil)
+	return recorder
+}
 
-		request.Header.Set(`Authorization`, token)
-		apiv0.ServeHTTP(recorder, request)
+func testCode(t *testing.T, expected int, recorder *httptest.ResponseRecorder) {
+	if recorder.Code != expected {
+		t.Errorf(`expected %v, got %v; body: %s`,
+			expected, recorder.Code, recorder.Body)
+	}
+}
 
-		if recorder.Code != http.StatusBadRequest {
-			t.Errorf(`expected %v, got %v`,
-				http.StatusBadRequest, recorder.Code)
-		}
-	})
+func testBody(t *testing.T, expected string, recorder *httptest.ResponseRecorder) {
+	if result := recorder.Body.String(); result != expected {
+		t.Errorf(`expected %q, got %q`, expected, result)
+	}
+}
 
-	t.Run(`invalid`, func(t *testing.T) {
-		t.Parallel()
+/*
+testRequest is a structure describing a request to apiv0.APIv0 and the
+expected response. response is not compared if it is empty.
+*/
+type testRequest struct {
+	description   string
+	method        string
+	path          string
+	authorization string
+	body          string
+	code          int
+	response      string
+}
 
-		recorder := httptest.NewRecorder()
-		request := httptest.NewRequest(
-			`GET`, `http://kagucho.net/invalid_path`, nil)
+/*
+testRequests serves the given requests in order and tests the responses. The
+responses are also tested to conform to the document served at /openapi.json.
+*/
+func (apiv0 testAPIv0) testRequests(t *testing.T, tests []testRequest) {
+	document := apiv0.openAPI(t)
 
-		request.Header.Set(`Authorization`, token)
-		apiv0.ServeHTTP(recorder, request)
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := apiv0.serve(test.method, test.path,
+				test.authorization, test.body)
 
-		if recorder.Code != http.StatusNotFound {
-			t.Errorf(`expected %v, got %v`,
-				http.StatusNotFound, recorder.Code)
-		}
-	})
-}
+			testCode(t, test.code, recorder)
 
-func TestApiv0(t *testing.T) {
-	var apiv0 Apiv0
+			if test.response != `` {
+				testBody(t, test.response, recorder)
+			}
 
-	db, err := db.Prepare()
-	if err != nil {
-		t.Fatal(err)
+			apiv0.testConformance(t, document,
+				test.method, test.path, recorder)
+		})
 	}
+}
 
-	defer db.Close()
-
-	if !t.Run(`New`, func(t *testing.T) {
-		// TODO: test that mail.Mail is used to send mails.
-		apiv0, err = New(db, mail.Mail{})
-		if err != nil {
-			t.Error(err)
-		}
-	}) {
-		t.FailNow()
+func TestAPIv0ServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	authorization := apiv0.authorization(t, `1stDisplayID`, `user member`)
+
+	for _, test := range [...]struct {
+		description   string
+		method        string
+		path          string
+		authorization string
+		code          int
+	}{
+		{`notFound`, `GET`, `/invalid`, authorization, http.StatusNotFound},
+		{`unauthorized`, `GET`, `/members`, ``, http.StatusUnauthorized},
+		{`exact`, `GET`, `/members`, authorization, http.StatusOK},
+		{`prefix`, `GET`, `/member/2ndDisplayID`, authorization, http.StatusOK},
+		{`prefixWithoutSlash`, `GET`, `/membersx`, authorization, http.StatusNotFound},
+		{`methodNotAllowed`, `POST`, `/members`, authorization, http.StatusMethodNotAllowed},
+	} {
+		test := test
+
+		t.Run(test.description, func(t *testing.T) {
+			t.Parallel()
+
+			testCode(t, test.code,
+				apiv0.serve(test.method, test.path, test.authorization, ``))
+		})
 	}
 
-	t.Run(`ServeHTTP`, apiv0.TestServeHTTP)
+	t.Run(`options`, func(t *testing.T) {
+		t.Parallel()
+
+		recorder := apiv0.serve(`OPTIONS`, `/club/prog`, ``, ``)
+		testCode(t, http.StatusOK, recorder)
+
+		const expected = `application/x-www-form-urlencoded, application/merge-patch+json`
+		if result := recorder.HeaderMap.Get(`Accept-Patch`); result != expected {
+			t.Errorf(`expected %q, got %q`, expected, result)
+		}
+	})
 }
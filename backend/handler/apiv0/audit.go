@@ -0,0 +1,276 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/token"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
+	"github.com/kagucho/tsubonesystem3/backend/scope"
+	"github.com/kagucho/tsubonesystem3/unchunked"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// auditKey is the key of the context value holding *audit of a request.
+type auditKey struct{}
+
+/*
+audit is a structure holding the properties of a request to record in the
+audit log. The handlers fill it while serving the request.
+*/
+type audit struct {
+	authenticated claim
+	entry         db.AuditEntry
+}
+
+type auditVerification struct {
+	Broken *uint32 `json:"broken"`
+	Count  uint32  `json:"count"`
+}
+
+/*
+newAudit returns a new audit for the given request, and the request bound to
+it.
+*/
+func newAudit(request *http.Request) (*audit, *http.Request) {
+	address, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		address = request.RemoteAddr
+	}
+
+	bound := &audit{
+		entry: db.AuditEntry{
+			Address: address,
+			Date:    encoding.NewTime(time.Now()),
+			Method:  request.Method,
+			Target:  encoding.ZeroString(request.URL.Path),
+		},
+	}
+
+	return bound, request.WithContext(
+		context.WithValue(request.Context(), auditKey{}, bound))
+}
+
+/*
+auditAuthenticated tells the audit bound to the given request that it is
+authenticated with the given claim. The claim is recorded even if it lacks the
+scope required by the request.
+*/
+func auditAuthenticated(request *http.Request, authenticated claim) {
+	if bound, ok := request.Context().Value(auditKey{}).(*audit); ok {
+		bound.authenticated = authenticated
+	}
+}
+
+/*
+record records the request responded with the given status in the audit log if
+it may change anything, that is, its method is neither GET nor HEAD.
+*/
+func (bound *audit) record(store db.Store, status int) {
+	if bound.entry.Method == `GET` || bound.entry.Method == `HEAD` {
+		return
+	}
+
+	encodedScope, err := token.EncodeScope(bound.authenticated.scope)
+	if err != nil {
+		log.Print(err)
+	}
+
+	bound.entry.Actor = encoding.ZeroString(bound.authenticated.sub)
+	bound.entry.Scope = encoding.ZeroString(encodedScope)
+	bound.entry.Status = uint16(status)
+
+	if err := store.InsertAudit(bound.entry); err != nil {
+		log.Print(err)
+	}
+}
+
+/*
+route tells the audit the route matching with the request and the target in
+the route.
+*/
+func (bound *audit) route(route, target string) {
+	bound.entry.Route = route
+	bound.entry.Target = encoding.ZeroString(target)
+}
+
+var (
+	schemaAuditEntry = object(map[string]*schema{
+		`actor`:    nullable(schemaString),
+		`address`:  schemaString,
+		`date`:     schemaTime,
+		`hash`:     nullable(schemaString),
+		`id`:       schemaInteger,
+		`method`:   schemaString,
+		`previous`: nullable(schemaString),
+		`route`:    schemaString,
+		`scope`:    nullable(schemaString),
+		`status`:   schemaInteger,
+		`target`:   nullable(schemaString),
+	}, nil)
+
+	schemaAuditVerification = object(map[string]*schema{
+		`broken`: nullable(schemaInteger),
+		`count`:  schemaInteger,
+	}, nil)
+)
+
+var auditGetOperation = operation{
+	summary:  `Get the audit log.`,
+	security: []uint{scope.Management},
+	query: []parameter{
+		{`actor`, `query`, schemaString, false},
+		{`format`, `query`, &schema{Type: `string`, Enum: []string{`json`, `jsonl`}}, false},
+		{`route`, `query`, schemaString, false},
+		{`since`, `query`, schemaTime, false},
+		{`until`, `query`, schemaTime, false},
+	},
+	responses: map[int]response{
+		http.StatusOK: {`The entries.`, nil, map[string]*schema{
+			mediaJSON:           arrayOf(schemaAuditEntry),
+			`application/jsonl`: nil,
+		}},
+		http.StatusBadRequest:          errorResponse(`The query is malformed.`),
+		http.StatusUnprocessableEntity: errorResponse(`The time is out of range.`),
+	},
+}
+
+func auditGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+		return
+	}
+
+	auditGetEntriesServeHTTP(writer, request, shared)
+}
+
+var auditVerifyGetOperation = operation{
+	summary:  `Verify the hash chain of the audit log.`,
+	security: []uint{scope.Management},
+	responses: map[int]response{
+		http.StatusOK: jsonResponse(`The result of the verification.`, schemaAuditVerification),
+	},
+}
+
+func auditVerifyGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+		return
+	}
+
+	auditGetVerificationServeHTTP(writer, request, shared)
+}
+
+func auditGetEntriesServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	format := request.FormValue(`format`)
+	if format != `` && format != `json` && format != `jsonl` {
+		util.ServeError(writer,
+			util.Error{Description: `unknown format`},
+			http.StatusBadRequest)
+
+		return
+	}
+
+	filter := db.AuditFilter{
+		Actor: request.FormValue(`actor`),
+		Route: request.FormValue(`route`),
+	}
+
+	for _, bound := range [...]struct {
+		key   string
+		value *time.Time
+	}{{`since`, &filter.Since}, {`until`, &filter.Until}} {
+		if encoded := request.FormValue(bound.key); encoded != `` {
+			decoded, err := encoding.ParseQueryTime(encoded)
+			if err != nil {
+				description := `syntax error in ` + bound.key
+				status := http.StatusBadRequest
+
+				if err == strconv.ErrRange {
+					description = bound.key + ` out of range`
+					status = http.StatusUnprocessableEntity
+				}
+
+				util.ServeError(writer,
+					util.Error{Description: description},
+					status)
+
+				return
+			}
+
+			*bound.value = decoded.Generic()
+		}
+	}
+
+	// The log grows without bound; avoid holding all of it in memory.
+	unchunked.Stream(writer)
+
+	entries := shared.DB.QueryAudit(request.Context(), filter)
+	if format != `jsonl` {
+		util.ServeJSON(writer, entries, http.StatusOK)
+		return
+	}
+
+	/*
+		JSON Lines
+		http://jsonlines.org/
+	*/
+	writer.Header().Set(`Content-Disposition`,
+		`attachment; filename="audit.jsonl"`)
+	writer.Header().Set(`Content-Type`, `application/jsonl`)
+	writer.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+
+	for result := range entries {
+		if result.Error != nil {
+			panic(result.Error)
+		}
+
+		if err := encoder.Encode(result.AuditEntry); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func auditGetVerificationServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	var previous db.AuditHash
+	var verification auditVerification
+
+	for result := range shared.DB.QueryAudit(request.Context(), db.AuditFilter{}) {
+		if result.Error != nil {
+			panic(result.Error)
+		}
+
+		if verification.Broken == nil && !result.Verify(previous) {
+			id := result.ID
+			verification.Broken = &id
+		}
+
+		previous = result.Hash
+		verification.Count++
+	}
+
+	util.ServeJSON(writer, verification, http.StatusOK)
+}
@@ -0,0 +1,198 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type testAuditEntry struct {
+	Actor   *string `json:"actor"`
+	Address string  `json:"address"`
+	Method  string  `json:"method"`
+	Route   string  `json:"route"`
+	Scope   *string `json:"scope"`
+	Status  uint16  `json:"status"`
+	Target  *string `json:"target"`
+}
+
+func testAuditString(value string) *string {
+	return &value
+}
+
+func TestAudit(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
+	management := apiv0.authorization(t, `1stDisplayID`, `management member`)
+
+	apiv0.testRequests(t, []testRequest{
+		{
+			`patchMember`, `PATCH`, `/member/4thDisplayID`, management,
+			`tel=000-000-004`, http.StatusOK, ``,
+		}, {
+			`getMember`, `GET`, `/member/4thDisplayID`, member,
+			``, http.StatusOK, ``,
+		}, {
+			`patchClub`, `PATCH`, `/club/prog`, member,
+			`name=Prog2部`, http.StatusForbidden, ``,
+		}, {
+			`notFound`, `DELETE`, `/invalid`, ``,
+			``, http.StatusNotFound, ``,
+		}, {
+			`forbidden`, `GET`, `/audit`, member,
+			``, http.StatusForbidden, ``,
+		}, {
+			`unknownFormat`, `GET`, `/audit?format=xml`, management,
+			``, http.StatusBadRequest, ``,
+		}, {
+			`invalidSince`, `GET`, `/audit?since=x`, management,
+			``, http.StatusBadRequest, ``,
+		}, {
+			`verify`, `GET`, `/audit/verify`, management,
+			``, http.StatusOK, "{\"broken\":null,\"count\":3}\n",
+		}, {
+			`invalidPath`, `GET`, `/audit/invalid`, management,
+			``, http.StatusNotFound, ``,
+		},
+	})
+
+	patchMember := testAuditEntry{
+		testAuditString(`1stDisplayID`), `192.0.2.1`, `PATCH`, `/member/{id}`,
+		testAuditString(`management member`), http.StatusOK,
+		testAuditString(`4thDisplayID`),
+	}
+
+	patchClub := testAuditEntry{
+		testAuditString(`3rdDisplayID`), `192.0.2.1`, `PATCH`, `/club/{id}`,
+		testAuditString(`member`), http.StatusForbidden,
+		testAuditString(`prog`),
+	}
+
+	notFound := testAuditEntry{
+		nil, `192.0.2.1`, `DELETE`, ``, nil, http.StatusNotFound,
+		testAuditString(`/invalid`),
+	}
+
+	for _, test := range [...]struct {
+		description string
+		query       string
+		expected    []testAuditEntry
+	}{
+		{`all`, ``, []testAuditEntry{patchMember, patchClub, notFound}},
+		{`actor`, `?actor=3rdDisplayID`, []testAuditEntry{patchClub}},
+		{`route`, `?route=/member/%7Bid%7D`, []testAuditEntry{patchMember}},
+		{`since`, `?since=4102444800`, []testAuditEntry{}},
+		{`until`, `?until=946684800`, []testAuditEntry{}},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := apiv0.serve(`GET`, `/audit`+test.query, management, ``)
+			testCode(t, http.StatusOK, recorder)
+
+			entries := []testAuditEntry{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(entries, test.expected) {
+				t.Errorf(`unexpected entries: %s`, recorder.Body)
+			}
+		})
+	}
+
+	t.Run(`jsonl`, func(t *testing.T) {
+		recorder := apiv0.serve(`GET`, `/audit?format=jsonl`, management, ``)
+		testCode(t, http.StatusOK, recorder)
+
+		if result := recorder.HeaderMap.Get(`Content-Type`); result != `application/jsonl` {
+			t.Errorf(`expected %q, got %q`, `application/jsonl`, result)
+		}
+
+		var entries []testAuditEntry
+		scanner := bufio.NewScanner(recorder.Body)
+		for scanner.Scan() {
+			var entry testAuditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				t.Fatal(err)
+			}
+
+			entries = append(entries, entry)
+		}
+
+		expected := []testAuditEntry{patchMember, patchClub, notFound}
+		if !reflect.DeepEqual(entries, expected) {
+			t.Errorf(`unexpected entries: %v`, entries)
+		}
+	})
+}
+
+func TestAuditPanic(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	routes := router{
+		newRoute(`/panic`, methodMux{
+			handlers: map[string]handlerFunc{
+				`POST`: func(http.ResponseWriter, *http.Request, shared) {
+					panic(`panic`)
+				},
+			},
+		}),
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(`POST`, `https://kagucho.net/panic`, nil)
+
+	func() {
+		defer func() {
+			recover()
+		}()
+
+		apiv0.APIv0.serve(recorder, request, routes, ``)
+	}()
+
+	testCode(t, http.StatusInternalServerError, recorder)
+
+	var statuses []uint16
+	for result := range apiv0.memory.QueryAudit(context.Background(), db.AuditFilter{}) {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+
+		statuses = append(statuses, result.Status)
+	}
+
+	if len(statuses) != 1 || statuses[0] != http.StatusInternalServerError {
+		t.Errorf(`expected [500], got %v`, statuses)
+	}
+}
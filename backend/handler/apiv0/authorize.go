@@ -69,7 +69,7 @@ func authorize(writer http.ResponseWriter, request *http.Request, shared shared,
 	}
 
 	if authenticated.Tmp {
-		temporary, queryErr := shared.DB.QueryMemberTmp(authenticated.Sub)
+		temporary, queryErr := shared.DB.QueryMemberTmp(request.Context(), authenticated.Sub)
 		if queryErr == db.ErrIncorrectIdentity || !temporary {
 			token.ServeError(writer,
 				token.Error{
@@ -104,6 +104,10 @@ func authorize(writer http.ResponseWriter, request *http.Request, shared shared,
 		return claim{}
 	}
 
+	authenticatedClaim :=
+		claim{authenticated.Sub, decodedScope, authenticated.Tmp}
+	auditAuthenticated(request, authenticatedClaim)
+
 	if !decodedScope.IsSet(scope) {
 		token.ServeError(writer,
 			token.Error{
@@ -118,5 +122,5 @@ func authorize(writer http.ResponseWriter, request *http.Request, shared shared,
 		return claim{}
 	}
 
-	return claim{authenticated.Sub, decodedScope, authenticated.Tmp}
+	return authenticatedClaim
 }
@@ -1,3 +1,6 @@
+//go:build dbtest
+// +build dbtest
+
 /*
 	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
 
@@ -18,9 +21,6 @@
 package apiv0
 
 import (
-	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/common"
-	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/context"
-	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/token/backend"
 	"github.com/kagucho/tsubonesystem3/backend/scope"
 	"net/http"
 	"net/http/httptest"
@@ -30,23 +30,42 @@ import (
 func TestAuthorize(t *testing.T) {
 	t.Parallel()
 
-	backend, err := backend.New()
-	if err != nil {
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	if err := apiv0.memory.InsertMember(``, `tmp`, `tmp@kagucho.net`, `tmp`); err != nil {
 		t.Fatal(err)
 	}
 
-	authorizerContext := context.Context{Token: backend}
-	request := httptest.NewRequest(`GET`, `https://kagucho.net/`, nil)
+	issueTmp := func(t *testing.T, sub string) string {
+		issued, err := apiv0.shared.Token.IssueTmpUserAccess(sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return `Bearer ` + issued
+	}
 
-	testError := func(code int, authenticate string, body string) {
+	authorizeWith := func(authorization string, required uint) (claim, *httptest.ResponseRecorder) {
 		recorder := httptest.NewRecorder()
-		Authorize(recorder, request, authorizerContext, Route{Scope: scope.Basic})
+		request := httptest.NewRequest(`GET`, `https://kagucho.net/`, nil)
+		if authorization != `` {
+			request.Header.Set(`Authorization`, authorization)
+		}
+
+		return authorize(recorder, request, apiv0.shared, required),
+			recorder
+	}
 
-		if recorder.Code != code {
-			t.Error(`invalid status code; expected `, code,
-				`, got `, recorder.Code)
+	testError := func(t *testing.T, authorization string, code int, authenticate string, body string) {
+		authorized, recorder := authorizeWith(authorization, scope.Member)
+
+		if (authorized != claim{}) {
+			t.Errorf(`expected zero claim, got %v`, authorized)
 		}
 
+		testCode(t, code, recorder)
+
 		/*
 			RFC 6750 - The OAuth 2.0 Authorization Framework: Bearer Token Usage
 			3.  The WWW-Authenticate Response Header Field
@@ -65,13 +84,12 @@ func TestAuthorize(t *testing.T) {
 				authenticate, result)
 		}
 
-		if result := recorder.Body.String(); result != body {
-			t.Error(`invalid body; expected `, body,
-				`, got `, recorder.Body)
-		}
+		testBody(t, body, recorder)
 	}
 
 	t.Run(`none`, func(t *testing.T) {
+		t.Parallel()
+
 		/*
 			> All challenges defined by this specification MUST use
 			> the auth-scheme value "Bearer".  This scheme MUST be
@@ -79,7 +97,16 @@ func TestAuthorize(t *testing.T) {
 			> auth-param attributes used or defined by this
 			> specification are as follows.  Other auth-param
 			> attributes MAY be used as well.
+		*/
+		testError(t, ``, http.StatusUnauthorized, `Bearer scope=member`,
+			`{"error":"invalid_token","error_description":"expected bearer authentication scheme","error_uri":"https://tools.ietf.org/html/rfc6750#section-2.1","scope":"member"}
+`)
+	})
 
+	t.Run(`invalidToken`, func(t *testing.T) {
+		t.Parallel()
+
+		/*
 			3.1.  Error Codes
 			https://tools.ietf.org/html/rfc6750#section-3.1
 
@@ -88,49 +115,24 @@ func TestAuthorize(t *testing.T) {
 			> The resource SHOULD respond with the HTTP 401
 			> (Unauthorized) status code.
 		*/
-		testError(http.StatusUnauthorized, `Bearer scope=basic`,
-			`{"error":"invalid_token","error_description":"expected bearer authentication scheme","error_uri":"https://tools.ietf.org/html/rfc6750#section-2.1","scope":"basic"}
-`)
-	})
-
-	t.Run(`invalidToken`, func(t *testing.T) {
-		/*
-			2.1.  Authorization Request Header Field
-			https://tools.ietf.org/html/rfc6750#section-2.1
-			> When sending the access token in the "Authorization"
-			> request header field defined by HTTP/1.1 [RFC2617],
-			> the client uses the "Bearer" authentication scheme
-			> to transmit the access token.
-		*/
-		request.Header.Set(`Authorization`, `Bearer invalid`)
-
-		testError(http.StatusUnauthorized,
-			`Bearer error="invalid_token",error_description="expected 3 parts, got 1 parts",error_uri="https://tools.ietf.org/html/rfc7519#section-3.1",scope=basic`,
-			`{"error":"invalid_token","error_description":"expected 3 parts, got 1 parts","error_uri":"https://tools.ietf.org/html/rfc7519#section-3.1","scope":"basic"}
+		testError(t, `Bearer invalid`, http.StatusUnauthorized,
+			`Bearer error="invalid_token",error_description="expected 3 parts, got 1 parts",error_uri="https://tools.ietf.org/html/rfc7519#section-3.1",scope=member`,
+			`{"error":"invalid_token","error_description":"expected 3 parts, got 1 parts","error_uri":"https://tools.ietf.org/html/rfc7519#section-3.1","scope":"member"}
 `)
 	})
 
 	t.Run(`invalidScope`, func(t *testing.T) {
-		issued, err := backend.IssueAccess(`sub`, `invalid`)
-		if err != nil {
-			t.Fatal(err)
-		}
+		t.Parallel()
 
-		request.Header.Set(`Authorization`, `Bearer `+issued)
-
-		testError(http.StatusUnauthorized,
-			`Bearer error="invalid_token",error_description="unknown scope: %22invalid%22",error_uri="https://tools.ietf.org/html/rfc6749#section-7.2",scope=basic`,
-			`{"error":"invalid_token","error_description":"unknown scope: %22invalid%22","error_uri":"https://tools.ietf.org/html/rfc6749#section-7.2","scope":"basic"}
+		testError(t, apiv0.authorization(t, `1stDisplayID`, `invalid`),
+			http.StatusUnauthorized,
+			`Bearer error="invalid_token",error_description="unknown scope: %22invalid%22",error_uri="https://tools.ietf.org/html/rfc6749#section-7.2",scope=member`,
+			`{"error":"invalid_token","error_description":"unknown scope: %22invalid%22","error_uri":"https://tools.ietf.org/html/rfc6749#section-7.2","scope":"member"}
 `)
 	})
 
 	t.Run(`insufficientScope`, func(t *testing.T) {
-		issued, err := backend.IssueAccess(`sub`, ``)
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		request.Header.Set(`Authorization`, `Bearer `+issued)
+		t.Parallel()
 
 		/*
 			> The request requires higher privileges than provided
@@ -139,61 +141,46 @@ func TestAuthorize(t *testing.T) {
 			> MAY include the "scope" attribute with the scope
 			> necessary to access the protected resource.
 		*/
-		testError(http.StatusForbidden,
-			`Bearer error="insufficient_scope",error_description="The request requires higher privileges than provided by the access token.",error_uri="https://tools.ietf.org/html/rfc6750#section-3.1",scope=basic`,
-			`{"error":"insufficient_scope","error_description":"The request requires higher privileges than provided by the access token.","error_uri":"https://tools.ietf.org/html/rfc6750#section-3.1","scope":"basic"}
+		testError(t, apiv0.authorization(t, `1stDisplayID`, `user`),
+			http.StatusForbidden,
+			`Bearer error="insufficient_scope",error_description="The request requires higher privileges than provided by the access token.",error_uri="https://tools.ietf.org/html/rfc6750#section-3.1",scope=member`,
+			`{"error":"insufficient_scope","error_description":"The request requires higher privileges than provided by the access token.","error_uri":"https://tools.ietf.org/html/rfc6750#section-3.1","scope":"member"}
 `)
 	})
 
-	t.Run(`valid`, func(t *testing.T) {
-		issued, err := backend.IssueAccess(`sub`, `basic`)
-		if err != nil {
-			t.Fatal(err)
-		}
+	t.Run(`tmpRegistered`, func(t *testing.T) {
+		t.Parallel()
 
-		request.Header.Set(`Authorization`, `Bearer `+issued)
-		recorder := httptest.NewRecorder()
-		Authorize(recorder, request, authorizerContext,
-			Route{
-				Handle: func(writer http.ResponseWriter,
-					request *http.Request, context context.Context, claim Claim) {
-					if claim.Sub != `sub` {
-						t.Errorf(`invalid Sub; expected "sub", got %q`,
-							claim.Sub)
-					}
-
-					if (claim.Scope != scope.Scope{}.Set(scope.Basic)) {
-						t.Errorf(`invalid scope; expected %v, got %v`,
-							claim.Scope, scope.Scope{}.Set(scope.Basic))
-					}
-
-					writer.WriteHeader(http.StatusOK)
-				},
-			})
-
-		if recorder.Code != http.StatusOK {
-			t.Error(`invalid status code; expected `, http.StatusOK,
-				`, got `, recorder.Code)
+		testError(t, issueTmp(t, `1stDisplayID`), http.StatusUnauthorized,
+			`Bearer error="invalid_token",error_description="invalid token",error_uri="https://tools.ietf.org/html/rfc6749#section-7.2",scope=member`,
+			`{"error":"invalid_token","error_description":"invalid token","error_uri":"https://tools.ietf.org/html/rfc6749#section-7.2","scope":"member"}
+`)
+	})
+
+	t.Run(`tmp`, func(t *testing.T) {
+		t.Parallel()
+
+		authorized, _ := authorizeWith(issueTmp(t, `tmp`), scope.User)
+		if authorized.sub != `tmp` || !authorized.tmp {
+			t.Errorf(`expected temporary claim of "tmp", got %v`, authorized)
 		}
 	})
-}
 
-func TestServeError(t *testing.T) {
-	t.Parallel()
+	t.Run(`valid`, func(t *testing.T) {
+		t.Parallel()
 
-	recorder := httptest.NewRecorder()
-	serveError(recorder, oauthError{common.Error{"\x00", "\x20", "\x22"}, "basic"},
-		http.StatusUnauthorized)
+		authorized, _ := authorizeWith(
+			apiv0.authorization(t, `1stDisplayID`, `member privacy`),
+			scope.Member)
 
-	const expectedChallenge = `Bearer error="%00",error_description=" ",error_uri="%22",scope=basic`
-	if result := recorder.HeaderMap.Get(`WWW-Authenticate`); result != expectedChallenge {
-		t.Errorf(`invalid WWW-Authenticate field in header; expected %q, got %q`,
-			expectedChallenge, result)
-	}
+		expected := claim{
+			`1stDisplayID`,
+			scope.Scope{}.Set(scope.Member).Set(scope.Privacy),
+			false,
+		}
 
-	const expectedCode = http.StatusUnauthorized
-	if result := recorder.Code; result != expectedCode {
-		t.Errorf(`invalid status code; expected %v, got %v`,
-			expectedCode, result)
-	}
+		if authorized != expected {
+			t.Errorf(`expected %v, got %v`, expected, authorized)
+		}
+	})
 }
@@ -0,0 +1,277 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
+	"github.com/kagucho/tsubonesystem3/backend/scope"
+	"github.com/kagucho/tsubonesystem3/safehttp"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// batchMaxRequests is the maximum number of the sub-requests of a batch.
+const batchMaxRequests = 16
+
+/*
+batchRequest is a structure describing a sub-request of a batch. path is
+relative to the root of API v0 and may have a query. The sub-request inherits
+Authorization header field of the batch unless headers has one. body is sent as
+application/x-www-form-urlencoded unless headers has Content-Type.
+*/
+type batchRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+/*
+batchResponse is a structure describing the response to a sub-request. The
+values of a header field occurring several times are joined with commas as
+RFC 7230 allows.
+
+RFC 7230 - Hypertext Transfer Protocol (HTTP/1.1): Message Syntax and Routing
+3.2.2.  Field Order
+https://tools.ietf.org/html/rfc7230#section-3.2.2
+*/
+type batchResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+/*
+errBatchAborted is an error telling a sub-request of an atomic batch failed and
+the transaction must be rolled back.
+*/
+var errBatchAborted = errors.New(`batch aborted`)
+
+var schemaBatchResponse = object(map[string]*schema{
+	`status`:  schemaInteger,
+	`headers`: mapOf(schemaString),
+	`body`:    schemaString,
+}, nil)
+
+var batchPostOperation = operation{
+	summary:  `Serve the sub-requests given as a JSON object, optionally in one transaction.`,
+	security: []uint{scope.User},
+	responses: map[int]response{
+		http.StatusOK: jsonResponse(`The responses to the sub-requests.`,
+			object(map[string]*schema{
+				`aborted`:   schemaBoolean,
+				`responses`: arrayOf(schemaBatchResponse),
+			}, nil)),
+		http.StatusBadRequest:           errorResponse(`The batch is malformed, or atomic and has a request sending emails.`),
+		http.StatusUnsupportedMediaType: errorResponse(`The body is not JSON.`),
+	},
+}
+
+/*
+batchPostServeHTTP returns handlerFunc serving a batch of sub-requests with the
+given routes.
+
+The body is a JSON object whose requests member is a list of batchRequest. The
+sub-requests are served in order, each of them authorized and recorded in the
+audit log as if it was sent alone. If the atomic member is true, they are
+served in one transaction of the database, and the first one failing with a
+status code of 400 or greater rolls back the changes made by the preceding ones
+and aborts the rest. An atomic batch cannot have a sub-request which may send
+emails.
+*/
+func batchPostServeHTTP(routes *router) handlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request, shared shared) {
+		if (authorize(writer, request, shared, scope.User) == claim{}) {
+			return
+		}
+
+		media, _, err := mime.ParseMediaType(request.Header.Get(`Content-Type`))
+		if err != nil || media != mediaJSON {
+			util.ServeError(writer,
+				util.Error{Description: `expected ` + mediaJSON},
+				http.StatusUnsupportedMediaType)
+
+			return
+		}
+
+		var batch struct {
+			Atomic   bool           `json:"atomic"`
+			Requests []batchRequest `json:"requests"`
+		}
+
+		if err := json.NewDecoder(request.Body).Decode(&batch); err != nil {
+			util.ServeError(writer,
+				util.Error{Description: err.Error()},
+				http.StatusBadRequest)
+
+			return
+		}
+
+		if len(batch.Requests) > batchMaxRequests {
+			util.ServeError(writer,
+				util.Error{Description: fmt.Sprintf(`more than %d requests`, batchMaxRequests)},
+				http.StatusBadRequest)
+
+			return
+		}
+
+		subrequests := make([]*http.Request, len(batch.Requests))
+		for index, requested := range batch.Requests {
+			subrequests[index], err = newBatchSubrequest(request, requested, *routes, batch.Atomic)
+			if err != nil {
+				util.ServeError(writer,
+					util.Error{Description: fmt.Sprintf(`requests[%d]: %v`, index, err)},
+					http.StatusBadRequest)
+
+				return
+			}
+		}
+
+		/*
+			The sub-requests are recorded in the audit log out of the
+			transaction so that the failed attempts are kept.
+		*/
+		auditStore := shared.DB
+		responses := make([]batchResponse, 0, len(subrequests))
+
+		serve := func(store db.Store) error {
+			bound := shared
+			bound.DB = store
+
+			for _, subrequest := range subrequests {
+				response := serveBatchSubrequest(subrequest, *routes, bound, auditStore)
+				responses = append(responses, response)
+
+				if batch.Atomic && response.Status >= http.StatusBadRequest {
+					return errBatchAborted
+				}
+			}
+
+			return nil
+		}
+
+		if batch.Atomic {
+			err = shared.DB.Transact(serve)
+		} else {
+			err = serve(shared.DB)
+		}
+
+		switch err {
+		case nil, errBatchAborted:
+			util.ServeJSON(writer, struct {
+				Aborted   bool            `json:"aborted"`
+				Responses []batchResponse `json:"responses"`
+			}{err == errBatchAborted, responses}, http.StatusOK)
+
+		default:
+			panic(err)
+		}
+	}
+}
+
+/*
+newBatchSubrequest returns http.Request for the given sub-request of the given
+batch. It refuses a sub-request nesting another batch or requesting a route of
+the given routes which streams its response, since a batch responds only after
+all of the sub-requests finish. If atomic is true, it also refuses a
+sub-request which may send emails, since they cannot be taken back when the
+transaction is rolled back.
+*/
+func newBatchSubrequest(batch *http.Request, requested batchRequest, routes router, atomic bool) (*http.Request, error) {
+	if requested.Method == `` {
+		return nil, errors.New(`method is required`)
+	}
+
+	if !strings.HasPrefix(requested.Path, `/`) {
+		return nil, errors.New(`path must be absolute`)
+	}
+
+	target, err := url.ParseRequestURI(requested.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Path == `/batch` {
+		return nil, errors.New(`batch cannot be nested`)
+	}
+
+	if matched, _, found := routes.search(target); found {
+		if matched.stream {
+			return nil, errors.New(`stream cannot be batched`)
+		}
+
+		if declared, present := matched.handler.operation(requested.Method); atomic && present && declared.mails {
+			return nil, errors.New(`request sending emails cannot be atomic`)
+		}
+	}
+
+	subrequest, err := http.NewRequest(requested.Method, target.String(),
+		strings.NewReader(requested.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	subrequest = subrequest.WithContext(batch.Context())
+	subrequest.RemoteAddr = batch.RemoteAddr
+	subrequest.Host = batch.Host
+
+	if authorization := batch.Header.Get(`Authorization`); authorization != `` {
+		subrequest.Header.Set(`Authorization`, authorization)
+	}
+
+	if requested.Body != `` {
+		subrequest.Header.Set(`Content-Type`, mediaForm)
+	}
+
+	for name, value := range requested.Headers {
+		subrequest.Header.Set(name, value)
+	}
+
+	return subrequest, nil
+}
+
+/*
+serveBatchSubrequest serves the given sub-request with the given routes and
+records it in the audit log of the given store.
+*/
+func serveBatchSubrequest(subrequest *http.Request, routes router, shared shared, auditStore db.Store) batchResponse {
+	held := v1Writer{header: http.Header{}}
+	safeWriter := safehttp.NewWriter(&held)
+	bound, subrequest := newAudit(subrequest)
+
+	dispatch(&safeWriter, subrequest, bound, routes, ``, shared)
+
+	if held.code == 0 {
+		held.code = http.StatusOK
+	}
+
+	bound.record(auditStore, held.code)
+
+	headers := make(map[string]string, len(held.header))
+	for name, values := range held.header {
+		headers[name] = strings.Join(values, `, `)
+	}
+
+	return batchResponse{held.code, headers, held.body.String()}
+}
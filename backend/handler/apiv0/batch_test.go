@@ -0,0 +1,192 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	document := apiv0.openAPI(t)
+	creator := apiv0.authorization(t, `3rdDisplayID`, `user member`)
+	management := apiv0.authorization(t, `1stDisplayID`, `management member user`)
+
+	serve := func(t *testing.T, authorization, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(`POST`, `https://kagucho.net/batch`,
+			strings.NewReader(body))
+		request.Header.Set(`Authorization`, authorization)
+		request.Header.Set(`Content-Type`, `application/json`)
+
+		recorder := httptest.NewRecorder()
+		apiv0.ServeHTTP(recorder, request)
+		apiv0.testConformance(t, document, `POST`, `/batch`, recorder)
+
+		return recorder
+	}
+
+	decode := func(t *testing.T, recorder *httptest.ResponseRecorder) (bool, []batchResponse) {
+		var decoded struct {
+			Aborted   bool            `json:"aborted"`
+			Responses []batchResponse `json:"responses"`
+		}
+
+		if err := json.Unmarshal(recorder.Body.Bytes(), &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		return decoded.Aborted, decoded.Responses
+	}
+
+	const put = `{"method":"PUT","path":"/party/%s","body":"start=1500000000&end=1500003600&place=place&due=1499990000&invited_ids=3rdDisplayID&inviteds=inviteds&details=details"}`
+
+	for _, test := range [...]struct {
+		description   string
+		authorization string
+		body          string
+		code          int
+	}{
+		{`unauthorized`, ``, `{"requests":[]}`, http.StatusUnauthorized},
+		{`malformed`, creator, `[`, http.StatusBadRequest},
+		{`method`, creator, `{"requests":[{"path":"/clubs"}]}`, http.StatusBadRequest},
+		{`path`, creator, `{"requests":[{"method":"GET","path":"https://example.com/clubs"}]}`, http.StatusBadRequest},
+		{`nested`, creator, `{"requests":[{"method":"POST","path":"/batch"}]}`, http.StatusBadRequest},
+		{`stream`, creator, `{"atomic":true,"requests":[{"method":"GET","path":"/events"}]}`, http.StatusBadRequest},
+		{`tooMany`, creator, `{"requests":[` + strings.Repeat(`{"method":"GET","path":"/clubs"},`, batchMaxRequests) + `{"method":"GET","path":"/clubs"}]}`, http.StatusBadRequest},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			testCode(t, test.code, serve(t, test.authorization, test.body))
+		})
+	}
+
+	t.Run(`form`, func(t *testing.T) {
+		testCode(t, http.StatusUnsupportedMediaType,
+			testServe(apiv0, `POST`, `/batch`, creator, `requests=`))
+	})
+
+	t.Run(`sequential`, func(t *testing.T) {
+		recorder := serve(t, creator, `{"requests":[`+
+			strings.Replace(put, `%s`, `sequential`, 1)+`,`+
+			`{"method":"GET","path":"/party/invalid"},`+
+			`{"method":"GET","path":"/party/sequential","headers":{"Authorization":""}}]}`)
+		testCode(t, http.StatusOK, recorder)
+
+		aborted, responses := decode(t, recorder)
+		if aborted {
+			t.Error(`expected not aborted`)
+		}
+
+		if len(responses) != 3 {
+			t.Fatalf(`expected 3 responses, got %v`, responses)
+		}
+
+		for index, expected := range [...]int{http.StatusCreated, http.StatusNotFound, http.StatusUnauthorized} {
+			if responses[index].Status != expected {
+				t.Errorf(`responses[%d]: expected %d, got %d`,
+					index, expected, responses[index].Status)
+			}
+		}
+
+		if header := responses[2].Headers[`Www-Authenticate`]; header == `` {
+			t.Error(`expected WWW-Authenticate header field`)
+		}
+
+		testCode(t, http.StatusOK,
+			apiv0.serve(`GET`, `/party/sequential`, creator, ``))
+	})
+
+	t.Run(`atomic`, func(t *testing.T) {
+		recorder := serve(t, management, `{"atomic":true,"requests":[`+
+			`{"method":"PUT","path":"/club/atomic","body":"name=atomic&chief=1stDisplayID"},`+
+			`{"method":"PATCH","path":"/club/atomic","headers":{"Content-Type":"application/merge-patch+json"},"body":"{\"name\":\"renamed\"}"},`+
+			`{"method":"GET","path":"/club/atomic"}]}`)
+		testCode(t, http.StatusOK, recorder)
+
+		aborted, responses := decode(t, recorder)
+		if aborted {
+			t.Error(`expected not aborted`)
+		}
+
+		if len(responses) != 3 {
+			t.Fatalf(`expected 3 responses, got %v`, responses)
+		}
+
+		for index, expected := range [...]int{http.StatusCreated, http.StatusOK, http.StatusOK} {
+			if responses[index].Status != expected {
+				t.Errorf(`responses[%d]: expected %d, got %d`,
+					index, expected, responses[index].Status)
+			}
+		}
+
+		if !strings.Contains(responses[2].Body, `"name":"renamed"`) {
+			t.Errorf(`expected the name updated, got %q`, responses[2].Body)
+		}
+	})
+
+	t.Run(`aborted`, func(t *testing.T) {
+		recorder := serve(t, management, `{"atomic":true,"requests":[`+
+			`{"method":"PUT","path":"/club/aborted","body":"name=aborted&chief=1stDisplayID"},`+
+			`{"method":"PUT","path":"/club/aborted2"},`+
+			`{"method":"GET","path":"/club/aborted"}]}`)
+		testCode(t, http.StatusOK, recorder)
+
+		aborted, responses := decode(t, recorder)
+		if !aborted {
+			t.Error(`expected aborted`)
+		}
+
+		if len(responses) != 2 {
+			t.Fatalf(`expected 2 responses, got %v`, responses)
+		}
+
+		if responses[1].Status != http.StatusUnprocessableEntity {
+			t.Errorf(`expected %d, got %d`,
+				http.StatusUnprocessableEntity, responses[1].Status)
+		}
+
+		testCode(t, http.StatusNotFound,
+			apiv0.serve(`GET`, `/club/aborted`, management, ``))
+	})
+
+	t.Run(`mails`, func(t *testing.T) {
+		recorded := len(apiv0.recorder.Recorded())
+
+		testCode(t, http.StatusBadRequest,
+			serve(t, creator, `{"atomic":true,"requests":[`+
+				strings.Replace(put, `%s`, `mails`, 1)+`,`+
+				`{"method":"PUT","path":"/party/mails/attendance"}]}`))
+
+		if mails := apiv0.recorder.Recorded(); len(mails) != recorded {
+			t.Errorf(`expected no mails, got %v`, mails[recorded:])
+		}
+
+		testCode(t, http.StatusNotFound,
+			apiv0.serve(`GET`, `/party/mails`, creator, ``))
+	})
+}
@@ -0,0 +1,179 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	mediaForm       = `application/x-www-form-urlencoded`
+	mediaJSON       = `application/json`
+	mediaMergePatch = `application/merge-patch+json`
+)
+
+/*
+withBody returns a handlerFunc which accepts a JSON object of the given media
+type in addition to application/x-www-form-urlencoded and calls next.
+
+The members of the object are converted to the form so that next validates
+them in the same manner:
+A string is kept as is.
+A number is represented in its literal.
+A boolean is represented as 1 or 0.
+An array of strings is represented as a space-separated list.
+
+null is rejected with 400 Bad Request. It removes the member in a merge patch,
+but the fields cannot be removed. Instead, an empty string or array clears a
+field which can be cleared, like clubs of a member, and leaves the others
+unchanged. Errors are served as problems like the ones found by validation.
+
+RFC 7396 - JSON Merge Patch
+2.  Processing Merge Patch Documents
+https://tools.ietf.org/html/rfc7396#section-2
+> Null values in the merge patch are given special meaning to indicate the
+> removal of existing values in the target.
+*/
+func withBody(mediaType string, next handlerFunc) handlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request, shared shared) {
+		contentType := request.Header.Get(`Content-Type`)
+		if contentType == `` {
+			next(writer, request, shared)
+			return
+		}
+
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			util.ServeProblem(writer,
+				util.Problem{Detail: `invalid Content-Type`},
+				http.StatusBadRequest)
+
+			return
+		}
+
+		switch parsed {
+		case mediaType:
+
+		case mediaJSON, mediaMergePatch:
+			util.ServeProblem(writer,
+				util.Problem{Detail: fmt.Sprintf(`expected %s or %s`, mediaForm, mediaType)},
+				http.StatusUnsupportedMediaType)
+
+			return
+
+		default:
+			next(writer, request, shared)
+			return
+		}
+
+		var object map[string]interface{}
+
+		decoder := json.NewDecoder(request.Body)
+		decoder.UseNumber()
+
+		if err := decoder.Decode(&object); err != nil || object == nil {
+			util.ServeProblem(writer,
+				util.Problem{Detail: `body is not a JSON object`},
+				http.StatusBadRequest)
+
+			return
+		}
+
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		var validation validation
+		form := make(url.Values, len(object))
+
+		for _, key := range keys {
+			converted, ok := bodyValue(object[key])
+			if !ok {
+				validation.invalid(key, reasonSyntax)
+				continue
+			}
+
+			form.Set(key, converted)
+		}
+
+		if validation.serve(writer) {
+			return
+		}
+
+		request.PostForm = form
+		request.Form = make(url.Values, len(form))
+
+		for key, values := range form {
+			request.Form[key] = values
+		}
+
+		for key, values := range request.URL.Query() {
+			request.Form[key] = append(request.Form[key], values...)
+		}
+
+		next(writer, request, shared)
+	}
+}
+
+/*
+bodyValue returns the representation of the given decoded JSON value in the
+form. It returns false if the value cannot be represented.
+*/
+func bodyValue(value interface{}) (string, bool) {
+	switch typed := value.(type) {
+	case bool:
+		if typed {
+			return `1`, true
+		}
+
+		return `0`, true
+
+	case json.Number:
+		return typed.String(), true
+
+	case string:
+		return typed, true
+
+	case []interface{}:
+		items := make([]string, len(typed))
+
+		for index, item := range typed {
+			itemString, ok := item.(string)
+			if !ok || itemString == `` || strings.ContainsRune(itemString, ' ') {
+				return ``, false
+			}
+
+			items[index] = itemString
+		}
+
+		return strings.Join(items, ` `), true
+
+	default:
+		return ``, false
+	}
+}
@@ -0,0 +1,165 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWithBody(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
+	management := apiv0.authorization(t, `1stDisplayID`, `management member`)
+
+	serve := func(method, path, authorization, contentType, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method,
+			`https://kagucho.net`+path, strings.NewReader(body))
+		request.Header.Set(`Authorization`, authorization)
+		request.Header.Set(`Content-Type`, contentType)
+
+		recorder := httptest.NewRecorder()
+		apiv0.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	for _, test := range [...]struct {
+		description string
+		method      string
+		path        string
+		contentType string
+		body        string
+		code        int
+	}{
+		{
+			`putMergePatch`, `PUT`, `/club/sports`, mediaMergePatch,
+			`{"name":"Sports部"}`, http.StatusUnsupportedMediaType,
+		}, {
+			`patchJSON`, `PATCH`, `/club/prog`, mediaJSON,
+			`{"name":"Prog部"}`, http.StatusUnsupportedMediaType,
+		}, {
+			`invalidContentType`, `PUT`, `/club/sports`, `;`,
+			`{}`, http.StatusBadRequest,
+		}, {
+			`notObject`, `PUT`, `/club/sports`, mediaJSON,
+			`["name"]`, http.StatusBadRequest,
+		}, {
+			`null`, `PUT`, `/club/sports`, mediaJSON,
+			`null`, http.StatusBadRequest,
+		}, {
+			`nested`, `PUT`, `/club/sports`, mediaJSON,
+			`{"name":{"ja":"Sports部"}}`, http.StatusBadRequest,
+		}, {
+			`invalidList`, `PUT`, `/club/sports`, mediaJSON,
+			`{"name":["Sports 部"]}`, http.StatusBadRequest,
+		}, {
+			`omitted`, `PUT`, `/club/sports`, mediaJSON,
+			`{"chief":"3rdDisplayID"}`, http.StatusUnprocessableEntity,
+		}, {
+			`put`, `PUT`, `/club/sports`, mediaJSON + `; charset=utf-8`,
+			`{"name":"Sports部","chief":"3rdDisplayID"}`,
+			http.StatusCreated,
+		}, {
+			`patchNull`, `PATCH`, `/club/sports`, mediaMergePatch,
+			`{"name":"Sports部2","chief":null}`, http.StatusBadRequest,
+		}, {
+			`patch`, `PATCH`, `/club/sports`, mediaMergePatch,
+			`{"name":"Sports部2"}`, http.StatusOK,
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := serve(test.method, test.path,
+				management, test.contentType, test.body)
+			testCode(t, test.code, recorder)
+
+			if test.code >= http.StatusBadRequest {
+				if result := recorder.HeaderMap.Get(`Content-Type`); result != `application/problem+json` {
+					t.Errorf(`expected application/problem+json, got %q`, result)
+				}
+			}
+		})
+	}
+
+	t.Run(`nullParam`, func(t *testing.T) {
+		recorder := serve(`PATCH`, `/club/sports`, management,
+			mediaMergePatch, `{"chief":null}`)
+		testCode(t, http.StatusBadRequest, recorder)
+		testBody(t, `{"type":"https://tools.ietf.org/html/rfc7231#section-6.5.1","title":"Bad Request","status":400,"detail":"some fields are invalid","invalid-params":[{"name":"chief","reason":"syntax_error"}]}
+`, recorder)
+	})
+
+	t.Run(`patched`, func(t *testing.T) {
+		recorder := apiv0.serve(`GET`, `/club/sports`, member, ``)
+		testCode(t, http.StatusOK, recorder)
+		testBody(t, `{"name":"Sports部2","chief":"3rdDisplayID","members":[]}
+`, recorder)
+	})
+
+	t.Run(`emptyList`, func(t *testing.T) {
+		testCode(t, http.StatusOK,
+			serve(`PATCH`, `/member/2ndDisplayID`, management,
+				mediaMergePatch, `{"clubs":[]}`))
+
+		recorder := apiv0.serve(`GET`, `/club/prog`, member, ``)
+		testCode(t, http.StatusOK, recorder)
+		testBody(t, `{"name":"Prog部","chief":"2ndDisplayID","members":["1stDisplayID"]}
+`, recorder)
+	})
+
+	t.Run(`omittedList`, func(t *testing.T) {
+		testCode(t, http.StatusOK,
+			serve(`PATCH`, `/member/1stDisplayID`, management,
+				mediaMergePatch, `{"tel":"000-000-010"}`))
+
+		recorder := apiv0.serve(`GET`, `/club/web`, member, ``)
+		testCode(t, http.StatusOK, recorder)
+
+		testBody(t, `{"name":"Web部","chief":"1stDisplayID","members":["1stDisplayID"]}
+`, recorder)
+	})
+
+	t.Run(`list`, func(t *testing.T) {
+		apiv0.recorder.Reset()
+
+		recorder := serve(`PUT`, `/mail/subject`, member, mediaJSON,
+			`{"recipients":["1stDisplayID","2ndDisplayID"],"to":"Prog部","body":"body"}`)
+		testCode(t, http.StatusCreated, recorder)
+
+		recorded := apiv0.recorder.Recorded()
+		if len(recorded) != 1 {
+			t.Fatalf(`expected 1 mail, got %v`, len(recorded))
+		}
+
+		expected := []string{`1st@kagucho.net`, `2nd@kagucho.net`}
+		if !reflect.DeepEqual(recorded[0].Recipients, expected) {
+			t.Errorf(`expected %v, got %v`,
+				expected, recorded[0].Recipients)
+		}
+	})
+}
@@ -24,20 +24,49 @@ import (
 	"net/http"
 )
 
+var (
+	schemaClub = object(map[string]*schema{
+		`chief`:   schemaString,
+		`members`: arrayOf(schemaString),
+		`name`:    schemaString,
+	}, nil)
+
+	schemaClubEntry = object(map[string]*schema{
+		`chief`:   schemaString,
+		`id`:      schemaString,
+		`members`: arrayOf(schemaString),
+		`name`:    schemaString,
+	}, nil)
+)
+
+var clubDeleteOperation = operation{
+	summary:  `Delete the club.`,
+	security: []uint{scope.Management},
+	ifMatch:  true,
+	responses: map[int]response{
+		http.StatusOK:       resultResponse,
+		http.StatusNotFound: errorResponse(`The club is not found.`),
+	},
+}
+
 func clubDeleteServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path == `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
+	authorized := authorize(writer, request, shared, scope.Management)
+	if (authorized == claim{}) {
 		return
 	}
 
-	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+	version, ok := ifMatch(writer, request)
+	if !ok {
 		return
 	}
 
-	switch err := shared.DB.DeleteClub(request.URL.Path[1:]); err {
+	switch err := shared.DB.DeleteClub(authorized.sub, pathParam(request, `id`), version); err {
 	case db.ErrIncorrectIdentity:
 		util.ServeErrorDefault(writer, http.StatusNotFound)
 
+	case db.ErrVersionMismatch:
+		servePreconditionFailed(writer)
+
 	case nil:
 		util.ServeJSON(writer, struct{}{}, http.StatusOK)
 
@@ -46,48 +75,83 @@ func clubDeleteServeHTTP(writer http.ResponseWriter, request *http.Request, shar
 	}
 }
 
-func clubGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path == `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
-		return
-	}
+var clubGetOperation = operation{
+	summary:  `Get the club.`,
+	security: []uint{scope.Member},
+	etag:     true,
+	responses: map[int]response{
+		http.StatusOK:       jsonResponse(`The club.`, schemaClub),
+		http.StatusNotFound: errorResponse(`The club is not found.`),
+	},
+}
 
+func clubGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
 	if (authorize(writer, request, shared, scope.Member) == claim{}) {
 		return
 	}
 
-	switch detail, err := shared.DB.QueryClub(request.URL.Path[1:]); err {
+	switch detail, err := shared.DB.QueryClub(request.Context(), pathParam(request, `id`)); err {
 	case db.ErrIncorrectIdentity:
 		util.ServeErrorDefault(writer, http.StatusNotFound)
 
 	case nil:
-		util.ServeJSON(writer, detail, http.StatusOK)
+		if !serveETag(writer, request, detail.Version) {
+			util.ServeJSON(writer, detail, http.StatusOK)
+		}
 
 	default:
 		panic(err)
 	}
 }
 
+var clubPatchOperation = operation{
+	summary:  `Update the club.`,
+	security: []uint{scope.Management},
+	body: []parameter{
+		{`chief`, ``, property(db.PropertyID), false},
+		{`name`, ``, property(db.PropertyName), false},
+	},
+	media:   mediaMergePatch,
+	ifMatch: true,
+	responses: map[int]response{
+		http.StatusOK:                  resultResponse,
+		http.StatusNotFound:            errorResponse(`The club or the chief is not found.`),
+		http.StatusUnprocessableEntity: invalidResponse(`The properties are invalid.`),
+	},
+}
+
 func clubPatchServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path == `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
+	authorized := authorize(writer, request, shared, scope.Management)
+	if (authorized == claim{}) {
+		return
+	}
+
+	version, ok := ifMatch(writer, request)
+	if !ok {
 		return
 	}
 
-	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+	name := request.PostFormValue(`name`)
+	chief := request.PostFormValue(`chief`)
+
+	var validation validation
+	validation.property(`name`, name, db.PropertyName)
+	validation.property(`chief`, chief, db.PropertyID)
+	if validation.serve(writer) {
 		return
 	}
 
-	switch err := shared.DB.UpdateClub(
-		request.URL.Path[1:],
-		request.PostFormValue(`name`),
-		request.PostFormValue(`chief`)); err {
+	switch err := shared.DB.UpdateClub(authorized.sub,
+		pathParam(request, `id`), name, chief, version); err {
 	case db.ErrIncorrectIdentity:
 		util.ServeErrorDefault(writer, http.StatusNotFound)
 
 	case db.ErrInvalid:
 		util.ServeErrorDefault(writer, http.StatusUnprocessableEntity)
 
+	case db.ErrVersionMismatch:
+		servePreconditionFailed(writer)
+
 	case nil:
 		util.ServeJSON(writer, struct{}{}, http.StatusOK)
 
@@ -96,19 +160,41 @@ func clubPatchServeHTTP(writer http.ResponseWriter, request *http.Request, share
 	}
 }
 
+var clubPutOperation = operation{
+	summary:  `Create the club.`,
+	security: []uint{scope.Management},
+	body: []parameter{
+		{`chief`, ``, property(db.PropertyID), false},
+		{`name`, ``, property(db.PropertyName), true},
+	},
+	media: mediaJSON,
+	responses: map[int]response{
+		http.StatusCreated:             resultResponse,
+		http.StatusNotFound:            errorResponse(`The chief is not found.`),
+		http.StatusUnprocessableEntity: invalidResponse(`The properties are invalid or duplicate.`),
+	},
+}
+
 func clubPutServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if len(request.URL.Path) < 2 {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
+	authorized := authorize(writer, request, shared, scope.Management)
+	if (authorized == claim{}) {
 		return
 	}
 
-	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+	id := pathParam(request, `id`)
+	name := request.PostFormValue(`name`)
+	chief := request.PostFormValue(`chief`)
+
+	var validation validation
+	validation.property(`id`, id, db.PropertyID)
+	validation.require(`name`, name)
+	validation.property(`name`, name, db.PropertyName)
+	validation.property(`chief`, chief, db.PropertyID)
+	if validation.serve(writer) {
 		return
 	}
 
-	switch err := shared.DB.InsertClub(request.URL.Path[1:],
-		request.PostFormValue(`name`),
-		request.PostFormValue(`chief`)); err {
+	switch err := shared.DB.InsertClub(authorized.sub, id, name, chief); err {
 	case db.ErrBadOmission:
 		util.ServeError(writer,
 			util.Error{Description: `id and name are required`},
@@ -134,16 +220,18 @@ func clubPutServeHTTP(writer http.ResponseWriter, request *http.Request, shared
 	}
 }
 
-func clubsGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path != `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
-		return
-	}
+var clubsGetOperation = pageOperation(`List the clubs.`, nil, schemaClubEntry)
 
-	clubChan, err := shared.DB.QueryClubs()
-	if err != nil {
-		panic(err)
-	}
-
-	util.ServeJSON(writer, clubChan, http.StatusOK)
+func clubsGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	serveSoughtPage(writer, request, func(after string, limit int) (pageNext, bool) {
+		clubChan, err := shared.DB.QueryClubs(request.Context(), after, limit)
+		if err != nil {
+			panic(err)
+		}
+
+		return func() (interface{}, string, error, bool) {
+			result, present := <-clubChan
+			return result, result.ID, nil, present
+		}, true
+	})
 }
@@ -0,0 +1,143 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag returns the strong entity tag of the given version.
+func etag(version db.Version) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+/*
+etagList returns the entity tags listed in the given header field, or nil if
+the field is absent. The list includes "*" if the field has it.
+
+RFC 7232 - Hypertext Transfer Protocol (HTTP/1.1): Conditional Requests
+3.1.  If-Match
+https://tools.ietf.org/html/rfc7232#section-3.1
+> If-Match = "*" / 1#entity-tag
+*/
+func etagList(request *http.Request, key string) []string {
+	values, present := request.Header[key]
+	if !present {
+		return nil
+	}
+
+	var list []string
+	for _, value := range values {
+		for _, tag := range strings.Split(value, `,`) {
+			if tag = strings.TrimSpace(tag); tag != `` {
+				list = append(list, tag)
+			}
+		}
+	}
+
+	return list
+}
+
+/*
+serveETag sets ETag header field for the given version. If the request has
+If-None-Match header field matching it, it responds with 304 Not Modified and
+returns true.
+
+RFC 7232 - Hypertext Transfer Protocol (HTTP/1.1): Conditional Requests
+3.2.  If-None-Match
+https://tools.ietf.org/html/rfc7232#section-3.2
+> A recipient MUST use the weak comparison function when comparing
+> entity-tags for If-None-Match
+*/
+func serveETag(writer http.ResponseWriter, request *http.Request, version db.Version) bool {
+	current := etag(version)
+	writer.Header().Set(`ETag`, current)
+
+	for _, tag := range etagList(request, `If-None-Match`) {
+		if tag == `*` || strings.TrimPrefix(tag, `W/`) == current {
+			writer.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ifMatch returns db.Precondition the record should satisfy according to If-Match
+header field of the request. It returns db.AnyVersion if the field is absent,
+and db.PresentVersion if it is "*".
+
+It responds with 412 Precondition Failed and returns false if the field never
+matches, which is the case if it lists only weak entity tags. Otherwise, the
+version is checked by the database, which matches if the record has any of the
+listed versions.
+
+RFC 7232 - Hypertext Transfer Protocol (HTTP/1.1): Conditional Requests
+3.1.  If-Match
+https://tools.ietf.org/html/rfc7232#section-3.1
+> An origin server MUST use the strong comparison function when comparing
+> entity-tags for If-Match
+> If the field-value is "*", the condition is false if the origin server
+> does not have a current representation for the target resource.  If the
+> field-value is a list of entity-tags, the condition is false if none of
+> the listed tags match the entity-tag of the selected representation.
+*/
+func ifMatch(writer http.ResponseWriter, request *http.Request) (db.Precondition, bool) {
+	list := etagList(request, `If-Match`)
+	if list == nil {
+		return db.AnyVersion, true
+	}
+
+	var versions []db.Version
+
+	for _, tag := range list {
+		if tag == `*` {
+			return db.PresentVersion, true
+		}
+
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+
+		parsed, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 32)
+		if err != nil {
+			continue
+		}
+
+		versions = append(versions, db.Version(parsed))
+	}
+
+	if versions == nil {
+		servePreconditionFailed(writer)
+		return db.AnyVersion, false
+	}
+
+	return db.OneOfVersions(versions...), true
+}
+
+// servePreconditionFailed serves an error telling the entity tag does not match.
+func servePreconditionFailed(writer http.ResponseWriter) {
+	util.ServeError(writer,
+		util.Error{Description: `entity tag mismatch`},
+		http.StatusPreconditionFailed)
+}
@@ -0,0 +1,109 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestETag(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	management := apiv0.authorization(t, `1stDisplayID`, `management member`)
+
+	serve := func(method, body, key, value string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method,
+			`https://kagucho.net/club/prog`, strings.NewReader(body))
+		request.Header.Set(`Authorization`, management)
+		request.Header.Set(`Content-Type`, `application/x-www-form-urlencoded`)
+
+		if key != `` {
+			request.Header.Set(key, value)
+		}
+
+		recorder := httptest.NewRecorder()
+		apiv0.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	recorder := serve(`GET`, ``, ``, ``)
+	testCode(t, http.StatusOK, recorder)
+	old := recorder.Header().Get(`ETag`)
+	if old == `` {
+		t.Fatal(`expected ETag, got nothing`)
+	}
+
+	t.Run(`notModified`, func(t *testing.T) {
+		for _, value := range [...]string{old, `W/` + old, `"0", ` + old, `*`} {
+			recorder := serve(`GET`, ``, `If-None-Match`, value)
+			testCode(t, http.StatusNotModified, recorder)
+			testBody(t, ``, recorder)
+		}
+	})
+
+	t.Run(`modified`, func(t *testing.T) {
+		recorder := serve(`GET`, ``, `If-None-Match`, `"0"`)
+		testCode(t, http.StatusOK, recorder)
+	})
+
+	t.Run(`preconditionFailed`, func(t *testing.T) {
+		for _, value := range [...]string{`"4294967295"`, `W/` + old, `invalid`, `W/` + old + `, "4294967295"`} {
+			recorder := serve(`PATCH`, `name=Prog部2`, `If-Match`, value)
+			testCode(t, http.StatusPreconditionFailed, recorder)
+		}
+	})
+
+	t.Run(`match`, func(t *testing.T) {
+		recorder := serve(`PATCH`, `name=Prog部2`, `If-Match`, `"4294967295", `+old)
+		testCode(t, http.StatusOK, recorder)
+
+		recorder = serve(`GET`, ``, ``, ``)
+		if current := recorder.Header().Get(`ETag`); current == old {
+			t.Errorf(`expected ETag other than %s, got the same`, old)
+		}
+
+		recorder = serve(`PATCH`, `name=Prog部3`, `If-Match`, old)
+		testCode(t, http.StatusPreconditionFailed, recorder)
+
+		recorder = serve(`PATCH`, `name=Prog部3`, `If-Match`, `*`)
+		testCode(t, http.StatusOK, recorder)
+	})
+
+	t.Run(`missing`, func(t *testing.T) {
+		for _, value := range [...]string{`*`, old} {
+			request := httptest.NewRequest(`DELETE`,
+				`https://kagucho.net/club/missing`, nil)
+			request.Header.Set(`Authorization`, management)
+			request.Header.Set(`If-Match`, value)
+
+			recorder := httptest.NewRecorder()
+			apiv0.ServeHTTP(recorder, request)
+			testCode(t, http.StatusPreconditionFailed, recorder)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kagucho/tsubonesystem3/backend/event"
+	"github.com/kagucho/tsubonesystem3/backend/scope"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const mediaEventStream = `text/event-stream`
+
+/*
+eventsHeartbeat is the interval of the comments sent to keep the connection
+alive through proxies.
+*/
+var eventsHeartbeat = 30 * time.Second
+
+var eventsGetOperation = operation{
+	summary:  `Stream the changes of the records as Server-Sent Events.`,
+	security: []uint{scope.User},
+	query: []parameter{
+		{`access_token`, `query`, schemaString, false},
+		{`Last-Event-ID`, `header`, schemaString, false},
+	},
+	responses: map[int]response{
+		http.StatusOK: {
+			`The stream of the events. The type of an event is like member.updated, and its data is a JSON object whose target member is the ID of the changed record. An event typed reset tells some events are lost.`,
+			nil, map[string]*schema{mediaEventStream: nil},
+		},
+	},
+}
+
+/*
+eventsGetServeHTTP streams the events of db.Store relevant to the user.
+
+The token may be given as access_token in the query since EventSource cannot set
+Authorization header field.
+
+RFC 6750 - The OAuth 2.0 Authorization Framework: Bearer Token Usage
+2.3.  URI Query Parameter
+https://tools.ietf.org/html/rfc6750#section-2.3
+
+HTML Standard
+9.2 Server-sent events
+https://html.spec.whatwg.org/multipage/server-sent-events.html
+*/
+func eventsGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	if request.Header.Get(`Authorization`) == `` {
+		if accessToken := request.URL.Query().Get(`access_token`); accessToken != `` {
+			request.Header.Set(`Authorization`, `Bearer `+accessToken)
+		}
+	}
+
+	authorized := authorize(writer, request, shared, scope.User)
+	if (authorized == claim{}) {
+		return
+	}
+
+	flusher := writer.(http.Flusher)
+	bus := shared.DB.Events()
+
+	var subscription *event.Subscription
+	var missed []event.Event
+	resumed := true
+
+	if lastEventID := request.Header.Get(`Last-Event-ID`); lastEventID == `` {
+		subscription = bus.Subscribe()
+	} else if id, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+		subscription, missed, resumed = bus.SubscribeAfter(id)
+	} else {
+		subscription = bus.Subscribe()
+		resumed = false
+	}
+
+	defer bus.Unsubscribe(subscription)
+
+	writer.Header().Set(`Cache-Control`, `no-cache`)
+	writer.Header().Set(`Content-Type`, mediaEventStream)
+	writer.WriteHeader(http.StatusOK)
+
+	if !resumed {
+		fmt.Fprint(writer, "event: reset\ndata: {}\n\n")
+	}
+
+	for _, published := range missed {
+		eventsWrite(writer, published, authorized)
+	}
+
+	flusher.Flush()
+
+	ticker := time.NewTicker(eventsHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+
+		case <-ticker.C:
+			fmt.Fprint(writer, ":\n\n")
+
+		case published, present := <-subscription.Events():
+			/*
+				The subscription is closed when the client falls
+				behind. It will reconnect and resume with
+				Last-Event-ID.
+			*/
+			if !present {
+				return
+			}
+
+			eventsWrite(writer, published, authorized)
+		}
+
+		flusher.Flush()
+	}
+}
+
+/*
+eventsWrite writes the given event if it is relevant to the given claim. A
+manager receives the events relevant to anyone.
+*/
+func eventsWrite(writer http.ResponseWriter, published event.Event, authorized claim) {
+	if !authorized.scope.IsSet(published.Scope) {
+		return
+	}
+
+	if !authorized.scope.IsSet(scope.Management) &&
+		!published.AudienceIncludes(authorized.sub) {
+		return
+	}
+
+	data, err := json.Marshal(struct {
+		Target string `json:"target"`
+	}{published.Target})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Fprintf(writer, "id: %d\nevent: %s\ndata: %s\n\n",
+		published.ID, published.Type, data)
+}
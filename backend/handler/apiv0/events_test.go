@@ -0,0 +1,180 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"bufio"
+	"github.com/kagucho/tsubonesystem3/unchunked"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEvent is an event read from a stream.
+type testEvent struct {
+	id        string
+	eventType string
+	data      string
+}
+
+/*
+testEvents connects to the event stream served by the given server and returns
+a function reading the next event. The stream will be closed with the returned
+function.
+*/
+func testEvents(t *testing.T, server *httptest.Server, accessToken, lastEventID string) (func() testEvent, func()) {
+	request, err := http.NewRequest(`GET`,
+		server.URL+`/events?access_token=`+url.QueryEscape(accessToken), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lastEventID != `` {
+		request.Header.Set(`Last-Event-ID`, lastEventID)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		t.Fatalf(`expected %d, got %d`, http.StatusOK, response.StatusCode)
+	}
+
+	if media := response.Header.Get(`Content-Type`); media != mediaEventStream {
+		t.Errorf(`expected %s, got %s`, mediaEventStream, media)
+	}
+
+	scanner := bufio.NewScanner(response.Body)
+
+	return func() testEvent {
+		var read testEvent
+
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == ``:
+				if read.eventType != `` {
+					return read
+				}
+
+			case strings.HasPrefix(line, `id: `):
+				read.id = line[len(`id: `):]
+
+			case strings.HasPrefix(line, `event: `):
+				read.eventType = line[len(`event: `):]
+
+			case strings.HasPrefix(line, `data: `):
+				read.data = line[len(`data: `):]
+			}
+		}
+
+		t.Fatal(`unexpected end of the stream`, scanner.Err())
+
+		return read
+	}, func() { response.Body.Close() }
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	server := httptest.NewServer(unchunked.New(apiv0.ServeHTTP))
+	defer server.Close()
+
+	creator := apiv0.authorization(t, `3rdDisplayID`, `user member`)
+	member := apiv0.authorization(t, `4thDisplayID`, `user member`)
+	memberToken := strings.TrimPrefix(member, `Bearer `)
+
+	t.Run(`unauthorized`, func(t *testing.T) {
+		testCode(t, http.StatusUnauthorized,
+			apiv0.serve(`GET`, `/events`, ``, ``))
+	})
+
+	read, closeStream := testEvents(t, server, memberToken, ``)
+
+	testCode(t, http.StatusCreated,
+		apiv0.serve(`PUT`, `/party/events`, creator,
+			`start=1500000000&end=1500003600&place=place&due=1499990000&invited_ids=3rdDisplayID&inviteds=inviteds&details=details`))
+
+	testCode(t, http.StatusOK,
+		apiv0.serve(`PATCH`, `/member`, member, `affiliation=events`))
+
+	// The party is irrelevant to the member who is not invited.
+	updated := read()
+	closeStream()
+
+	if updated.eventType != `member.updated` ||
+		updated.data != `{"target":"4thDisplayID"}` {
+		t.Errorf(`expected member.updated of 4thDisplayID, got %+v`, updated)
+	}
+
+	id, err := strconv.ParseUint(updated.id, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run(`resume`, func(t *testing.T) {
+		read, closeStream := testEvents(t, server, memberToken,
+			strconv.FormatUint(id-2, 10))
+		defer closeStream()
+
+		if resumed := read(); resumed != updated {
+			t.Errorf(`expected %+v, got %+v`, updated, resumed)
+		}
+	})
+
+	t.Run(`reset`, func(t *testing.T) {
+		read, closeStream := testEvents(t, server, memberToken, `0`)
+		defer closeStream()
+
+		if reset := read(); reset.eventType != `reset` {
+			t.Errorf(`expected reset, got %+v`, reset)
+		}
+	})
+
+	t.Run(`close`, func(t *testing.T) {
+		client := http.Client{Timeout: time.Second}
+
+		response, err := client.Get(server.URL + `/events?access_token=` +
+			url.QueryEscape(memberToken))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer response.Body.Close()
+
+		apiv0.shared.DB.Events().Close()
+
+		if _, err := ioutil.ReadAll(response.Body); err != nil {
+			t.Error(`expected the stream to end, got `, err)
+		}
+	})
+}
@@ -0,0 +1,685 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/graphql"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
+	"github.com/kagucho/tsubonesystem3/backend/scope"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// graphQLKey is the key of the context value holding graphQLContext.
+type graphQLKey struct{}
+
+// graphQLContext is a structure holding the context of resolvers.
+type graphQLContext struct {
+	shared shared
+	claim  claim
+}
+
+/*
+graphQLLoader is a function returning the record identified by the given ID,
+or nil if it is not found.
+*/
+type graphQLLoader func(ctx context.Context, id string) (interface{}, error)
+
+/*
+errGraphQLScope is an error telling the field requires the member scope, which
+the token does not have.
+*/
+var errGraphQLScope = errors.New(`insufficient_scope: the field requires member scope`)
+
+// graphQLSchema is the schema of the GraphQL endpoint.
+var graphQLSchema = newGraphQLSchema()
+
+/*
+newGraphQLSchema returns the schema of the GraphQL endpoint. The fields and
+their visibility are the same as the ones of API v0.
+*/
+func newGraphQLSchema() graphql.Schema {
+	fillMember := graphQLFill(graphQLMember, `clubs`, `confirmed`, `deleted`,
+		`gender`, `mail`, `positions`, `tel`)
+	fillOfficer := graphQLFill(graphQLOfficer, `scope`)
+	fillMail := graphQLFill(graphQLMail, `body`, `recipients`)
+	fillParty := graphQLFill(graphQLParty, `attendances`, `details`)
+
+	member := graphql.Field{Type: `Member`, Cost: 1}
+	members := graphql.Field{Type: `[Member]`, Cost: 1}
+
+	return graphql.Schema{
+		Objects: map[string]graphql.Object{
+			`Club`: {
+				`chief`:   graphQLWith(member, graphQLReference(graphQLSource(`chief`), graphQLMember)),
+				`id`:      {Type: `String`},
+				`members`: graphQLWith(members, graphQLReference(graphQLSource(`members`), graphQLMember)),
+				`name`:    {Type: `String`},
+			},
+			`Mail`: {
+				`body`:       {Type: `String`, Cost: 1, Resolve: fillMail(`body`)},
+				`date`:       {Type: `Int`},
+				`from`:       graphQLWith(member, graphQLReference(graphQLSource(`from`), graphQLMember)),
+				`id`:         {Type: `Int`},
+				`recipients`: graphQLWith(members, graphQLReference(fillMail(`recipients`), graphQLMember)),
+				`subject`:    {Type: `String`},
+				`to`:         {Type: `String`},
+			},
+			`Member`: {
+				`affiliation`: {Type: `String`},
+				`clubs`:       {Type: `[MemberClub]`, Cost: 1, Resolve: fillMember(`clubs`)},
+				`confirmed`:   {Type: `Boolean`, Cost: 1, Resolve: fillMember(`confirmed`)},
+				`deleted`:     {Type: `Boolean`, Cost: 1, Resolve: fillMember(`deleted`)},
+				`entrance`:    {Type: `Int`},
+				`gender`:      {Type: `String`, Cost: 1, Resolve: fillMember(`gender`)},
+				`id`:          {Type: `String`},
+				`mail`:        {Type: `String`, Cost: 1, Resolve: fillMember(`mail`)},
+				`nickname`:    {Type: `String`},
+				`ob`:          {Type: `Boolean`},
+				`positions`: {
+					Type:    `[Officer]`,
+					Cost:    1,
+					Resolve: graphQLReference(fillMember(`positions`), graphQLOfficer),
+				},
+				`realname`: {Type: `String`},
+				`tel`:      {Type: `String`, Cost: 1, Resolve: fillMember(`tel`)},
+			},
+			`MemberClub`: {
+				`chief`: {Type: `Boolean`},
+				`club`: {
+					Type:    `Club`,
+					Cost:    1,
+					Resolve: graphQLReference(graphQLSource(`id`), graphQLClub),
+				},
+			},
+			`Officer`: {
+				`id`:     {Type: `String`},
+				`member`: graphQLWith(member, graphQLReference(graphQLSource(`member`), graphQLMember)),
+				`name`:   {Type: `String`},
+				`scope`:  {Type: `[String]`, Cost: 1, Resolve: fillOfficer(`scope`)},
+			},
+			`Party`: {
+				`attendance`: {
+					Type:    `String`,
+					Cost:    1,
+					Resolve: graphQLPartyAttendance(fillParty(`attendances`)),
+				},
+				`attendances`: {
+					Type:    `[PartyAttendance]`,
+					Cost:    1,
+					Resolve: graphQLPartyAttendances(fillParty(`attendances`)),
+				},
+				`creator`:  graphQLWith(member, graphQLReference(graphQLSource(`creator`), graphQLMember)),
+				`details`:  {Type: `String`, Cost: 1, Resolve: fillParty(`details`)},
+				`due`:      {Type: `Int`},
+				`end`:      {Type: `Int`},
+				`id`:       {Type: `Int`},
+				`inviteds`: {Type: `String`},
+				`name`:     {Type: `String`},
+				`place`:    {Type: `String`},
+				`start`:    {Type: `Int`},
+			},
+			`PartyAttendance`: {
+				`attendance`: {Type: `String`},
+				`member`:     graphQLWith(member, graphQLReference(graphQLSource(`member`), graphQLMember)),
+			},
+			`Query`: {
+				`club`: {
+					Type:      `Club`,
+					Arguments: map[string]graphql.Argument{`id`: {Type: `String`, Required: true}},
+					Cost:      1,
+					Resolve:   graphQLReference(graphQLArgument(`id`), graphQLClub),
+				},
+				`clubs`: {Type: `[Club]`, Cost: 1, Resolve: graphQLClubs},
+				`mail`: {
+					Type:      `Mail`,
+					Arguments: map[string]graphql.Argument{`id`: {Type: `Int`, Required: true}},
+					Cost:      1,
+					Resolve:   graphQLReference(graphQLArgument(`id`), graphQLMail),
+				},
+				`mails`: {Type: `[Mail]`, Cost: 1, Resolve: graphQLMails},
+				`member`: {
+					Type:      `Member`,
+					Arguments: map[string]graphql.Argument{`id`: {Type: `String`, Required: true}},
+					Cost:      1,
+					Resolve:   graphQLReference(graphQLArgument(`id`), graphQLMember),
+				},
+				`members`: {Type: `[Member]`, Cost: 1, Resolve: graphQLMembers},
+				`officer`: {
+					Type:      `Officer`,
+					Arguments: map[string]graphql.Argument{`id`: {Type: `String`, Required: true}},
+					Cost:      1,
+					Resolve:   graphQLReference(graphQLArgument(`id`), graphQLOfficer),
+				},
+				`officers`: {Type: `[Officer]`, Cost: 1, Resolve: graphQLOfficers},
+				`parties`:  {Type: `[Party]`, Cost: 1, Resolve: graphQLParties},
+				`party`: {
+					Type:      `Party`,
+					Arguments: map[string]graphql.Argument{`id`: {Type: `Int`, Required: true}},
+					Cost:      1,
+					Resolve:   graphQLReference(graphQLArgument(`id`), graphQLParty),
+				},
+				`user`: {Type: `Member`, Cost: 1, Resolve: graphQLUser},
+			},
+		},
+		Query:    `Query`,
+		MaxDepth: 8,
+		MaxCost:  1000,
+		ListSize: 10,
+	}
+}
+
+/*
+graphQLServeHTTP serves GraphQL queries. A query is given as the query
+parameters of GET or a JSON object of POST, with the members query,
+operationName and variables.
+
+GraphQL
+Serving over HTTP
+http://graphql.org/learn/serving-over-http/
+*/
+func graphQLServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	authorized := authorize(writer, request, shared, scope.User)
+	if (authorized == claim{}) {
+		return
+	}
+
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+
+	if request.Method == `POST` {
+		media, _, err := mime.ParseMediaType(request.Header.Get(`Content-Type`))
+		if err != nil || media != mediaJSON {
+			util.ServeError(writer,
+				util.Error{Description: `expected ` + mediaJSON},
+				http.StatusUnsupportedMediaType)
+
+			return
+		}
+
+		decoder := json.NewDecoder(request.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&params); err != nil {
+			util.ServeError(writer,
+				util.Error{Description: err.Error()},
+				http.StatusBadRequest)
+
+			return
+		}
+	} else {
+		params.Query = request.FormValue(`query`)
+		params.OperationName = request.FormValue(`operationName`)
+
+		if variables := request.FormValue(`variables`); variables != `` {
+			decoder := json.NewDecoder(bytes.NewBufferString(variables))
+			decoder.UseNumber()
+			if err := decoder.Decode(&params.Variables); err != nil {
+				util.ServeError(writer,
+					util.Error{Description: err.Error()},
+					http.StatusBadRequest)
+
+				return
+			}
+		}
+	}
+
+	ctx := context.WithValue(request.Context(), graphQLKey{},
+		graphQLContext{shared, authorized})
+
+	response := graphQLSchema.Execute(ctx,
+		params.Query, params.OperationName, params.Variables)
+	if response.Data == nil {
+		util.ServeJSON(writer, response, http.StatusBadRequest)
+		return
+	}
+
+	util.ServeJSON(writer, response, http.StatusOK)
+}
+
+// graphQLRequest returns graphQLContext bound to the given context.
+func graphQLRequest(ctx context.Context) graphQLContext {
+	return ctx.Value(graphQLKey{}).(graphQLContext)
+}
+
+/*
+graphQLMemberRequest returns graphQLContext bound to the given context, or an
+error if the token does not have the member scope.
+*/
+func graphQLMemberRequest(ctx context.Context) (graphQLContext, error) {
+	request := graphQLRequest(ctx)
+	if !request.claim.scope.IsSet(scope.Member) {
+		return graphQLContext{}, errGraphQLScope
+	}
+
+	return request, nil
+}
+
+/*
+graphQLDecode returns the value of graphql encoding the given value in JSON as
+API v0 does.
+*/
+func graphQLDecode(value interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewBuffer(encoded))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	return decoded, decoder.Decode(&decoded)
+}
+
+// graphQLObject returns the object decoded with the given members added.
+func graphQLObject(value interface{}, members map[string]interface{}) (interface{}, error) {
+	decoded, err := graphQLDecode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	object := decoded.(map[string]interface{})
+	for key, member := range members {
+		object[key] = member
+	}
+
+	return object, nil
+}
+
+// graphQLWith returns the given field resolved with the given resolver.
+func graphQLWith(field graphql.Field, resolve graphql.Resolver) graphql.Field {
+	field.Resolve = resolve
+	return field
+}
+
+// graphQLSource returns graphql.Resolver resolving the given member of the source.
+func graphQLSource(name string) graphql.Resolver {
+	return func(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+		return source[name], nil
+	}
+}
+
+// graphQLArgument returns graphql.Resolver resolving the given argument.
+func graphQLArgument(name string) graphql.Resolver {
+	return func(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+		return arguments[name], nil
+	}
+}
+
+/*
+graphQLReference returns graphql.Resolver resolving the records identified by
+the ID or the list of the IDs resolved by the given resolver with the given
+loader. Records not found are null in a list.
+*/
+func graphQLReference(resolve graphql.Resolver, load graphQLLoader) graphql.Resolver {
+	return func(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+		value, err := resolve(ctx, source, arguments)
+		if err != nil || value == nil {
+			return nil, err
+		}
+
+		ids, list := value.([]interface{})
+		if !list {
+			return load(ctx, graphQLID(value))
+		}
+
+		records := make([]interface{}, len(ids))
+		for index, id := range ids {
+			records[index], err = load(ctx, graphQLID(id))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return records, nil
+	}
+}
+
+// graphQLID returns the given ID in a string.
+func graphQLID(id interface{}) string {
+	if number, ok := id.(json.Number); ok {
+		return string(number)
+	}
+
+	return id.(string)
+}
+
+/*
+graphQLFill returns a function which returns graphql.Resolver resolving the
+given member of the source. If the source lacks the member, it fills the given
+members of the source with the record loaded with the ID of the source first.
+Members absent in the loaded record are filled with null so that the record is
+loaded only once.
+*/
+func graphQLFill(load graphQLLoader, names ...string) func(string) graphql.Resolver {
+	return func(name string) graphql.Resolver {
+		return func(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+			if value, present := source[name]; present {
+				return value, nil
+			}
+
+			loaded, err := load(ctx, graphQLID(source[`id`]))
+			if err != nil {
+				return nil, err
+			}
+
+			record, _ := loaded.(map[string]interface{})
+			for _, filled := range names {
+				if _, present := source[filled]; !present {
+					source[filled] = record[filled]
+				}
+			}
+
+			return source[name], nil
+		}
+	}
+}
+
+func graphQLUser(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+	request := graphQLRequest(ctx)
+	return graphQLMemberDetail(ctx, request, request.claim.sub)
+}
+
+func graphQLMember(ctx context.Context, id string) (interface{}, error) {
+	request, err := graphQLMemberRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return graphQLMemberDetail(ctx, request, id)
+}
+
+/*
+graphQLMemberDetail returns the member identified with the given ID with the
+visibility of memberGetServeHTTP.
+*/
+func graphQLMemberDetail(ctx context.Context, request graphQLContext, id string) (interface{}, error) {
+	switch detail, err := request.shared.DB.QueryMemberDetail(ctx, id); err {
+	case db.ErrIncorrectIdentity:
+		return nil, nil
+
+	case nil:
+		return graphQLObject(memberVisibleDetail(detail, id, request.claim),
+			map[string]interface{}{`id`: id})
+
+	default:
+		return nil, err
+	}
+}
+
+func graphQLMembers(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+	request, err := graphQLMemberRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := request.shared.DB.QueryMembers(ctx,
+		db.MemberFilter{}, db.MemberOrderID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return graphQLDecode(members)
+}
+
+func graphQLClub(ctx context.Context, id string) (interface{}, error) {
+	request, err := graphQLMemberRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch club, err := request.shared.DB.QueryClub(ctx, id); err {
+	case db.ErrIncorrectIdentity:
+		return nil, nil
+
+	case nil:
+		return graphQLObject(club, map[string]interface{}{`id`: id})
+
+	default:
+		return nil, err
+	}
+}
+
+func graphQLClubs(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+	request, err := graphQLMemberRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clubs, err := request.shared.DB.QueryClubs(ctx, ``, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return graphQLDecode(clubs)
+}
+
+func graphQLOfficer(ctx context.Context, id string) (interface{}, error) {
+	request, err := graphQLMemberRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch officer, err := request.shared.DB.QueryOfficerDetail(ctx, id); err {
+	case db.ErrIncorrectIdentity:
+		return nil, nil
+
+	case nil:
+		return graphQLObject(officer, map[string]interface{}{`id`: id})
+
+	default:
+		return nil, err
+	}
+}
+
+func graphQLOfficers(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+	request, err := graphQLMemberRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return graphQLDecode(request.shared.DB.QueryOfficers(ctx, ``, -1))
+}
+
+// graphQLMail loads the email identified with the given internal ID.
+func graphQLMail(ctx context.Context, id string) (interface{}, error) {
+	request, err := graphQLMemberRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	internal, err := strconv.ParseUint(id, 10, 16)
+	if err != nil {
+		return nil, nil
+	}
+
+	subject, err := request.shared.DB.QueryMailSubject(ctx, uint16(internal))
+	if err == db.ErrIncorrectIdentity {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch mail, err := request.shared.DB.QueryMail(ctx, subject); err {
+	case db.ErrIncorrectIdentity:
+		return nil, nil
+
+	case nil:
+		return graphQLObject(mail, map[string]interface{}{
+			`id`:      json.Number(id),
+			`subject`: subject,
+		})
+
+	default:
+		return nil, err
+	}
+}
+
+func graphQLMails(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+	request, err := graphQLMemberRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var mails []interface{}
+	for result := range request.shared.DB.QueryMails(ctx, 0, -1) {
+		if result.Error != nil {
+			err = result.Error
+			continue
+		}
+
+		mail, objectErr := graphQLObject(result.MailEntry, map[string]interface{}{
+			`id`: json.Number(strconv.FormatUint(uint64(result.ID), 10)),
+		})
+		if objectErr != nil {
+			err = objectErr
+			continue
+		}
+
+		mails = append(mails, mail)
+	}
+
+	return mails, err
+}
+
+// graphQLParty loads the party identified with the given internal ID.
+func graphQLParty(ctx context.Context, id string) (interface{}, error) {
+	request, err := graphQLMemberRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	internal, err := strconv.ParseUint(id, 10, 16)
+	if err != nil {
+		return nil, nil
+	}
+
+	name, err := request.shared.DB.QueryPartyName(ctx, uint16(internal))
+	if err == db.ErrIncorrectIdentity {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch party, err := request.shared.DB.QueryParty(ctx, name); err {
+	case db.ErrIncorrectIdentity:
+		return nil, nil
+
+	case nil:
+		return graphQLObject(party, map[string]interface{}{
+			`id`:   json.Number(id),
+			`name`: name,
+		})
+
+	default:
+		return nil, err
+	}
+}
+
+func graphQLParties(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+	request, err := graphQLMemberRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var parties []interface{}
+	for result := range request.shared.DB.QueryParties(ctx, request.claim.sub, 0, -1) {
+		if result.Error != nil {
+			err = result.Error
+			continue
+		}
+
+		party, objectErr := graphQLObject(result.PartyUser, map[string]interface{}{
+			`id`: json.Number(strconv.FormatUint(uint64(result.ID), 10)),
+		})
+		if objectErr != nil {
+			err = objectErr
+			continue
+		}
+
+		parties = append(parties, party)
+	}
+
+	return parties, err
+}
+
+/*
+graphQLPartyAttendance returns graphql.Resolver resolving the attendance of the
+user, which is in user member of the parties listed, or in the attendances
+resolved with the given resolver.
+*/
+func graphQLPartyAttendance(attendances graphql.Resolver) graphql.Resolver {
+	return func(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+		if user, present := source[`user`]; present {
+			return user, nil
+		}
+
+		resolved, err := attendances(ctx, source, arguments)
+		if err != nil {
+			return nil, err
+		}
+
+		attendance, present := resolved.(map[string]interface{})[graphQLRequest(ctx).claim.sub]
+		if !present {
+			return `uninvited`, nil
+		}
+
+		return attendance, nil
+	}
+}
+
+/*
+graphQLPartyAttendances returns graphql.Resolver resolving the object of the
+attendances resolved with the given resolver as a list sorted by the members.
+*/
+func graphQLPartyAttendances(attendances graphql.Resolver) graphql.Resolver {
+	return func(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+		resolved, err := attendances(ctx, source, arguments)
+		if err != nil || resolved == nil {
+			return nil, err
+		}
+
+		object := resolved.(map[string]interface{})
+		members := make([]string, 0, len(object))
+		for member := range object {
+			members = append(members, member)
+		}
+
+		sort.Strings(members)
+
+		list := make([]interface{}, len(members))
+		for index, member := range members {
+			list[index] = map[string]interface{}{
+				`attendance`: object[member],
+				`member`:     member,
+			}
+		}
+
+		return list, nil
+	}
+}
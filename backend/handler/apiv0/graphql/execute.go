@@ -0,0 +1,437 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// execution is a structure holding the state of executing an operation.
+type execution struct {
+	schema    Schema
+	document  document
+	variables map[string]interface{}
+	errors    []Error
+}
+
+/*
+collectedField is a field collected from a selection set. It may have several
+selection sets if the field is selected several times with the same key.
+
+GraphQL
+6.3.2 Field Collection
+http://facebook.github.io/graphql/October2016/#sec-Field-Collection
+*/
+type collectedField struct {
+	key       string
+	name      string
+	arguments map[string]interface{}
+	sets      [][]selection
+}
+
+// scalars is the set of the names of the supported scalars.
+var scalars = map[string]bool{`Boolean`: true, `Int`: true, `String`: true}
+
+/*
+coerceVariables returns the values of the variables defined with the given
+definitions.
+
+GraphQL
+6.1.2 Coercing Variable Values
+http://facebook.github.io/graphql/October2016/#sec-Coercing-Variable-Values
+*/
+func (execution *execution) coerceVariables(definitions []variableDefinition, provided map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(definitions))
+
+	for _, definition := range definitions {
+		if item, _ := listItem(definition.kind); !scalars[item] {
+			return nil, fmt.Errorf(`variable $%s: unknown type %s`,
+				definition.name, definition.kind)
+		}
+
+		value, present := provided[definition.name]
+		if !present {
+			value = definition.defaultValue
+		}
+
+		if value == nil && definition.required {
+			return nil, fmt.Errorf(`variable $%s is required`, definition.name)
+		}
+
+		if !validValue(definition.kind, value) {
+			return nil, fmt.Errorf(`variable $%s: expected %s`,
+				definition.name, definition.kind)
+		}
+
+		values[definition.name] = value
+	}
+
+	return values, nil
+}
+
+// validValue returns whether the given value is valid as the given type.
+func validValue(kind string, value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	if item, list := listItem(kind); list {
+		values, ok := value.([]interface{})
+		if !ok {
+			return validValue(item, value)
+		}
+
+		for _, value := range values {
+			if !validValue(item, value) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	switch kind {
+	case `Boolean`:
+		_, ok := value.(bool)
+		return ok
+
+	case `Int`:
+		number, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+
+		_, err := strconv.ParseInt(string(number), 10, 32)
+		return err == nil
+
+	case `String`:
+		_, ok := value.(string)
+		return ok
+	}
+
+	return false
+}
+
+/*
+validate returns the errors of the given selection set of the root. It checks
+the fields, the arguments and the fragments, and the depth and cost limits.
+
+GraphQL
+5 Validation
+http://facebook.github.io/graphql/October2016/#sec-Validation
+*/
+func (execution *execution) validate(set []selection) []Error {
+	var errs []Error
+
+	report := func(format string, arguments ...interface{}) {
+		errs = append(errs, Error{Message: fmt.Sprintf(format, arguments...)})
+	}
+
+	cost, depth := execution.validateSet(execution.schema.Query, set, map[string]bool{}, report)
+	if errs != nil {
+		return errs
+	}
+
+	if depth > execution.schema.MaxDepth {
+		report(`depth %d exceeds the limit %d`, depth, execution.schema.MaxDepth)
+	}
+
+	if cost > execution.schema.MaxCost {
+		report(`cost %d exceeds the limit %d`, cost, execution.schema.MaxCost)
+	}
+
+	return errs
+}
+
+/*
+validateSet validates the given selection set of the given object, and returns
+its cost and depth. visiting is the set of the fragments being spread to detect
+cycles.
+*/
+func (execution *execution) validateSet(object string, set []selection, visiting map[string]bool, report func(string, ...interface{})) (int, int) {
+	cost := 0
+	depth := 0
+
+	for _, selected := range set {
+		var selectedCost, selectedDepth int
+
+		switch {
+		case selected.name != ``:
+			selectedCost, selectedDepth = execution.validateField(object, selected, visiting, report)
+
+		case selected.fragment != ``:
+			definition, present := execution.document.fragments[selected.fragment]
+			if !present {
+				report(`unknown fragment %q`, selected.fragment)
+				continue
+			}
+
+			if visiting[selected.fragment] {
+				report(`fragment %q spreads itself`, selected.fragment)
+				continue
+			}
+
+			if definition.condition != object {
+				report(`fragment %q cannot be spread on %s`,
+					selected.fragment, object)
+				continue
+			}
+
+			visiting[selected.fragment] = true
+			selectedCost, selectedDepth = execution.validateSet(object, definition.set, visiting, report)
+			delete(visiting, selected.fragment)
+
+		default:
+			if selected.condition != `` && selected.condition != object {
+				report(`fragment on %s cannot be spread on %s`,
+					selected.condition, object)
+				continue
+			}
+
+			selectedCost, selectedDepth = execution.validateSet(object, selected.set, visiting, report)
+		}
+
+		cost += selectedCost
+		if selectedDepth > depth {
+			depth = selectedDepth
+		}
+	}
+
+	return cost, depth
+}
+
+// validateField validates the given field and returns its cost and depth.
+func (execution *execution) validateField(object string, selected selection, visiting map[string]bool, report func(string, ...interface{})) (int, int) {
+	/*
+		GraphQL
+		4.1.4 Type Name Introspection
+		http://facebook.github.io/graphql/October2016/#sec-Type-Name-Introspection
+	*/
+	if selected.name == `__typename` {
+		if selected.arguments != nil || selected.set != nil {
+			report(`__typename cannot have arguments or selections`)
+		}
+
+		return 0, 1
+	}
+
+	field, present := execution.schema.Objects[object][selected.name]
+	if !present {
+		report(`unknown field %q on %s`, selected.name, object)
+		return 0, 1
+	}
+
+	for name, value := range selected.arguments {
+		argument, present := field.Arguments[name]
+		if !present {
+			report(`unknown argument %q of %s.%s`, name, object, selected.name)
+			continue
+		}
+
+		if reference, ok := value.(variable); ok {
+			if _, present := execution.variables[string(reference)]; !present {
+				report(`undefined variable $%s`, reference)
+			}
+
+			continue
+		}
+
+		if !validValue(argument.Type, value) {
+			report(`argument %q of %s.%s: expected %s`,
+				name, object, selected.name, argument.Type)
+		}
+	}
+
+	for name, argument := range field.Arguments {
+		if reference, ok := selected.arguments[name].(variable); ok {
+			if _, present := execution.variables[string(reference)]; !present {
+				continue
+			}
+		}
+
+		if argument.Required && execution.argument(selected.arguments, name) == nil {
+			report(`argument %q of %s.%s is required`, name, object, selected.name)
+		}
+	}
+
+	item, list := listItem(field.Type)
+	cost := field.Cost
+	depth := 1
+
+	if scalars[item] {
+		if selected.set != nil {
+			report(`scalar %s.%s cannot have selections`, object, selected.name)
+		}
+	} else if selected.set == nil {
+		report(`object %s.%s must have selections`, object, selected.name)
+	} else {
+		setCost, setDepth := execution.validateSet(item, selected.set, visiting, report)
+		cost += setCost
+		depth += setDepth
+	}
+
+	// A list of scalars is resolved at once.
+	if list && !scalars[item] {
+		cost *= execution.schema.ListSize
+	}
+
+	return cost, depth
+}
+
+// argument returns the value of the given argument, resolving variables.
+func (execution *execution) argument(arguments map[string]interface{}, name string) interface{} {
+	value := arguments[name]
+	if reference, ok := value.(variable); ok {
+		return execution.variables[string(reference)]
+	}
+
+	return value
+}
+
+// collect collects the fields of the given selection set of the given object.
+func (execution *execution) collect(object string, set []selection, fields []collectedField) []collectedField {
+Selections:
+	for _, selected := range set {
+		switch {
+		case selected.name != ``:
+			for index := range fields {
+				if fields[index].key == selected.alias {
+					fields[index].sets = append(fields[index].sets, selected.set)
+					continue Selections
+				}
+			}
+
+			fields = append(fields, collectedField{
+				selected.alias, selected.name,
+				selected.arguments, [][]selection{selected.set},
+			})
+
+		case selected.fragment != ``:
+			fields = execution.collect(object,
+				execution.document.fragments[selected.fragment].set,
+				fields)
+
+		default:
+			fields = execution.collect(object, selected.set, fields)
+		}
+	}
+
+	return fields
+}
+
+/*
+execute executes the given selection set of the given object with the given
+source.
+
+GraphQL
+6.3 Executing Selection Sets
+http://facebook.github.io/graphql/October2016/#sec-Executing-Selection-Sets
+*/
+func (execution *execution) execute(ctx context.Context, object string, set []selection, source map[string]interface{}, path []interface{}) Result {
+	fields := execution.collect(object, set, nil)
+	result := make(Result, 0, len(fields))
+
+	for _, collected := range fields {
+		fieldPath := append(append(make([]interface{}, 0, len(path)+1), path...), collected.key)
+
+		if collected.name == `__typename` {
+			result = append(result, resultMember{collected.key, object})
+			continue
+		}
+
+		field := execution.schema.Objects[object][collected.name]
+
+		var value interface{}
+		if field.Resolve == nil {
+			value = source[collected.name]
+		} else {
+			arguments := make(map[string]interface{}, len(field.Arguments))
+			for name := range field.Arguments {
+				if argument := execution.argument(collected.arguments, name); argument != nil {
+					arguments[name] = argument
+				}
+			}
+
+			var err error
+			value, err = field.Resolve(ctx, source, arguments)
+			if err != nil {
+				execution.errors = append(execution.errors,
+					Error{err.Error(), fieldPath})
+				value = nil
+			}
+		}
+
+		var merged []selection
+		for _, set := range collected.sets {
+			merged = append(merged, set...)
+		}
+
+		result = append(result, resultMember{collected.key,
+			execution.complete(ctx, field.Type, merged, value, fieldPath)})
+	}
+
+	return result
+}
+
+/*
+complete returns the value of the given type completed with the given selection
+set.
+
+GraphQL
+6.4.3 Value Completion
+http://facebook.github.io/graphql/October2016/#sec-Value-Completion
+*/
+func (execution *execution) complete(ctx context.Context, kind string, set []selection, value interface{}, path []interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	item, list := listItem(kind)
+	if list {
+		values, ok := value.([]interface{})
+		if !ok {
+			execution.errors = append(execution.errors,
+				Error{`expected list`, path})
+			return nil
+		}
+
+		completed := make([]interface{}, len(values))
+		for index, value := range values {
+			itemPath := append(append(make([]interface{}, 0, len(path)+1), path...), index)
+			completed[index] = execution.complete(ctx, item, set, value, itemPath)
+		}
+
+		return completed
+	}
+
+	if scalars[kind] {
+		return value
+	}
+
+	source, ok := value.(map[string]interface{})
+	if !ok {
+		execution.errors = append(execution.errors,
+			Error{`expected object`, path})
+		return nil
+	}
+
+	return execution.execute(ctx, kind, set, source, path)
+}
@@ -0,0 +1,220 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+/*
+Package graphql implements a read-only subset of GraphQL.
+
+It executes queries against a schema whose types are objects and the scalars,
+String, Int and Boolean. Mutations, subscriptions, directives and introspection
+except __typename are not supported. Queries exceeding the depth and cost
+limits of the schema are rejected before any resolver is called.
+
+GraphQL
+http://facebook.github.io/graphql/October2016/
+*/
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+/*
+Resolver is a function returning the value of a field. source is the object
+holding the field, and arguments have the values of the arguments. The value
+must consist of map[string]interface{}, []interface{}, string, json.Number,
+bool and nil as encoding/json decodes JSON with UseNumber. A resolver may add
+members to source so that resolvers of the other fields can reuse them.
+*/
+type Resolver func(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error)
+
+// Argument is a structure describing an argument of a field.
+type Argument struct {
+	Type     string
+	Required bool
+}
+
+/*
+Field is a structure describing a field of an object.
+
+Type is the name of a scalar or an object, or a list of them like [Member].
+Cost is the estimated cost to resolve the field once. Resolve is called to
+resolve the field; if it is nil, the member of the source with the name of the
+field is the value.
+*/
+type Field struct {
+	Type      string
+	Arguments map[string]Argument
+	Cost      int
+	Resolve   Resolver
+}
+
+// Object is a map of the names of fields and them.
+type Object map[string]Field
+
+/*
+Schema is a structure describing a schema.
+
+Objects have the object types, and Query is the name of the object type of the
+root. MaxDepth is the maximum depth of the nested selection sets. MaxCost is
+the maximum sum of the cost of the selected fields, where ListSize is the
+estimated number of the items of each list of objects.
+*/
+type Schema struct {
+	Objects  map[string]Object
+	Query    string
+	MaxDepth int
+	MaxCost  int
+	ListSize int
+}
+
+/*
+Error is a structure describing an error.
+
+GraphQL
+7.2.2 Errors
+http://facebook.github.io/graphql/October2016/#sec-Errors
+*/
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+/*
+Response is a structure describing a response. Data is nil if the request
+failed before the execution.
+
+GraphQL
+7.2 Response Format
+http://facebook.github.io/graphql/October2016/#sec-Response-Format
+*/
+type Response struct {
+	Data   *Result `json:"data,omitempty"`
+	Errors []Error `json:"errors,omitempty"`
+}
+
+type resultMember struct {
+	key   string
+	value interface{}
+}
+
+/*
+Result is a map resulted from the execution of a selection set. It keeps the
+order of the fields as requested.
+
+GraphQL
+7.2.2 Serialized Map Ordering
+http://facebook.github.io/graphql/October2016/#sec-Serialized-Map-Ordering
+*/
+type Result []resultMember
+
+/*
+MarshalJSON returns the JSON encoding of the result.
+
+This implements an interface used in encoding/encoding.
+
+json - The Go Programming Language
+Example (CustomMarshalJSON)
+https://golang.org/pkg/encoding/json/#example__customMarshalJSON
+*/
+func (result Result) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+
+	buffer.WriteByte('{')
+	for index, member := range result {
+		if index > 0 {
+			buffer.WriteByte(',')
+		}
+
+		key, err := json.Marshal(member.key)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(member.value)
+		if err != nil {
+			return nil, err
+		}
+
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+
+	return buffer.Bytes(), nil
+}
+
+/*
+Execute parses and executes the given query with the given variables. If
+operationName is empty, the query must have only one operation.
+
+Data of the returned response is nil if the request is invalid.
+*/
+func (schema Schema) Execute(ctx context.Context, query, operationName string, variables map[string]interface{}) Response {
+	parsed, err := parse(query)
+	if err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	var selected *operation
+	for index := range parsed.operations {
+		if operationName == `` || parsed.operations[index].name == operationName {
+			if selected != nil {
+				return Response{Errors: []Error{{Message: `operationName is required`}}}
+			}
+
+			selected = &parsed.operations[index]
+		}
+	}
+
+	if selected == nil {
+		return Response{Errors: []Error{{Message: `unknown operation`}}}
+	}
+
+	if selected.kind != `query` {
+		return Response{Errors: []Error{{Message: `only query is supported`}}}
+	}
+
+	execution := execution{schema: schema, document: parsed}
+
+	values, err := execution.coerceVariables(selected.variables, variables)
+	if err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	execution.variables = values
+
+	if errs := execution.validate(selected.set); errs != nil {
+		return Response{Errors: errs}
+	}
+
+	data := execution.execute(ctx, schema.Query, selected.set, map[string]interface{}{}, nil)
+
+	return Response{&data, execution.errors}
+}
+
+// listItem returns the type of the items if the given type is a list.
+func listItem(kind string) (string, bool) {
+	if strings.HasPrefix(kind, `[`) && strings.HasSuffix(kind, `]`) {
+		return kind[1 : len(kind)-1], true
+	}
+
+	return kind, false
+}
@@ -0,0 +1,185 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func testSchema() Schema {
+	people := map[string]interface{}{
+		`alice`: map[string]interface{}{`name`: `Alice`, `age`: json.Number(`20`), `friends`: []interface{}{`bob`}},
+		`bob`:   map[string]interface{}{`name`: `Bob`, `age`: json.Number(`30`), `friends`: []interface{}{`alice`, `bob`}},
+	}
+
+	person := func(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+		return people[arguments[`id`].(string)], nil
+	}
+
+	return Schema{
+		Objects: map[string]Object{
+			`Person`: {
+				`age`: {Type: `Int`},
+				`friends`: {
+					Type: `[Person]`,
+					Cost: 1,
+					Resolve: func(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+						var friends []interface{}
+						for _, id := range source[`friends`].([]interface{}) {
+							friends = append(friends, people[id.(string)])
+						}
+
+						return friends, nil
+					},
+				},
+				`name`: {Type: `String`},
+				`secret`: {
+					Type: `String`,
+					Resolve: func(ctx context.Context, source map[string]interface{}, arguments map[string]interface{}) (interface{}, error) {
+						return nil, errors.New(`forbidden`)
+					},
+				},
+			},
+			`Query`: {
+				`person`: {
+					Type:      `Person`,
+					Arguments: map[string]Argument{`id`: {`String`, true}},
+					Cost:      1,
+					Resolve:   person,
+				},
+			},
+		},
+		Query:    `Query`,
+		MaxDepth: 3,
+		MaxCost:  20,
+		ListSize: 4,
+	}
+}
+
+func TestExecute(t *testing.T) {
+	t.Parallel()
+
+	schema := testSchema()
+
+	for _, test := range [...]struct {
+		description   string
+		query         string
+		operationName string
+		variables     map[string]interface{}
+		expected      string
+	}{
+		{
+			`shorthand`, `{ person(id: "alice") { name, age } }`, ``, nil,
+			`{"data":{"person":{"name":"Alice","age":20}}}`,
+		}, {
+			`order`, `query { person(id: "alice") { age name } }`, ``, nil,
+			`{"data":{"person":{"age":20,"name":"Alice"}}}`,
+		}, {
+			`alias`, `{ a: person(id: "alice") { name } b: person(id: "bob") { n: name } }`, ``, nil,
+			`{"data":{"a":{"name":"Alice"},"b":{"n":"Bob"}}}`,
+		}, {
+			`variable`, `query Q($id: String!) { person(id: $id) { name } }`, ``,
+			map[string]interface{}{`id`: `bob`},
+			`{"data":{"person":{"name":"Bob"}}}`,
+		}, {
+			`defaultVariable`, `query Q($id: String = "bob") { person(id: $id) { name } }`, ``, nil,
+			`{"data":{"person":{"name":"Bob"}}}`,
+		}, {
+			`operationName`, `query A { person(id: "alice") { name } } query B { person(id: "bob") { name } }`, `B`, nil,
+			`{"data":{"person":{"name":"Bob"}}}`,
+		}, {
+			`fragment`, `{ person(id: "alice") { ...P friends { ... on Person { name } } } } fragment P on Person { name }`, ``, nil,
+			`{"data":{"person":{"name":"Alice","friends":[{"name":"Bob"}]}}}`,
+		}, {
+			`merge`, `{ person(id: "alice") { friends { name } friends { age } } }`, ``, nil,
+			`{"data":{"person":{"friends":[{"name":"Bob","age":30}]}}}`,
+		}, {
+			`typename`, `{ person(id: "alice") { __typename } }`, ``, nil,
+			`{"data":{"person":{"__typename":"Person"}}}`,
+		}, {
+			`null`, `{ person(id: "carol") { name } }`, ``, nil,
+			`{"data":{"person":null}}`,
+		}, {
+			`comment`, `{ person(id: "alice") { name } } # comment`, ``, nil,
+			`{"data":{"person":{"name":"Alice"}}}`,
+		}, {
+			`fieldError`, `{ person(id: "alice") { name secret } }`, ``, nil,
+			`{"data":{"person":{"name":"Alice","secret":null}},"errors":[{"message":"forbidden","path":["person","secret"]}]}`,
+		}, {
+			`syntax`, `{ person(id: "alice") { name }`, ``, nil,
+			`{"errors":[{"message":"syntax error at 30: expected name, got \"\""}]}`,
+		}, {
+			`mutation`, `mutation { person(id: "alice") { name } }`, ``, nil,
+			`{"errors":[{"message":"only query is supported"}]}`,
+		}, {
+			`directive`, `{ person(id: "alice") @skip(if: true) { name } }`, ``, nil,
+			`{"errors":[{"message":"syntax error at 23: directives are not supported"}]}`,
+		}, {
+			`ambiguous`, `query A { person(id: "alice") { name } } query B { person(id: "bob") { name } }`, ``, nil,
+			`{"errors":[{"message":"operationName is required"}]}`,
+		}, {
+			`unknownField`, `{ person(id: "alice") { email } }`, ``, nil,
+			`{"errors":[{"message":"unknown field \"email\" on Person"}]}`,
+		}, {
+			`missingArgument`, `{ person { name } }`, ``, nil,
+			`{"errors":[{"message":"argument \"id\" of Query.person is required"}]}`,
+		}, {
+			`argumentType`, `{ person(id: 1) { name } }`, ``, nil,
+			`{"errors":[{"message":"argument \"id\" of Query.person: expected String"}]}`,
+		}, {
+			`undefinedVariable`, `{ person(id: $id) { name } }`, ``, nil,
+			`{"errors":[{"message":"undefined variable $id"}]}`,
+		}, {
+			`missingVariable`, `query Q($id: String!) { person(id: $id) { name } }`, ``, nil,
+			`{"errors":[{"message":"variable $id is required"}]}`,
+		}, {
+			`selections`, `{ person(id: "alice") }`, ``, nil,
+			`{"errors":[{"message":"object Query.person must have selections"}]}`,
+		}, {
+			`cycle`, `{ person(id: "alice") { ...P } } fragment P on Person { friends { ...P } }`, ``, nil,
+			`{"errors":[{"message":"fragment \"P\" spreads itself"}]}`,
+		}, {
+			`depth`, `{ person(id: "alice") { friends { friends { name } } } }`, ``, nil,
+			`{"errors":[{"message":"depth 4 exceeds the limit 3"},{"message":"cost 21 exceeds the limit 20"}]}`,
+		}, {
+			`cost`, `{ a: person(id: "alice") { friends { name } } b: person(id: "alice") { friends { name } } c: person(id: "alice") { friends { name } } d: person(id: "alice") { friends { name } } e: person(id: "alice") { friends { name } } }`, ``, nil,
+			`{"errors":[{"message":"cost 25 exceeds the limit 20"}]}`,
+		},
+	} {
+		test := test
+
+		t.Run(test.description, func(t *testing.T) {
+			t.Parallel()
+
+			response := schema.Execute(context.Background(),
+				test.query, test.operationName, test.variables)
+
+			encoded, err := json.Marshal(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(encoded) != test.expected {
+				t.Errorf(`expected %s, got %s`, test.expected, encoded)
+			}
+		})
+	}
+}
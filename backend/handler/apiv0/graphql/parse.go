@@ -0,0 +1,837 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+tokenKind is the kind of a lexical token.
+
+GraphQL
+2.1.6 Lexical Tokens
+http://facebook.github.io/graphql/October2016/#sec-Language.Lexical-Tokens
+*/
+type tokenKind uint
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+/*
+parser is a structure holding the state of parsing a document. It is a
+recursive descent parser reading one token ahead.
+*/
+type parser struct {
+	source string
+	offset int
+	token  token
+}
+
+// variable is a value referring to a variable.
+type variable string
+
+/*
+selection is a selection in a selection set. It is a field if name is not
+empty, a fragment spread if fragment is not empty, and an inline fragment
+otherwise.
+*/
+type selection struct {
+	alias     string
+	name      string
+	arguments map[string]interface{}
+	set       []selection
+	fragment  string
+	condition string
+}
+
+// variableDefinition is a definition of a variable of an operation.
+type variableDefinition struct {
+	name         string
+	kind         string
+	required     bool
+	defaultValue interface{}
+}
+
+type operation struct {
+	kind      string
+	name      string
+	variables []variableDefinition
+	set       []selection
+}
+
+type fragment struct {
+	condition string
+	set       []selection
+}
+
+// document is a parsed GraphQL document.
+type document struct {
+	operations []operation
+	fragments  map[string]fragment
+}
+
+/*
+parse returns the parsed document. It supports the executable definitions
+except directives.
+
+GraphQL
+2.2 Query Document
+http://facebook.github.io/graphql/October2016/#sec-Language.Query-Document
+*/
+func parse(source string) (document, error) {
+	parser := parser{source: source}
+	parsed := document{fragments: map[string]fragment{}}
+
+	if err := parser.next(); err != nil {
+		return document{}, err
+	}
+
+	if parser.token.kind == tokenEOF {
+		return document{}, parser.errorf(`empty document`)
+	}
+
+	for parser.token.kind != tokenEOF {
+		if parser.peek(tokenName, `fragment`) {
+			name, definition, err := parser.fragment()
+			if err != nil {
+				return document{}, err
+			}
+
+			if _, present := parsed.fragments[name]; present {
+				return document{}, fmt.Errorf(`duplicate fragment %q`, name)
+			}
+
+			parsed.fragments[name] = definition
+			continue
+		}
+
+		definition, err := parser.operation()
+		if err != nil {
+			return document{}, err
+		}
+
+		parsed.operations = append(parsed.operations, definition)
+	}
+
+	return parsed, nil
+}
+
+func (parser *parser) errorf(format string, arguments ...interface{}) error {
+	return fmt.Errorf(`syntax error at %d: %s`,
+		parser.offset, fmt.Sprintf(format, arguments...))
+}
+
+/*
+next reads the next token, skipping ignored tokens.
+
+GraphQL
+2.1.7 Ignored Tokens
+http://facebook.github.io/graphql/October2016/#sec-Ignored-Tokens
+*/
+func (parser *parser) next() error {
+	for parser.offset < len(parser.source) {
+		switch parser.source[parser.offset] {
+		case '\t', '\n', '\r', ' ', ',':
+			parser.offset++
+			continue
+
+		case '#':
+			end := strings.IndexAny(parser.source[parser.offset:], "\n\r")
+			if end < 0 {
+				parser.offset = len(parser.source)
+			} else {
+				parser.offset += end
+			}
+
+			continue
+		}
+
+		break
+	}
+
+	if parser.offset >= len(parser.source) {
+		parser.token = token{tokenEOF, ``}
+		return nil
+	}
+
+	start := parser.offset
+	character := parser.source[start]
+
+	switch {
+	case strings.IndexByte(`!$():=@[]{|}`, character) >= 0:
+		parser.offset++
+		parser.token = token{tokenPunctuator, parser.source[start:parser.offset]}
+
+	case strings.HasPrefix(parser.source[start:], `...`):
+		parser.offset += 3
+		parser.token = token{tokenPunctuator, `...`}
+
+	case character == '_' || isLetter(character):
+		for parser.offset < len(parser.source) &&
+			(parser.source[parser.offset] == '_' ||
+				isLetter(parser.source[parser.offset]) ||
+				isDigit(parser.source[parser.offset])) {
+			parser.offset++
+		}
+
+		parser.token = token{tokenName, parser.source[start:parser.offset]}
+
+	case character == '-' || isDigit(character):
+		return parser.number()
+
+	case character == '"':
+		return parser.string()
+
+	default:
+		return parser.errorf(`unexpected character %q`, character)
+	}
+
+	return nil
+}
+
+func isDigit(character byte) bool {
+	return character >= '0' && character <= '9'
+}
+
+func isLetter(character byte) bool {
+	return (character >= 'A' && character <= 'Z') ||
+		(character >= 'a' && character <= 'z')
+}
+
+/*
+number reads IntValue or FloatValue.
+
+GraphQL
+2.9.1 Int Value
+http://facebook.github.io/graphql/October2016/#sec-Int-Value
+2.9.2 Float Value
+http://facebook.github.io/graphql/October2016/#sec-Float-Value
+*/
+func (parser *parser) number() error {
+	start := parser.offset
+	kind := tokenInt
+
+	if parser.source[parser.offset] == '-' {
+		parser.offset++
+	}
+
+	digits := func() error {
+		digitsStart := parser.offset
+		for parser.offset < len(parser.source) && isDigit(parser.source[parser.offset]) {
+			parser.offset++
+		}
+
+		if parser.offset == digitsStart {
+			return parser.errorf(`expected digit`)
+		}
+
+		return nil
+	}
+
+	if err := digits(); err != nil {
+		return err
+	}
+
+	if parser.offset < len(parser.source) && parser.source[parser.offset] == '.' {
+		kind = tokenFloat
+		parser.offset++
+		if err := digits(); err != nil {
+			return err
+		}
+	}
+
+	if parser.offset < len(parser.source) &&
+		(parser.source[parser.offset] == 'e' || parser.source[parser.offset] == 'E') {
+		kind = tokenFloat
+		parser.offset++
+
+		if parser.offset < len(parser.source) &&
+			(parser.source[parser.offset] == '+' || parser.source[parser.offset] == '-') {
+			parser.offset++
+		}
+
+		if err := digits(); err != nil {
+			return err
+		}
+	}
+
+	parser.token = token{kind, parser.source[start:parser.offset]}
+
+	return nil
+}
+
+/*
+string reads StringValue.
+
+GraphQL
+2.9.4 String Value
+http://facebook.github.io/graphql/October2016/#sec-String-Value
+*/
+func (parser *parser) string() error {
+	var value []byte
+	parser.offset++
+
+	for {
+		if parser.offset >= len(parser.source) {
+			return parser.errorf(`unterminated string`)
+		}
+
+		character := parser.source[parser.offset]
+		switch character {
+		case '"':
+			parser.offset++
+			parser.token = token{tokenString, string(value)}
+			return nil
+
+		case '\n', '\r':
+			return parser.errorf(`unterminated string`)
+
+		case '\\':
+			if parser.offset+1 >= len(parser.source) {
+				return parser.errorf(`unterminated string`)
+			}
+
+			escaped := parser.source[parser.offset+1]
+			parser.offset += 2
+
+			switch escaped {
+			case '"', '/', '\\':
+				value = append(value, escaped)
+
+			case 'b':
+				value = append(value, '\b')
+
+			case 'f':
+				value = append(value, '\f')
+
+			case 'n':
+				value = append(value, '\n')
+
+			case 'r':
+				value = append(value, '\r')
+
+			case 't':
+				value = append(value, '\t')
+
+			case 'u':
+				if parser.offset+4 > len(parser.source) {
+					return parser.errorf(`invalid escape`)
+				}
+
+				code, err := strconv.ParseUint(parser.source[parser.offset:parser.offset+4], 16, 16)
+				if err != nil {
+					return parser.errorf(`invalid escape`)
+				}
+
+				var encoded [utf8.UTFMax]byte
+				value = append(value, encoded[:utf8.EncodeRune(encoded[:], rune(code))]...)
+				parser.offset += 4
+
+			default:
+				return parser.errorf(`invalid escape %q`, escaped)
+			}
+
+		default:
+			value = append(value, character)
+			parser.offset++
+		}
+	}
+}
+
+// peek returns whether the current token is the given one.
+func (parser *parser) peek(kind tokenKind, value string) bool {
+	return parser.token.kind == kind && parser.token.value == value
+}
+
+// expect consumes the given token or returns an error.
+func (parser *parser) expect(kind tokenKind, value string) error {
+	if !parser.peek(kind, value) {
+		return parser.errorf(`expected %q, got %q`, value, parser.token.value)
+	}
+
+	return parser.next()
+}
+
+// name consumes a name and returns it.
+func (parser *parser) name() (string, error) {
+	if parser.token.kind != tokenName {
+		return ``, parser.errorf(`expected name, got %q`, parser.token.value)
+	}
+
+	name := parser.token.value
+	return name, parser.next()
+}
+
+/*
+operation reads OperationDefinition.
+
+GraphQL
+2.3 Operations
+http://facebook.github.io/graphql/October2016/#sec-Language.Operations
+*/
+func (parser *parser) operation() (operation, error) {
+	definition := operation{kind: `query`}
+
+	if parser.token.kind == tokenName {
+		definition.kind = parser.token.value
+		if err := parser.next(); err != nil {
+			return operation{}, err
+		}
+
+		if parser.token.kind == tokenName {
+			var err error
+			definition.name, err = parser.name()
+			if err != nil {
+				return operation{}, err
+			}
+		}
+
+		if parser.peek(tokenPunctuator, `(`) {
+			var err error
+			definition.variables, err = parser.variableDefinitions()
+			if err != nil {
+				return operation{}, err
+			}
+		}
+	}
+
+	if err := parser.directives(); err != nil {
+		return operation{}, err
+	}
+
+	var err error
+	definition.set, err = parser.selectionSet()
+
+	return definition, err
+}
+
+/*
+variableDefinitions reads VariableDefinitions.
+
+GraphQL
+2.10 Variables
+http://facebook.github.io/graphql/October2016/#sec-Language.Variables
+*/
+func (parser *parser) variableDefinitions() ([]variableDefinition, error) {
+	var definitions []variableDefinition
+
+	if err := parser.expect(tokenPunctuator, `(`); err != nil {
+		return nil, err
+	}
+
+	for !parser.peek(tokenPunctuator, `)`) {
+		var definition variableDefinition
+
+		if err := parser.expect(tokenPunctuator, `$`); err != nil {
+			return nil, err
+		}
+
+		var err error
+		definition.name, err = parser.name()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := parser.expect(tokenPunctuator, `:`); err != nil {
+			return nil, err
+		}
+
+		definition.kind, definition.required, err = parser.typeReference()
+		if err != nil {
+			return nil, err
+		}
+
+		if parser.peek(tokenPunctuator, `=`) {
+			if err := parser.next(); err != nil {
+				return nil, err
+			}
+
+			definition.defaultValue, err = parser.value(true)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		definitions = append(definitions, definition)
+	}
+
+	return definitions, parser.next()
+}
+
+/*
+typeReference reads Type and returns the type in the notation of Field.Type and
+whether it is non-null.
+
+GraphQL
+2.11 Type References
+http://facebook.github.io/graphql/October2016/#sec-Type-References
+*/
+func (parser *parser) typeReference() (string, bool, error) {
+	var kind string
+
+	if parser.peek(tokenPunctuator, `[`) {
+		if err := parser.next(); err != nil {
+			return ``, false, err
+		}
+
+		item, _, err := parser.typeReference()
+		if err != nil {
+			return ``, false, err
+		}
+
+		if err := parser.expect(tokenPunctuator, `]`); err != nil {
+			return ``, false, err
+		}
+
+		kind = `[` + item + `]`
+	} else {
+		var err error
+		kind, err = parser.name()
+		if err != nil {
+			return ``, false, err
+		}
+	}
+
+	if !parser.peek(tokenPunctuator, `!`) {
+		return kind, false, nil
+	}
+
+	return kind, true, parser.next()
+}
+
+/*
+directives rejects directives, which are not supported.
+
+GraphQL
+2.12 Directives
+http://facebook.github.io/graphql/October2016/#sec-Language.Directives
+*/
+func (parser *parser) directives() error {
+	if parser.peek(tokenPunctuator, `@`) {
+		return parser.errorf(`directives are not supported`)
+	}
+
+	return nil
+}
+
+/*
+selectionSet reads SelectionSet.
+
+GraphQL
+2.4 Selection Sets
+http://facebook.github.io/graphql/October2016/#sec-Selection-Sets
+*/
+func (parser *parser) selectionSet() ([]selection, error) {
+	if err := parser.expect(tokenPunctuator, `{`); err != nil {
+		return nil, err
+	}
+
+	var set []selection
+	for !parser.peek(tokenPunctuator, `}`) {
+		selected, err := parser.selection()
+		if err != nil {
+			return nil, err
+		}
+
+		set = append(set, selected)
+	}
+
+	if set == nil {
+		return nil, parser.errorf(`empty selection set`)
+	}
+
+	return set, parser.next()
+}
+
+func (parser *parser) selection() (selection, error) {
+	if parser.peek(tokenPunctuator, `...`) {
+		return parser.fragmentSelection()
+	}
+
+	var selected selection
+	var err error
+
+	selected.name, err = parser.name()
+	if err != nil {
+		return selection{}, err
+	}
+
+	/*
+		GraphQL
+		2.7 Field Alias
+		http://facebook.github.io/graphql/October2016/#sec-Field-Alias
+	*/
+	if parser.peek(tokenPunctuator, `:`) {
+		if err := parser.next(); err != nil {
+			return selection{}, err
+		}
+
+		selected.alias = selected.name
+		selected.name, err = parser.name()
+		if err != nil {
+			return selection{}, err
+		}
+	} else {
+		selected.alias = selected.name
+	}
+
+	if parser.peek(tokenPunctuator, `(`) {
+		selected.arguments, err = parser.arguments()
+		if err != nil {
+			return selection{}, err
+		}
+	}
+
+	if err := parser.directives(); err != nil {
+		return selection{}, err
+	}
+
+	if parser.peek(tokenPunctuator, `{`) {
+		selected.set, err = parser.selectionSet()
+	}
+
+	return selected, err
+}
+
+/*
+fragmentSelection reads FragmentSpread or InlineFragment.
+
+GraphQL
+2.8 Fragments
+http://facebook.github.io/graphql/October2016/#sec-Language.Fragments
+*/
+func (parser *parser) fragmentSelection() (selection, error) {
+	var selected selection
+
+	if err := parser.next(); err != nil {
+		return selection{}, err
+	}
+
+	if parser.token.kind == tokenName && parser.token.value != `on` {
+		var err error
+		selected.fragment, err = parser.name()
+		if err != nil {
+			return selection{}, err
+		}
+
+		return selected, parser.directives()
+	}
+
+	if parser.peek(tokenName, `on`) {
+		if err := parser.next(); err != nil {
+			return selection{}, err
+		}
+
+		var err error
+		selected.condition, err = parser.name()
+		if err != nil {
+			return selection{}, err
+		}
+	}
+
+	if err := parser.directives(); err != nil {
+		return selection{}, err
+	}
+
+	var err error
+	selected.set, err = parser.selectionSet()
+
+	return selected, err
+}
+
+func (parser *parser) fragment() (string, fragment, error) {
+	if err := parser.expect(tokenName, `fragment`); err != nil {
+		return ``, fragment{}, err
+	}
+
+	name, err := parser.name()
+	if err != nil {
+		return ``, fragment{}, err
+	}
+
+	if name == `on` {
+		return ``, fragment{}, parser.errorf(`unexpected "on"`)
+	}
+
+	if err := parser.expect(tokenName, `on`); err != nil {
+		return ``, fragment{}, err
+	}
+
+	var definition fragment
+	definition.condition, err = parser.name()
+	if err != nil {
+		return ``, fragment{}, err
+	}
+
+	if err := parser.directives(); err != nil {
+		return ``, fragment{}, err
+	}
+
+	definition.set, err = parser.selectionSet()
+
+	return name, definition, err
+}
+
+/*
+arguments reads Arguments.
+
+GraphQL
+2.6 Arguments
+http://facebook.github.io/graphql/October2016/#sec-Language.Arguments
+*/
+func (parser *parser) arguments() (map[string]interface{}, error) {
+	if err := parser.expect(tokenPunctuator, `(`); err != nil {
+		return nil, err
+	}
+
+	arguments := map[string]interface{}{}
+	for !parser.peek(tokenPunctuator, `)`) {
+		name, err := parser.name()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, present := arguments[name]; present {
+			return nil, parser.errorf(`duplicate argument %q`, name)
+		}
+
+		if err := parser.expect(tokenPunctuator, `:`); err != nil {
+			return nil, err
+		}
+
+		arguments[name], err = parser.value(false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return arguments, parser.next()
+}
+
+/*
+value reads Value. Numbers are json.Number so that they are represented as
+the values decoded from variables are. Enum values are strings.
+
+GraphQL
+2.9 Input Values
+http://facebook.github.io/graphql/October2016/#sec-Input-Values
+*/
+func (parser *parser) value(constant bool) (interface{}, error) {
+	current := parser.token
+
+	switch current.kind {
+	case tokenInt, tokenFloat:
+		return json.Number(current.value), parser.next()
+
+	case tokenString:
+		return current.value, parser.next()
+
+	case tokenName:
+		var value interface{}
+
+		switch current.value {
+		case `false`:
+			value = false
+
+		case `null`:
+			value = nil
+
+		case `true`:
+			value = true
+
+		default:
+			value = current.value
+		}
+
+		return value, parser.next()
+
+	case tokenPunctuator:
+		switch current.value {
+		case `$`:
+			if constant {
+				return nil, parser.errorf(`unexpected variable`)
+			}
+
+			if err := parser.next(); err != nil {
+				return nil, err
+			}
+
+			name, err := parser.name()
+			return variable(name), err
+
+		case `[`:
+			if err := parser.next(); err != nil {
+				return nil, err
+			}
+
+			list := []interface{}{}
+			for !parser.peek(tokenPunctuator, `]`) {
+				item, err := parser.value(constant)
+				if err != nil {
+					return nil, err
+				}
+
+				list = append(list, item)
+			}
+
+			return list, parser.next()
+
+		case `{`:
+			if err := parser.next(); err != nil {
+				return nil, err
+			}
+
+			object := map[string]interface{}{}
+			for !parser.peek(tokenPunctuator, `}`) {
+				name, err := parser.name()
+				if err != nil {
+					return nil, err
+				}
+
+				if err := parser.expect(tokenPunctuator, `:`); err != nil {
+					return nil, err
+				}
+
+				object[name], err = parser.value(constant)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return object, parser.next()
+		}
+	}
+
+	return nil, parser.errorf(`unexpected %q`, current.value)
+}
@@ -0,0 +1,132 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGraphQL(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	graphQL := apiv0.GraphQL()
+	member := apiv0.authorization(t, `3rdDisplayID`, `user member`)
+	user := apiv0.authorization(t, `2ndDisplayID`, `user`)
+
+	for _, test := range [...]struct {
+		description   string
+		authorization string
+		query         string
+		code          int
+		response      string
+	}{
+		{
+			`unauthorized`, ``, `{user{id}}`, http.StatusUnauthorized, ``,
+		}, {
+			`profile`, user,
+			`{user{id nickname tel clubs{chief}}}`, http.StatusOK,
+			`{"data":{"user":{"id":"2ndDisplayID","nickname":"2 !%_1'#","tel":"000-000-002","clubs":[{"chief":true}]}}}
+`,
+		}, {
+			`userScope`, user, `{user{clubs{club{name}}}}`, http.StatusOK,
+			`{"data":{"user":{"clubs":[{"club":null}]}},"errors":[{"message":"insufficient_scope: the field requires member scope","path":["user","clubs",0,"club"]}]}
+`,
+		}, {
+			`public`, member,
+			`{member(id:"4thDisplayID"){mail tel confirmed}}`, http.StatusOK,
+			`{"data":{"member":{"mail":"4th@kagucho.net","tel":null,"confirmed":null}}}
+`,
+		}, {
+			`chief`, member,
+			`{member(id:"2ndDisplayID"){tel}}`, http.StatusOK,
+			`{"data":{"member":{"tel":"000-000-002"}}}
+`,
+		}, {
+			`notFound`, member, `{member(id:"invalid"){id}}`, http.StatusOK,
+			`{"data":{"member":null}}
+`,
+		}, {
+			`graph`, member,
+			`{club(id:"prog"){name chief{nickname} members{id positions{name scope}}}}`,
+			http.StatusOK,
+			`{"data":{"club":{"name":"Prog部","chief":{"nickname":"2 !%_1'#"},"members":[{"id":"1stDisplayID","positions":[{"name":"局長","scope":["management","privacy"]},{"name":"副局長","scope":["privacy"]}]},{"id":"2ndDisplayID","positions":[]}]}}}
+`,
+		}, {
+			`list`, member,
+			`{members{id gender} officers{id member{id}}}`, http.StatusOK,
+			``,
+		}, {
+			`cost`, member,
+			`{members{clubs{club{members{clubs{chief}}}}}}`,
+			http.StatusBadRequest,
+			`{"errors":[{"message":"cost 11210 exceeds the limit 1000"}]}
+`,
+		}, {
+			`syntax`, member, `{`, http.StatusBadRequest, ``,
+		},
+	} {
+		test := test
+
+		t.Run(test.description, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := testServe(graphQL, `GET`,
+				`/graphql?query=`+url.QueryEscape(test.query),
+				test.authorization, ``)
+
+			testCode(t, test.code, recorder)
+
+			if test.response != `` {
+				testBody(t, test.response, recorder)
+			}
+		})
+	}
+
+	t.Run(`post`, func(t *testing.T) {
+		t.Parallel()
+
+		request := httptest.NewRequest(`POST`, `https://kagucho.net/graphql`,
+			strings.NewReader(`{"query":"query Q($id: String!) { member(id: $id) { nickname } }","variables":{"id":"4thDisplayID"}}`))
+		request.Header.Set(`Authorization`, member)
+		request.Header.Set(`Content-Type`, `application/json`)
+
+		recorder := httptest.NewRecorder()
+		graphQL.ServeHTTP(recorder, request)
+
+		testCode(t, http.StatusOK, recorder)
+		testBody(t, `{"data":{"member":{"nickname":"4 !)_1'#"}}}
+`, recorder)
+	})
+
+	t.Run(`postForm`, func(t *testing.T) {
+		t.Parallel()
+
+		testCode(t, http.StatusUnsupportedMediaType,
+			testServe(graphQL, `POST`, `/graphql`, member, `query={user{id}}`))
+	})
+}
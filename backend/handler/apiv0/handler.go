@@ -18,15 +18,18 @@
 package apiv0
 
 import (
-	"bytes"
 	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
 	"net/http"
+	"sort"
+	"strings"
 )
 
 type handlerFunc func(http.ResponseWriter, *http.Request, shared)
 
 type handler interface {
 	serveHTTP(writer http.ResponseWriter, request *http.Request, shared shared)
+	methods() []string
+	operation(method string) (operation, bool)
 }
 
 type field struct {
@@ -37,6 +40,29 @@ type field struct {
 type methodMux struct {
 	handlers map[string]handlerFunc
 	options []field
+
+	// operations describes the handlers for OpenAPI.
+	operations map[string]operation
+}
+
+// methods returns the sorted methods the mux handles, including OPTIONS.
+func (mux methodMux) methods() []string {
+	methods := make([]string, 0, len(mux.handlers)+1)
+	methods = append(methods, `OPTIONS`)
+
+	for method := range mux.handlers {
+		methods = append(methods, method)
+	}
+
+	sort.Strings(methods)
+
+	return methods
+}
+
+// operation returns operation describing the handler of the given method.
+func (mux methodMux) operation(method string) (operation, bool) {
+	declared, present := mux.operations[method]
+	return declared, present
 }
 
 func (mux methodMux) serveHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
@@ -46,13 +72,15 @@ func (mux methodMux) serveHTTP(writer http.ResponseWriter, request *http.Request
 		return
 	}
 
-	buffer := bytes.NewBufferString(`OPTIONS`)
-	for method := range mux.handlers {
-		buffer.WriteString(`, `)
-		buffer.WriteString(method)
-	}
-
-	writer.Header().Set(`Allow`, buffer.String())
+	/*
+		RFC 7231 - Hypertext Transfer Protocol (HTTP/1.1): Semantics and Content
+		6.5.5.  405 Method Not Allowed
+		https://tools.ietf.org/html/rfc7231#section-6.5.5
+		> The origin server MUST generate an Allow header field in a 405
+		> response containing a list of the target resource's currently
+		> supported methods.
+	*/
+	writer.Header().Set(`Allow`, strings.Join(mux.methods(), `, `))
 
 	if request.Method == `OPTIONS` {
 		for _, option := range mux.options {
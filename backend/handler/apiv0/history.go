@@ -0,0 +1,64 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
+	"github.com/kagucho/tsubonesystem3/backend/scope"
+	"net/http"
+)
+
+var historyGetOperation = operation{
+	summary:  `Get the history of the changes of the record.`,
+	security: []uint{scope.Management},
+	responses: map[int]response{
+		http.StatusOK: jsonResponse(`The changes in the order they were made.`,
+			arrayOf(object(map[string]*schema{
+				`date`:     schemaTime,
+				`field`:    schemaString,
+				`new`:      nullable(schemaString),
+				`old`:      nullable(schemaString),
+				`operator`: nullable(schemaString),
+			}, nil))),
+	},
+}
+
+/*
+historyMux returns methodMux serving the history of the record of the given
+subject identified by the first path parameter. The history is only available
+for management.
+*/
+func historyMux(subject db.HistorySubject) methodMux {
+	serve := func(writer http.ResponseWriter, request *http.Request, shared shared) {
+		if (authorize(writer, request, shared, scope.Management) == claim{}) {
+			return
+		}
+
+		util.ServeJSON(writer,
+			shared.DB.QueryHistory(request.Context(), subject,
+				requestParams(request).target()),
+			http.StatusOK)
+	}
+
+	return methodMux{
+		map[string]handlerFunc{`GET`: serve, `HEAD`: serve},
+		[]field{{`Accept-Ranges`, `none`}},
+		map[string]operation{`GET`: historyGetOperation},
+	}
+}
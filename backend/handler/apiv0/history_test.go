@@ -0,0 +1,114 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+type testHistoryEntry struct {
+	Field    string  `json:"field"`
+	New      *string `json:"new"`
+	Old      *string `json:"old"`
+	Operator *string `json:"operator"`
+}
+
+func testHistoryString(value string) *string {
+	return &value
+}
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
+	management := apiv0.authorization(t, `1stDisplayID`, `management member`)
+
+	apiv0.testRequests(t, []testRequest{
+		{
+			`forbidden`, `GET`, `/member/4thDisplayID/history`, member,
+			``, http.StatusForbidden, ``,
+		}, {
+			`patchMember`, `PATCH`, `/member/4thDisplayID`, management,
+			`affiliation=理学部&tel=000-000-004`, http.StatusOK, ``,
+		}, {
+			`patchClub`, `PATCH`, `/club/prog`, management,
+			`name=Prog2部`, http.StatusOK, ``,
+		}, {
+			`deleteMember`, `DELETE`, `/member/4thDisplayID`, management,
+			``, http.StatusOK, ``,
+		},
+	})
+
+	for _, test := range [...]struct {
+		description string
+		path        string
+		expected    []testHistoryEntry
+	}{
+		{
+			`member`, `/member/4thDisplayID/history`,
+			[]testHistoryEntry{
+				{`affiliation`, testHistoryString(`理学部`), nil, testHistoryString(`1stDisplayID`)},
+				{`tel`, testHistoryString(`000-000-004`), nil, testHistoryString(`1stDisplayID`)},
+				{`deleted`, testHistoryString(`true`), testHistoryString(`false`), testHistoryString(`1stDisplayID`)},
+			},
+		}, {
+			`club`, `/club/prog/history`,
+			[]testHistoryEntry{
+				{`name`, testHistoryString(`Prog2部`), testHistoryString(`Prog部`), testHistoryString(`1stDisplayID`)},
+			},
+		}, {
+			`empty`, `/officer/invalid/history`, []testHistoryEntry{},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := apiv0.serve(`GET`, test.path, management, ``)
+			testCode(t, http.StatusOK, recorder)
+
+			var entries []testHistoryEntry
+			if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil {
+				t.Fatal(err)
+			}
+
+			// Skip the entries of the fixture, which have no operator.
+			changes := []testHistoryEntry{}
+			for _, entry := range entries {
+				if entry.Operator != nil {
+					changes = append(changes, entry)
+				}
+			}
+
+			if !reflect.DeepEqual(changes, test.expected) {
+				encoded, err := json.Marshal(changes)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				t.Errorf(`unexpected history: %s`, encoded)
+			}
+		})
+	}
+}
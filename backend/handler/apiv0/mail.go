@@ -19,27 +19,55 @@ package apiv0
 
 import (
 	"github.com/kagucho/tsubonesystem3/backend/db"
-	"github.com/kagucho/tsubonesystem3/backend/encoding"
 	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
 	"github.com/kagucho/tsubonesystem3/backend/scope"
 	"net/http"
-	"strconv"
 )
 
+var (
+	schemaMail = object(map[string]*schema{
+		`body`:       schemaString,
+		`date`:       schemaTime,
+		`from`:       nullable(schemaString),
+		`recipients`: arrayOf(schemaString),
+		`to`:         schemaString,
+	}, nil)
+
+	schemaMailEntry = object(map[string]*schema{
+		`date`:    schemaTime,
+		`from`:    nullable(schemaString),
+		`subject`: schemaString,
+		`to`:      schemaString,
+	}, nil)
+)
+
+var mailDeleteOperation = operation{
+	summary:  `Delete the email.`,
+	security: []uint{scope.Management},
+	ifMatch:  true,
+	responses: map[int]response{
+		http.StatusOK:       resultResponse,
+		http.StatusNotFound: errorResponse(`The email is not found.`),
+	},
+}
+
 func mailDeleteServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path == `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
+	if (authorize(writer, request, shared, scope.Management) == claim{}) {
 		return
 	}
 
-	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+	version, ok := ifMatch(writer, request)
+	if !ok {
 		return
 	}
 
-	switch err := shared.DB.DeleteMail(request.URL.Path[1:]); err {
+	switch err := shared.DB.DeleteMail(pathParam(request, `subject`), version); err {
 	case db.ErrIncorrectIdentity:
 		util.ServeErrorDefault(writer, http.StatusNotFound)
 
+	case db.ErrVersionMismatch:
+		servePreconditionFailed(writer)
+
 	case nil:
 		util.ServeJSON(writer, struct{}{}, http.StatusOK)
 
@@ -48,65 +76,80 @@ func mailDeleteServeHTTP(writer http.ResponseWriter, request *http.Request, shar
 	}
 }
 
-func mailGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path == `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
-		return
-	}
+var mailGetOperation = operation{
+	summary:  `Get the email.`,
+	security: []uint{scope.Member},
+	etag:     true,
+	responses: map[int]response{
+		http.StatusOK:       jsonResponse(`The email.`, schemaMail),
+		http.StatusNotFound: errorResponse(`The email is not found.`),
+	},
+}
 
+func mailGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
 	if (authorize(writer, request, shared, scope.Member) == claim{}) {
 		return
 	}
 
-	switch mail, err := shared.DB.QueryMail(request.URL.Path[1:]); err {
+	switch mail, err := shared.DB.QueryMail(request.Context(), pathParam(request, `subject`)); err {
 	case db.ErrIncorrectIdentity:
 		util.ServeErrorDefault(writer, http.StatusNotFound)
 
 	case nil:
-		util.ServeJSON(writer, mail, http.StatusOK)
+		if !serveETag(writer, request, mail.Version) {
+			util.ServeJSON(writer, mail, http.StatusOK)
+		}
 
 	default:
 		panic(err)
 	}
 }
 
+var mailPatchOperation = operation{
+	summary:  `Update the email.`,
+	security: []uint{scope.Management},
+	body: []parameter{
+		{`body`, ``, property(db.PropertyText), false},
+		{`date`, ``, schemaTime, false},
+		{`from`, ``, property(db.PropertyID), false},
+		{`recipients`, ``, schemaList, false},
+		{`to`, ``, property(db.PropertyName), false},
+	},
+	media:   mediaMergePatch,
+	ifMatch: true,
+	responses: map[int]response{
+		http.StatusOK:                  resultResponse,
+		http.StatusNotFound:            errorResponse(`The email, the sender, or a recipient is not found.`),
+		http.StatusUnprocessableEntity: invalidResponse(`The properties are invalid.`),
+	},
+}
+
 func mailPatchServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path == `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
+	if (authorize(writer, request, shared, scope.Management) == claim{}) {
 		return
 	}
 
-	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+	version, ok := ifMatch(writer, request)
+	if !ok {
 		return
 	}
 
-	var date encoding.Time
-
-	if dateString := request.FormValue(`date`); dateString != `` {
-		var err error
-
-		date, err = encoding.ParseQueryTime(dateString)
-		if err != nil {
-			description := `syntax error in date`
-			status := http.StatusBadRequest
-
-			if err == strconv.ErrRange {
-				description = `date out of range`
-				status = http.StatusUnprocessableEntity
-			}
-
-			util.ServeError(writer,
-				util.Error{Description: description},
-				status)
+	from := request.PostFormValue(`from`)
+	to := request.PostFormValue(`to`)
+	body := request.PostFormValue(`body`)
 
-			return
-		}
+	var validation validation
+	date := validation.time(`date`, request.FormValue(`date`))
+	validation.property(`from`, from, db.PropertyID)
+	validation.property(`to`, to, db.PropertyName)
+	validation.property(`body`, body, db.PropertyText)
+	if validation.serve(writer) {
+		return
 	}
 
-	switch err := shared.DB.UpdateMail(request.URL.Path[1:],
+	switch err := shared.DB.UpdateMail(pathParam(request, `subject`),
 		request.PostFormValue(`recipients`), date,
-		request.PostFormValue(`from`), request.PostFormValue(`to`),
-		request.PostFormValue(`body`)); err {
+		from, to, body, version); err {
 	case db.ErrIncorrectIdentity:
 		// FIXME: should be StatusUnprocessableEntity if mail is found
 		// but recipients or from is not.
@@ -115,6 +158,9 @@ func mailPatchServeHTTP(writer http.ResponseWriter, request *http.Request, share
 	case db.ErrInvalid:
 		util.ServeErrorDefault(writer, http.StatusUnprocessableEntity)
 
+	case db.ErrVersionMismatch:
+		servePreconditionFailed(writer)
+
 	case nil:
 		util.ServeJSON(writer, struct{}{}, http.StatusOK)
 
@@ -123,23 +169,47 @@ func mailPatchServeHTTP(writer http.ResponseWriter, request *http.Request, share
 	}
 }
 
-func mailPutServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if len(request.URL.Path) < 2 {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
-		return
-	}
+var mailPutOperation = operation{
+	summary:  `Send an email with the subject.`,
+	security: []uint{scope.Member},
+	body: []parameter{
+		{`body`, ``, property(db.PropertyText), true},
+		{`recipients`, ``, schemaList, true},
+		{`to`, ``, property(db.PropertyName), true},
+	},
+	media: mediaJSON,
+	mails: true,
+	responses: map[int]response{
+		http.StatusCreated:             resultResponse,
+		http.StatusNotFound:            errorResponse(`A recipient is not found.`),
+		http.StatusUnprocessableEntity: invalidResponse(`The properties are invalid or the subject is duplicate.`),
+	},
+}
 
+func mailPutServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
 	authorized := authorize(writer, request, shared, scope.Member)
 	if (authorized == claim{}) {
 		return
 	}
 
 	body := request.PostFormValue(`body`)
+	recipients := request.PostFormValue(`recipients`)
 	to := request.PostFormValue(`to`)
-	subject := request.URL.Path[1:]
+	subject := pathParam(request, `subject`)
+
+	var validation validation
+	validation.require(`recipients`, recipients)
+	validation.require(`to`, to)
+	validation.property(`to`, to, db.PropertyName)
+	validation.property(`subject`, subject, db.PropertyName)
+	validation.require(`body`, body)
+	validation.property(`body`, body, db.PropertyText)
+	if validation.serve(writer) {
+		return
+	}
 
 	switch nickname, recipients, err := shared.DB.InsertMail(
-		request.PostFormValue(`recipients`), authorized.sub, to, subject, body); err {
+		recipients, authorized.sub, to, subject, body); err {
 	case db.ErrBadOmission:
 		util.ServeError(writer,
 			util.Error{Description: `recipients, to, subject, and body are required`},
@@ -173,15 +243,37 @@ func mailPutServeHTTP(writer http.ResponseWriter, request *http.Request, shared
 	}
 }
 
-func mailsGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path != `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
-		return
-	}
+var mailsGetOperation = pageOperation(`List the emails.`,
+	[]uint{scope.Member}, schemaMailEntry)
 
+func mailsGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
 	if (authorize(writer, request, shared, scope.Member) == claim{}) {
 		return
 	}
 
-	util.ServeJSON(writer, shared.DB.QueryMails(), http.StatusOK)
+	serveSoughtPage(writer, request, func(after string, limit int) (pageNext, bool) {
+		var afterID uint16
+
+		if after != `` {
+			var err error
+
+			afterID, err = shared.DB.QueryMailID(request.Context(), after)
+			switch err {
+			case nil:
+
+			case db.ErrIncorrectIdentity:
+				return nil, false
+
+			default:
+				panic(err)
+			}
+		}
+
+		mailChan := shared.DB.QueryMails(request.Context(), afterID, limit)
+
+		return func() (interface{}, string, error, bool) {
+			result, present := <-mailChan
+			return result.MailEntry, result.Subject, result.Error, present
+		}, true
+	})
 }
@@ -0,0 +1,103 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMail(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
+	management := apiv0.authorization(t, `1stDisplayID`, `management member`)
+
+	t.Run(`put`, func(t *testing.T) {
+		apiv0.recorder.Reset()
+
+		recorder := apiv0.serve(`PUT`, `/mail/subject`, member,
+			`recipients=1stDisplayID 2ndDisplayID&to=Prog部&body=body`)
+		testCode(t, http.StatusCreated, recorder)
+
+		recorded := apiv0.recorder.Recorded()
+		if len(recorded) != 1 {
+			t.Fatalf(`expected 1 mail, got %v`, len(recorded))
+		}
+
+		expected := []string{`1st@kagucho.net`, `2nd@kagucho.net`}
+		if !reflect.DeepEqual(recorded[0].Recipients, expected) {
+			t.Errorf(`expected %v, got %v`,
+				expected, recorded[0].Recipients)
+		}
+
+		if recorded[0].Subject != `subject` {
+			t.Errorf(`expected "subject", got %q`, recorded[0].Subject)
+		}
+
+		if !bytes.Contains(recorded[0].Message, []byte(`body`)) {
+			t.Errorf(`expected body in message, got %s`,
+				recorded[0].Message)
+		}
+	})
+
+	t.Run(`putIncorrectRecipients`, func(t *testing.T) {
+		apiv0.recorder.Reset()
+
+		recorder := apiv0.serve(`PUT`, `/mail/incorrect`, member,
+			`recipients=invalid&to=invalid&body=body`)
+		testCode(t, http.StatusNotFound, recorder)
+
+		if recorded := apiv0.recorder.Recorded(); len(recorded) != 0 {
+			t.Errorf(`expected no mails, got %v`, recorded)
+		}
+	})
+
+	apiv0.testRequests(t, []testRequest{
+		{
+			`putDuplicate`, `PUT`, `/mail/subject`, member,
+			`recipients=1stDisplayID&to=to&body=body`,
+			http.StatusUnprocessableEntity, ``,
+		}, {
+			`get`, `GET`, `/mail/subject`, member, ``, http.StatusOK,
+			``,
+		}, {
+			`mails`, `GET`, `/mails`, member, ``, http.StatusOK, ``,
+		}, {
+			`patchForbidden`, `PATCH`, `/mail/subject`, member,
+			`body=patched`, http.StatusForbidden, ``,
+		}, {
+			`patch`, `PATCH`, `/mail/subject`, management,
+			`body=patched`, http.StatusOK, ``,
+		}, {
+			`delete`, `DELETE`, `/mail/subject`, management, ``,
+			http.StatusOK, ``,
+		}, {
+			`deleted`, `GET`, `/mail/subject`, member, ``,
+			http.StatusNotFound, ``,
+		},
+	})
+}
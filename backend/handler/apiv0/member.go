@@ -28,12 +28,13 @@ import (
 	"log"
 	"net/http"
 	netMail "net/mail"
-	"strconv"
+	"strings"
 )
 
 type publicDetail struct {
 	Affiliation encoding.ZeroString `json:"affiliation"`
 	Clubs       json.RawMessage     `json:"clubs"`
+	Deleted     bool                `json:"deleted"`
 	Entrance    encoding.ZeroUint16 `json:"entrance"`
 	Gender      encoding.ZeroString `json:"gender"`
 	Mail        string              `json:"mail"`
@@ -49,20 +50,135 @@ type privateDetail struct {
 	Tel       encoding.ZeroString `json:"tel"`
 }
 
-func memberDeleteServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path == `` {
+var (
+	schemaMember = object(map[string]*schema{
+		`affiliation`: nullable(schemaString),
+		`clubs`: arrayOf(object(map[string]*schema{
+			`chief`: schemaBoolean,
+			`id`:    schemaString,
+		}, nil)),
+		`deleted`:   schemaBoolean,
+		`entrance`:  nullable(schemaInteger),
+		`gender`:    nullable(schemaString),
+		`mail`:      schemaString,
+		`nickname`:  schemaString,
+		`ob`:        schemaBoolean,
+		`positions`: arrayOf(schemaString),
+		`realname`:  nullable(schemaString),
+	}, map[string]*schema{
+		`confirmed`: schemaBoolean,
+		`tel`:       nullable(schemaString),
+	})
+
+	schemaMemberEntry = object(map[string]*schema{
+		`affiliation`: nullable(schemaString),
+		`entrance`:    nullable(schemaInteger),
+		`id`:          schemaString,
+		`nickname`:    schemaString,
+		`ob`:          schemaBoolean,
+		`realname`:    nullable(schemaString),
+	}, nil)
+
+	// schemaMemberPatched is a result which may have a new token.
+	schemaMemberPatched = optional(map[string]*schema{
+		`access_token`:      schemaString,
+		`error`:             schemaString,
+		`error_description`: schemaString,
+		`error_uri`:         schemaString,
+		`refresh_token`:     schemaString,
+		`scope`:             schemaString,
+	})
+)
+
+var deletedMemberDeleteOperation = operation{
+	summary:  `Purge the archived member.`,
+	security: []uint{scope.Management},
+	responses: map[int]response{
+		http.StatusOK:                  resultResponse,
+		http.StatusNotFound:            errorResponse(`The archived member is not found.`),
+		http.StatusUnprocessableEntity: errorResponse(`The member is an officer.`),
+	},
+}
+
+func deletedMemberDeleteServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	authorized := authorize(writer, request, shared, scope.Management)
+	if (authorized == claim{}) {
+		return
+	}
+
+	switch err := shared.DB.PurgeMember(authorized.sub, pathParam(request, `id`)); err {
+	case db.ErrIncorrectIdentity:
 		util.ServeErrorDefault(writer, http.StatusNotFound)
+
+	case db.ErrMemberIsOfficer:
+		util.ServeError(writer,
+			util.Error{Description: `member is officer`},
+			http.StatusUnprocessableEntity)
+
+	case nil:
+		util.ServeJSON(writer, struct{}{}, http.StatusOK)
+
+	default:
+		panic(err)
+	}
+}
+
+var deletedMemberPostOperation = operation{
+	summary:  `Restore the archived member.`,
+	security: []uint{scope.Management},
+	responses: map[int]response{
+		http.StatusOK:       resultResponse,
+		http.StatusNotFound: errorResponse(`The archived member is not found.`),
+	},
+}
+
+func deletedMemberPostServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	authorized := authorize(writer, request, shared, scope.Management)
+	if (authorized == claim{}) {
 		return
 	}
 
-	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+	switch err := shared.DB.RestoreMember(authorized.sub, pathParam(request, `id`)); err {
+	case db.ErrIncorrectIdentity:
+		util.ServeErrorDefault(writer, http.StatusNotFound)
+
+	case nil:
+		util.ServeJSON(writer, struct{}{}, http.StatusOK)
+
+	default:
+		panic(err)
+	}
+}
+
+var memberDeleteOperation = operation{
+	summary:  `Archive the member.`,
+	security: []uint{scope.Management},
+	ifMatch:  true,
+	responses: map[int]response{
+		http.StatusOK:                  resultResponse,
+		http.StatusNotFound:            errorResponse(`The member is not found.`),
+		http.StatusUnprocessableEntity: errorResponse(`The member is an officer.`),
+	},
+}
+
+func memberDeleteServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	authorized := authorize(writer, request, shared, scope.Management)
+	if (authorized == claim{}) {
 		return
 	}
 
-	switch err := shared.DB.DeleteMember(request.URL.Path[1:]); err {
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
+
+	switch err := shared.DB.DeleteMember(authorized.sub, pathParam(request, `id`), version); err {
 	case db.ErrIncorrectIdentity:
 		util.ServeErrorDefault(writer, http.StatusNotFound)
 
+	case db.ErrVersionMismatch:
+		servePreconditionFailed(writer)
+
 	case db.ErrMemberIsOfficer:
 		util.ServeError(writer,
 			util.Error{Description: `member is officer`},
@@ -162,65 +278,58 @@ func memberTransformPositions(querier db.PositionQuerier) (bool, json.RawMessage
 	}
 }
 
+/*
+memberGetOperation and userGetOperation describe memberGetServeHTTP serving
+the member identified with the path parameter and the user respectively.
+*/
+var (
+	memberGetOperation = operation{
+		summary:  `Get the member.`,
+		security: []uint{scope.Member},
+		etag:     true,
+		responses: map[int]response{
+			http.StatusOK:       jsonResponse(`The member. The private properties are only for privileged users.`, schemaMember),
+			http.StatusNotFound: errorResponse(`The member is not found.`),
+		},
+	}
+
+	userGetOperation = operation{
+		summary:  `Get the user.`,
+		security: []uint{scope.User},
+		etag:     true,
+		responses: map[int]response{
+			http.StatusOK:       jsonResponse(`The user.`, schemaMember),
+			http.StatusNotFound: errorResponse(`The user is not found.`),
+		},
+	}
+)
+
 func memberGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
 	var authorized claim
-	var id string
 
-	if request.URL.Path == `` {
+	id := pathParam(request, `id`)
+	if id == `` {
 		authorized = authorize(writer, request, shared, scope.User)
 		id = authorized.sub
 	} else {
 		authorized = authorize(writer, request, shared, scope.Member)
-		id = request.URL.Path[1:]
 	}
 
 	if (authorized == claim{}) {
 		return
 	}
 
-	switch detail, err := shared.DB.QueryMemberDetail(id); err {
+	switch detail, err := shared.DB.QueryMemberDetail(request.Context(), id); err {
 	case nil:
-		var chief bool
-		var positionsPresent bool
-		var clubs json.RawMessage
-		var positions json.RawMessage
-
-		func() {
-			defer func() {
-				if err := detail.End(); err != nil {
-					log.Print(err)
-				}
-			}()
-
-			chief, clubs = memberTransformClubs(detail.Clubs)
-			positionsPresent, positions = memberTransformPositions(detail.Positions)
-		}()
-
-		mail, err := mail.AddressToUnicode(detail.Mail)
-		if err != nil {
-			panic(err)
-		}
-
-		public := publicDetail{
-			Affiliation: detail.Affiliation,
-			Clubs:       clubs,
-			Entrance:    detail.Entrance,
-			Gender:      detail.Gender,
-			Mail:        mail,
-			Nickname:    detail.Nickname,
-			OB:          detail.OB,
-			Positions:   positions,
-			Realname:    detail.Realname,
-		}
+		if serveETag(writer, request, detail.Version) {
+			if err := detail.End(); err != nil {
+				log.Print(err)
+			}
 
-		var unmarshalled interface{}
-		if id == authorized.sub || chief || positionsPresent || authorized.scope.IsSet(scope.Privacy) {
-			unmarshalled = privateDetail{public, detail.Confirmed, detail.Tel}
-		} else {
-			unmarshalled = public
+			return
 		}
 
-		util.ServeJSON(writer, unmarshalled, http.StatusOK)
+		util.ServeJSON(writer, memberVisibleDetail(detail, id, authorized), http.StatusOK)
 
 	case db.ErrIncorrectIdentity:
 		util.ServeErrorDefault(writer, http.StatusNotFound)
@@ -230,22 +339,127 @@ func memberGetServeHTTP(writer http.ResponseWriter, request *http.Request, share
 	}
 }
 
+/*
+memberVisibleDetail returns the details of the member identified with the given
+ID visible for the given claim, and ends the given db.MemberDetail. The private
+properties are only visible for the member, chiefs, officers and users with the
+privacy scope.
+*/
+func memberVisibleDetail(detail db.MemberDetail, id string, authorized claim) interface{} {
+	var chief bool
+	var positionsPresent bool
+	var clubs json.RawMessage
+	var positions json.RawMessage
+
+	func() {
+		defer func() {
+			if err := detail.End(); err != nil {
+				log.Print(err)
+			}
+		}()
+
+		chief, clubs = memberTransformClubs(detail.Clubs)
+		positionsPresent, positions = memberTransformPositions(detail.Positions)
+	}()
+
+	mail, err := mail.AddressToUnicode(detail.Mail)
+	if err != nil {
+		panic(err)
+	}
+
+	public := publicDetail{
+		Affiliation: detail.Affiliation,
+		Clubs:       clubs,
+		Deleted:     detail.Deleted,
+		Entrance:    detail.Entrance,
+		Gender:      detail.Gender,
+		Mail:        mail,
+		Nickname:    detail.Nickname,
+		OB:          detail.OB,
+		Positions:   positions,
+		Realname:    detail.Realname,
+	}
+
+	if id == authorized.sub || chief || positionsPresent || authorized.scope.IsSet(scope.Privacy) {
+		return privateDetail{public, detail.Confirmed, detail.Tel}
+	}
+
+	return public
+}
+
+/*
+memberPatchOperation and userPatchOperation describe memberPatchServeHTTP
+updating the member identified with the path parameter and the user
+respectively.
+*/
+var (
+	memberPatchOperation = operation{
+		summary:  `Update the member.`,
+		security: []uint{scope.Management},
+		query:    []parameter{{`token`, `query`, schemaString, false}},
+		body:     memberPatchBody,
+		media:    mediaMergePatch,
+		ifMatch:  true,
+		mails:    true,
+		responses: map[int]response{
+			http.StatusOK:                  jsonResponse(`The member is updated.`, schemaMemberPatched),
+			http.StatusBadRequest:          invalidResponse(`The body is malformed or the token is bad.`),
+			http.StatusNotFound:            errorResponse(`The member or a club is not found.`),
+			http.StatusUnprocessableEntity: invalidResponse(`The properties are invalid.`),
+		},
+	}
+
+	userPatchOperation = operation{
+		summary:  `Update the user. A temporary user gets new tokens by setting the password.`,
+		security: []uint{scope.User},
+		query:    []parameter{{`token`, `query`, schemaString, false}},
+		body:     memberPatchBody,
+		media:    mediaMergePatch,
+		ifMatch:  true,
+		mails:    true,
+		responses: map[int]response{
+			http.StatusOK:                  jsonResponse(`The user is updated.`, schemaMemberPatched),
+			http.StatusBadRequest:          invalidResponse(`The body is malformed or the token is bad.`),
+			http.StatusNotFound:            errorResponse(`A club is not found.`),
+			http.StatusUnprocessableEntity: invalidResponse(`The properties or the current password are invalid.`),
+		},
+	}
+
+	memberPatchBody = []parameter{
+		{`affiliation`, ``, property(db.PropertyName), false},
+		{`clubs`, ``, schemaList, false},
+		{`current_password`, ``, schemaString, false},
+		{`entrance`, ``, schemaInteger, false},
+		{`gender`, ``, property(db.PropertyName), false},
+		{`mail`, ``, property(db.PropertyMail), false},
+		{`new_password`, ``, property(db.PropertyPassword), false},
+		{`nickname`, ``, property(db.PropertyNickname), false},
+		{`ob`, ``, schemaFlag, false},
+		{`realname`, ``, property(db.PropertyName), false},
+		{`tel`, ``, property(db.PropertyTel), false},
+	}
+)
+
 func memberPatchServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
 	var authorized claim
-	var id string
 
-	if request.URL.Path == `` {
+	id := pathParam(request, `id`)
+	if id == `` {
 		authorized = authorize(writer, request, shared, scope.User)
 		id = authorized.sub
 	} else {
 		authorized = authorize(writer, request, shared, scope.Management)
-		id = request.URL.Path[1:]
 	}
 
 	if (authorized == claim{}) {
 		return
 	}
 
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
+
 	confirm := authorized.tmp
 	if !confirm {
 		if token := request.FormValue(`token`); token != `` {
@@ -270,39 +484,29 @@ func memberPatchServeHTTP(writer http.ResponseWriter, request *http.Request, sha
 		}
 	}
 
-	entrance := 0
-	if entranceString := request.PostFormValue(`entrance`); entranceString != `` {
-		var err error
-
-		entrance, err = strconv.Atoi(entranceString)
-		if err != nil {
-			description := `syntax error in entrance`
-			status := http.StatusBadRequest
-
-			if err == strconv.ErrRange {
-				description = `entrance out of range`
-				status = http.StatusUnprocessableEntity
-			}
-
-			util.ServeError(writer,
-				util.Error{Description: description},
-				status)
+	affiliation := request.PostFormValue(`affiliation`)
 
-			return
-		}
+	// An empty list of the clubs removes the member from all of them.
+	clubs := db.NoClubsUpdate
+	if formClubs := request.PostForm[`clubs`]; formClubs != nil {
+		clubs = formClubs[0]
 	}
 
-	address := request.PostFormValue(`mail`)
-	if address != `` {
-		var err error
+	gender := request.PostFormValue(`gender`)
+	nickname := request.PostFormValue(`nickname`)
+	password := request.PostFormValue(`new_password`)
+	realname := request.PostFormValue(`realname`)
+	tel := request.PostFormValue(`tel`)
 
-		address, err = mail.AddressToASCII(address)
-		if err != nil {
-			util.ServeError(writer,
-				util.Error{Description: `invalid mail`},
-				http.StatusBadRequest)
-		}
-	}
+	var validation validation
+	entrance := validation.entrance(`entrance`, request.PostFormValue(`entrance`))
+	address := validation.mail(`mail`, request.PostFormValue(`mail`))
+	validation.property(`affiliation`, affiliation, db.PropertyName)
+	validation.property(`gender`, gender, db.PropertyName)
+	validation.property(`nickname`, nickname, db.PropertyNickname)
+	validation.property(`new_password`, password, db.PropertyPassword)
+	validation.property(`realname`, realname, db.PropertyName)
+	validation.property(`tel`, tel, db.PropertyTel)
 
 	ob := false
 	switch request.PostFormValue(`ob`) {
@@ -312,16 +516,16 @@ func memberPatchServeHTTP(writer http.ResponseWriter, request *http.Request, sha
 		ob = true
 
 	default:
-		util.ServeError(writer,
-			util.Error{Description: `invalid ob`},
-			http.StatusBadRequest)
+		validation.invalid(`ob`, reasonSyntax)
+	}
+
+	if validation.serve(writer) {
+		return
 	}
 
-	
-	password := request.PostFormValue(`new_password`)
 	if password != `` && !authorized.tmp {
 		// FIXME: should I add rate limiter?
-		err := shared.DB.Authenticate(id, request.PostFormValue(`current_password`));
+		err := shared.DB.Authenticate(id, request.PostFormValue(`current_password`))
 		if err == db.ErrIncorrectIdentity {
 			util.ServeError(writer,
 				util.Error{Description: `incorrect identity`},
@@ -332,16 +536,14 @@ func memberPatchServeHTTP(writer http.ResponseWriter, request *http.Request, sha
 		}
 	}
 
-	affiliation := request.PostFormValue(`affiliation`)
-	clubs := request.PostFormValue(`clubs`)
-	gender := request.PostFormValue(`gender`)
-	nickname := request.PostFormValue(`nickname`)
-	realname := request.PostFormValue(`realname`)
-	tel := request.PostFormValue(`tel`)
-
-	switch err := shared.DB.UpdateMember(id, confirm, ob, password,
+	switch err := shared.DB.UpdateMember(authorized.sub, id, confirm, ob, password,
 		affiliation, clubs, entrance, gender,
-		address, nickname, realname, tel); err {
+		address, nickname, realname, tel, version); err {
+	case db.ErrDupEntry:
+		util.ServeError(writer,
+			util.Error{Description: `duplicate nickname`},
+			http.StatusUnprocessableEntity)
+
 	case db.ErrIncorrectIdentity:
 		// FIXME: should be StatusUnprocessableEntity if member is found
 		// but clubs is not.
@@ -350,6 +552,9 @@ func memberPatchServeHTTP(writer http.ResponseWriter, request *http.Request, sha
 	case db.ErrInvalid:
 		util.ServeErrorDefault(writer, http.StatusUnprocessableEntity)
 
+	case db.ErrVersionMismatch:
+		servePreconditionFailed(writer)
+
 	case nil:
 		if address != `` {
 			token, tokenErr := shared.Token.IssueMail(id)
@@ -357,7 +562,7 @@ func memberPatchServeHTTP(writer http.ResponseWriter, request *http.Request, sha
 				panic(tokenErr)
 			}
 
-			nickname, queryErr := shared.DB.QueryMemberNickname(id)
+			nickname, queryErr := shared.DB.QueryMemberNickname(request.Context(), id)
 			if queryErr != nil {
 				panic(queryErr)
 			}
@@ -411,30 +616,42 @@ func memberPatchServeHTTP(writer http.ResponseWriter, request *http.Request, sha
 	}
 }
 
-func memberPutServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if len(request.URL.Path) < 2 {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
-		return
-	}
+var memberPutOperation = operation{
+	summary:  `Create the member and mail the invitation.`,
+	security: []uint{scope.Management},
+	body: []parameter{
+		{`mail`, ``, property(db.PropertyMail), true},
+		{`nickname`, ``, property(db.PropertyNickname), true},
+	},
+	media: mediaJSON,
+	mails: true,
+	responses: map[int]response{
+		http.StatusCreated:             resultResponse,
+		http.StatusUnprocessableEntity: invalidResponse(`The properties are invalid or duplicate.`),
+	},
+}
 
-	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+func memberPutServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	authorized := authorize(writer, request, shared, scope.Management)
+	if (authorized == claim{}) {
 		return
 	}
 
 	address := request.FormValue(`mail`)
-	id := request.URL.Path[1:]
+	id := pathParam(request, `id`)
 	nickname := request.FormValue(`nickname`)
 
-	asciiAddress, err := mail.AddressToASCII(address)
-	if err != nil {
-		util.ServeError(writer,
-			util.Error{Description: `invalid mail`},
-			http.StatusBadRequest)
-
+	var validation validation
+	validation.property(`id`, id, db.PropertyID)
+	validation.require(`mail`, address)
+	asciiAddress := validation.mail(`mail`, address)
+	validation.require(`nickname`, nickname)
+	validation.property(`nickname`, nickname, db.PropertyNickname)
+	if validation.serve(writer) {
 		return
 	}
 
-	switch err := shared.DB.InsertMember(id, asciiAddress, nickname); err {
+	switch err := shared.DB.InsertMember(authorized.sub, id, asciiAddress, nickname); err {
 	case db.ErrBadOmission:
 		util.ServeError(writer,
 			util.Error{Description: `id, mail, and nickname are required`},
@@ -470,28 +687,106 @@ func memberPutServeHTTP(writer http.ResponseWriter, request *http.Request, share
 	}
 }
 
+var membersGetOperation = pageOperation(`List the members.`,
+	[]uint{scope.Member}, schemaMemberEntry,
+	parameter{`affiliation`, `query`, schemaString, false},
+	parameter{`club`, `query`, schemaString, false},
+	parameter{`confirmed`, `query`, schemaFlag, false},
+	parameter{`entrance`, `query`, schemaInteger, false},
+	parameter{`nickname`, `query`, schemaString, false},
+	parameter{`ob`, `query`, schemaFlag, false},
+	parameter{`realname`, `query`, schemaString, false},
+	parameter{`sort`, `query`, &schema{Type: `string`, Enum: []string{
+		`entrance`, `-entrance`, `id`, `-id`,
+		`nickname`, `-nickname`, `realname`, `-realname`,
+	}}, false},
+).with(http.StatusUnprocessableEntity, invalidResponse(`The filter is out of range.`))
+
 func membersGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path != `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
+	if (authorize(writer, request, shared, scope.Member) == claim{}) {
 		return
 	}
 
-	if (authorize(writer, request, shared, scope.Member) == claim{}) {
-		return
+	filter := db.MemberFilter{
+		Affiliation: request.FormValue(`affiliation`),
+		Club:        request.FormValue(`club`),
+		Nickname:    request.FormValue(`nickname`),
+		Realname:    request.FormValue(`realname`),
 	}
 
-	util.ServeJSON(writer, shared.DB.QueryMembers(), http.StatusOK)
-}
+	var validation validation
+	filter.Entrance = validation.entrance(`entrance`, request.FormValue(`entrance`))
 
-func membersMailsGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path != `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
+	switch request.FormValue(`ob`) {
+	case ``:
+
+	case `0`:
+		filter.Status = db.MemberStatusActive
+
+	case `1`:
+		filter.Status = db.MemberStatusOB
+
+	default:
+		validation.invalid(`ob`, reasonSyntax)
+	}
+
+	switch request.FormValue(`confirmed`) {
+	case ``:
+
+	case `0`:
+		filter.Confirmation = db.MemberUnconfirmed
+
+	case `1`:
+		filter.Confirmation = db.MemberConfirmed
+
+	default:
+		validation.invalid(`confirmed`, reasonSyntax)
+	}
+
+	// A leading "-" sorts in the descending order.
+	sort := request.FormValue(`sort`)
+	descending := strings.HasPrefix(sort, `-`)
+	order, present := map[string]db.MemberOrder{
+		``:         db.MemberOrderID,
+		`entrance`: db.MemberOrderEntrance,
+		`id`:       db.MemberOrderID,
+		`nickname`: db.MemberOrderNickname,
+		`realname`: db.MemberOrderRealname,
+	}[strings.TrimPrefix(sort, `-`)]
+	if !present {
+		validation.invalid(`sort`, reasonSyntax)
+	}
+
+	if validation.serve(writer) {
 		return
 	}
 
+	servePage(writer, request, func() pageNext {
+		members, err := shared.DB.QueryMembers(request.Context(), filter, order, descending)
+		if err != nil {
+			panic(err)
+		}
+
+		return func() (interface{}, string, error, bool) {
+			result, present := <-members
+			return result.MemberEntry, result.ID, result.Error, present
+		}
+	})
+}
+
+var membersMailsGetOperation = operation{
+	summary:  `List the email addresses of the members.`,
+	security: []uint{scope.Member},
+	responses: map[int]response{
+		http.StatusOK: jsonResponse(`The email addresses keyed by the IDs.`,
+			mapOf(nullable(schemaString))),
+	},
+}
+
+func membersMailsGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
 	if (authorize(writer, request, shared, scope.Member) == claim{}) {
 		return
 	}
 
-	util.ServeJSON(writer, shared.DB.QueryMemberMails(), http.StatusOK)
+	util.ServeJSON(writer, shared.DB.QueryMemberMails(request.Context()), http.StatusOK)
 }
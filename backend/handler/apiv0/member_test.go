@@ -1,3 +1,6 @@
+//go:build dbtest
+// +build dbtest
+
 /*
 	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
 
@@ -18,141 +21,278 @@
 package apiv0
 
 import (
-	"github.com/kagucho/tsubonesystem3/backend/db"
-	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/context"
-	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/token/authorizer"
-	"github.com/kagucho/tsubonesystem3/backend/scope"
+	"bytes"
+	"context"
+	"encoding/json"
 	"net/http"
-	"net/http/httptest"
+	"reflect"
 	"testing"
 )
 
-func TestMemberDetailServeHTTP(t *testing.T) {
+func TestMember(t *testing.T) {
 	t.Parallel()
 
-	db, err := db.Prepare()
-	if err != nil {
-		t.Fatal(err)
-	}
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
 
-	context := context.Context{DB: db}
-
-	func() {
-		defer db.Close()
-
-		for _, test := range [...]struct {
-			description  string
-			requestQuery string
-			requestClaim authorizer.Claim
-			responseCode int
-			responseBody string
-		}{
-			{
-				`invalidID`, ``,
-				authorizer.Claim{
-					Sub:   `1stDisplayID`,
-					Scope: scope.Scope{}.Set(scope.Basic),
-				}, http.StatusBadRequest,
-				`{"error":"invalid_id","error_description":"invalid ID","error_uri":"https://tools.ietf.org/html/rfc7231#section-6.5.1"}
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
+	privacy := apiv0.authorization(t, `3rdDisplayID`, `member privacy`)
+	management := apiv0.authorization(t, `1stDisplayID`, `management member`)
+	user := apiv0.authorization(t, `2ndDisplayID`, `user`)
+
+	apiv0.testRequests(t, []testRequest{
+		{
+			`getNotFound`, `GET`, `/member/invalid`, member, ``,
+			http.StatusNotFound, ``,
+		}, {
+			`getPublic`, `GET`, `/member/4thDisplayID`, member, ``,
+			http.StatusOK, `{"affiliation":null,"clubs":[],"deleted":false,"entrance":1901,"gender":null,"mail":"4th@kagucho.net","nickname":"4 !)_1'#","ob":false,"positions":[],"realname":"$\u0026\\%_2'("}
+`,
+		}, {
+			`getPrivate`, `GET`, `/member/4thDisplayID`, privacy, ``,
+			http.StatusOK, `{"affiliation":null,"clubs":[],"deleted":false,"entrance":1901,"gender":null,"mail":"4th@kagucho.net","nickname":"4 !)_1'#","ob":false,"positions":[],"realname":"$\u0026\\%_2'(","confirmed":false,"tel":null}
+`,
+		}, {
+			`getChief`, `GET`, `/member/2ndDisplayID`, member, ``,
+			http.StatusOK, `{"affiliation":null,"clubs":[{"chief":true,"id":"prog"}],"deleted":false,"entrance":1901,"gender":"女","mail":"2nd@kagucho.net","nickname":"2 !%_1'#","ob":false,"positions":[],"realname":"$\u0026\\%_2'(","confirmed":false,"tel":"000-000-002"}
 `,
-			}, {
-				`president`, `id=1stDisplayID`,
-				authorizer.Claim{
-					Scope: scope.Scope{}.Set(scope.Basic),
-				}, http.StatusOK,
-				`{"affiliation":"理学部第一部 数理情報科学科","clubs":[{"chief":true,"id":"web","name":"Web部"},{"chief":false,"id":"prog","name":"Prog部"}],"entrance":1901,"gender":"男","mail":"1st@kagucho.net","nickname":"1 !\\%_1\"#","ob":false,"positions":[{"id":"president","name":"局長"},{"id":"vice","name":"副局長"}],"realname":"$&\\%_2'(","tel":"000-000-001"}
+		}, {
+			`getUser`, `GET`, `/member`, user, ``, http.StatusOK, `{"affiliation":null,"clubs":[{"chief":true,"id":"prog"}],"deleted":false,"entrance":1901,"gender":"女","mail":"2nd@kagucho.net","nickname":"2 !%_1'#","ob":false,"positions":[],"realname":"$\u0026\\%_2'(","confirmed":false,"tel":"000-000-002"}
 `,
-			}, {
-				`chief`, `id=2ndDisplayID`,
-				authorizer.Claim{
-					Scope: scope.Scope{}.Set(scope.Basic),
-				}, http.StatusOK,
-				`{"clubs":[{"chief":true,"id":"prog","name":"Prog部"}],"entrance":1901,"gender":"女","mail":"","nickname":"2 !%_1\"#","ob":false,"positions":[],"realname":"$&\\%_2'(","tel":"000-000-002"}
+		}, {
+			`members`, `GET`, `/members`, member, ``, http.StatusOK,
+			`[{"affiliation":"理学部第一部 数理情報科学科","entrance":1901,"nickname":"1 !\\%_1'#","ob":false,"realname":"$\u0026\\%_2'(","id":"1stDisplayID"},{"affiliation":null,"entrance":1901,"nickname":"2 !%_1'#","ob":false,"realname":"$\u0026\\%_2'(","id":"2ndDisplayID"},{"affiliation":null,"entrance":1901,"nickname":"3 !\\%*1'#","ob":false,"realname":"$\u0026\\%_2'(","id":"3rdDisplayID"},{"affiliation":null,"entrance":1901,"nickname":"4 !)_1'#","ob":false,"realname":"$\u0026\\%_2'(","id":"4thDisplayID"},{"affiliation":null,"entrance":1901,"nickname":"5 !\\%_1'#","ob":false,"realname":"$\u0026%+2'(","id":"5thDisplayID"},{"affiliation":null,"entrance":2155,"nickname":"6 !\\%_1'#","ob":false,"realname":"$\u0026\\%+2'(","id":"6thDisplayID"},{"affiliation":null,"entrance":1901,"nickname":"7 !\\%_1'#","ob":true,"realname":"$\u0026,_2'(","id":"7thDisplayID"}]
 `,
-			}, {
-				`same`, `id=3rdDisplayID`,
-				authorizer.Claim{
-					Sub:   `3rdDisplayID`,
-					Scope: scope.Scope{}.Set(scope.Basic),
-				}, http.StatusOK,
-				`{"clubs":[],"entrance":1901,"mail":"","nickname":"3 !\\%*1\"#","ob":false,"positions":[],"realname":"$&\\%_2'(","tel":"000-000-003"}
+		}, {
+			`membersMails`, `GET`, `/members/mails`, member, ``,
+			http.StatusOK, `{"1stDisplayID":"1st@kagucho.net","2ndDisplayID":"2nd@kagucho.net","3rdDisplayID":"3rd@kagucho.net","4thDisplayID":"4th@kagucho.net","5thDisplayID":"5th@kagucho.net","6thDisplayID":"6th@kagucho.net","7thDisplayID":"7th@kagucho.net"}
 `,
-			}, {
-				`privacy`, `id=3rdDisplayID`,
-				authorizer.Claim{
-					Scope: scope.Scope{}.Set(scope.Basic).Set(scope.Privacy),
-				}, http.StatusOK,
-				`{"clubs":[],"entrance":1901,"mail":"","nickname":"3 !\\%*1\"#","ob":false,"positions":[],"realname":"$&\\%_2'(","tel":"000-000-003"}
+		}, {
+			`deleteOfficer`, `DELETE`, `/member/1stDisplayID`,
+			management, ``, http.StatusUnprocessableEntity, ``,
+		}, {
+			`delete`, `DELETE`, `/member/5thDisplayID`, management, ``,
+			http.StatusOK, ``,
+		}, {
+			`deleted`, `GET`, `/member/5thDisplayID`, member, ``,
+			http.StatusOK, `{"affiliation":null,"clubs":[],"deleted":true,"entrance":1901,"gender":null,"mail":"5th@kagucho.net","nickname":"5 !\\%_1'#","ob":false,"positions":[],"realname":"$\u0026%+2'("}
 `,
-			}, {
-				`normal`, `id=3rdDisplayID`,
-				authorizer.Claim{
-					Scope: scope.Scope{}.Set(scope.Basic),
-				}, http.StatusOK,
-				`{"clubs":[],"entrance":1901,"mail":"","nickname":"3 !\\%*1\"#","ob":false,"positions":[],"realname":"$&\\%_2'("}
+		}, {
+			`deletedMails`, `GET`, `/members/mails`, member, ``,
+			http.StatusOK, `{"1stDisplayID":"1st@kagucho.net","2ndDisplayID":"2nd@kagucho.net","3rdDisplayID":"3rd@kagucho.net","4thDisplayID":"4th@kagucho.net","6thDisplayID":"6th@kagucho.net","7thDisplayID":"7th@kagucho.net"}
 `,
-			},
-		} {
-			t.Run(test.description, func(t *testing.T) {
-				request := httptest.NewRequest(`GET`,
-					`http://kagucho.net/api/v0/member/detail?`+test.requestQuery, nil)
-
-				recorder := httptest.NewRecorder()
-				DetailServeHTTP(recorder, request, context, test.requestClaim)
-
-				if recorder.Code != test.responseCode {
-					t.Error(`expected `, test.responseCode,
-						`, got `, recorder.Code)
-				}
-
-				if result := recorder.Body.String(); result != test.responseBody {
-					t.Error(`expected `, test.responseBody,
-						`, got `, result)
-				}
-			})
+		}, {
+			`deletedToken`, `POST`, `/token`, ``,
+			`grant_type=password&username=5thDisplayID&password=5thPassword`,
+			http.StatusBadRequest,
+			`{"error":"invalid_grant","error_description":"invalid username and/or password","error_uri":"https://tools.ietf.org/html/rfc6749#section-5.2"}
+`,
+		}, {
+			`restoreActive`, `POST`, `/deleted_member/4thDisplayID`,
+			management, ``, http.StatusNotFound, ``,
+		}, {
+			`restore`, `POST`, `/deleted_member/5thDisplayID`,
+			management, ``, http.StatusOK, `{}
+`,
+		}, {
+			`restored`, `POST`, `/token`, ``,
+			`grant_type=password&username=5thDisplayID&password=5thPassword`,
+			http.StatusOK, ``,
+		}, {
+			`purgeActive`, `DELETE`, `/deleted_member/5thDisplayID`,
+			management, ``, http.StatusNotFound, ``,
+		}, {
+			`purge`, `DELETE`, `/member/5thDisplayID`, management, ``,
+			http.StatusOK, ``,
+		}, {
+			`purged`, `DELETE`, `/deleted_member/5thDisplayID`,
+			management, ``, http.StatusOK, ``,
+		}, {
+			`purgedGet`, `GET`, `/member/5thDisplayID`, member, ``,
+			http.StatusNotFound, ``,
+		}, {
+			`patchDuplicateNickname`, `PATCH`, `/member`, user,
+			`nickname=1+!%5C%25_1'%23`, http.StatusUnprocessableEntity, ``,
+		}, {
+			`patchIncorrectPassword`, `PATCH`, `/member`, user,
+			`current_password=invalid&new_password=2ndNewPassword`,
+			http.StatusUnprocessableEntity, ``,
+		}, {
+			`patchPassword`, `PATCH`, `/member`, user,
+			`current_password=2ndPassword&new_password=2ndNewPassword`,
+			http.StatusOK, ``,
+		},
+	})
+
+	t.Run(`put`, func(t *testing.T) {
+		apiv0.recorder.Reset()
+
+		recorder := apiv0.serve(`PUT`, `/member/8thDisplayID`, management,
+			`mail=8th@kagucho.net&nickname=8`)
+		testCode(t, http.StatusCreated, recorder)
+
+		recorded := apiv0.recorder.Recorded()
+		if len(recorded) != 1 {
+			t.Fatalf(`expected 1 mail, got %v`, len(recorded))
 		}
-	}()
 
-	t.Run(`internalServerError`, func(t *testing.T) {
-		t.Parallel()
+		if len(recorded[0].Recipients) != 1 || recorded[0].Recipients[0] != `-t` {
+			t.Errorf(`expected [-t], got %v`, recorded[0].Recipients)
+		}
+
+		if !bytes.Contains(recorded[0].Message, []byte(`8th@kagucho.net`)) {
+			t.Errorf(`expected 8th@kagucho.net in message, got %s`,
+				recorded[0].Message)
+		}
 
-		request := httptest.NewRequest(`GET`,
-			`http://kagucho.net/api/v0/member/detail?id=1stDisplayID`, nil)
+		if recorded[0].Subject != `TsuboneSystem 登録手続き` {
+			t.Errorf(`expected %q, got %q`,
+				`TsuboneSystem 登録手続き`, recorded[0].Subject)
+		}
 
-		recorder := httptest.NewRecorder()
-		DetailServeHTTP(recorder, request, context,
-			authorizer.Claim{Scope: scope.Scope{}.Set(scope.Basic)})
+		if !bytes.Contains(recorded[0].Message, []byte(`8thDisplayID`)) {
+			t.Errorf(`expected registration URL in message, got %s`,
+				recorded[0].Message)
+		}
 
-		if recorder.Code != http.StatusInternalServerError {
-			t.Errorf(`expected %v, got %v`,
-				http.StatusInternalServerError, recorder.Code)
+		temporary, err := apiv0.memory.QueryMemberTmp(context.Background(), `8thDisplayID`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !temporary {
+			t.Error(`expected temporary member`)
+		}
+	})
+
+	t.Run(`putDuplicate`, func(t *testing.T) {
+		apiv0.recorder.Reset()
+
+		recorder := apiv0.serve(`PUT`, `/member/1stDisplayID`, management,
+			`mail=1st@kagucho.net&nickname=1`)
+		testCode(t, http.StatusUnprocessableEntity, recorder)
+
+		if recorded := apiv0.recorder.Recorded(); len(recorded) != 0 {
+			t.Errorf(`expected no mails, got %v`, recorded)
+		}
+	})
+
+	t.Run(`patchMail`, func(t *testing.T) {
+		apiv0.recorder.Reset()
+
+		recorder := apiv0.serve(`PATCH`, `/member`, user,
+			`mail=2nd.new@kagucho.net`)
+		testCode(t, http.StatusOK, recorder)
+
+		recorded := apiv0.recorder.Recorded()
+		if len(recorded) != 1 {
+			t.Fatalf(`expected 1 mail, got %v`, len(recorded))
+		}
+
+		if len(recorded[0].Recipients) != 1 || recorded[0].Recipients[0] != `-t` {
+			t.Errorf(`expected [-t], got %v`, recorded[0].Recipients)
+		}
+
+		if !bytes.Contains(recorded[0].Message, []byte(`2nd.new@kagucho.net`)) {
+			t.Errorf(`expected 2nd.new@kagucho.net in message, got %s`,
+				recorded[0].Message)
+		}
+
+		if recorded[0].Subject != `TsuboneSystem メール確認` {
+			t.Errorf(`expected %q, got %q`,
+				`TsuboneSystem メール確認`, recorded[0].Subject)
+		}
+
+		detail, err := apiv0.memory.QueryMemberDetail(context.Background(), `2ndDisplayID`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := detail.End(); err != nil {
+			t.Error(err)
+		}
+
+		if detail.Mail != `2nd.new@kagucho.net` || detail.Confirmed {
+			t.Errorf(`expected unconfirmed 2nd.new@kagucho.net, got %v (confirmed: %v)`,
+				detail.Mail, detail.Confirmed)
 		}
 	})
 }
 
-func TestMemberListServeHTTP(t *testing.T) {
+func TestMembers(t *testing.T) {
 	t.Parallel()
 
-	db, err := db.Prepare()
-	if err != nil {
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	if err := apiv0.memory.ConfirmMember(`3rdDisplayID`); err != nil {
 		t.Fatal(err)
 	}
 
-	defer db.Close()
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
 
-	request := httptest.NewRequest(`GET`, `http://kagucho.net/member/list`, nil)
+	apiv0.testRequests(t, []testRequest{
+		{
+			`invalidEntrance`, `GET`, `/members?entrance=x`, member, ``,
+			http.StatusBadRequest, ``,
+		}, {
+			`invalidOB`, `GET`, `/members?ob=2`, member, ``,
+			http.StatusBadRequest, ``,
+		}, {
+			`invalidConfirmed`, `GET`, `/members?confirmed=2`, member, ``,
+			http.StatusBadRequest, ``,
+		}, {
+			`invalidSort`, `GET`, `/members?sort=mail`, member, ``,
+			http.StatusBadRequest, ``,
+		},
+	})
 
-	recorder := httptest.NewRecorder()
+	for _, test := range [...]struct {
+		description string
+		query       string
+		expected    []string
+	}{
+		{`entrance`, `?entrance=2155`, []string{`6thDisplayID`}},
+		{
+			`nickname`, `?nickname=%5C`,
+			[]string{`1stDisplayID`, `3rdDisplayID`, `5thDisplayID`, `6thDisplayID`, `7thDisplayID`},
+		},
+		{`realname`, `?realname=%2B`, []string{`5thDisplayID`, `6thDisplayID`}},
+		{`affiliation`, `?affiliation=理学部`, []string{`1stDisplayID`}},
+		{`club`, `?club=prog`, []string{`1stDisplayID`, `2ndDisplayID`}},
+		{`unknownClub`, `?club=invalid`, []string{}},
+		{`ob`, `?ob=1`, []string{`7thDisplayID`}},
+		{`confirmed`, `?confirmed=1`, []string{`3rdDisplayID`}},
+		{`combined`, `?club=prog&nickname=%5C`, []string{`1stDisplayID`}},
+		{
+			`sortDescending`, `?sort=-entrance&ob=0`,
+			[]string{`6thDisplayID`, `1stDisplayID`, `2ndDisplayID`, `3rdDisplayID`, `4thDisplayID`, `5thDisplayID`},
+		},
+		{
+			`sortID`, `?sort=-id&entrance=1901&ob=0`,
+			[]string{`5thDisplayID`, `4thDisplayID`, `3rdDisplayID`, `2ndDisplayID`, `1stDisplayID`},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := apiv0.serve(`GET`, `/members`+test.query, member, ``)
+			testCode(t, http.StatusOK, recorder)
 
-	ListServeHTTP(recorder, request, context.Context{DB: db}, authorizer.Claim{})
+			var entries []struct {
+				ID string `json:"id"`
+			}
 
-	if recorder.Code != http.StatusOK {
-		t.Error(`invalid code; expected `, http.StatusOK, `, got `, recorder.Code)
-	}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil {
+				t.Fatal(err)
+			}
+
+			ids := make([]string, len(entries))
+			for index, entry := range entries {
+				ids[index] = entry.ID
+			}
 
-	const expected = `[{"affiliation":"理学部第一部 数理情報科学科","entrance":1901,"id":"1stDisplayID","nickname":"1 !\\%_1\"#","ob":false,"realname":"$&\\%_2'("},{"entrance":1901,"id":"2ndDisplayID","nickname":"2 !%_1\"#","ob":false,"realname":"$&\\%_2'("},{"entrance":1901,"id":"3rdDisplayID","nickname":"3 !\\%*1\"#","ob":false,"realname":"$&\\%_2'("},{"entrance":1901,"id":"4thDisplayID","nickname":"4 !)_1\"#","ob":false,"realname":"$&\\%_2'("},{"entrance":1901,"id":"5thDisplayID","nickname":"5 !\\%_1\"#","ob":false,"realname":"$&%+2'("},{"entrance":2155,"id":"6thDisplayID","nickname":"6 !\\%_1\"#","ob":false,"realname":"$&\\%+2'("},{"entrance":1901,"id":"7thDisplayID","nickname":"7 !\\%_1\"#","ob":true,"realname":"$&,_2'("}]
-`
-	if result := recorder.Body.String(); result != expected {
-		t.Error(`expected `, expected, `, got `, result)
+			if !reflect.DeepEqual(ids, test.expected) {
+				t.Errorf(`expected %v, got %v`, test.expected, ids)
+			}
+		})
 	}
 }
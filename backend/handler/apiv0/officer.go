@@ -24,21 +24,54 @@ import (
 	"net/http"
 )
 
+var (
+	schemaOfficer = object(map[string]*schema{
+		`member`: schemaString,
+		`name`:   schemaString,
+		`scope`:  arrayOf(schemaString),
+	}, nil)
+
+	schemaOfficerEntry = object(map[string]*schema{
+		`id`:     schemaString,
+		`member`: schemaString,
+		`name`:   schemaString,
+	}, nil)
+
+	schemaOfficerName = object(map[string]*schema{
+		`id`:   schemaString,
+		`name`: schemaString,
+	}, nil)
+)
+
+var officerDeleteOperation = operation{
+	summary:  `Delete the officer.`,
+	security: []uint{scope.Management},
+	ifMatch:  true,
+	responses: map[int]response{
+		http.StatusOK:                  resultResponse,
+		http.StatusNotFound:            errorResponse(`The officer is not found.`),
+		http.StatusUnprocessableEntity: errorResponse(`The user would lose the management permission.`),
+	},
+}
+
 func officerDeleteServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path == `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
+	authorized := authorize(writer, request, shared, scope.Management)
+	if (authorized == claim{}) {
 		return
 	}
 
-	authorized := authorize(writer, request, shared, scope.Management)
-	if (authorized == claim{}) {
+	version, ok := ifMatch(writer, request)
+	if !ok {
 		return
 	}
 
-	switch err := shared.DB.DeleteOfficer(authorized.sub, request.URL.Path[1:]); err {
+	switch err := shared.DB.DeleteOfficer(authorized.sub, pathParam(request, `id`), version); err {
 	case db.ErrIncorrectIdentity:
 		util.ServeErrorDefault(writer, http.StatusNotFound)
 
+	case db.ErrVersionMismatch:
+		servePreconditionFailed(writer)
+
 	case db.ErrOfficerSuicide:
 		util.ServeError(writer,
 			util.Error{Description: `removing user's own management permission`},
@@ -52,20 +85,27 @@ func officerDeleteServeHTTP(writer http.ResponseWriter, request *http.Request, s
 	}
 }
 
-func officerGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path == `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
-		return
-	}
+var officerGetOperation = operation{
+	summary:  `Get the officer.`,
+	security: []uint{scope.Member},
+	etag:     true,
+	responses: map[int]response{
+		http.StatusOK:       jsonResponse(`The officer.`, schemaOfficer),
+		http.StatusNotFound: errorResponse(`The officer is not found.`),
+	},
+}
 
+func officerGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
 	if (authorize(writer, request, shared, scope.Member) == claim{}) {
 		return
 	}
 
-	officer, err := shared.DB.QueryOfficerDetail(request.URL.Path[1:])
+	officer, err := shared.DB.QueryOfficerDetail(request.Context(), pathParam(request, `id`))
 	switch err {
 	case nil:
-		util.ServeJSON(writer, officer, http.StatusOK)
+		if !serveETag(writer, request, officer.Version) {
+			util.ServeJSON(writer, officer, http.StatusOK)
+		}
 
 	case db.ErrIncorrectIdentity:
 		util.ServeErrorDefault(writer, http.StatusNotFound)
@@ -75,14 +115,32 @@ func officerGetServeHTTP(writer http.ResponseWriter, request *http.Request, shar
 	}
 }
 
+var officerPatchOperation = operation{
+	summary:  `Update the officer.`,
+	security: []uint{scope.Management},
+	body: []parameter{
+		{`member`, ``, property(db.PropertyID), false},
+		{`name`, ``, property(db.PropertyName), false},
+		{`scope`, ``, schemaList, false},
+	},
+	media:   mediaMergePatch,
+	ifMatch: true,
+	responses: map[int]response{
+		http.StatusOK:                  resultResponse,
+		http.StatusBadRequest:          invalidResponse(`The body is malformed or the name is duplicate.`),
+		http.StatusNotFound:            errorResponse(`The officer or the member is not found.`),
+		http.StatusUnprocessableEntity: invalidResponse(`The properties are invalid.`),
+	},
+}
+
 func officerPatchServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path == `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
+	authorized := authorize(writer, request, shared, scope.Management)
+	if (authorized == claim{}) {
 		return
 	}
 
-	authorized := authorize(writer, request, shared, scope.Management)
-	if (authorized == claim{}) {
+	version, ok := ifMatch(writer, request)
+	if !ok {
 		return
 	}
 
@@ -100,9 +158,18 @@ func officerPatchServeHTTP(writer http.ResponseWriter, request *http.Request, sh
 		scope = formScope[0]
 	}
 
+	name := request.PostForm.Get(`name`)
+	member := request.PostForm.Get(`member`)
+
+	var validation validation
+	validation.property(`name`, name, db.PropertyName)
+	validation.property(`member`, member, db.PropertyID)
+	if validation.serve(writer) {
+		return
+	}
+
 	switch err := shared.DB.UpdateOfficer(authorized.sub,
-		request.PostForm.Get(`id`), request.PostForm.Get(`name`),
-		request.PostForm.Get(`member`), scope); err {
+		pathParam(request, `id`), name, member, scope, version); err {
 	case db.ErrDupEntry:
 		util.ServeError(writer,
 			util.Error{Description: `duplicate name`},
@@ -118,6 +185,9 @@ func officerPatchServeHTTP(writer http.ResponseWriter, request *http.Request, sh
 	case db.ErrInvalid:
 		util.ServeErrorDefault(writer, http.StatusUnprocessableEntity)
 
+	case db.ErrVersionMismatch:
+		servePreconditionFailed(writer)
+
 	case db.ErrOfficerSuicide:
 		util.ServeJSON(writer,
 			util.Error{Description: `removing user's own management permission`},
@@ -131,18 +201,41 @@ func officerPatchServeHTTP(writer http.ResponseWriter, request *http.Request, sh
 	}
 }
 
+var officerPutOperation = operation{
+	summary:  `Create the officer.`,
+	security: []uint{scope.Management},
+	body: []parameter{
+		{`member`, ``, property(db.PropertyID), false},
+		{`name`, ``, property(db.PropertyName), true},
+		{`scope`, ``, schemaList, false},
+	},
+	media: mediaJSON,
+	responses: map[int]response{
+		http.StatusCreated:             resultResponse,
+		http.StatusUnprocessableEntity: invalidResponse(`The properties are invalid or duplicate.`),
+	},
+}
+
 func officerPutServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if len(request.URL.Path) < 2 {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
+	authorized := authorize(writer, request, shared, scope.Management)
+	if (authorized == claim{}) {
 		return
 	}
 
-	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+	id := pathParam(request, `id`)
+	name := request.PostFormValue(`name`)
+	member := request.PostFormValue(`member`)
+
+	var validation validation
+	validation.property(`id`, id, db.PropertyID)
+	validation.require(`name`, name)
+	validation.property(`name`, name, db.PropertyName)
+	validation.property(`member`, member, db.PropertyID)
+	if validation.serve(writer) {
 		return
 	}
 
-	switch err := shared.DB.InsertOfficer(request.URL.Path[1:],
-		request.PostFormValue(`name`), request.PostFormValue(`member`),
+	switch err := shared.DB.InsertOfficer(authorized.sub, id, name, member,
 		request.PostFormValue(`scope`)); err {
 	case db.ErrBadOmission:
 		util.ServeError(writer,
@@ -171,28 +264,36 @@ func officerPutServeHTTP(writer http.ResponseWriter, request *http.Request, shar
 	}
 }
 
-func officersGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path != `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
-		return
-	}
+var officersGetOperation = pageOperation(`List the officers.`,
+	[]uint{scope.Member}, schemaOfficerEntry)
 
+func officersGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
 	if (authorize(writer, request, shared, scope.Member) == claim{}) {
 		return
 	}
 
-	util.ServeJSON(writer, shared.DB.QueryOfficers(), http.StatusOK)
+	serveSoughtPage(writer, request, func(after string, limit int) (pageNext, bool) {
+		officerChan := shared.DB.QueryOfficers(request.Context(), after, limit)
+
+		return func() (interface{}, string, error, bool) {
+			result, present := <-officerChan
+			return result.OfficerEntry, result.ID, result.Error, present
+		}, true
+	})
 }
 
-func officersNamesGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path != `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
-		return
-	}
+var officersNamesGetOperation = operation{
+	summary:  `List the names of the officers.`,
+	security: []uint{scope.Member},
+	responses: map[int]response{
+		http.StatusOK: jsonResponse(`The names.`, arrayOf(schemaOfficerName)),
+	},
+}
 
+func officersNamesGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
 	if (authorize(writer, request, shared, scope.Member) == claim{}) {
 		return
 	}
 
-	util.ServeJSON(writer, shared.DB.QueryOfficerNames(), http.StatusOK)
+	util.ServeJSON(writer, shared.DB.QueryOfficerNames(request.Context()), http.StatusOK)
 }
@@ -1,3 +1,6 @@
+//go:build dbtest
+// +build dbtest
+
 /*
 	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
 
@@ -18,100 +21,64 @@
 package apiv0
 
 import (
-	"github.com/kagucho/tsubonesystem3/backend/db"
-	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/context"
-	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/token/authorizer"
 	"net/http"
-	"net/http/httptest"
 	"testing"
 )
 
-func TestOfficerDetailServeHTTP(t *testing.T) {
-	db, err := db.Prepare()
-	if err != nil {
-		t.Fatal(err)
-	}
+func TestOfficer(t *testing.T) {
+	t.Parallel()
 
-	context := context.Context{DB: db}
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
 
-	func() {
-		defer db.Close()
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
+	management := apiv0.authorization(t, `1stDisplayID`, `management member`)
 
-		for _, test := range [...]struct {
-			description  string
-			request      string
-			responseCode int
-			responseBody string
-		}{
-			{
-				`invalid`, ``, http.StatusBadRequest,
-				`{"error":"invalid_id","error_description":"invalid ID","error_uri":"https://tools.ietf.org/html/rfc7231#section-6.5.1"}
+	apiv0.testRequests(t, []testRequest{
+		{
+			`getNotFound`, `GET`, `/officer/invalid`, member, ``,
+			http.StatusNotFound, ``,
+		}, {
+			`get`, `GET`, `/officer/president`, member, ``,
+			http.StatusOK, `{"member":"1stDisplayID","name":"局長","scope":["management","privacy"]}
 `,
-			}, {
-				`valid`, `id=president`, http.StatusOK,
-				`{"member":{"id":"1stDisplayID","mail":"1st@kagucho.net","nickname":"1 !\\%_1\"#","realname":"$&\\%_2'(","tel":"000-000-001"},"name":"局長","scope":["management","privacy"]}
+		}, {
+			`officers`, `GET`, `/officers`, member, ``, http.StatusOK,
+			`[{"id":"president","name":"局長","member":"1stDisplayID"},{"id":"vice","name":"副局長","member":"1stDisplayID"}]
 `,
-			},
-		} {
-			request := httptest.NewRequest(`GET`,
-				`http://kagucho.net/api/v0/officer/detail?`+test.request, nil)
-
-			recorder := httptest.NewRecorder()
-			DetailServeHTTP(recorder, request, context, authorizer.Claim{})
-
-			if recorder.Code != test.responseCode {
-				t.Errorf(`expected %v, got %v`,
-					test.responseCode, recorder.Code)
-			}
-
-			if result := recorder.Body.String(); result != test.responseBody {
-				t.Error(`expected `, test.responseBody,
-					`, got `, result)
-			}
-		}
-	}()
-
-	t.Run(`internalServerError`, func(t *testing.T) {
-		t.Parallel()
-
-		request := httptest.NewRequest(`GET`,
-			`http://kagucho.net/api/v0/officer/detail?id=president`, nil)
-
-		recorder := httptest.NewRecorder()
-		DetailServeHTTP(recorder, request, context, authorizer.Claim{})
-
-		if recorder.Code != http.StatusInternalServerError {
-			t.Errorf(`expected %v, got %v`,
-				http.StatusInternalServerError, recorder.Code)
-		}
+		}, {
+			`officersNames`, `GET`, `/officers/names`, member, ``,
+			http.StatusOK, `[{"id":"president","name":"局長"},{"id":"vice","name":"副局長"}]
+`,
+		}, {
+			`deleteSuicide`, `DELETE`, `/officer/president`, management,
+			``, http.StatusUnprocessableEntity, ``,
+		}, {
+			`putIncorrectMember`, `PUT`, `/officer/secretary`,
+			management, `name=書記&member=invalid&scope=`,
+			http.StatusUnprocessableEntity, ``,
+		}, {
+			`put`, `PUT`, `/officer/secretary`, management,
+			`name=書記&member=2ndDisplayID&scope=management`,
+			http.StatusCreated, `{}
+`,
+		}, {
+			`putDuplicate`, `PUT`, `/officer/secretary`, management,
+			`name=書記&member=2ndDisplayID&scope=management`,
+			http.StatusUnprocessableEntity, ``,
+		}, {
+			`patch`, `PATCH`, `/officer/secretary`, management,
+			`id=secretary&name=書記長`, http.StatusOK, ``,
+		}, {
+			`patched`, `GET`, `/officer/secretary`, member, ``,
+			http.StatusOK, `{"member":"2ndDisplayID","name":"書記長","scope":["management"]}
+`,
+		}, {
+			`delete`, `DELETE`, `/officer/secretary`, management, ``,
+			http.StatusOK, ``,
+		}, {
+			`deleted`, `GET`, `/officer/secretary`, member, ``,
+			http.StatusNotFound, ``,
+		},
 	})
 }
-
-func TestOfficerListServeHTTP(t *testing.T) {
-	t.Parallel()
-
-	db, err := db.Prepare()
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	defer db.Close()
-
-	request := httptest.NewRequest(`GET`,
-		`http://kagucho.net/member/officers`, nil)
-
-	recorder := httptest.NewRecorder()
-
-	ListServeHTTP(recorder, request, context.Context{DB: db}, authorizer.Claim{})
-
-	if recorder.Code != http.StatusOK {
-		t.Errorf(`invalid code; expected %v, got %v`,
-			http.StatusOK, recorder.Code)
-	}
-
-	const expected = `[{"id":"president","member":{"id":"1stDisplayID","mail":"1st@kagucho.net","nickname":"1 !\\%_1\"#","realname":"$&\\%_2'(","tel":"000-000-001"},"name":"局長"},{"id":"vice","member":{"id":"1stDisplayID","mail":"1st@kagucho.net","nickname":"1 !\\%_1\"#","realname":"$&\\%_2'(","tel":"000-000-001"},"name":"副局長"}]
-`
-	if result := recorder.Body.String(); result != expected {
-		t.Error(`expected `, expected, `, got `, result)
-	}
-}
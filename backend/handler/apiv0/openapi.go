@@ -0,0 +1,578 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/token"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
+	"github.com/kagucho/tsubonesystem3/backend/scope"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+schema is a structure describing a JSON value. It is marshalled as Schema
+Object of OpenAPI.
+
+OpenAPI Specification
+Version 3.0.0
+Schema Object
+https://github.com/OAI/OpenAPI-Specification/blob/master/versions/3.0.0.md#schemaObject
+*/
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	MaxLength            int                `json:"maxLength,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+}
+
+/*
+parameter is a structure describing a parameter of an operation. in is query or
+header. It is ignored for the members of a request body.
+*/
+type parameter struct {
+	name     string
+	in       string
+	schema   *schema
+	required bool
+}
+
+/*
+response is a structure describing a response of an operation. content
+associates media types with the schemas of the bodies. A schema may be nil if
+the body is not JSON.
+*/
+type response struct {
+	description string
+	headers     []string
+	content     map[string]*schema
+}
+
+/*
+operation is a structure describing how a handler serves a method of a route.
+
+security is the scopes the handler requires. query and body are the parameters
+in the query and the request body. media is the JSON media type the handler
+accepts in addition to application/x-www-form-urlencoded, if it takes a body.
+etag tells the handler serves ETag and honours If-None-Match, and ifMatch tells
+it honours If-Match. mails tells the handler may send emails, which cannot be
+taken back when the transaction of an atomic batch is rolled back.
+
+The responses common to the handlers, such as ones telling an authorization
+failure, are added by describeOperation.
+*/
+type operation struct {
+	summary   string
+	security  []uint
+	query     []parameter
+	body      []parameter
+	media     string
+	etag      bool
+	ifMatch   bool
+	mails     bool
+	responses map[int]response
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIParameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *schema `json:"schema"`
+}
+
+type openAPIMediaType struct {
+	Schema *schema `json:"schema,omitempty"`
+}
+
+/*
+openAPIMergePatchDescription describes how withBody deviates from JSON Merge
+Patch.
+*/
+const openAPIMergePatchDescription = `null is rejected with 400 Bad Request. An empty string or array clears a property which can be cleared, and leaves the others unchanged.`
+
+type openAPIRequestBody struct {
+	Description string                      `json:"description,omitempty"`
+	Required    bool                        `json:"required,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIHeader struct {
+	Schema *schema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Headers     map[string]openAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+}
+
+type openAPIOAuthFlow struct {
+	TokenURL   string            `json:"tokenUrl"`
+	RefreshURL string            `json:"refreshUrl"`
+	Scopes     map[string]string `json:"scopes"`
+}
+
+type openAPISecurityScheme struct {
+	Type  string                      `json:"type"`
+	Flows map[string]openAPIOAuthFlow `json:"flows"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*schema               `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+/*
+openAPI is a structure holding a document describing API v0. It is marshalled
+as OpenAPI Object.
+
+OpenAPI Specification
+Version 3.0.0
+OpenAPI Object
+https://github.com/OAI/OpenAPI-Specification/blob/master/versions/3.0.0.md#openapi-object
+*/
+type openAPI struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       openAPIInfo                            `json:"info"`
+	Servers    []openAPIServer                        `json:"servers"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components openAPIComponents                      `json:"components"`
+}
+
+const openAPISecurity = `oauth2`
+
+// openAPIScopes associates the scopes with their descriptions.
+var openAPIScopes = map[uint]string{
+	scope.Management: `Manage the records.`,
+	scope.Member:     `Read the records as a member.`,
+	scope.Privacy:    `Read the private properties of members.`,
+	scope.User:       `Read and update the record of the user.`,
+}
+
+var (
+	schemaBoolean = &schema{Type: `boolean`}
+	schemaInteger = &schema{Type: `integer`}
+	schemaString  = &schema{Type: `string`}
+
+	// schemaTime is NumericDate defined in RFC 7519.
+	schemaTime = &schema{Type: `integer`, Format: `int64`}
+
+	// schemaFlag is a boolean, which is represented as 0 or 1 in a form.
+	schemaFlag = schemaBoolean
+
+	// schemaList is a space-separated list in a form.
+	schemaList = schemaString
+
+	schemaAttendance = &schema{
+		Type: `string`,
+		Enum: []string{`uninvited`, `invited`, `declined`, `accepted`},
+	}
+)
+
+// openAPISchemas is the schemas referred with schemaRef.
+var openAPISchemas = map[string]*schema{
+	`Error`: optional(map[string]*schema{
+		`error`:             schemaString,
+		`error_description`: schemaString,
+		`error_uri`:         schemaString,
+	}),
+	`Problem`: object(map[string]*schema{
+		`type`:   schemaString,
+		`title`:  schemaString,
+		`status`: schemaInteger,
+	}, map[string]*schema{
+		`detail`: schemaString,
+		`invalid-params`: arrayOf(object(map[string]*schema{
+			`name`:   schemaString,
+			`reason`: schemaString,
+		}, nil)),
+	}),
+	`Result`: optional(map[string]*schema{
+		`error`:             schemaString,
+		`error_description`: schemaString,
+		`error_uri`:         schemaString,
+	}),
+	`Token`: object(map[string]*schema{
+		`access_token`: schemaString,
+		`scope`:        schemaString,
+	}, map[string]*schema{
+		`refresh_token`: schemaString,
+	}),
+	`TokenError`: object(map[string]*schema{
+		`scope`: schemaString,
+	}, map[string]*schema{
+		`error`:             schemaString,
+		`error_description`: schemaString,
+		`error_uri`:         schemaString,
+	}),
+}
+
+// property returns the schema of a string of the given property.
+func property(property db.Property) *schema {
+	return &schema{Type: `string`, MaxLength: property.MaxLength()}
+}
+
+// nullable returns the given schema which also allows null.
+func nullable(base *schema) *schema {
+	copied := *base
+	copied.Nullable = true
+
+	return &copied
+}
+
+// arrayOf returns the schema of an array of the given items.
+func arrayOf(items *schema) *schema {
+	return &schema{Type: `array`, Items: items}
+}
+
+// mapOf returns the schema of an object whose members have the given values.
+func mapOf(values *schema) *schema {
+	return &schema{Type: `object`, AdditionalProperties: values}
+}
+
+/*
+object returns the schema of an object which has the given required members
+and may have the given optional members.
+*/
+func object(required, optional map[string]*schema) *schema {
+	properties := make(map[string]*schema, len(required)+len(optional))
+	names := make([]string, 0, len(required))
+
+	for name, value := range required {
+		properties[name] = value
+		names = append(names, name)
+	}
+
+	for name, value := range optional {
+		properties[name] = value
+	}
+
+	sort.Strings(names)
+
+	return &schema{Type: `object`, Properties: properties, Required: names}
+}
+
+// optional returns the schema of an object whose members are all optional.
+func optional(properties map[string]*schema) *schema {
+	return object(nil, properties)
+}
+
+// schemaRef returns the schema referring the schema with the given name.
+func schemaRef(name string) *schema {
+	return &schema{Ref: `#/components/schemas/` + name}
+}
+
+// jsonResponse returns response with a JSON body of the given schema.
+func jsonResponse(description string, body *schema) response {
+	return response{description, nil, map[string]*schema{mediaJSON: body}}
+}
+
+// errorResponse returns response with util.Error.
+func errorResponse(description string) response {
+	return jsonResponse(description, schemaRef(`Error`))
+}
+
+/*
+invalidResponse returns response with util.Error or util.Problem, which
+validation serves.
+*/
+func invalidResponse(description string) response {
+	return response{description, nil, map[string]*schema{
+		mediaJSON:                  schemaRef(`Error`),
+		`application/problem+json`: schemaRef(`Problem`),
+	}}
+}
+
+/*
+resultResponse is a response of a successful operation. The body is an empty
+object unless it tells mailing failed.
+*/
+var resultResponse = jsonResponse(`The operation succeeded.`, schemaRef(`Result`))
+
+/*
+pageOperation returns operation of a handler serving a list of the given items
+with servePage.
+*/
+func pageOperation(summary string, security []uint, items *schema, query ...parameter) operation {
+	return operation{
+		summary:  summary,
+		security: security,
+		query: append(query,
+			parameter{`limit`, `query`, schemaInteger, false},
+			parameter{`after`, `query`, schemaString, false},
+			parameter{`Range`, `header`, schemaString, false}),
+		responses: map[int]response{
+			http.StatusOK: {`The page.`, []string{`Link`},
+				map[string]*schema{mediaJSON: arrayOf(items)}},
+			http.StatusPartialContent: {`The requested range.`,
+				[]string{`Content-Range`},
+				map[string]*schema{mediaJSON: arrayOf(items)}},
+			http.StatusBadRequest: invalidResponse(`The page is invalid.`),
+			http.StatusRequestedRangeNotSatisfiable: {
+				`The range is not satisfiable.`,
+				[]string{`Content-Range`},
+				map[string]*schema{mediaJSON: schemaRef(`Error`)},
+			},
+		},
+	}
+}
+
+/*
+with returns the operation which also has the given response for the given
+status code.
+*/
+func (declared operation) with(code int, added response) operation {
+	responses := make(map[int]response, len(declared.responses)+1)
+	for key, value := range declared.responses {
+		responses[key] = value
+	}
+
+	responses[code] = added
+	declared.responses = responses
+
+	return declared
+}
+
+func (param parameter) openAPI() openAPIParameter {
+	return openAPIParameter{param.name, param.in, param.required, param.schema}
+}
+
+func (response response) openAPI() openAPIResponse {
+	result := openAPIResponse{Description: response.description}
+
+	if response.headers != nil {
+		result.Headers = make(map[string]openAPIHeader, len(response.headers))
+		for _, header := range response.headers {
+			result.Headers[header] = openAPIHeader{schemaString}
+		}
+	}
+
+	if response.content != nil {
+		result.Content = make(map[string]openAPIMediaType, len(response.content))
+		for media, body := range response.content {
+			result.Content[media] = openAPIMediaType{body}
+		}
+	}
+
+	return result
+}
+
+/*
+describeOperation returns OpenAPI Operation Object of the given operation of a
+route with the given path parameters. The body of the responses are omitted if
+head is true.
+*/
+func describeOperation(declared operation, params []RouteParam, head bool) openAPIOperation {
+	responses := make(map[int]response, len(declared.responses)+8)
+	for code, declaredResponse := range declared.responses {
+		responses[code] = declaredResponse
+	}
+
+	parameters := make([]openAPIParameter, 0, len(params)+len(declared.query)+1)
+	for _, param := range params {
+		parameters = append(parameters, openAPIParameter{
+			param.Name, `path`, true, paramTypes[param.Type].schema,
+		})
+	}
+
+	for _, param := range declared.query {
+		parameters = append(parameters, param.openAPI())
+	}
+
+	result := openAPIOperation{Summary: declared.summary}
+
+	if declared.security != nil {
+		scopes := make([]string, len(declared.security))
+		for index, required := range declared.security {
+			scopes[index] = token.EncodeScopeIndex(required)
+		}
+
+		result.Security = []map[string][]string{{openAPISecurity: scopes}}
+
+		for code, description := range map[int]string{
+			http.StatusUnauthorized: `The request is not authenticated.`,
+			http.StatusForbidden:    `The token lacks the scope.`,
+		} {
+			responses[code] = response{description,
+				[]string{`WWW-Authenticate`},
+				map[string]*schema{mediaJSON: schemaRef(`TokenError`)}}
+		}
+	}
+
+	if declared.body != nil {
+		properties := make(map[string]*schema, len(declared.body))
+		var required []string
+
+		for _, member := range declared.body {
+			properties[member.name] = member.schema
+			if member.required {
+				required = append(required, member.name)
+			}
+		}
+
+		body := &schema{Type: `object`, Properties: properties, Required: required}
+		result.RequestBody = &openAPIRequestBody{
+			Required: required != nil,
+			Content:  map[string]openAPIMediaType{mediaForm: {body}},
+		}
+
+		if declared.media != `` {
+			result.RequestBody.Content[declared.media] = openAPIMediaType{body}
+			responses[http.StatusUnsupportedMediaType] =
+				errorResponse(`The media type of the body is unsupported.`)
+		}
+
+		if declared.media == mediaMergePatch {
+			result.RequestBody.Description = openAPIMergePatchDescription
+		}
+
+		if _, present := responses[http.StatusBadRequest]; !present {
+			responses[http.StatusBadRequest] =
+				invalidResponse(`The body is malformed.`)
+		}
+	}
+
+	if declared.etag {
+		parameters = append(parameters,
+			parameter{`If-None-Match`, `header`, schemaString, false}.openAPI())
+
+		ok := responses[http.StatusOK]
+		ok.headers = append([]string{`ETag`}, ok.headers...)
+		responses[http.StatusOK] = ok
+		responses[http.StatusNotModified] = response{
+			`The representation is not modified.`,
+			[]string{`ETag`}, nil,
+		}
+	}
+
+	if declared.ifMatch {
+		parameters = append(parameters,
+			parameter{`If-Match`, `header`, schemaString, false}.openAPI())
+
+		responses[http.StatusPreconditionFailed] =
+			errorResponse(`The entity tag does not match.`)
+	}
+
+	responses[http.StatusInternalServerError] =
+		errorResponse(`The server encountered an error.`)
+
+	result.Parameters = parameters
+	result.Responses = make(map[string]openAPIResponse, len(responses))
+	for code, declaredResponse := range responses {
+		if head {
+			declaredResponse.content = nil
+		}
+
+		result.Responses[strconv.Itoa(code)] = declaredResponse.openAPI()
+	}
+
+	return result
+}
+
+/*
+newOpenAPI returns openAPI describing the given routes. HEAD is described with
+the operation of GET.
+
+OpenAPI Specification
+Version 3.0.0
+https://github.com/OAI/OpenAPI-Specification/blob/master/versions/3.0.0.md
+*/
+func newOpenAPI(routes router) openAPI {
+	paths := make(map[string]map[string]openAPIOperation, len(routes))
+
+	for _, route := range routes {
+		description := route.describe()
+		operations := make(map[string]openAPIOperation, len(description.Methods))
+
+		for _, method := range description.Methods {
+			declared, present := route.handler.operation(method)
+			head := false
+			if !present && method == `HEAD` {
+				declared, present = route.handler.operation(`GET`)
+				head = true
+			}
+
+			if present {
+				operations[strings.ToLower(method)] =
+					describeOperation(declared, description.Params, head)
+			}
+		}
+
+		paths[description.Template] = operations
+	}
+
+	scopes := make(map[string]string, len(openAPIScopes))
+	for index, description := range openAPIScopes {
+		scopes[token.EncodeScopeIndex(index)] = description
+	}
+
+	return openAPI{
+		OpenAPI: `3.0.0`,
+		Info:    openAPIInfo{`TsuboneSystem API`, `0`},
+		Servers: []openAPIServer{{`/api/v0`}},
+		Paths:   paths,
+		Components: openAPIComponents{
+			openAPISchemas,
+			map[string]openAPISecurityScheme{
+				openAPISecurity: {`oauth2`, map[string]openAPIOAuthFlow{
+					`password`: {`token`, `token`, scopes},
+				}},
+			},
+		},
+	}
+}
+
+// openAPIGetServeHTTP returns handlerFunc serving openAPI of the given routes.
+func openAPIGetServeHTTP(routes *router) handlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request, shared shared) {
+		util.ServeJSON(writer, newOpenAPI(*routes), http.StatusOK)
+	}
+}
+
+var openAPIGetOperation = operation{
+	summary: `Describe API v0 in OpenAPI.`,
+	responses: map[int]response{
+		http.StatusOK: jsonResponse(`The document.`, &schema{Type: `object`}),
+	},
+}
@@ -0,0 +1,329 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+testDocument is the document served at /openapi.json, decoded as generic JSON
+values.
+*/
+type testDocument map[string]interface{}
+
+// openAPI returns the document served by apiv0.APIv0.
+func (apiv0 testAPIv0) openAPI(t *testing.T) testDocument {
+	recorder := apiv0.serve(`GET`, `/openapi.json`, ``, ``)
+	testCode(t, http.StatusOK, recorder)
+
+	var document testDocument
+	if err := json.Unmarshal(recorder.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+
+	return document
+}
+
+/*
+resolve returns the schema referred by the given schema if it has $ref, and
+the given schema otherwise.
+*/
+func (document testDocument) resolve(schema map[string]interface{}) map[string]interface{} {
+	ref, present := schema[`$ref`].(string)
+	if !present {
+		return schema
+	}
+
+	resolved := interface{}(map[string]interface{}(document))
+	for _, key := range strings.Split(strings.TrimPrefix(ref, `#/`), `/`) {
+		resolved = resolved.(map[string]interface{})[key]
+	}
+
+	return resolved.(map[string]interface{})
+}
+
+/*
+validate returns an error if the given value does not conform to the given
+schema. It supports the subset of Schema Object the document uses, and rejects
+undocumented members of objects which have properties so that the document
+stays complete.
+*/
+func (document testDocument) validate(schema map[string]interface{}, value interface{}, location string) error {
+	schema = document.resolve(schema)
+
+	if value == nil {
+		if nullable, _ := schema[`nullable`].(bool); !nullable {
+			return fmt.Errorf(`%s: unexpected null`, location)
+		}
+
+		return nil
+	}
+
+	if enum, present := schema[`enum`].([]interface{}); present {
+		found := false
+		for _, candidate := range enum {
+			if fmt.Sprint(candidate) == fmt.Sprint(value) {
+				found = true
+			}
+		}
+
+		if !found {
+			return fmt.Errorf(`%s: %v is not in %v`, location, value, enum)
+		}
+	}
+
+	switch schema[`type`] {
+	case `array`:
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf(`%s: expected array, got %v`, location, value)
+		}
+
+		items := schema[`items`].(map[string]interface{})
+		for index, item := range array {
+			if err := document.validate(items, item, location+`[`+strconv.Itoa(index)+`]`); err != nil {
+				return err
+			}
+		}
+
+	case `boolean`:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf(`%s: expected boolean, got %v`, location, value)
+		}
+
+	case `integer`:
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return fmt.Errorf(`%s: expected integer, got %v`, location, value)
+		}
+
+	case `object`:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf(`%s: expected object, got %v`, location, value)
+		}
+
+		if required, present := schema[`required`].([]interface{}); present {
+			for _, name := range required {
+				if _, present := object[name.(string)]; !present {
+					return fmt.Errorf(`%s: missing %v`, location, name)
+				}
+			}
+		}
+
+		properties, _ := schema[`properties`].(map[string]interface{})
+		additional, _ := schema[`additionalProperties`].(map[string]interface{})
+		for name, member := range object {
+			memberSchema, present := properties[name].(map[string]interface{})
+			if !present {
+				memberSchema = additional
+			}
+
+			if memberSchema == nil {
+				if properties != nil {
+					return fmt.Errorf(`%s: unexpected %s`, location, name)
+				}
+
+				continue
+			}
+
+			if err := document.validate(memberSchema, member, location+`.`+name); err != nil {
+				return err
+			}
+		}
+
+	case `string`:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf(`%s: expected string, got %v`, location, value)
+		}
+	}
+
+	return nil
+}
+
+/*
+testConformance tests the given response to the given request conforms to the
+document. Requests not routed to any operation are ignored.
+*/
+func (apiv0 testAPIv0) testConformance(t *testing.T, document testDocument, method, path string, recorder *httptest.ResponseRecorder) {
+	requestURL, err := url.Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route, _, found := apiv0.routes.search(requestURL)
+	if !found || method == `OPTIONS` ||
+		recorder.Code == http.StatusMethodNotAllowed {
+		return
+	}
+
+	paths := document[`paths`].(map[string]interface{})
+	operation, present := paths[route.template].(map[string]interface{})[strings.ToLower(method)].(map[string]interface{})
+	if !present {
+		t.Errorf(`%s %s is not documented`, method, route.template)
+		return
+	}
+
+	responses := operation[`responses`].(map[string]interface{})
+	response, present := responses[strconv.Itoa(recorder.Code)].(map[string]interface{})
+	if !present {
+		t.Errorf(`%s %s: status %d is not documented`,
+			method, route.template, recorder.Code)
+		return
+	}
+
+	content, present := response[`content`].(map[string]interface{})
+	if !present {
+		if recorder.Body.Len() > 0 {
+			t.Errorf(`%s %s: unexpected body for %d`,
+				method, route.template, recorder.Code)
+		}
+
+		return
+	}
+
+	media, _, err := mime.ParseMediaType(recorder.HeaderMap.Get(`Content-Type`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	mediaType, present := content[media].(map[string]interface{})
+	if !present {
+		t.Errorf(`%s %s: media type %s for %d is not documented`,
+			method, route.template, media, recorder.Code)
+		return
+	}
+
+	schema, present := mediaType[`schema`].(map[string]interface{})
+	if !present {
+		return
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := document.validate(schema, body, method+` `+route.template); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	document := apiv0.openAPI(t)
+
+	if document[`openapi`] != `3.0.0` {
+		t.Errorf(`expected "3.0.0", got %v`, document[`openapi`])
+	}
+
+	paths := document[`paths`].(map[string]interface{})
+
+	for _, route := range apiv0.Routes() {
+		operations, present := paths[route.Template].(map[string]interface{})
+		if !present {
+			t.Errorf(`%s is not documented`, route.Template)
+			continue
+		}
+
+		for _, method := range route.Methods {
+			if method == `OPTIONS` {
+				continue
+			}
+
+			operation, present := operations[strings.ToLower(method)].(map[string]interface{})
+			if !present {
+				t.Errorf(`%s %s is not documented`, method, route.Template)
+				continue
+			}
+
+			var params []string
+			parameters, _ := operation[`parameters`].([]interface{})
+			for _, parameter := range parameters {
+				parameter := parameter.(map[string]interface{})
+				if parameter[`in`] == `path` {
+					params = append(params, parameter[`name`].(string))
+				}
+			}
+
+			if len(params) != len(route.Params) {
+				t.Errorf(`%s %s: expected %d path parameters, got %v`,
+					method, route.Template, len(route.Params), params)
+			}
+		}
+	}
+
+	t.Run(`mergePatch`, func(t *testing.T) {
+		t.Parallel()
+
+		operation := paths[`/member`].(map[string]interface{})[`patch`].(map[string]interface{})
+		body := operation[`requestBody`].(map[string]interface{})
+		if body[`description`] != openAPIMergePatchDescription {
+			t.Errorf(`expected %q, got %v`,
+				openAPIMergePatchDescription, body[`description`])
+		}
+	})
+
+	t.Run(`validate`, func(t *testing.T) {
+		t.Parallel()
+
+		schema := map[string]interface{}{
+			`$ref`: `#/components/schemas/Problem`,
+		}
+
+		for _, test := range [...]struct {
+			description string
+			value       string
+			valid       bool
+		}{
+			{`valid`, `{"type":"about:blank","title":"","status":400,"invalid-params":[{"name":"a","reason":"b"}]}`, true},
+			{`missing`, `{"type":"about:blank","title":""}`, false},
+			{`type`, `{"type":"about:blank","title":"","status":"400"}`, false},
+			{`unexpected`, `{"type":"about:blank","title":"","status":400,"x":0}`, false},
+			{`items`, `{"type":"about:blank","title":"","status":400,"invalid-params":[{}]}`, false},
+		} {
+			var value interface{}
+			if err := json.Unmarshal([]byte(test.value), &value); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := document.validate(schema, value, ``); (err == nil) != test.valid {
+				t.Errorf(`%s: expected valid %v, got %v`,
+					test.description, test.valid, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,280 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"context"
+	"fmt"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// envelopeKey is the key of the context value telling withEnvelope is used.
+type envelopeKey struct{}
+
+/*
+pageEnvelope is a structure wrapping the items of a page. Next is the reference
+to the next page if any.
+*/
+type pageEnvelope struct {
+	Items []interface{} `json:"items"`
+	Next  string        `json:"next,omitempty"`
+}
+
+/*
+pageNext is a function returning the next item of a list, the cursor
+identifying it, an error, and a boolean telling whether a new item is present.
+It resembles the function given to encoding.MarshalJSONArray.
+*/
+type pageNext func() (interface{}, string, error, bool)
+
+/*
+pageQuery is a function returning pageNext of the items following the item
+identified with the given cursor, which is empty for the first item, up to the
+given number, which is negative for no limit. The returned Boolean is false if
+the cursor is unknown.
+*/
+type pageQuery func(after string, limit int) (pageNext, bool)
+
+/*
+pageRange is a structure describing the items requested with Range header
+field. last is negative if it is omitted.
+*/
+type pageRange struct {
+	first int
+	last  int
+}
+
+/*
+parsePageRange parses the given value of Range header field. It returns false
+if the value does not specify a range of items, in which case the field should
+be ignored.
+
+RFC 7233 - Hypertext Transfer Protocol (HTTP/1.1): Range Requests
+3.1.  Range
+https://tools.ietf.org/html/rfc7233#section-3.1
+> An origin server MUST ignore a Range header field that contains a range
+> unit it does not understand.
+*/
+func parsePageRange(field string) (pageRange, bool) {
+	const prefix = `items=`
+	if !strings.HasPrefix(field, prefix) {
+		return pageRange{}, false
+	}
+
+	bounds := strings.SplitN(field[len(prefix):], `-`, 2)
+	if len(bounds) != 2 {
+		return pageRange{}, false
+	}
+
+	first, err := strconv.Atoi(bounds[0])
+	if err != nil || first < 0 {
+		return pageRange{}, false
+	}
+
+	if bounds[1] == `` {
+		return pageRange{first, -1}, true
+	}
+
+	last, err := strconv.Atoi(bounds[1])
+	if err != nil || last < first {
+		return pageRange{}, false
+	}
+
+	return pageRange{first, last}, true
+}
+
+/*
+servePage serves a page of the list returned by the given function.
+
+The page is specified with the query parameters, limit and after. limit is the
+maximum number of the items in the page, and after is the cursor of the item
+just before the page. Link header field tells the next page if any.
+
+Without those parameters, the page may be specified with Range header field
+with items range unit instead. The first item is 0, and the response tells the
+total number of the items in Content-Range header field.
+
+servePage scans the whole list to find the page. Prefer serveSoughtPage if the
+store can seek the list by the cursor.
+*/
+func servePage(writer http.ResponseWriter, request *http.Request, query func() pageNext) {
+	servePageQuery(writer, request, func(string, int) (pageNext, bool) {
+		return query(), true
+	}, false)
+}
+
+/*
+serveSoughtPage serves a page of the list like servePage, but lets the given
+function seek the list by the cursor and limit the number of the items.
+*/
+func serveSoughtPage(writer http.ResponseWriter, request *http.Request, query pageQuery) {
+	servePageQuery(writer, request, query, true)
+}
+
+/*
+servePageQuery serves a page of the list returned by the given function. If
+sought is false, the function is called with an empty cursor and no limit, and
+the page is found by scanning the whole list.
+*/
+func servePageQuery(writer http.ResponseWriter, request *http.Request, query pageQuery, sought bool) {
+	writer.Header().Set(`Accept-Ranges`, `items`)
+
+	limit := -1
+	if limitString := request.FormValue(`limit`); limitString != `` {
+		var err error
+
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit <= 0 {
+			util.ServeError(writer,
+				util.Error{Description: `invalid limit`},
+				http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	after := request.FormValue(`after`)
+	requested, ranged := pageRange{}, false
+	if limit < 0 && after == `` {
+		requested, ranged = parsePageRange(request.Header.Get(`Range`))
+	}
+
+	queryAfter, queryLimit := ``, -1
+	if sought {
+		queryAfter = after
+
+		// Query one more item to tell whether the next page exists.
+		if limit >= 0 {
+			queryLimit = limit + 1
+		}
+	}
+
+	next, known := query(queryAfter, queryLimit)
+	if !known {
+		util.ServeError(writer,
+			util.Error{Description: `unknown after`},
+			http.StatusBadRequest)
+
+		return
+	}
+
+	items := make([]interface{}, 0)
+	started := after == `` || sought
+	continued := false
+	cursor := ``
+	total := 0
+
+	for item, itemCursor, err, present := next(); present; item, itemCursor, err, present = next() {
+		if err != nil {
+			panic(err)
+		}
+
+		if ranged {
+			if total >= requested.first &&
+				(requested.last < 0 || total <= requested.last) {
+				items = append(items, item)
+			}
+		} else if started {
+			if limit < 0 || len(items) < limit {
+				items = append(items, item)
+				cursor = itemCursor
+			} else {
+				continued = true
+			}
+		} else if itemCursor == after {
+			started = true
+		}
+
+		total++
+	}
+
+	if !started {
+		util.ServeError(writer,
+			util.Error{Description: `unknown after`},
+			http.StatusBadRequest)
+
+		return
+	}
+
+	if ranged {
+		if requested.first >= total {
+			writer.Header().Set(`Content-Range`,
+				fmt.Sprint(`items */`, total))
+			util.ServeErrorDefault(writer,
+				http.StatusRequestedRangeNotSatisfiable)
+
+			return
+		}
+
+		writer.Header().Set(`Content-Range`,
+			fmt.Sprint(`items `, requested.first, `-`,
+				requested.first+len(items)-1, `/`, total))
+		servePageItems(writer, request, items, ``, http.StatusPartialContent)
+
+		return
+	}
+
+	following := ``
+	if continued {
+		link, err := url.Parse(request.RequestURI)
+		if err != nil {
+			panic(err)
+		}
+
+		values := link.Query()
+		values.Set(`after`, cursor)
+		values.Set(`limit`, strconv.Itoa(limit))
+		following = (&url.URL{Path: link.Path, RawQuery: values.Encode()}).String()
+
+		/*
+			RFC 8288 - Web Linking
+			3.3.  Relation Type
+			https://tools.ietf.org/html/rfc8288#section-3.3
+		*/
+		writer.Header().Set(`Link`, `<`+following+`>; rel="next"`)
+	}
+
+	servePageItems(writer, request, items, following, http.StatusOK)
+}
+
+/*
+servePageItems serves the items of a page with the given status code. If the
+request is bound with withEnvelope, the items are wrapped in pageEnvelope with
+the reference to the next page, which is empty if there is no next page.
+*/
+func servePageItems(writer http.ResponseWriter, request *http.Request, items []interface{}, next string, code int) {
+	if enveloped, _ := request.Context().Value(envelopeKey{}).(bool); !enveloped {
+		util.ServeJSON(writer, items, code)
+		return
+	}
+
+	util.ServeJSON(writer, pageEnvelope{items, next}, code)
+}
+
+// withEnvelope returns handlerFunc serving pages of next in pageEnvelope.
+func withEnvelope(next handlerFunc) handlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request, shared shared) {
+		next(writer,
+			request.WithContext(context.WithValue(request.Context(), envelopeKey{}, true)),
+			shared)
+	}
+}
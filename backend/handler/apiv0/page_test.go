@@ -0,0 +1,144 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPage(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
+
+	for _, test := range [...]struct {
+		description  string
+		path         string
+		rangeField   string
+		code         int
+		link         string
+		contentRange string
+		expected     []string
+	}{
+		{
+			`limit`, `/members?limit=3`, ``, http.StatusOK,
+			`</members?after=3rdDisplayID&limit=3>; rel="next"`, ``,
+			[]string{`1stDisplayID`, `2ndDisplayID`, `3rdDisplayID`},
+		}, {
+			`after`, `/members?after=3rdDisplayID&limit=3&sort=id`, ``,
+			http.StatusOK,
+			`</members?after=6thDisplayID&limit=3&sort=id>; rel="next"`, ``,
+			[]string{`4thDisplayID`, `5thDisplayID`, `6thDisplayID`},
+		}, {
+			`last`, `/members?after=6thDisplayID&limit=3`, ``,
+			http.StatusOK, ``, ``, []string{`7thDisplayID`},
+		}, {
+			`afterWithoutLimit`, `/members?after=5thDisplayID`, ``,
+			http.StatusOK, ``, ``,
+			[]string{`6thDisplayID`, `7thDisplayID`},
+		}, {
+			`unknownAfter`, `/members?after=invalid`, ``,
+			http.StatusBadRequest, ``, ``, nil,
+		}, {
+			`invalidLimit`, `/members?limit=0`, ``,
+			http.StatusBadRequest, ``, ``, nil,
+		}, {
+			`range`, `/members`, `items=1-2`, http.StatusPartialContent,
+			``, `items 1-2/7`, []string{`2ndDisplayID`, `3rdDisplayID`},
+		}, {
+			`rangeOpen`, `/members`, `items=5-`, http.StatusPartialContent,
+			``, `items 5-6/7`, []string{`6thDisplayID`, `7thDisplayID`},
+		}, {
+			`rangeExceeding`, `/members`, `items=6-9`,
+			http.StatusPartialContent, ``, `items 6-6/7`,
+			[]string{`7thDisplayID`},
+		}, {
+			`rangeNotSatisfiable`, `/members`, `items=7-`,
+			http.StatusRequestedRangeNotSatisfiable, ``, `items */7`, nil,
+		}, {
+			`rangeUnknown`, `/members?ob=1`, `bytes=0-1`, http.StatusOK,
+			``, ``, []string{`7thDisplayID`},
+		}, {
+			`clubs`, `/clubs?limit=1`, ``, http.StatusOK,
+			`</clubs?after=prog&limit=1>; rel="next"`, ``,
+			[]string{`prog`},
+		}, {
+			`clubsAfter`, `/clubs?after=prog&limit=1`, ``, http.StatusOK,
+			``, ``, []string{`web`},
+		}, {
+			// A cursor is a position in the order; it need not exist.
+			`clubsAfterAbsent`, `/clubs?after=q`, ``, http.StatusOK,
+			``, ``, []string{`web`},
+		}, {
+			`clubsRange`, `/clubs`, `items=1-`, http.StatusPartialContent,
+			``, `items 1-1/2`, []string{`web`},
+		},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			request := httptest.NewRequest(`GET`, `https://kagucho.net`+test.path, nil)
+			request.Header.Set(`Authorization`, member)
+			if test.rangeField != `` {
+				request.Header.Set(`Range`, test.rangeField)
+			}
+
+			recorder := httptest.NewRecorder()
+			apiv0.ServeHTTP(recorder, request)
+			testCode(t, test.code, recorder)
+
+			if result := recorder.HeaderMap.Get(`Link`); result != test.link {
+				t.Errorf(`expected Link %q, got %q`, test.link, result)
+			}
+
+			if result := recorder.HeaderMap.Get(`Content-Range`); result != test.contentRange {
+				t.Errorf(`expected Content-Range %q, got %q`,
+					test.contentRange, result)
+			}
+
+			if test.expected == nil {
+				return
+			}
+
+			var entries []struct {
+				ID string `json:"id"`
+			}
+
+			if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil {
+				t.Fatal(err)
+			}
+
+			ids := make([]string, len(entries))
+			for index, entry := range entries {
+				ids[index] = entry.ID
+			}
+
+			if !reflect.DeepEqual(ids, test.expected) {
+				t.Errorf(`expected %v, got %v`, test.expected, ids)
+			}
+		})
+	}
+}
@@ -18,30 +18,65 @@
 package apiv0
 
 import (
-	"fmt"
 	"github.com/kagucho/tsubonesystem3/backend/db"
 	"github.com/kagucho/tsubonesystem3/backend/encoding"
 	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
 	"github.com/kagucho/tsubonesystem3/backend/scope"
 	"net/http"
-	"strconv"
 )
 
+var (
+	schemaParty = object(map[string]*schema{
+		`attendances`: mapOf(schemaAttendance),
+		`creator`:     nullable(schemaString),
+		`details`:     schemaString,
+		`due`:         schemaTime,
+		`end`:         schemaTime,
+		`inviteds`:    schemaString,
+		`place`:       schemaString,
+		`start`:       schemaTime,
+	}, nil)
+
+	schemaPartyUser = object(map[string]*schema{
+		`creator`:  nullable(schemaString),
+		`due`:      schemaTime,
+		`end`:      schemaTime,
+		`inviteds`: schemaString,
+		`name`:     schemaString,
+		`place`:    schemaString,
+		`start`:    schemaTime,
+		`user`:     schemaAttendance,
+	}, nil)
+)
+
+var partyDeleteOperation = operation{
+	summary:  `Delete the party created by the user.`,
+	security: []uint{scope.Member},
+	ifMatch:  true,
+	responses: map[int]response{
+		http.StatusOK:       resultResponse,
+		http.StatusNotFound: errorResponse(`The party created by the user is not found.`),
+	},
+}
+
 func partyDeleteServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path == `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
+	authorized := authorize(writer, request, shared, scope.Member)
+	if (authorized == claim{}) {
 		return
 	}
 
-	authorized := authorize(writer, request, shared, scope.Member)
-	if (authorized == claim{}) {
+	version, ok := ifMatch(writer, request)
+	if !ok {
 		return
 	}
 
-	switch err := shared.DB.DeleteParty(request.URL.Path[1:], authorized.sub); err {
+	switch err := shared.DB.DeleteParty(pathParam(request, `name`), authorized.sub, version); err {
 	case db.ErrIncorrectIdentity:
 		util.ServeErrorDefault(writer, http.StatusNotFound)
 
+	case db.ErrVersionMismatch:
+		servePreconditionFailed(writer)
+
 	case nil:
 		util.ServeJSON(writer, struct{}{}, http.StatusOK)
 
@@ -50,116 +85,93 @@ func partyDeleteServeHTTP(writer http.ResponseWriter, request *http.Request, sha
 	}
 }
 
-func partyGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path == `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
-	}
+var partyGetOperation = operation{
+	summary:  `Get the party.`,
+	security: []uint{scope.Member},
+	etag:     true,
+	responses: map[int]response{
+		http.StatusOK:       jsonResponse(`The party.`, schemaParty),
+		http.StatusNotFound: errorResponse(`The party is not found.`),
+	},
+}
 
+func partyGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
 	if (authorize(writer, request, shared, scope.Member) == claim{}) {
 		return
 	}
 
-	switch party, err := shared.DB.QueryParty(request.URL.Path[1:]); err {
+	switch party, err := shared.DB.QueryParty(request.Context(), pathParam(request, `name`)); err {
 	case db.ErrIncorrectIdentity:
 		util.ServeErrorDefault(writer, http.StatusNotFound)
 
 	case nil:
-		util.ServeJSON(writer, party, http.StatusOK)
+		if !serveETag(writer, request, party.Version) {
+			util.ServeJSON(writer, party, http.StatusOK)
+		}
 
 	default:
 		panic(err)
 	}
 }
 
+var partyPatchOperation = operation{
+	summary:  `Update the party or the attendance of the user.`,
+	security: []uint{scope.Member},
+	body: []parameter{
+		{`attending`, ``, schemaFlag, false},
+		{`details`, ``, property(db.PropertyText), false},
+		{`due`, ``, schemaTime, false},
+		{`end`, ``, schemaTime, false},
+		{`invited_ids`, ``, schemaList, false},
+		{`inviteds`, ``, property(db.PropertyName), false},
+		{`place`, ``, property(db.PropertyName), false},
+		{`start`, ``, schemaTime, false},
+	},
+	media:   mediaMergePatch,
+	ifMatch: true,
+	responses: map[int]response{
+		http.StatusOK:                  resultResponse,
+		http.StatusNotFound:            errorResponse(`The party or an invited member is not found.`),
+		http.StatusUnprocessableEntity: invalidResponse(`The properties are invalid.`),
+	},
+}
+
 func partyPatchServeHTTP(writer http.ResponseWriter, request *http.Request,
 	shared shared) {
-	if request.URL.Path == `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
+	authorized := authorize(writer, request, shared, scope.Member)
+	if (authorized == claim{}) {
 		return
 	}
 
-	authorized := authorize(writer, request, shared, scope.Member)
-	if (authorized == claim{}) {
+	version, ok := ifMatch(writer, request)
+	if !ok {
 		return
 	}
 
-	var start encoding.Time
-	var end encoding.Time
-	var due encoding.Time
-	party := request.URL.Path[1:]
+	party := pathParam(request, `name`)
 	place := request.FormValue(`place`)
 	inviteds := request.FormValue(`inviteds`)
 	invitedIDs := request.FormValue(`invited_ids`)
 	details := request.FormValue(`details`)
-
-	if startString := request.FormValue(`start`); startString != `` {
-		var err error
-
-		start, err = encoding.ParseQueryTime(startString)
-		if err != nil {
-			description := `syntax error in start`
-			status := http.StatusBadRequest
-
-			if err == strconv.ErrRange {
-				description = `start out of range`
-				status = http.StatusUnprocessableEntity
-			}
-
-			util.ServeError(writer,
-				util.Error{Description: description},
-				status)
-
-			return
-		}
-	}
-
-	if endString := request.FormValue(`end`); endString != `` {
-		var err error
-
-		end, err = encoding.ParseQueryTime(endString)
-		if err != nil {
-			description := `syntax error in end`
-			status := http.StatusBadRequest
-
-			if err == strconv.ErrRange {
-				description = `end out of range`
-				status = http.StatusUnprocessableEntity
-			}
-
-			util.ServeError(writer,
-				util.Error{Description: description},
-				status)
-
-			return
-		}
-	}
-
-	if dueString := request.FormValue(`due`); dueString != `` {
-		var err error
-
-		due, err = encoding.ParseQueryTime(dueString)
-		if err != nil {
-			description := `syntax error in due`
-			status := http.StatusBadRequest
-
-			if err == strconv.ErrRange {
-				description = `due out of range`
-				status = http.StatusUnprocessableEntity
-			}
-
-			util.ServeError(writer,
-				util.Error{Description: description},
-				status)
-
-			return
-		}
+	attendingString := request.FormValue(`attending`)
+
+	var validation validation
+	start := validation.time(`start`, request.FormValue(`start`))
+	end := validation.time(`end`, request.FormValue(`end`))
+	due := validation.time(`due`, request.FormValue(`due`))
+	validation.property(`place`, place, db.PropertyName)
+	validation.property(`inviteds`, inviteds, db.PropertyName)
+	validation.property(`details`, details, db.PropertyText)
+	attending := validation.flag(`attending`, attendingString)
+	if validation.serve(writer) {
+		return
 	}
 
 	if (start != encoding.Time{} || end != encoding.Time{} || place != `` || due != encoding.Time{} || inviteds != `` || invitedIDs != `` || details != ``) {
 		switch err := shared.DB.UpdateParty(
 			party, authorized.sub, start, end,
 			place, due, inviteds, invitedIDs,
-			details); err {
+			details, version); err {
 		case db.ErrIncorrectIdentity:
 			/*
 				FIXME: should be StatusUnprocessableEntity if
@@ -172,119 +184,150 @@ func partyPatchServeHTTP(writer http.ResponseWriter, request *http.Request,
 			util.ServeErrorDefault(writer, http.StatusUnprocessableEntity)
 			return
 
-		case nil:
-
-		default:
-			panic(err)
-		}
-	}
-
-	if attendingString := request.FormValue(`attending`); attendingString != `` {
-		var attending bool
-		switch ; attendingString {
-		case `0`:
-			attending = false
-
-		case `1`:
-			attending = true
-
-		default:
-			util.ServeError(writer,
-				util.Error{Description: fmt.Sprintf(`invalid attending: '%v'`, attending)},
-				http.StatusBadRequest)
-
-			return
-		}
-
-		switch err := shared.DB.UpdateAttendance(attending,
-			party, authorized.sub); err {
-		case db.ErrIncorrectIdentity:
-			/*
-				FIXME: should be StatusUnprocessableEntity if
-				party is found but creator is not.
-			*/
-			util.ServeErrorDefault(writer, http.StatusNotFound)
+		case db.ErrVersionMismatch:
+			servePreconditionFailed(writer)
 			return
 
 		case nil:
+			// The update above has already bumped the version.
+			version = db.AnyVersion
 
 		default:
 			panic(err)
 		}
 	}
 
+	if attendingString != `` &&
+		!partyUpdateAttendance(writer, shared, party, authorized.sub, attending, version) {
+		return
+	}
+
 	util.ServeJSON(writer, struct{}{}, http.StatusOK)
 }
 
-func partyPutServeHTTP(writer http.ResponseWriter, request *http.Request,
-	shared shared) {
-	if len(request.URL.Path) < 2 {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
-		return
-	}
+var partyAttendancePutOperation = operation{
+	summary:  `Set the attendance of the user.`,
+	security: []uint{scope.Member},
+	body:     []parameter{{`attending`, ``, schemaFlag, true}},
+	media:    mediaJSON,
+	ifMatch:  true,
+	responses: map[int]response{
+		http.StatusOK:                  resultResponse,
+		http.StatusNotFound:            errorResponse(`The party is not found.`),
+		http.StatusUnprocessableEntity: invalidResponse(`The attendance is missing.`),
+	},
+}
 
+func partyAttendancePutServeHTTP(writer http.ResponseWriter, request *http.Request,
+	shared shared) {
 	authorized := authorize(writer, request, shared, scope.Member)
 	if (authorized == claim{}) {
 		return
 	}
 
-	start, startErr := encoding.ParseQueryTime(request.PostFormValue(`start`))
-	if startErr != nil {
-		description := `syntax error in start`
-		status := http.StatusBadRequest
-
-		if startErr == strconv.ErrRange {
-			description = `start out of range`
-			status = http.StatusUnprocessableEntity
-		}
+	version, ok := ifMatch(writer, request)
+	if !ok {
+		return
+	}
 
-		util.ServeError(writer,
-			util.Error{Description: description},
-			status)
+	attendingString := request.PostFormValue(`attending`)
 
+	var validation validation
+	validation.require(`attending`, attendingString)
+	attending := validation.flag(`attending`, attendingString)
+	if validation.serve(writer) {
 		return
 	}
 
-	end, endErr := encoding.ParseQueryTime(request.PostFormValue(`end`))
-	if endErr != nil {
-		description := `syntax error in end`
-		status := http.StatusBadRequest
-
-		if endErr == strconv.ErrRange {
-			description = `end out of range`
-			status = http.StatusUnprocessableEntity
-		}
+	if partyUpdateAttendance(writer, shared, pathParam(request, `name`),
+		authorized.sub, attending, version) {
+		util.ServeJSON(writer, struct{}{}, http.StatusOK)
+	}
+}
 
-		util.ServeError(writer,
-			util.Error{Description: description},
-			status)
+/*
+partyUpdateAttendance updates the attendance of the given member for the given
+party. It serves an error and returns false if it failed.
+*/
+func partyUpdateAttendance(writer http.ResponseWriter, shared shared,
+	party, member string, attending bool, version db.Precondition) bool {
+	switch err := shared.DB.UpdateAttendance(attending,
+		party, member, version); err {
+	case db.ErrIncorrectIdentity:
+		/*
+			FIXME: should be StatusUnprocessableEntity if party is
+			found but creator is not.
+		*/
+		util.ServeErrorDefault(writer, http.StatusNotFound)
+		return false
 
-		return
-	}
+	case db.ErrVersionMismatch:
+		servePreconditionFailed(writer)
+		return false
 
-	due, dueErr := encoding.ParseQueryTime(request.PostFormValue(`due`))
-	if dueErr != nil {
-		description := `syntax error in due`
-		status := http.StatusBadRequest
+	case nil:
+		return true
 
-		if endErr == strconv.ErrRange {
-			description = `due out of range`
-			status = http.StatusUnprocessableEntity
-		}
+	default:
+		panic(err)
+	}
+}
 
-		util.ServeError(writer,
-			util.Error{Description: description},
-			status)
+var partyPutOperation = operation{
+	summary:  `Create the party and invite the members.`,
+	security: []uint{scope.Member},
+	body: []parameter{
+		{`details`, ``, property(db.PropertyText), true},
+		{`due`, ``, schemaTime, true},
+		{`end`, ``, schemaTime, true},
+		{`invited_ids`, ``, schemaList, true},
+		{`inviteds`, ``, property(db.PropertyName), true},
+		{`place`, ``, property(db.PropertyName), true},
+		{`start`, ``, schemaTime, true},
+	},
+	media: mediaJSON,
+	mails: true,
+	responses: map[int]response{
+		http.StatusCreated:             resultResponse,
+		http.StatusUnprocessableEntity: invalidResponse(`The properties are invalid or duplicate.`),
+	},
+}
 
+func partyPutServeHTTP(writer http.ResponseWriter, request *http.Request,
+	shared shared) {
+	authorized := authorize(writer, request, shared, scope.Member)
+	if (authorized == claim{}) {
 		return
 	}
 
-	name := request.URL.Path[1:]
+	name := pathParam(request, `name`)
+	startString := request.PostFormValue(`start`)
+	endString := request.PostFormValue(`end`)
 	place := request.PostFormValue(`place`)
+	dueString := request.PostFormValue(`due`)
 	invitedIDs := request.PostFormValue(`invited_ids`)
 	inviteds := request.PostFormValue(`inviteds`)
 	details := request.PostFormValue(`details`)
 
+	var validation validation
+	validation.property(`name`, name, db.PropertyName)
+	validation.require(`start`, startString)
+	start := validation.time(`start`, startString)
+	validation.require(`end`, endString)
+	end := validation.time(`end`, endString)
+	validation.require(`place`, place)
+	validation.property(`place`, place, db.PropertyName)
+	validation.require(`due`, dueString)
+	due := validation.time(`due`, dueString)
+	validation.require(`invited_ids`, invitedIDs)
+	validation.require(`inviteds`, inviteds)
+	validation.property(`inviteds`, inviteds, db.PropertyName)
+	validation.require(`details`, details)
+	validation.property(`details`, details, db.PropertyText)
+	if validation.serve(writer) {
+		return
+	}
+
 	switch mails, err := shared.DB.InsertParty(name,
 		authorized.sub, start, end, place,
 		due, invitedIDs, inviteds, details); err {
@@ -324,17 +367,39 @@ func partyPutServeHTTP(writer http.ResponseWriter, request *http.Request,
 	}
 }
 
-func partiesGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
-	if request.URL.Path != `` {
-		util.ServeErrorDefault(writer, http.StatusNotFound)
-		return
-	}
+var partiesGetOperation = pageOperation(`List the parties with the attendances of the user.`,
+	[]uint{scope.Member}, schemaPartyUser)
 
+func partiesGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
 	authorized := authorize(writer, request, shared, scope.Member)
 	if (authorized == claim{}) {
 		return
 	}
 
-	util.ServeJSON(writer,
-		shared.DB.QueryParties(authorized.sub), http.StatusOK)
+	serveSoughtPage(writer, request, func(after string, limit int) (pageNext, bool) {
+		var afterID uint16
+
+		if after != `` {
+			var err error
+
+			afterID, err = shared.DB.QueryPartyID(request.Context(), after)
+			switch err {
+			case nil:
+
+			case db.ErrIncorrectIdentity:
+				return nil, false
+
+			default:
+				panic(err)
+			}
+		}
+
+		partyChan := shared.DB.QueryParties(request.Context(),
+			authorized.sub, afterID, limit)
+
+		return func() (interface{}, string, error, bool) {
+			result, present := <-partyChan
+			return result.PartyUser, result.Name, result.Error, present
+		}, true
+	})
 }
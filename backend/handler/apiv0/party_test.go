@@ -0,0 +1,102 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParty(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	creator := apiv0.authorization(t, `3rdDisplayID`, `member`)
+	invited := apiv0.authorization(t, `4thDisplayID`, `member`)
+
+	t.Run(`put`, func(t *testing.T) {
+		apiv0.recorder.Reset()
+
+		recorder := apiv0.serve(`PUT`, `/party/party`, creator,
+			`start=1500000000&end=1500003600&place=place&due=1499990000&invited_ids=3rdDisplayID 4thDisplayID&inviteds=inviteds&details=details`)
+		testCode(t, http.StatusCreated, recorder)
+
+		recorded := apiv0.recorder.Recorded()
+		if len(recorded) != 1 {
+			t.Fatalf(`expected 1 mail, got %v`, len(recorded))
+		}
+
+		expected := []string{`3rd@kagucho.net`, `4th@kagucho.net`}
+		if !reflect.DeepEqual(recorded[0].Recipients, expected) {
+			t.Errorf(`expected %v, got %v`,
+				expected, recorded[0].Recipients)
+		}
+
+		if recorded[0].Subject != `TsuboneSystem パーティー招待: party` {
+			t.Errorf(`expected %q, got %q`,
+				`TsuboneSystem パーティー招待: party`, recorded[0].Subject)
+		}
+	})
+
+	apiv0.testRequests(t, []testRequest{
+		{
+			`putBadOmission`, `PUT`, `/party/omitted`, creator,
+			`start=1500000000&end=1500003600&due=1499990000`,
+			http.StatusUnprocessableEntity, ``,
+		}, {
+			`putDuplicate`, `PUT`, `/party/party`, creator,
+			`start=1500000000&end=1500003600&place=place&due=1499990000&invited_ids=3rdDisplayID&inviteds=inviteds&details=details`,
+			http.StatusUnprocessableEntity, ``,
+		}, {
+			`attend`, `PATCH`, `/party/party`, invited, `attending=1`,
+			http.StatusOK, ``,
+		}, {
+			`attendanceInvalid`, `PUT`, `/party/party/attendance`,
+			creator, `attending=2`, http.StatusBadRequest, ``,
+		}, {
+			`attendanceNotFound`, `PUT`, `/party/invalid/attendance`,
+			creator, `attending=0`, http.StatusNotFound, ``,
+		}, {
+			`attendance`, `PUT`, `/party/party/attendance`, creator,
+			`attending=0`, http.StatusOK, ``,
+		}, {
+			`get`, `GET`, `/party/party`, invited, ``, http.StatusOK,
+			`{"creator":"3rdDisplayID","start":1500000000,"end":1500003600,"place":"place","inviteds":"inviteds","due":1499990000,"details":"details","attendances":{"3rdDisplayID":"declined","4thDisplayID":"accepted"}}
+`,
+		}, {
+			`parties`, `GET`, `/parties`, invited, ``, http.StatusOK,
+			`[{"creator":"3rdDisplayID","start":1500000000,"end":1500003600,"place":"place","inviteds":"inviteds","due":1499990000,"name":"party","user":"accepted"}]
+`,
+		}, {
+			`deleteNotCreator`, `DELETE`, `/party/party`, invited, ``,
+			http.StatusNotFound, ``,
+		}, {
+			`delete`, `DELETE`, `/party/party`, creator, ``,
+			http.StatusOK, ``,
+		}, {
+			`deleted`, `GET`, `/party/party`, invited, ``,
+			http.StatusNotFound, ``,
+		},
+	})
+}
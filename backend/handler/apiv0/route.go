@@ -17,202 +17,595 @@
 
 package apiv0
 
-import "sort"
+import (
+	"context"
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
 
+// routeParamsKey is the key of the context value holding routeParams.
+type routeParamsKey struct{}
+
+/*
+paramType is a structure describing a type of path parameters. match tells
+whether a segment of a path is valid as the type, and schema describes it.
+*/
+type paramType struct {
+	name   string
+	match  func(string) bool
+	schema *schema
+}
+
+// paramTypes associates the names of the types of path parameters with them.
+var paramTypes = map[string]paramType{
+	`id`: {`id`, db.PropertyID.Valid, property(db.PropertyID)},
+	`number`: {`number`, func(segment string) bool {
+		_, err := strconv.ParseUint(segment, 10, 16)
+		return err == nil
+	}, schemaInteger},
+	`serial`: {`serial`, func(segment string) bool {
+		_, err := strconv.ParseUint(segment, 10, 32)
+		return err == nil
+	}, schemaInteger},
+	`string`: {`string`, func(string) bool { return true }, schemaString},
+}
+
+/*
+routeSegment is a structure describing a segment of the pattern of a route. It
+is a literal if param is empty, and a path parameter otherwise.
+*/
+type routeSegment struct {
+	literal string
+	param   string
+	kind    paramType
+}
+
+type routeParam struct {
+	name  string
+	value string
+}
+
+// routeParams is the path parameters of a request in the order of the path.
+type routeParams []routeParam
+
+/*
+route is a structure describing a route. pattern is a path whose segments may
+be path parameters like {name:type}. type is string if omitted. template is the
+pattern without the types, like {name}. stream tells the handler keeps
+streaming the response until the client goes away.
+*/
 type route struct {
-	prefix string
-	handler handler
-}
-
-type routeSlice []route
-
-func (slice routeSlice) Len() int {
-	return len(slice)
-}
-
-func (slice routeSlice) Less(i, j int) bool {
-	return slice[i].prefix < slice[j].prefix
-}
-
-func (slice routeSlice) Swap(i, j int) {
-	temporary := slice[i]
-	slice[i] = slice[j]
-	slice[j] = temporary
-}
-
-func (slice routeSlice) search(path string) int {
-	return sort.Search(len(slice), func(index int) bool {
-		return slice[index].prefix >= path
-	})
-}
-
-func (apiv0 APIv0) newRoutes() routeSlice {
-	routes := routeSlice{
-		{
-			`/club`,
-			methodMux{
-				map[string]handlerFunc{
-					`DELETE`: clubDeleteServeHTTP,
-					`GET`:    clubGetServeHTTP,
-					`HEAD`:   clubGetServeHTTP,
-					`PATCH`:   clubPatchServeHTTP,
-					`PUT`:    clubPutServeHTTP,
-				},
-				[]field{
-					{`Accept-Patch`, `application/x-www-form-urlencoded`},
-					{`Accept-Ranges`, `none`},
-				},
-			},
-		},
-		{
-			`/clubs`,
-			methodMux{
-				map[string]handlerFunc{
-					`GET`:  clubsGetServeHTTP,
-					`HEAD`: clubsGetServeHTTP,
-				},
-				[]field{{`Accept-Ranges`, `none`}},
-			},
-		},
-		{
-			`/mail`,
-			methodMux{
-				map[string]handlerFunc{
-					`DELETE`: mailDeleteServeHTTP,
-					`GET`:    mailGetServeHTTP,
-					`HEAD`:   mailGetServeHTTP,
-					`PATCH`:  mailPatchServeHTTP,
-					`PUT`:    mailPutServeHTTP,
-				},
-				[]field{
-					{`Accept-Patch`, `application/x-www-form-urlencoded`},
-					{`Accept-Ranges`, `none`},
-				},
-			},
-		},
-		{
-			`/mails`,
-			methodMux{
-				map[string]handlerFunc{
-					`GET`:  mailsGetServeHTTP,
-					`HEAD`: mailsGetServeHTTP,
-				},
-				[]field{
-					{`Accept-Patch`, `application/x-www-form-urlencoded`},
-					{`Accept-Ranges`, `none`},
-				},
-			},
-		},
-		{
-			`/member`,
-			methodMux{
-				map[string]handlerFunc{
-					`DELETE`: memberDeleteServeHTTP,
-					`GET`:    memberGetServeHTTP,
-					`HEAD`:   memberGetServeHTTP,
-					`PATCH`:  memberPatchServeHTTP,
-					`PUT`:    memberPutServeHTTP,
-				},
-				[]field{
-					{`Accept-Patch`, `application/x-www-form-urlencoded`},
-					{`Accept-Ranges`, `none`},
-				},
-			},
-		},
-		{
-			`/members`,
-			methodMux{
-				map[string]handlerFunc{
-					`GET`:  membersGetServeHTTP,
-					`HEAD`: membersGetServeHTTP,
-				},
-				[]field{{`Accept-Ranges`, `none`}},
-			},
-		},
-		{
-			`/members/mails`,
-			methodMux{
-				map[string]handlerFunc{
-					`GET`:  membersMailsGetServeHTTP,
-					`HEAD`: membersMailsGetServeHTTP,
-				},
-				[]field{{`Accept-Ranges`, `none`}},
-			},
-		},
-		{
-			`/officer`,
-			methodMux{
-				map[string]handlerFunc{
-					`DELETE`: officerDeleteServeHTTP,
-					`GET`:    officerGetServeHTTP,
-					`HEAD`:   officerGetServeHTTP,
-					`PATCH`:  officerPatchServeHTTP,
-					`PUT`:    officerPutServeHTTP,
-				},
-				[]field{
-					{`Accept-Patch`, `application/x-www-form-urlencoded`},
-					{`Accept-Ranges`, `none`},
-				},
-			},
-		},
-		{
-			`/officers`,
-			methodMux{
-				map[string]handlerFunc{
-					`GET`:  officersGetServeHTTP,
-					`HEAD`: officersGetServeHTTP,
-				},
-				[]field{{`Accept-Ranges`, `none`}},
-			},
-		},
-		{
-			`/officers/names`,
-			methodMux{
-				map[string]handlerFunc{
-					`GET`:  officersNamesGetServeHTTP,
-					`HEAD`: officersNamesGetServeHTTP,
-				},
-				[]field{{`Accept-Ranges`, `none`}},
-			},
-		},
-		{
-			`/parties`,
-			methodMux{
-				map[string]handlerFunc{
-					`GET`:  partiesGetServeHTTP,
-					`HEAD`: partiesGetServeHTTP,
-				},
-				[]field{{`Accept-Ranges`, `none`}},
-			},
-		},
-		{
-			`/party`,
-			methodMux{
-				map[string]handlerFunc{
-					`DELETE`: partyDeleteServeHTTP,
-					`GET`:    partyGetServeHTTP,
-					`HEAD`:   partyGetServeHTTP,
-					`PATCH`:  partyPatchServeHTTP,
-					`PUT`:    partyPutServeHTTP,
-				},
-				[]field{
-					{`Accept-Patch`, `application/x-www-form-urlencoded`},
-					{`Accept-Ranges`, `none`},
-				},
-			},
-		},
-		{
-			`/token`,
-			methodMux{
-				map[string]handlerFunc{
-					`POST`: apiv0.tokenServer.serveHTTP,
-				},
-				[]field{{`Accept-Ranges`, `none`}},
-			},
-		},
+	pattern  string
+	template string
+	segments []routeSegment
+	handler  handler
+	stream   bool
+}
+
+/*
+router is routes sorted so that a route with a literal segment precedes one with
+a path parameter at the same position.
+*/
+type router []route
+
+/*
+Route is a structure describing a route of API v0. It is useful to generate
+documents and to label metrics. Template is a path whose segments may be path
+parameters like {name}.
+*/
+type Route struct {
+	Template string
+	Methods  []string
+	Params   []RouteParam
+}
+
+// RouteParam is a structure describing a path parameter of a route.
+type RouteParam struct {
+	Name string
+	Type string
+}
+
+/*
+newRoute returns a route with the given pattern and handler. It panics if the
+pattern is malformed.
+*/
+func newRoute(pattern string, handler handler) route {
+	if !strings.HasPrefix(pattern, `/`) {
+		panic(`pattern without leading slash: ` + pattern)
+	}
+
+	split := strings.Split(pattern[1:], `/`)
+	segments := make([]routeSegment, len(split))
+	templates := make([]string, len(split))
+
+	for index, segment := range split {
+		if !strings.HasPrefix(segment, `{`) || !strings.HasSuffix(segment, `}`) {
+			segments[index].literal = segment
+			templates[index] = segment
+			continue
+		}
+
+		name := segment[1 : len(segment)-1]
+		typeName := `string`
+		if colon := strings.IndexByte(name, ':'); colon >= 0 {
+			typeName = name[colon+1:]
+			name = name[:colon]
+		}
+
+		kind, present := paramTypes[typeName]
+		if name == `` || !present {
+			panic(`malformed path parameter: ` + segment)
+		}
+
+		segments[index].param = name
+		segments[index].kind = kind
+		templates[index] = `{` + name + `}`
+	}
+
+	return route{pattern, `/` + strings.Join(templates, `/`), segments, handler, false}
+}
+
+/*
+newStreamRoute returns a route like newRoute, but tells the handler keeps
+streaming the response. Such a route cannot be served in a batch.
+*/
+func newStreamRoute(pattern string, handler handler) route {
+	streamRoute := newRoute(pattern, handler)
+	streamRoute.stream = true
+
+	return streamRoute
+}
+
+// newRouter returns router consisting of the given routes.
+func newRouter(routes ...route) router {
+	sorted := router(routes)
+	sort.Stable(sorted)
+
+	return sorted
+}
+
+func (router router) Len() int {
+	return len(router)
+}
+
+func (router router) Less(i, j int) bool {
+	for index, segment := range router[i].segments {
+		if index >= len(router[j].segments) {
+			return false
+		}
+
+		other := router[j].segments[index]
+		if (segment.param == ``) != (other.param == ``) {
+			return segment.param == ``
+		}
+	}
+
+	return false
+}
+
+func (router router) Swap(i, j int) {
+	router[i], router[j] = router[j], router[i]
+}
+
+/*
+search returns the route matching with the given URL and the path parameters.
+It returns false if no route matches.
+*/
+func (router router) search(requestURL *url.URL) (route, routeParams, bool) {
+	escaped := requestURL.EscapedPath()
+	if !strings.HasPrefix(escaped, `/`) {
+		return route{}, nil, false
+	}
+
+	split := strings.Split(escaped[1:], `/`)
+	for index, segment := range split {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return route{}, nil, false
+		}
+
+		split[index] = unescaped
+	}
+
+	for _, candidate := range router {
+		if params, ok := candidate.match(split); ok {
+			return candidate, params, true
+		}
+	}
+
+	return route{}, nil, false
+}
+
+/*
+match returns the path parameters if the route matches with the given segments
+of a path.
+*/
+func (route route) match(segments []string) (routeParams, bool) {
+	if len(segments) != len(route.segments) {
+		return nil, false
+	}
+
+	var params routeParams
+
+	for index, segment := range route.segments {
+		if segment.param == `` {
+			if segments[index] != segment.literal {
+				return nil, false
+			}
+
+			continue
+		}
+
+		if segments[index] == `` || !segment.kind.match(segments[index]) {
+			return nil, false
+		}
+
+		params = append(params, routeParam{segment.param, segments[index]})
+	}
+
+	return params, true
+}
+
+// describe returns apiv0.Route describing the route.
+func (route route) describe() Route {
+	var params []RouteParam
+
+	for _, segment := range route.segments {
+		if segment.param != `` {
+			params = append(params,
+				RouteParam{segment.param, segment.kind.name})
+		}
+	}
+
+	return Route{route.template, route.handler.methods(), params}
+}
+
+/*
+target returns the value of the first path parameter, which identifies the
+resource the request targets, or an empty string if there is no parameters.
+*/
+func (params routeParams) target() string {
+	if len(params) == 0 {
+		return ``
+	}
+
+	return params[0].value
+}
+
+// withRouteParams returns the request bound to the given path parameters.
+func withRouteParams(request *http.Request, params routeParams) *http.Request {
+	return request.WithContext(
+		context.WithValue(request.Context(), routeParamsKey{}, params))
+}
+
+// requestParams returns the path parameters of the request.
+func requestParams(request *http.Request) routeParams {
+	params, _ := request.Context().Value(routeParamsKey{}).(routeParams)
+	return params
+}
+
+/*
+pathParam returns the path parameter with the given name of the request, or an
+empty string if it is absent.
+*/
+func pathParam(request *http.Request, name string) string {
+	for _, param := range requestParams(request) {
+		if param.name == name {
+			return param.value
+		}
 	}
 
-	sort.Sort(routes)
+	return ``
+}
+
+// Routes returns the descriptions of the routes of API v0.
+func (apiv0 APIv0) Routes() []Route {
+	routes := make([]Route, len(apiv0.routes))
+	for index, route := range apiv0.routes {
+		routes[index] = route.describe()
+	}
+
+	return routes
+}
+
+func (apiv0 APIv0) newRoutes() router {
+	acceptPatch := field{`Accept-Patch`, mediaForm + `, ` + mediaMergePatch}
+	rangesNone := field{`Accept-Ranges`, `none`}
+	rangesItems := field{`Accept-Ranges`, `items`}
+
+	var routes router
+	routes = newRouter(
+		newRoute(`/audit`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  auditGetServeHTTP,
+				`HEAD`: auditGetServeHTTP,
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`GET`: auditGetOperation,
+			},
+		}),
+		newRoute(`/audit/verify`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  auditVerifyGetServeHTTP,
+				`HEAD`: auditVerifyGetServeHTTP,
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`GET`: auditVerifyGetOperation,
+			},
+		}),
+		newRoute(`/batch`, methodMux{
+			map[string]handlerFunc{
+				`POST`: batchPostServeHTTP(&routes),
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`POST`: batchPostOperation,
+			},
+		}),
+		newRoute(`/club/{id:id}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: clubDeleteServeHTTP,
+				`GET`:    clubGetServeHTTP,
+				`HEAD`:   clubGetServeHTTP,
+				`PATCH`:  withBody(mediaMergePatch, clubPatchServeHTTP),
+				`PUT`:    withBody(mediaJSON, clubPutServeHTTP),
+			},
+			[]field{acceptPatch, rangesNone},
+			map[string]operation{
+				`DELETE`: clubDeleteOperation,
+				`GET`:    clubGetOperation,
+				`PATCH`:  clubPatchOperation,
+				`PUT`:    clubPutOperation,
+			},
+		}),
+		newRoute(`/club/{id:id}/history`, historyMux(db.HistoryClub)),
+		newRoute(`/clubs`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  clubsGetServeHTTP,
+				`HEAD`: clubsGetServeHTTP,
+			},
+			[]field{rangesItems},
+			map[string]operation{
+				`GET`: clubsGetOperation,
+			},
+		}),
+		newRoute(`/deleted_member/{id:id}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: deletedMemberDeleteServeHTTP,
+				`POST`:   deletedMemberPostServeHTTP,
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`DELETE`: deletedMemberDeleteOperation,
+				`POST`:   deletedMemberPostOperation,
+			},
+		}),
+		newStreamRoute(`/events`, methodMux{
+			map[string]handlerFunc{
+				`GET`: eventsGetServeHTTP,
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`GET`: eventsGetOperation,
+			},
+		}),
+		newRoute(`/mail/{subject}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: mailDeleteServeHTTP,
+				`GET`:    mailGetServeHTTP,
+				`HEAD`:   mailGetServeHTTP,
+				`PATCH`:  withBody(mediaMergePatch, mailPatchServeHTTP),
+				`PUT`:    withBody(mediaJSON, mailPutServeHTTP),
+			},
+			[]field{acceptPatch, rangesNone},
+			map[string]operation{
+				`DELETE`: mailDeleteOperation,
+				`GET`:    mailGetOperation,
+				`PATCH`:  mailPatchOperation,
+				`PUT`:    mailPutOperation,
+			},
+		}),
+		newRoute(`/mails`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  mailsGetServeHTTP,
+				`HEAD`: mailsGetServeHTTP,
+			},
+			[]field{rangesItems},
+			map[string]operation{
+				`GET`: mailsGetOperation,
+			},
+		}),
+		newRoute(`/member`, methodMux{
+			map[string]handlerFunc{
+				`GET`:   memberGetServeHTTP,
+				`HEAD`:  memberGetServeHTTP,
+				`PATCH`: withBody(mediaMergePatch, memberPatchServeHTTP),
+			},
+			[]field{acceptPatch, rangesNone},
+			map[string]operation{
+				`GET`:   userGetOperation,
+				`PATCH`: userPatchOperation,
+			},
+		}),
+		newRoute(`/member/{id:id}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: memberDeleteServeHTTP,
+				`GET`:    memberGetServeHTTP,
+				`HEAD`:   memberGetServeHTTP,
+				`PATCH`:  withBody(mediaMergePatch, memberPatchServeHTTP),
+				`PUT`:    withBody(mediaJSON, memberPutServeHTTP),
+			},
+			[]field{acceptPatch, rangesNone},
+			map[string]operation{
+				`DELETE`: memberDeleteOperation,
+				`GET`:    memberGetOperation,
+				`PATCH`:  memberPatchOperation,
+				`PUT`:    memberPutOperation,
+			},
+		}),
+		newRoute(`/member/{id:id}/history`, historyMux(db.HistoryMember)),
+		newRoute(`/members`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  membersGetServeHTTP,
+				`HEAD`: membersGetServeHTTP,
+			},
+			[]field{rangesItems},
+			map[string]operation{
+				`GET`: membersGetOperation,
+			},
+		}),
+		newRoute(`/members/mails`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  membersMailsGetServeHTTP,
+				`HEAD`: membersMailsGetServeHTTP,
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`GET`: membersMailsGetOperation,
+			},
+		}),
+		newRoute(`/officer/{id:id}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: officerDeleteServeHTTP,
+				`GET`:    officerGetServeHTTP,
+				`HEAD`:   officerGetServeHTTP,
+				`PATCH`:  withBody(mediaMergePatch, officerPatchServeHTTP),
+				`PUT`:    withBody(mediaJSON, officerPutServeHTTP),
+			},
+			[]field{acceptPatch, rangesNone},
+			map[string]operation{
+				`DELETE`: officerDeleteOperation,
+				`GET`:    officerGetOperation,
+				`PATCH`:  officerPatchOperation,
+				`PUT`:    officerPutOperation,
+			},
+		}),
+		newRoute(`/officer/{id:id}/history`, historyMux(db.HistoryOfficer)),
+		newRoute(`/officers`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  officersGetServeHTTP,
+				`HEAD`: officersGetServeHTTP,
+			},
+			[]field{rangesItems},
+			map[string]operation{
+				`GET`: officersGetOperation,
+			},
+		}),
+		newRoute(`/officers/names`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  officersNamesGetServeHTTP,
+				`HEAD`: officersNamesGetServeHTTP,
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`GET`: officersNamesGetOperation,
+			},
+		}),
+		newRoute(`/parties`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  partiesGetServeHTTP,
+				`HEAD`: partiesGetServeHTTP,
+			},
+			[]field{rangesItems},
+			map[string]operation{
+				`GET`: partiesGetOperation,
+			},
+		}),
+		newRoute(`/party/{name}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: partyDeleteServeHTTP,
+				`GET`:    partyGetServeHTTP,
+				`HEAD`:   partyGetServeHTTP,
+				`PATCH`:  withBody(mediaMergePatch, partyPatchServeHTTP),
+				`PUT`:    withBody(mediaJSON, partyPutServeHTTP),
+			},
+			[]field{acceptPatch, rangesNone},
+			map[string]operation{
+				`DELETE`: partyDeleteOperation,
+				`GET`:    partyGetOperation,
+				`PATCH`:  partyPatchOperation,
+				`PUT`:    partyPutOperation,
+			},
+		}),
+		newRoute(`/party/{name}/attendance`, methodMux{
+			map[string]handlerFunc{
+				`PUT`: withBody(mediaJSON, partyAttendancePutServeHTTP),
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`PUT`: partyAttendancePutOperation,
+			},
+		}),
+		newRoute(`/party/{name}/history`, historyMux(db.HistoryParty)),
+		newRoute(`/openapi.json`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  openAPIGetServeHTTP(&routes),
+				`HEAD`: openAPIGetServeHTTP(&routes),
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`GET`: openAPIGetOperation,
+			},
+		}),
+		newRoute(`/token`, methodMux{
+			map[string]handlerFunc{
+				`POST`: apiv0.tokenServer.serveHTTP,
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`POST`: tokenPostOperation,
+			},
+		}),
+		newRoute(`/webhook/{id:number}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: webhookDeleteServeHTTP,
+				`GET`:    webhookGetServeHTTP,
+				`HEAD`:   webhookGetServeHTTP,
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`DELETE`: webhookDeleteOperation,
+				`GET`:    webhookGetOperation,
+			},
+		}),
+		newRoute(`/webhook/{id:number}/deliveries`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  webhookDeliveriesGetServeHTTP,
+				`HEAD`: webhookDeliveriesGetServeHTTP,
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`GET`: webhookDeliveriesGetOperation,
+			},
+		}),
+		newRoute(`/webhook/{id:number}/delivery/{delivery:serial}/redelivery`, methodMux{
+			map[string]handlerFunc{
+				`POST`: webhookRedeliveryPostServeHTTP,
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`POST`: webhookRedeliveryPostOperation,
+			},
+		}),
+		newRoute(`/webhooks`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  webhooksGetServeHTTP,
+				`HEAD`: webhooksGetServeHTTP,
+				`POST`: withBody(mediaJSON, webhooksPostServeHTTP),
+			},
+			[]field{rangesNone},
+			map[string]operation{
+				`GET`:  webhooksGetOperation,
+				`POST`: webhooksPostOperation,
+			},
+		}),
+	)
 
 	return routes
 }
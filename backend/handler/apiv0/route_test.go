@@ -0,0 +1,162 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRouterSearch(t *testing.T) {
+	t.Parallel()
+
+	var mux methodMux
+
+	router := newRouter(
+		newRoute(`/member/{id:id}`, mux),
+		newRoute(`/member/{id:id}/history`, mux),
+		newRoute(`/members`, mux),
+		newRoute(`/members/mails`, mux),
+		newRoute(`/party/{name}`, mux),
+		newRoute(`/party/new`, mux),
+	)
+
+	for _, test := range [...]struct {
+		description string
+		path        string
+		template    string
+		params      routeParams
+	}{
+		{`literal`, `/members`, `/members`, nil},
+		{`nested`, `/members/mails`, `/members/mails`, nil},
+		{
+			`param`, `/member/1stDisplayID`, `/member/{id}`,
+			routeParams{{`id`, `1stDisplayID`}},
+		}, {
+			`subResource`, `/member/1stDisplayID/history`,
+			`/member/{id}/history`,
+			routeParams{{`id`, `1stDisplayID`}},
+		},
+		{`literalPrecedence`, `/party/new`, `/party/new`, nil},
+		{
+			`escaped`, `/party/a%2Fb`, `/party/{name}`,
+			routeParams{{`name`, `a/b`}},
+		},
+		{`typeMismatch`, `/member/a%20b`, ``, nil},
+		{`emptyParam`, `/member/`, ``, nil},
+		{`trailingSlash`, `/members/`, ``, nil},
+		{`afterLast`, `/zzz`, ``, nil},
+		{`beforeFirst`, `/a`, ``, nil},
+		{`root`, `/`, ``, nil},
+	} {
+		test := test
+
+		t.Run(test.description, func(t *testing.T) {
+			t.Parallel()
+
+			route, params, found := router.search(&url.URL{
+				RawPath: test.path,
+				Path:    mustUnescape(t, test.path),
+			})
+			if found != (test.template != ``) {
+				t.Fatalf(`expected found %v, got %v`, test.template != ``, found)
+			}
+
+			if route.template != test.template {
+				t.Errorf(`expected %q, got %q`, test.template, route.template)
+			}
+
+			if !reflect.DeepEqual(params, test.params) {
+				t.Errorf(`expected %v, got %v`, test.params, params)
+			}
+		})
+	}
+}
+
+func mustUnescape(t *testing.T, path string) string {
+	unescaped, err := url.PathUnescape(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return unescaped
+}
+
+func TestNewRoute(t *testing.T) {
+	t.Parallel()
+
+	for _, pattern := range [...]string{`member`, `/member/{}`, `/member/{id:unknown}`} {
+		pattern := pattern
+
+		t.Run(pattern, func(t *testing.T) {
+			t.Parallel()
+
+			defer func() {
+				if recover() == nil {
+					t.Error(`expected panicking`)
+				}
+			}()
+
+			newRoute(pattern, methodMux{})
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	var attendance Route
+	for _, route := range apiv0.Routes() {
+		if route.Template == `/party/{name}/attendance` {
+			attendance = route
+		}
+	}
+
+	expected := Route{
+		`/party/{name}/attendance`, []string{`OPTIONS`, `PUT`},
+		[]RouteParam{{`name`, `string`}},
+	}
+
+	if !reflect.DeepEqual(attendance, expected) {
+		t.Errorf(`expected %v, got %v`, expected, attendance)
+	}
+}
+
+func TestMethodMuxAllow(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
+	recorder := apiv0.serve(`POST`, `/club/prog`, member, ``)
+	testCode(t, http.StatusMethodNotAllowed, recorder)
+
+	const expected = `DELETE, GET, HEAD, OPTIONS, PATCH, PUT`
+	if result := recorder.HeaderMap.Get(`Allow`); result != expected {
+		t.Errorf(`expected %q, got %q`, expected, result)
+	}
+}
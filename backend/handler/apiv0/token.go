@@ -28,12 +28,27 @@ import (
 
 type tokenServer limiter.Limiter
 
+var tokenPostOperation = operation{
+	summary: `Issue a token with the password or a refresh token.`,
+	body: []parameter{
+		{`grant_type`, ``, &schema{Type: `string`, Enum: []string{`password`, `refresh_token`}}, true},
+		{`password`, ``, schemaString, false},
+		{`refresh_token`, ``, schemaString, false},
+		{`username`, ``, schemaString, false},
+	},
+	responses: map[int]response{
+		http.StatusOK:              jsonResponse(`The token.`, schemaRef(`Token`)),
+		http.StatusBadRequest:      errorResponse(`The grant is invalid.`),
+		http.StatusTooManyRequests: errorResponse(`Too many attempts for the user.`),
+	},
+}
+
 func newTokenServer() *tokenServer {
 	return (*tokenServer)(limiter.New())
 }
 
-func (server tokenServer) end() {
-	limiter.Limiter(server).End()
+func (server *tokenServer) end() {
+	(*limiter.Limiter)(server).End()
 }
 
 func (server *tokenServer) serveHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
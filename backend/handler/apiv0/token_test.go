@@ -1,3 +1,6 @@
+//go:build dbtest
+// +build dbtest
+
 /*
 	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
 
@@ -19,210 +22,131 @@ package apiv0
 
 import (
 	"encoding/json"
-	"fmt"
-	"github.com/kagucho/tsubonesystem3/backend/db"
-	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/context"
-	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/token/backend"
 	"net/http"
-	"net/http/httptest"
 	"regexp"
-	"strings"
 	"testing"
 )
 
-func (server Server) TestServeHTTP(t *testing.T) {
-	db, dbErr := db.Prepare()
-	if dbErr != nil {
-		t.Fatal(dbErr)
-	}
-
-	backend, backendErr := backend.New()
-	if backendErr != nil {
-		t.Fatal(backendErr)
-	}
-
-	record := func(t *testing.T, method string, body string) *httptest.ResponseRecorder {
-		recorder := httptest.NewRecorder()
-
-		request := httptest.NewRequest(
-			method, `http://kagucho.net/api/v0/token`, strings.NewReader(body))
-
-		/*
-			RFC 6749 - The OAuth 2.0 Authorization Framework
-			4.3.2.  Access Token Request
-			https://tools.ietf.org/html/rfc6749#section-4.3.2
-			4.4.2.  Access Token Request
-			https://tools.ietf.org/html/rfc6749#section-4.4.2
-			> The client makes a request to the token endpoint by
-			> adding the following parameters using the
-			> "application/x-www-form-urlencoded" format per
-			> Appendix B with a character encoding of UTF-8 in the
-			> HTTP request entity-body:
-		*/
-		request.Header.Set(`Content-Type`, `application/x-www-form-urlencoded`)
-
-		server.ServeHTTP(recorder, request,
-			context.Context{DB: db, Token: backend})
-
-		return recorder
-	}
-
-	func() {
-		defer db.Close()
-
-		for _, test := range [...]struct {
-			description   string
-			requestMethod string
-			requestBody   string
-			code          int
-			regexp        bool
-			pattern       string
-		}{
-			{
-				`invalidGrantType`, `POST`,
-				`grant_type=invalid&username=1stDisplayID&password=1stPassword`,
-				http.StatusBadRequest, false,
+func TestTokenServer(t *testing.T) {
+	t.Parallel()
 
-				/*
-					5.2.  Error Response
-					https://tools.ietf.org/html/rfc6749#section-5.2
-					> invalid_grant
-					>       The provided authorization grant
-					>       (e.g., authorization code,
-					>       resource owner credentials) or
-					>       refresh token is invalid,
-					>       expired, revoked, does not match
-					>       the redirection URI used in the
-					>       authorization request, or was
-					>       issued to another client.
-				*/
-				`{"error":"invalid_grant","error_description":"expected grant_type 'password' or 'refresh_token'","error_uri":"https://tools.ietf.org/html/rfc6749#section-5.2"}
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	/*
+		RFC 6749 - The OAuth 2.0 Authorization Framework
+		4.3.2.  Access Token Request
+		https://tools.ietf.org/html/rfc6749#section-4.3.2
+		4.4.2.  Access Token Request
+		https://tools.ietf.org/html/rfc6749#section-4.4.2
+		> The client makes a request to the token endpoint by
+		> adding the following parameters using the
+		> "application/x-www-form-urlencoded" format per
+		> Appendix B with a character encoding of UTF-8 in the
+		> HTTP request entity-body:
+	*/
+	apiv0.testRequests(t, []testRequest{
+		{
+			`invalidGrantType`, `POST`, `/token`, ``,
+			`grant_type=invalid&username=1stDisplayID&password=1stPassword`,
+			http.StatusBadRequest,
+
+			/*
+				5.2.  Error Response
+				https://tools.ietf.org/html/rfc6749#section-5.2
+				> invalid_grant
+				>       The provided authorization grant
+				>       (e.g., authorization code,
+				>       resource owner credentials) or
+				>       refresh token is invalid,
+				>       expired, revoked, does not match
+				>       the redirection URI used in the
+				>       authorization request, or was
+				>       issued to another client.
+			*/
+			`{"error":"invalid_grant","error_description":"expected grant_type 'password' or 'refresh_token'","error_uri":"https://tools.ietf.org/html/rfc6749#section-5.2"}
 `,
-			}, {
-				`invalidUsername`, `POST`,
-				`grant_type=password&password=1stPassword`,
-				http.StatusBadRequest, false,
-				`{"error":"invalid_grant","error_description":"invalid username and/or password","error_uri":"https://tools.ietf.org/html/rfc6749#section-5.2"}
+		}, {
+			`invalidUsername`, `POST`, `/token`, ``,
+			`grant_type=password&password=1stPassword`,
+			http.StatusBadRequest,
+			`{"error":"invalid_grant","error_description":"invalid username and/or password","error_uri":"https://tools.ietf.org/html/rfc6749#section-5.2"}
 `,
-			}, {
-				`invalidPassword`, `POST`,
-				`grant_type=password&username=1stDisplayID`,
-				http.StatusBadRequest, false,
-				`{"error":"invalid_grant","error_description":"invalid username and/or password","error_uri":"https://tools.ietf.org/html/rfc6749#section-5.2"}
+		}, {
+			`invalidPassword`, `POST`, `/token`, ``,
+			`grant_type=password&username=2ndDisplayID`,
+			http.StatusBadRequest,
+			`{"error":"invalid_grant","error_description":"invalid username and/or password","error_uri":"https://tools.ietf.org/html/rfc6749#section-5.2"}
 `,
-			}, {
-				/*
-					3.2.  Token Endpoint
-					https://tools.ietf.org/html/rfc6749#section-3.2
-					> The client MUST use the HTTP "POST"
-					> method when making access token
-					> requests.
-				*/
-				`invalidMethod`, `GET`, ``,
-				http.StatusMethodNotAllowed, false,
-				`{"error":"unsupported_response_type","error_description":"expected 'POST' method request","error_uri":"https://tools.ietf.org/html/rfc7231#section-6.5.5"}
+		}, {
+			/*
+				3.2.  Token Endpoint
+				https://tools.ietf.org/html/rfc6749#section-3.2
+				> The client MUST use the HTTP "POST"
+				> method when making access token
+				> requests.
+			*/
+			`invalidMethod`, `GET`, `/token`, ``, ``,
+			http.StatusMethodNotAllowed, ``,
+		}, {
+			`invalidRefresh`, `POST`, `/token`, ``,
+			`grant_type=refresh_token`, http.StatusBadRequest,
+			`{"error":"invalid_grant","error_description":"expected 3 parts, got 1 parts","error_uri":"https://tools.ietf.org/html/rfc7519#section-3.1"}
 `,
-			}, {
-				`invalidRefresh`, `POST`, `grant_type=refresh_token`,
-				http.StatusBadRequest, false,
-				`{"error":"invalid_grant","error_description":"expected 3 parts, got 1 parts","error_uri":"https://tools.ietf.org/html/rfc7519#section-3.1"}
-`,
-			}, {
-				`valid`, `POST`,
-				`grant_type=password&username=1stDisplayID&password=1stPassword`,
-				http.StatusOK, true,
-
-				/*
-					5.1.  Successful Response
-					https://tools.ietf.org/html/rfc6749#section-5.1
-					> access_token
-					>       REQUIRED.  The access token issued by the authorization server.
-
-					> refresh_token
-					>       OPTIONAL.  The refresh token,
-					>       which can be used to obtain new
-					>       access tokens using the same
-					>       authorization grant as described
-					>       in Section 6.
-				*/
-				`{"access_token":"[^"]+","refresh_token":"[^"]+","scope":"basic privacy management"}
-`,
-			},
-		} {
-			test := test
-
-			t.Run(test.description, func(t *testing.T) {
-				recorder := record(t, test.requestMethod, test.requestBody)
+		},
+	})
 
-				if test.regexp {
-					result := recorder.Body.Bytes()
-					if matched, matchErr := regexp.Match(test.pattern, result); matchErr != nil {
-						t.Error(matchErr)
-					} else if !matched {
-						t.Errorf(`invalid body; expected to match with %s, got %v`,
-							test.pattern, result)
-					}
-				} else {
-					if result := recorder.Body.String(); result != test.pattern {
-						t.Errorf(`invalid body; expected %s, got %v`,
-							test.pattern, result)
-					}
-				}
+	t.Run(`password`, func(t *testing.T) {
+		recorder := apiv0.serve(`POST`, `/token`, ``,
+			`grant_type=password&username=1stDisplayID&password=1stPassword`)
 
-				if recorder.Code != test.code {
-					t.Error(`invalid status code; expected `, test.code,
-						`, got `, recorder.Code)
-				}
-			})
-		}
+		testCode(t, http.StatusOK, recorder)
 
 		/*
-			4.3.2.  Access Token Request
-			https://tools.ietf.org/html/rfc6749#section-4.3.2
-			> Since this access token request utilizes the resource
-			> owner's password, the authorization server MUST
-			> protect the endpoint against brute force attacks
-			> (e.g., using rate-limitation or generating alerts).
-		*/
-		t.Run(`limit`, func(t *testing.T) {
-			for count := 0; count <= 4; count++ {
-				recorder := record(t, `POST`, `grant_type=password&username=limit`)
-
-				const expectedBody = `{"error":"invalid_grant","error_description":"invalid username and/or password","error_uri":"https://tools.ietf.org/html/rfc6749#section-5.2"}
-`
-				if result := recorder.Body.String(); result != expectedBody {
-					t.Error(`invalid body; expected `, expectedBody,
-						`, got `, result)
-				}
+			5.1.  Successful Response
+			https://tools.ietf.org/html/rfc6749#section-5.1
+			> access_token
+			>       REQUIRED.  The access token issued by the authorization server.
 
-				if recorder.Code != http.StatusBadRequest {
-					t.Error(`invalid status code; expected `, http.StatusBadRequest,
-						`, got `, recorder.Code)
-				}
-			}
-
-			recorder := record(t, `POST`, `grant_type=password&username=limit`)
+			> refresh_token
+			>       OPTIONAL.  The refresh token,
+			>       which can be used to obtain new
+			>       access tokens using the same
+			>       authorization grant as described
+			>       in Section 6.
+		*/
+		const pattern = `^{"access_token":"[^"]+","refresh_token":"[^"]+","scope":"management member privacy user"}
+$`
+		if result := recorder.Body.Bytes(); !regexp.MustCompile(pattern).Match(result) {
+			t.Errorf(`expected to match with %s, got %s`, pattern, result)
+		}
+	})
 
-			const expectedBody = `{"error":"too_many_requests","error_description":"Too Many Requests","error_uri":"https://tools.ietf.org/html/rfc6585#section-4"}
-`
-			if result := recorder.Body.String(); result != expectedBody {
-				t.Error(`invalid body; expected `, expectedBody,
-					`, got `, result)
-			}
+	/*
+		4.3.2.  Access Token Request
+		https://tools.ietf.org/html/rfc6749#section-4.3.2
+		> Since this access token request utilizes the resource
+		> owner's password, the authorization server MUST
+		> protect the endpoint against brute force attacks
+		> (e.g., using rate-limitation or generating alerts).
+	*/
+	t.Run(`limit`, func(t *testing.T) {
+		for count := 0; count <= 4; count++ {
+			recorder := apiv0.serve(`POST`, `/token`, ``,
+				`grant_type=password&username=limit`)
+
+			testCode(t, http.StatusBadRequest, recorder)
+		}
 
-			if recorder.Code != http.StatusTooManyRequests {
-				t.Error(`invalid status code; expected `, http.StatusTooManyRequests,
-					`, got `, recorder.Code)
-			}
-		})
-	}()
+		testCode(t, http.StatusTooManyRequests,
+			apiv0.serve(`POST`, `/token`, ``,
+				`grant_type=password&username=limit`))
+	})
 
 	t.Run(`refresh`, func(t *testing.T) {
-		t.Parallel()
-
-		refreshToken, tokenErr := backend.IssueRefresh(`1stDisplayID`, `basic`)
-		if tokenErr != nil {
-			t.Fatal(tokenErr)
+		refreshToken, err := apiv0.shared.Token.IssueRefresh(`1stDisplayID`, `user`)
+		if err != nil {
+			t.Fatal(err)
 		}
 
 		/*
@@ -234,66 +158,32 @@ func (server Server) TestServeHTTP(t *testing.T) {
 			> refresh_token
 			>       REQUIRED.  The refresh token issued to the client.
 		*/
-		recorder := record(t, `POST`,
-			fmt.Sprint(`grant_type=refresh_token&refresh_token=`,
-				refreshToken))
-
-		var response response
-		decoder := json.NewDecoder(recorder.Body)
-		if decodeErr := decoder.Decode(&response); decodeErr != nil {
-			t.Error(decodeErr)
-		} else if response.RefreshToken != `` {
-			t.Errorf(`invalid refresh_token; expected empty "", got %q`,
-				response.RefreshToken)
-		}
-	})
+		recorder := apiv0.serve(`POST`, `/token`, ``,
+			`grant_type=refresh_token&refresh_token=`+refreshToken)
 
-	t.Run(`expiringRefresh`, func(t *testing.T) {
-		t.Parallel()
+		testCode(t, http.StatusOK, recorder)
 
-		// TODO
-		/*
-			refreshToken, tokenError := server.refresh.Issue(
-				`1stDisplayID`, `basic`, 4398046511103)
-			if tokenError != nil {
-				t.Fatal(tokenError)
-			}
-
-			recorder := record(t, `POST`,
-				fmt.Sprint(`grant_type=refresh_token&refresh_token=`,
-					refreshToken))
+		var response struct {
+			AccessToken  string `json:"access_token"`
+			RefreshToken string `json:"refresh_token"`
+			Scope        string `json:"scope"`
+		}
 
-			var response response
-			decoder := json.NewDecoder(recorder.Body)
-			if decodeError := decoder.Decode(&response); decodeError != nil {
-				t.Error(decodeError)
-			} else if response.RefreshToken == `` {
-				t.Error(`expected refresh_token, got empty ""`)
-			}
-		*/
-	})
+		if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
 
-	t.Run(`internalServerError`, func(t *testing.T) {
-		t.Parallel()
+		if response.AccessToken == `` {
+			t.Error(`expected access_token, got empty ""`)
+		}
 
-		recorder := record(t, `POST`, `grant_type=password&username=1stDisplayID`)
+		if response.RefreshToken != `` {
+			t.Errorf(`expected empty refresh_token, got %q`,
+				response.RefreshToken)
+		}
 
-		if recorder.Code != http.StatusInternalServerError {
-			t.Error(`invalid status code; expected `, http.StatusInternalServerError,
-				`, got `, recorder.Code)
+		if response.Scope != `user` {
+			t.Errorf(`expected "user", got %q`, response.Scope)
 		}
 	})
 }
-
-func TestServer(t *testing.T) {
-	t.Parallel()
-
-	var server Server
-	if !t.Run(`New`, func(t *testing.T) {
-		server = New()
-	}) {
-		t.FailNow()
-	}
-
-	t.Run(`ServeHTTP`, server.TestServeHTTP)
-}
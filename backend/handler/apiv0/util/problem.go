@@ -0,0 +1,85 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+/*
+InvalidParam is a structure describing an invalid parameter of a request.
+
+RFC 7807 - Problem Details for HTTP APIs
+3.  The Problem Details JSON Object
+https://tools.ietf.org/html/rfc7807#section-3
+> "invalid-params": [ {
+>                     "name": "age",
+>                     "reason": "must be a positive integer"
+>                   },
+*/
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+/*
+Problem is a structure to hold a problem to serve.
+
+The JSON-encoded structure is conforming to RFC 7807.
+
+RFC 7807 - Problem Details for HTTP APIs
+3.1.  Members of a Problem Details Object
+https://tools.ietf.org/html/rfc7807#section-3.1
+*/
+type Problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+}
+
+/*
+ServeProblem serves a problem according to the given arguments. Type and Title
+default to the ones for the status code.
+*/
+func ServeProblem(writer http.ResponseWriter, problem Problem, code int) {
+	if problem.Type == `` {
+		problem.Type = statusError[code].uri
+		if problem.Type == `` {
+			problem.Type = `about:blank`
+		}
+	}
+
+	if problem.Title == `` {
+		problem.Title = http.StatusText(code)
+	}
+
+	problem.Status = code
+
+	writer.Header().Set(`Content-Type`, `application/problem+json`)
+	writer.WriteHeader(code)
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(problem); err != nil {
+		panic(err)
+	}
+}
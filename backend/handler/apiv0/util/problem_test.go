@@ -0,0 +1,89 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeProblem(t *testing.T) {
+	/*
+		RFC 7807 - Problem Details for HTTP APIs
+		6.1.  application/problem+json
+		https://tools.ietf.org/html/rfc7807#section-6.1
+	*/
+	const contentType = `application/problem+json`
+
+	t.Parallel()
+
+	for _, test := range [...]struct {
+		description string
+		problem     Problem
+		code        int
+		body        string
+	}{
+		{
+			`invalidParams`,
+			Problem{
+				Detail: `some fields are invalid`,
+				InvalidParams: []InvalidParam{
+					{`start`, `syntax_error`},
+					{`place`, `too_long`},
+				},
+			},
+			http.StatusBadRequest,
+			`{"type":"https://tools.ietf.org/html/rfc7231#section-6.5.1","title":"Bad Request","status":400,"detail":"some fields are invalid","invalid-params":[{"name":"start","reason":"syntax_error"},{"name":"place","reason":"too_long"}]}
+`,
+		}, {
+			/*
+				4.2.  Predefined Problem Types
+				https://tools.ietf.org/html/rfc7807#section-4.2
+
+				> When "about:blank" is used, the title SHOULD
+				> be the same as the HTTP status phrase for that
+				> code
+			*/
+			`aboutBlank`, Problem{}, http.StatusConflict,
+			`{"type":"about:blank","title":"Conflict","status":409}
+`,
+		},
+	} {
+		test := test
+
+		t.Run(test.description, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			ServeProblem(recorder, test.problem, test.code)
+
+			if recorder.Code != test.code {
+				t.Errorf(`expected %v, got %v`, test.code, recorder.Code)
+			}
+
+			if result := recorder.HeaderMap.Get(`Content-Type`); result != contentType {
+				t.Errorf(`expected %q, got %q`, contentType, result)
+			}
+
+			if result := recorder.Body.String(); result != test.body {
+				t.Errorf(`expected %q, got %q`, test.body, result)
+			}
+		})
+	}
+}
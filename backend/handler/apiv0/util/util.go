@@ -53,6 +53,18 @@ var statusError = map[int]struct {
 		`unsupported_response_type`,
 		`https://tools.ietf.org/html/rfc7231#section-6.5.5`,
 	},
+	http.StatusPreconditionFailed: {
+		`precondition_failed`,
+		`https://tools.ietf.org/html/rfc7232#section-4.2`,
+	},
+	http.StatusUnsupportedMediaType: {
+		`unsupported_media_type`,
+		`https://tools.ietf.org/html/rfc7231#section-6.5.13`,
+	},
+	http.StatusRequestedRangeNotSatisfiable: {
+		`range_not_satisfiable`,
+		`https://tools.ietf.org/html/rfc7233#section-4.4`,
+	},
 	http.StatusInternalServerError: {
 		`server_error`,
 		`https://tools.ietf.org/html/rfc7231#section-6.6.1`,
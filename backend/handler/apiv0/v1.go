@@ -0,0 +1,434 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"bytes"
+	"context"
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
+	"github.com/kagucho/tsubonesystem3/backend/scope"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+/*
+API v1 differs from API v0 in the following points:
+Collections are plural and their members are under them, like /clubs/{id}.
+Emails and parties are identified with numeric IDs instead of their subjects
+and names, which may change.
+Resources are created with POST to the collections, which responds with 201
+Created, Location header field and the ID.
+Successful updates and deletions respond with 204 No Content.
+Pages are wrapped in pageEnvelope.
+
+The handlers of API v0 serve API v1 with the wrappers in this file.
+*/
+
+/*
+v1Locate is a function returning the ID of the resource created with the given
+request, and the reference to it relative to the collection.
+*/
+type v1Locate func(request *http.Request, shared shared) (interface{}, string, error)
+
+/*
+v1Writer is http.ResponseWriter holding the status code and the body until the
+handler returns.
+*/
+type v1Writer struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+// v1MailEntry is db.MailEntry with its ID exposed.
+type v1MailEntry struct {
+	db.MailEntry
+	ID uint16 `json:"id"`
+}
+
+// v1PartyUser is db.PartyUser with the ID of the party exposed.
+type v1PartyUser struct {
+	db.PartyUser
+	ID uint16 `json:"id"`
+}
+
+func (writer *v1Writer) Header() http.Header {
+	return writer.header
+}
+
+func (writer *v1Writer) Write(data []byte) (int, error) {
+	if writer.code == 0 {
+		writer.code = http.StatusOK
+	}
+
+	return writer.body.Write(data)
+}
+
+func (writer *v1Writer) WriteHeader(code int) {
+	writer.code = code
+}
+
+/*
+withV1Result returns handlerFunc which calls next and replaces the empty object
+next serves on success. 200 OK results in 204 No Content, and 201 Created
+results in the ID and Location header field given by locate. Other responses
+are kept as is.
+*/
+func withV1Result(next handlerFunc, locate v1Locate) handlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request, shared shared) {
+		held := v1Writer{header: writer.Header()}
+		next(&held, request, shared)
+
+		if held.body.String() == "{}\n" {
+			switch held.code {
+			case http.StatusOK:
+				writer.Header().Del(`Content-Type`)
+				writer.WriteHeader(http.StatusNoContent)
+				return
+
+			case http.StatusCreated:
+				id, location, err := locate(request, shared)
+				if err != nil {
+					panic(err)
+				}
+
+				/*
+					RFC 7231 - Hypertext Transfer Protocol (HTTP/1.1): Semantics and Content
+					7.1.2.  Location
+					https://tools.ietf.org/html/rfc7231#section-7.1.2
+					> If the Location value is a relative reference, the final value is
+					> computed by resolving it against the effective request URI
+				*/
+				writer.Header().Set(`Location`, location)
+				util.ServeJSON(writer,
+					struct {
+						ID interface{} `json:"id"`
+					}{id}, http.StatusCreated)
+
+				return
+			}
+		}
+
+		if held.code != 0 {
+			writer.WriteHeader(held.code)
+		}
+
+		if _, err := writer.Write(held.body.Bytes()); err != nil {
+			panic(err)
+		}
+	}
+}
+
+/*
+withBodyKey returns handlerFunc which calls next with the path parameter of the
+given name taken from the body. It lets a handler of API v0 creating a resource
+identified by the path serve POST to a collection.
+*/
+func withBodyKey(name string, next handlerFunc) handlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request, shared shared) {
+		params := append(routeParams{{name, request.PostFormValue(name)}},
+			requestParams(request)...)
+
+		next(writer, withRouteParams(request, params), shared)
+	}
+}
+
+/*
+withResolved returns handlerFunc which resolves the numeric ID given as the
+path parameter id with resolve and calls next with the result as the path
+parameter of the given name. The result precedes the other parameters so that
+it is the target. It serves 404 Not Found if the ID is incorrect.
+*/
+func withResolved(name string, resolve func(db.Store, context.Context, uint16) (string, error), next handlerFunc) handlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request, shared shared) {
+		id, err := strconv.ParseUint(pathParam(request, `id`), 10, 16)
+		if err != nil {
+			panic(err)
+		}
+
+		switch resolved, err := resolve(shared.DB, request.Context(), uint16(id)); err {
+		case db.ErrIncorrectIdentity:
+			util.ServeErrorDefault(writer, http.StatusNotFound)
+
+		case nil:
+			params := append(routeParams{{name, resolved}},
+				requestParams(request)...)
+
+			next(writer, withRouteParams(request, params), shared)
+
+		default:
+			panic(err)
+		}
+	}
+}
+
+// withMail returns handlerFunc calling next with the subject of the email.
+func withMail(next handlerFunc) handlerFunc {
+	return withResolved(`subject`, db.Store.QueryMailSubject, next)
+}
+
+// withParty returns handlerFunc calling next with the name of the party.
+func withParty(next handlerFunc) handlerFunc {
+	return withResolved(`name`, db.Store.QueryPartyName, next)
+}
+
+/*
+v1LocateParam returns v1Locate telling the resource is identified by the path
+parameter of the given name in the given collection.
+*/
+func v1LocateParam(collection, name string) v1Locate {
+	return func(request *http.Request, shared shared) (interface{}, string, error) {
+		id := pathParam(request, name)
+		return id, collection + `/` + url.PathEscape(id), nil
+	}
+}
+
+/*
+v1LocateInternal returns v1Locate telling the resource is identified by the
+internal ID queried with the path parameter of the given name.
+*/
+func v1LocateInternal(collection, name string, query func(db.Store, context.Context, string) (uint16, error)) v1Locate {
+	return func(request *http.Request, shared shared) (interface{}, string, error) {
+		id, err := query(shared.DB, request.Context(), pathParam(request, name))
+		return id, collection + `/` + strconv.FormatUint(uint64(id), 10), err
+	}
+}
+
+func v1MailsGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	if (authorize(writer, request, shared, scope.Member) == claim{}) {
+		return
+	}
+
+	serveSoughtPage(writer, request, func(after string, limit int) (pageNext, bool) {
+		afterID, known := v1PageAfter(after)
+		if !known {
+			return nil, false
+		}
+
+		mails := shared.DB.QueryMails(request.Context(), afterID, limit)
+
+		return func() (interface{}, string, error, bool) {
+			result, present := <-mails
+			return v1MailEntry{result.MailEntry, result.ID},
+				strconv.FormatUint(uint64(result.ID), 10),
+				result.Error, present
+		}, true
+	})
+}
+
+func v1PartiesGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	authorized := authorize(writer, request, shared, scope.Member)
+	if (authorized == claim{}) {
+		return
+	}
+
+	serveSoughtPage(writer, request, func(after string, limit int) (pageNext, bool) {
+		afterID, known := v1PageAfter(after)
+		if !known {
+			return nil, false
+		}
+
+		parties := shared.DB.QueryParties(request.Context(),
+			authorized.sub, afterID, limit)
+
+		return func() (interface{}, string, error, bool) {
+			result, present := <-parties
+			return v1PartyUser{result.PartyUser, result.ID},
+				strconv.FormatUint(uint64(result.ID), 10),
+				result.Error, present
+		}, true
+	})
+}
+
+/*
+v1PageAfter returns the internal ID represented by the given cursor of API v1,
+which is 0 if the cursor is empty. The returned Boolean is false if the cursor
+is malformed.
+*/
+func v1PageAfter(after string) (uint16, bool) {
+	if after == `` {
+		return 0, true
+	}
+
+	id, err := strconv.ParseUint(after, 10, 16)
+	return uint16(id), err == nil && id != 0
+}
+
+func (apiv0 APIv0) newV1Routes() router {
+	acceptPatch := field{`Accept-Patch`, mediaForm + `, ` + mediaMergePatch}
+	rangesNone := field{`Accept-Ranges`, `none`}
+	rangesItems := field{`Accept-Ranges`, `items`}
+
+	return newRouter(
+		newRoute(`/archived_members/{id:id}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: withV1Result(deletedMemberDeleteServeHTTP, nil),
+			},
+			[]field{rangesNone},
+			nil,
+		}),
+		newRoute(`/archived_members/{id:id}/restoration`, methodMux{
+			map[string]handlerFunc{
+				`POST`: withV1Result(deletedMemberPostServeHTTP, nil),
+			},
+			[]field{rangesNone},
+			nil,
+		}),
+		newRoute(`/clubs`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  withEnvelope(clubsGetServeHTTP),
+				`HEAD`: withEnvelope(clubsGetServeHTTP),
+				`POST`: withBody(mediaJSON, withBodyKey(`id`,
+					withV1Result(clubPutServeHTTP, v1LocateParam(`clubs`, `id`)))),
+			},
+			[]field{rangesItems},
+			nil,
+		}),
+		newRoute(`/clubs/{id:id}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: withV1Result(clubDeleteServeHTTP, nil),
+				`GET`:    clubGetServeHTTP,
+				`HEAD`:   clubGetServeHTTP,
+				`PATCH`:  withBody(mediaMergePatch, withV1Result(clubPatchServeHTTP, nil)),
+			},
+			[]field{acceptPatch, rangesNone},
+			nil,
+		}),
+		newRoute(`/clubs/{id:id}/history`, historyMux(db.HistoryClub)),
+		newRoute(`/mails`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  withEnvelope(v1MailsGetServeHTTP),
+				`HEAD`: withEnvelope(v1MailsGetServeHTTP),
+				`POST`: withBody(mediaJSON, withBodyKey(`subject`,
+					withV1Result(mailPutServeHTTP,
+						v1LocateInternal(`mails`, `subject`, db.Store.QueryMailID)))),
+			},
+			[]field{rangesItems},
+			nil,
+		}),
+		newRoute(`/mails/{id:number}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: withMail(withV1Result(mailDeleteServeHTTP, nil)),
+				`GET`:    withMail(mailGetServeHTTP),
+				`HEAD`:   withMail(mailGetServeHTTP),
+				`PATCH`: withBody(mediaMergePatch,
+					withMail(withV1Result(mailPatchServeHTTP, nil))),
+			},
+			[]field{acceptPatch, rangesNone},
+			nil,
+		}),
+		newRoute(`/members`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  withEnvelope(membersGetServeHTTP),
+				`HEAD`: withEnvelope(membersGetServeHTTP),
+				`POST`: withBody(mediaJSON, withBodyKey(`id`,
+					withV1Result(memberPutServeHTTP, v1LocateParam(`members`, `id`)))),
+			},
+			[]field{rangesItems},
+			nil,
+		}),
+		newRoute(`/members/{id:id}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: withV1Result(memberDeleteServeHTTP, nil),
+				`GET`:    memberGetServeHTTP,
+				`HEAD`:   memberGetServeHTTP,
+				`PATCH`:  withBody(mediaMergePatch, withV1Result(memberPatchServeHTTP, nil)),
+			},
+			[]field{acceptPatch, rangesNone},
+			nil,
+		}),
+		newRoute(`/members/{id:id}/history`, historyMux(db.HistoryMember)),
+		newRoute(`/officers`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  withEnvelope(officersGetServeHTTP),
+				`HEAD`: withEnvelope(officersGetServeHTTP),
+				`POST`: withBody(mediaJSON, withBodyKey(`id`,
+					withV1Result(officerPutServeHTTP, v1LocateParam(`officers`, `id`)))),
+			},
+			[]field{rangesItems},
+			nil,
+		}),
+		newRoute(`/officers/{id:id}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: withV1Result(officerDeleteServeHTTP, nil),
+				`GET`:    officerGetServeHTTP,
+				`HEAD`:   officerGetServeHTTP,
+				`PATCH`:  withBody(mediaMergePatch, withV1Result(officerPatchServeHTTP, nil)),
+			},
+			[]field{acceptPatch, rangesNone},
+			nil,
+		}),
+		newRoute(`/officers/{id:id}/history`, historyMux(db.HistoryOfficer)),
+		newRoute(`/parties`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  withEnvelope(v1PartiesGetServeHTTP),
+				`HEAD`: withEnvelope(v1PartiesGetServeHTTP),
+				`POST`: withBody(mediaJSON, withBodyKey(`name`,
+					withV1Result(partyPutServeHTTP,
+						v1LocateInternal(`parties`, `name`, db.Store.QueryPartyID)))),
+			},
+			[]field{rangesItems},
+			nil,
+		}),
+		newRoute(`/parties/{id:number}`, methodMux{
+			map[string]handlerFunc{
+				`DELETE`: withParty(withV1Result(partyDeleteServeHTTP, nil)),
+				`GET`:    withParty(partyGetServeHTTP),
+				`HEAD`:   withParty(partyGetServeHTTP),
+				`PATCH`: withBody(mediaMergePatch,
+					withParty(withV1Result(partyPatchServeHTTP, nil))),
+			},
+			[]field{acceptPatch, rangesNone},
+			nil,
+		}),
+		newRoute(`/parties/{id:number}/attendance`, methodMux{
+			map[string]handlerFunc{
+				`PUT`: withBody(mediaJSON,
+					withParty(withV1Result(partyAttendancePutServeHTTP, nil))),
+			},
+			[]field{rangesNone},
+			nil,
+		}),
+		newRoute(`/parties/{id:number}/history`, methodMux{
+			map[string]handlerFunc{
+				`GET`:  withParty(historyMux(db.HistoryParty).handlers[`GET`]),
+				`HEAD`: withParty(historyMux(db.HistoryParty).handlers[`HEAD`]),
+			},
+			[]field{rangesNone},
+			nil,
+		}),
+		newRoute(`/token`, methodMux{
+			map[string]handlerFunc{
+				`POST`: apiv0.tokenServer.serveHTTP,
+			},
+			[]field{rangesNone},
+			nil,
+		}),
+		newRoute(`/user`, methodMux{
+			map[string]handlerFunc{
+				`GET`:   memberGetServeHTTP,
+				`HEAD`:  memberGetServeHTTP,
+				`PATCH`: withBody(mediaMergePatch, withV1Result(memberPatchServeHTTP, nil)),
+			},
+			[]field{acceptPatch, rangesNone},
+			nil,
+		}),
+	)
+}
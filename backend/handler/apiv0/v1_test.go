@@ -0,0 +1,205 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"net/http"
+	"testing"
+)
+
+/*
+testV1Request is testRequest for API v1 with the expected Location header
+field, which is not compared if it is empty.
+*/
+type testV1Request struct {
+	testRequest
+	location string
+}
+
+// testV1Requests serves the given requests with API v1 in order.
+func (apiv0 testAPIv0) testV1Requests(t *testing.T, tests []testV1Request) {
+	v1 := apiv0.V1()
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := testServe(v1, test.method, test.path,
+				test.authorization, test.body)
+
+			testCode(t, test.code, recorder)
+
+			if test.response != `` {
+				testBody(t, test.response, recorder)
+			}
+
+			if result := recorder.HeaderMap.Get(`Location`); result != test.location {
+				t.Errorf(`expected Location %q, got %q`,
+					test.location, result)
+			}
+		})
+	}
+}
+
+func TestV1(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
+	management := apiv0.authorization(t, `1stDisplayID`, `management member`)
+
+	apiv0.testV1Requests(t, []testV1Request{
+		{testRequest{
+			`clubs`, `GET`, `/clubs?limit=1`, ``, ``, http.StatusOK,
+			`{"items":[{"name":"Prog部","chief":"2ndDisplayID","id":"prog","members":["1stDisplayID","2ndDisplayID"]}],"next":"/clubs?after=prog&limit=1"}
+`,
+		}, ``}, {testRequest{
+			`clubPost`, `POST`, `/clubs`, management,
+			`id=sports&name=Sports部&chief=3rdDisplayID`,
+			http.StatusCreated, `{"id":"sports"}
+`,
+		}, `clubs/sports`}, {testRequest{
+			`clubPostDuplicate`, `POST`, `/clubs`, management,
+			`id=sports&name=Sports部&chief=3rdDisplayID`,
+			http.StatusUnprocessableEntity, ``,
+		}, ``}, {testRequest{
+			`clubPatch`, `PATCH`, `/clubs/sports`, management,
+			`name=Sports部2`, http.StatusNoContent, ``,
+		}, ``}, {testRequest{
+			`clubGet`, `GET`, `/clubs/sports`, member, ``, http.StatusOK,
+			`{"name":"Sports部2","chief":"3rdDisplayID","members":[]}
+`,
+		}, ``}, {testRequest{
+			`clubDelete`, `DELETE`, `/clubs/sports`, management, ``,
+			http.StatusNoContent, ``,
+		}, ``}, {testRequest{
+			`clubDeleted`, `GET`, `/clubs/sports`, member, ``,
+			http.StatusNotFound, ``,
+		}, ``}, {testRequest{
+			`partyPost`, `POST`, `/parties`, member,
+			`name=party&start=1500000000&end=1500003600&place=place&due=1499990000&invited_ids=3rdDisplayID&inviteds=inviteds&details=details`,
+			http.StatusCreated, `{"id":1}
+`,
+		}, `parties/1`}, {testRequest{
+			`partyGet`, `GET`, `/parties/1`, member, ``,
+			http.StatusOK, ``,
+		}, ``}, {testRequest{
+			`partyGetNotFound`, `GET`, `/parties/2`, member, ``,
+			http.StatusNotFound, ``,
+		}, ``}, {testRequest{
+			`partyGetInvalid`, `GET`, `/parties/party`, member, ``,
+			http.StatusNotFound, ``,
+		}, ``}, {testRequest{
+			`attendance`, `PUT`, `/parties/1/attendance`, member,
+			`attending=1`, http.StatusNoContent, ``,
+		}, ``}, {testRequest{
+			`partyDelete`, `DELETE`, `/parties/1`, member, ``,
+			http.StatusNoContent, ``,
+		}, ``}, {testRequest{
+			`partyDeleted`, `GET`, `/parties/1`, member, ``,
+			http.StatusNotFound, ``,
+		}, ``},
+	})
+}
+
+// TestV1Compatibility tests resources are shared by API v0 and API v1.
+func TestV1Compatibility(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
+	management := apiv0.authorization(t, `1stDisplayID`, `management member`)
+	v1 := apiv0.V1()
+
+	testCode(t, http.StatusCreated,
+		apiv0.serve(`PUT`, `/mail/v0`, member,
+			`recipients=1stDisplayID&to=to&body=body`))
+
+	recorder := testServe(v1, `POST`, `/mails`, member,
+		`subject=v1&recipients=1stDisplayID&to=to&body=body`)
+	testCode(t, http.StatusCreated, recorder)
+	testBody(t, `{"id":2}
+`, recorder)
+
+	for _, test := range [...]struct {
+		description string
+		v0          string
+		v1          string
+	}{
+		{`v0`, `/mail/v0`, `/mails/1`},
+		{`v1`, `/mail/v1`, `/mails/2`},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			v0Recorder := apiv0.serve(`GET`, test.v0, member, ``)
+			testCode(t, http.StatusOK, v0Recorder)
+
+			v1Recorder := testServe(v1, `GET`, test.v1, member, ``)
+			testCode(t, http.StatusOK, v1Recorder)
+
+			testBody(t, v0Recorder.Body.String(), v1Recorder)
+		})
+	}
+
+	t.Run(`list`, func(t *testing.T) {
+		recorder := apiv0.serve(`GET`, `/mails`, member, ``)
+		testCode(t, http.StatusOK, recorder)
+
+		if body := recorder.Body.String(); body[0] != '[' {
+			t.Errorf(`expected an array, got %s`, body)
+		}
+
+		recorder = testServe(v1, `GET`, `/mails?limit=1`, member, ``)
+		testCode(t, http.StatusOK, recorder)
+
+		if result := recorder.HeaderMap.Get(`Link`); result != `</mails?after=1&limit=1>; rel="next"` {
+			t.Errorf(`unexpected Link %q`, result)
+		}
+
+		recorder = testServe(v1, `GET`, `/mails?after=1&limit=1`, member, ``)
+		testCode(t, http.StatusOK, recorder)
+
+		if result := recorder.HeaderMap.Get(`Link`); result != `` {
+			t.Errorf(`unexpected Link %q`, result)
+		}
+
+		testCode(t, http.StatusBadRequest,
+			testServe(v1, `GET`, `/mails?after=v0`, member, ``))
+
+		testCode(t, http.StatusBadRequest,
+			apiv0.serve(`GET`, `/mails?after=unknown`, member, ``))
+	})
+
+	t.Run(`update`, func(t *testing.T) {
+		testCode(t, http.StatusNoContent,
+			testServe(v1, `PATCH`, `/mails/1`, management, `body=updated`))
+
+		testCode(t, http.StatusOK,
+			apiv0.serve(`PATCH`, `/mail/v1`, management, `body=updated`))
+
+		testCode(t, http.StatusOK,
+			apiv0.serve(`DELETE`, `/mail/v0`, management, ``))
+
+		testCode(t, http.StatusNotFound,
+			testServe(v1, `GET`, `/mails/1`, member, ``))
+	})
+}
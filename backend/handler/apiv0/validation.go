@@ -0,0 +1,198 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
+	"github.com/kagucho/tsubonesystem3/backend/mail"
+	"net/http"
+	"strconv"
+)
+
+// The reasons telling why fields are invalid.
+const (
+	reasonInvalid    = `invalid`
+	reasonOutOfRange = `out_of_range`
+	reasonRequired   = `required`
+	reasonSyntax     = `syntax_error`
+	reasonTooLong    = `too_long`
+)
+
+/*
+validation is a structure collecting the invalid fields of a request so that
+all of them are reported at once.
+*/
+type validation struct {
+	params []util.InvalidParam
+	syntax bool
+}
+
+// invalid records the field with the given name is invalid for the reason.
+func (validation *validation) invalid(name, reason string) {
+	validation.params = append(validation.params,
+		util.InvalidParam{Name: name, Reason: reason})
+
+	if reason == reasonSyntax {
+		validation.syntax = true
+	}
+}
+
+/*
+require records the field with the given name is missing if the given value is
+empty. It returns whether the value is present.
+*/
+func (validation *validation) require(name, value string) bool {
+	if value == `` {
+		validation.invalid(name, reasonRequired)
+		return false
+	}
+
+	return true
+}
+
+/*
+property validates the given value of the field with the given name as the
+given property. An empty value is regarded as omitted and valid.
+*/
+func (validation *validation) property(name, value string, property db.Property) {
+	if value == `` {
+		return
+	}
+
+	if property.TooLong(value) {
+		validation.invalid(name, reasonTooLong)
+	} else if !property.Valid(value) {
+		validation.invalid(name, reasonInvalid)
+	}
+}
+
+/*
+number records the field with the given name is invalid for the given error of
+strconv.
+*/
+func (validation *validation) number(name string, err error) {
+	if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+		validation.invalid(name, reasonOutOfRange)
+	} else {
+		validation.invalid(name, reasonSyntax)
+	}
+}
+
+/*
+time parses the given value of the field with the given name as
+encoding.Time. An empty value results in the zero value.
+*/
+func (validation *validation) time(name, value string) encoding.Time {
+	if value == `` {
+		return encoding.Time{}
+	}
+
+	parsed, err := encoding.ParseQueryTime(value)
+	if err != nil {
+		validation.number(name, err)
+	}
+
+	return parsed
+}
+
+/*
+entrance parses the given value of the field with the given name as an
+entrance year. An empty value results in 0, which tells the year is unknown.
+*/
+func (validation *validation) entrance(name, value string) int {
+	if value == `` {
+		return 0
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		validation.number(name, err)
+		return 0
+	}
+
+	if parsed != 0 && (parsed < db.EntranceMin || parsed > db.EntranceMax) {
+		validation.invalid(name, reasonOutOfRange)
+	}
+
+	return parsed
+}
+
+/*
+mail converts the given value of the field with the given name to an email
+address encoded in ASCII and validates it. An empty value is regarded as
+omitted and valid.
+*/
+func (validation *validation) mail(name, value string) string {
+	if value == `` {
+		return ``
+	}
+
+	address, err := mail.AddressToASCII(value)
+	if err != nil {
+		validation.invalid(name, reasonSyntax)
+		return ``
+	}
+
+	validation.property(name, address, db.PropertyMail)
+
+	return address
+}
+
+/*
+flag parses the given value of the field with the given name, which should be
+0 or 1. An empty value results in false.
+*/
+func (validation *validation) flag(name, value string) bool {
+	switch value {
+	case ``, `0`:
+		return false
+
+	case `1`:
+		return true
+
+	default:
+		validation.invalid(name, reasonSyntax)
+		return false
+	}
+}
+
+/*
+serve serves the collected invalid fields as a problem and returns true if
+there are any. The status code is 400 Bad Request if some of them have syntax
+errors, and 422 Unprocessable Entity otherwise.
+*/
+func (validation *validation) serve(writer http.ResponseWriter) bool {
+	if validation.params == nil {
+		return false
+	}
+
+	status := http.StatusUnprocessableEntity
+	if validation.syntax {
+		status = http.StatusBadRequest
+	}
+
+	util.ServeProblem(writer,
+		util.Problem{
+			Detail:        `some fields are invalid`,
+			InvalidParams: validation.params,
+		}, status)
+
+	return true
+}
@@ -0,0 +1,66 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidation(t *testing.T) {
+	t.Parallel()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	user := apiv0.authorization(t, `3rdDisplayID`, `user member`)
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
+	management := apiv0.authorization(t, `1stDisplayID`, `management member`)
+	long := strings.Repeat(`a`, 64)
+
+	apiv0.testRequests(t, []testRequest{
+		{
+			`partyPut`, `PUT`, `/party/party`, member,
+			`start=start&end=99999999999999999999&place=` + long + `&invited_ids=3rdDisplayID`,
+			http.StatusBadRequest,
+			`{"type":"https://tools.ietf.org/html/rfc7231#section-6.5.1","title":"Bad Request","status":400,"detail":"some fields are invalid","invalid-params":[{"name":"start","reason":"syntax_error"},{"name":"end","reason":"out_of_range"},{"name":"place","reason":"too_long"},{"name":"due","reason":"required"},{"name":"inviteds","reason":"required"},{"name":"details","reason":"required"}]}
+`,
+		}, {
+			`clubPut`, `PUT`, `/club/sports`, management,
+			`name=` + long + `&chief=%22`,
+			http.StatusUnprocessableEntity,
+			`{"type":"https://tools.ietf.org/html/rfc4918#section-11.2","title":"Unprocessable Entity","status":422,"detail":"some fields are invalid","invalid-params":[{"name":"name","reason":"too_long"},{"name":"chief","reason":"invalid"}]}
+`,
+		}, {
+			`memberPatch`, `PATCH`, `/member`, user,
+			`entrance=1900&mail=invalid&nickname=%22&ob=0`,
+			http.StatusBadRequest,
+			`{"type":"https://tools.ietf.org/html/rfc7231#section-6.5.1","title":"Bad Request","status":400,"detail":"some fields are invalid","invalid-params":[{"name":"entrance","reason":"out_of_range"},{"name":"mail","reason":"syntax_error"},{"name":"nickname","reason":"invalid"},{"name":"ob","reason":"syntax_error"}]}
+`,
+		}, {
+			`members`, `GET`, `/members?entrance=x&sort=invalid`, member, ``,
+			http.StatusBadRequest,
+			`{"type":"https://tools.ietf.org/html/rfc7231#section-6.5.1","title":"Bad Request","status":400,"detail":"some fields are invalid","invalid-params":[{"name":"entrance","reason":"syntax_error"},{"name":"sort","reason":"syntax_error"}]}
+`,
+		},
+	})
+}
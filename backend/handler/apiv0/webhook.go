@@ -0,0 +1,268 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/handler/apiv0/util"
+	"github.com/kagucho/tsubonesystem3/backend/scope"
+	"net/http"
+	"strconv"
+)
+
+var (
+	schemaWebhook = object(map[string]*schema{
+		`events`: schemaList,
+		`id`:     schemaInteger,
+		`url`:    schemaString,
+	}, nil)
+
+	schemaDelivery = object(map[string]*schema{
+		`attempts`: schemaInteger,
+		`date`:     schemaTime,
+		`error`:    schemaString,
+		`event`:    schemaString,
+		`id`:       schemaInteger,
+		`next`:     nullable(schemaTime),
+		`payload`:  schemaString,
+		`status`:   schemaInteger,
+		`webhook`:  schemaInteger,
+	}, nil)
+
+	schemaWebhookCreated = object(map[string]*schema{
+		`id`: schemaInteger,
+	}, nil)
+)
+
+var webhookDeleteOperation = operation{
+	summary:  `Delete the webhook and its deliveries.`,
+	security: []uint{scope.Management},
+	responses: map[int]response{
+		http.StatusOK:       resultResponse,
+		http.StatusNotFound: errorResponse(`The webhook is not found.`),
+	},
+}
+
+func webhookDeleteServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+		return
+	}
+
+	switch err := shared.DB.DeleteWebhook(webhookParam(request)); err {
+	case db.ErrIncorrectIdentity:
+		util.ServeErrorDefault(writer, http.StatusNotFound)
+
+	case nil:
+		util.ServeJSON(writer, struct{}{}, http.StatusOK)
+
+	default:
+		panic(err)
+	}
+}
+
+var webhookGetOperation = operation{
+	summary:  `Get the webhook.`,
+	security: []uint{scope.Management},
+	responses: map[int]response{
+		http.StatusOK:       jsonResponse(`The webhook.`, schemaWebhook),
+		http.StatusNotFound: errorResponse(`The webhook is not found.`),
+	},
+}
+
+func webhookGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+		return
+	}
+
+	switch found, err := shared.DB.QueryWebhook(request.Context(), webhookParam(request)); err {
+	case db.ErrIncorrectIdentity:
+		util.ServeErrorDefault(writer, http.StatusNotFound)
+
+	case nil:
+		util.ServeJSON(writer, found, http.StatusOK)
+
+	default:
+		panic(err)
+	}
+}
+
+var webhookDeliveriesGetOperation = operation{
+	summary:  `Get the deliveries of the webhook.`,
+	security: []uint{scope.Management},
+	query: []parameter{
+		{`pending`, `query`, schemaFlag, false},
+	},
+	responses: map[int]response{
+		http.StatusOK:         jsonResponse(`The deliveries.`, arrayOf(schemaDelivery)),
+		http.StatusBadRequest: invalidResponse(`The query is malformed.`),
+		http.StatusNotFound:   errorResponse(`The webhook is not found.`),
+	},
+}
+
+func webhookDeliveriesGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+		return
+	}
+
+	var validation validation
+	filter := db.DeliveryFilter{
+		Pending: validation.flag(`pending`, request.FormValue(`pending`)),
+		Webhook: webhookParam(request),
+	}
+
+	if validation.serve(writer) {
+		return
+	}
+
+	switch _, err := shared.DB.QueryWebhook(request.Context(), filter.Webhook); err {
+	case db.ErrIncorrectIdentity:
+		util.ServeErrorDefault(writer, http.StatusNotFound)
+
+	case nil:
+		util.ServeJSON(writer,
+			shared.DB.QueryDeliveries(request.Context(), filter),
+			http.StatusOK)
+
+	default:
+		panic(err)
+	}
+}
+
+var webhookRedeliveryPostOperation = operation{
+	summary:  `Attempt the delivery again.`,
+	security: []uint{scope.Management},
+	responses: map[int]response{
+		http.StatusAccepted: resultResponse,
+		http.StatusNotFound: errorResponse(`The webhook or the delivery is not found.`),
+	},
+}
+
+func webhookRedeliveryPostServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+		return
+	}
+
+	id, err := strconv.ParseUint(pathParam(request, `delivery`), 10, 32)
+	if err != nil {
+		panic(err)
+	}
+
+	delivery, err := shared.DB.QueryDelivery(request.Context(), uint32(id))
+	if err == nil && delivery.Webhook != webhookParam(request) {
+		err = db.ErrIncorrectIdentity
+	}
+
+	if err == nil {
+		err = shared.Webhook.Redeliver(delivery.ID)
+	}
+
+	switch err {
+	case db.ErrIncorrectIdentity:
+		util.ServeErrorDefault(writer, http.StatusNotFound)
+
+	case nil:
+		util.ServeJSON(writer, struct{}{}, http.StatusAccepted)
+
+	default:
+		panic(err)
+	}
+}
+
+var webhooksGetOperation = operation{
+	summary:  `List the webhooks.`,
+	security: []uint{scope.Management},
+	responses: map[int]response{
+		http.StatusOK: jsonResponse(`The webhooks.`, arrayOf(schemaWebhook)),
+	},
+}
+
+func webhooksGetServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+		return
+	}
+
+	util.ServeJSON(writer, shared.DB.QueryWebhooks(request.Context()),
+		http.StatusOK)
+}
+
+var webhooksPostOperation = operation{
+	summary:  `Create a webhook delivering the events signed with the secret.`,
+	security: []uint{scope.Management},
+	body: []parameter{
+		{`events`, ``, schemaList, true},
+		{`secret`, ``, property(db.PropertyWebhookSecret), true},
+		{`url`, ``, property(db.PropertyWebhookURL), true},
+	},
+	media: mediaJSON,
+	responses: map[int]response{
+		http.StatusCreated:             jsonResponse(`The webhook is created.`, schemaWebhookCreated),
+		http.StatusUnprocessableEntity: invalidResponse(`The properties are invalid.`),
+	},
+}
+
+func webhooksPostServeHTTP(writer http.ResponseWriter, request *http.Request, shared shared) {
+	if (authorize(writer, request, shared, scope.Management) == claim{}) {
+		return
+	}
+
+	events := request.PostFormValue(`events`)
+	secret := request.PostFormValue(`secret`)
+	webhookURL := request.PostFormValue(`url`)
+
+	var validation validation
+	for _, field := range [...]struct {
+		name     string
+		value    string
+		property db.Property
+	}{
+		{`events`, events, db.PropertyWebhookEvents},
+		{`secret`, secret, db.PropertyWebhookSecret},
+		{`url`, webhookURL, db.PropertyWebhookURL},
+	} {
+		if validation.require(field.name, field.value) {
+			validation.property(field.name, field.value, field.property)
+		}
+	}
+
+	if validation.serve(writer) {
+		return
+	}
+
+	switch id, err := shared.DB.InsertWebhook(webhookURL, secret, events); err {
+	case db.ErrBadOmission, db.ErrInvalid:
+		util.ServeErrorDefault(writer, http.StatusUnprocessableEntity)
+
+	case nil:
+		util.ServeJSON(writer, struct {
+			ID uint16 `json:"id"`
+		}{id}, http.StatusCreated)
+
+	default:
+		panic(err)
+	}
+}
+
+// webhookParam returns the ID of the webhook in the path of the request.
+func webhookParam(request *http.Request) uint16 {
+	id, err := strconv.ParseUint(pathParam(request, `id`), 10, 16)
+	if err != nil {
+		panic(err)
+	}
+
+	return uint16(id)
+}
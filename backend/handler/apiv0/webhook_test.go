@@ -0,0 +1,159 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apiv0
+
+import (
+	"encoding/json"
+	"github.com/kagucho/tsubonesystem3/backend/webhook"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	t.Parallel()
+
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+
+	receivedChan := make(chan received, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		receivedChan <- received{request.Header, body}
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	apiv0 := newTestAPIv0(t)
+	defer apiv0.End()
+
+	member := apiv0.authorization(t, `3rdDisplayID`, `member`)
+	management := apiv0.authorization(t, `1stDisplayID`, `management member`)
+
+	receive := func(t *testing.T) received {
+		select {
+		case request := <-receivedChan:
+			return request
+
+		case <-time.After(time.Second):
+			t.Fatal(`timed out waiting for a delivery`)
+			return received{}
+		}
+	}
+
+	apiv0.testRequests(t, []testRequest{
+		{
+			`forbidden`, `GET`, `/webhooks`, member,
+			``, http.StatusForbidden, ``,
+		}, {
+			`invalidURL`, `POST`, `/webhooks`, management,
+			url.Values{`url`: {`ftp://example.com/`}, `secret`: {`secret`}, `events`: {`club.updated`}}.Encode(),
+			http.StatusUnprocessableEntity, ``,
+		}, {
+			`unknownEvent`, `POST`, `/webhooks`, management,
+			url.Values{`url`: {server.URL}, `secret`: {`secret`}, `events`: {`club.unknown`}}.Encode(),
+			http.StatusUnprocessableEntity, ``,
+		}, {
+			`missingSecret`, `POST`, `/webhooks`, management,
+			url.Values{`url`: {server.URL}, `events`: {`club.updated`}}.Encode(),
+			http.StatusUnprocessableEntity, ``,
+		}, {
+			`post`, `POST`, `/webhooks`, management,
+			url.Values{`url`: {server.URL}, `secret`: {`secret`}, `events`: {`club.updated member.confirmed`}}.Encode(),
+			http.StatusCreated, "{\"id\":1}\n",
+		}, {
+			`get`, `GET`, `/webhook/1`, management,
+			``, http.StatusOK, "{\"events\":\"club.updated member.confirmed\",\"id\":1,\"url\":\"" + server.URL + "\"}\n",
+		}, {
+			`getMissing`, `GET`, `/webhook/2`, management,
+			``, http.StatusNotFound, ``,
+		}, {
+			`patchClub`, `PATCH`, `/club/prog`, management,
+			`name=Prog2部`, http.StatusOK, ``,
+		},
+	})
+
+	request := receive(t)
+
+	if signature := request.header.Get(webhook.HeaderSignature); signature != webhook.Sign(`secret`, request.body) {
+		t.Errorf(`invalid signature %q`, signature)
+	}
+
+	var payload struct {
+		Event  string `json:"event"`
+		Target string `json:"target"`
+	}
+
+	if err := json.Unmarshal(request.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload.Event != `club.updated` || payload.Target != `prog` {
+		t.Errorf(`unexpected payload: %s`, request.body)
+	}
+
+	delivery := request.header.Get(webhook.HeaderDelivery)
+
+	apiv0.testRequests(t, []testRequest{
+		{
+			`deliveries`, `GET`, `/webhook/1/deliveries`, management,
+			``, http.StatusOK, ``,
+		}, {
+			`deliveriesInvalidPending`, `GET`, `/webhook/1/deliveries?pending=x`, management,
+			``, http.StatusBadRequest, ``,
+		}, {
+			`deliveriesMissing`, `GET`, `/webhook/2/deliveries`, management,
+			``, http.StatusNotFound, ``,
+		}, {
+			`redeliveryMissing`, `POST`, `/webhook/1/delivery/4294967295/redelivery`, management,
+			``, http.StatusNotFound, ``,
+		}, {
+			`redelivery`, `POST`, `/webhook/1/delivery/` + delivery + `/redelivery`, management,
+			``, http.StatusAccepted, ``,
+		},
+	})
+
+	if redelivered := receive(t); string(redelivered.body) != string(request.body) {
+		t.Errorf(`expected %s, got %s`, request.body, redelivered.body)
+	}
+
+	apiv0.testRequests(t, []testRequest{
+		{
+			`delete`, `DELETE`, `/webhook/1`, management,
+			``, http.StatusOK, ``,
+		}, {
+			`deleteMissing`, `DELETE`, `/webhook/1`, management,
+			``, http.StatusNotFound, ``,
+		}, {
+			`list`, `GET`, `/webhooks`, management,
+			``, http.StatusOK, "[]\n",
+		},
+	})
+}
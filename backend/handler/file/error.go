@@ -21,31 +21,50 @@ import (
 	"bytes"
 	"html/template"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
-	"path"
 	"runtime/debug"
 	"strconv"
+	"sync/atomic"
 )
 
 // Error is a structure to hold the context to serve error files.
 type Error struct {
+	// files holds errorFiles, replaced with Replace.
+	files *atomic.Value
+}
+
+type errorFiles struct {
 	fileSystem       http.FileSystem
 	movedPermanently *template.Template
 }
 
-// NewError returns a new file.Error.
-func NewError(share string) (Error, error) {
-	errorPath := path.Join(share, `error`)
+// NewError returns a new file.Error with the given share directory.
+func NewError(share fs.FS) (Error, error) {
+	errorFS, err := fs.Sub(share, `error`)
+	if err != nil {
+		return Error{}, err
+	}
 
-	movedPermanently, err :=
-		template.ParseFiles(path.Join(errorPath, `301`))
+	movedPermanently, err := template.ParseFS(errorFS, `301`)
 	if err != nil {
 		return Error{}, err
 	}
 
-	return Error{http.Dir(errorPath), movedPermanently}, nil
+	files := new(atomic.Value)
+	files.Store(errorFiles{http.FS(errorFS), movedPermanently})
+
+	return Error{files}, nil
+}
+
+/*
+Replace replaces the error files with those of the given file.Error, usually
+returned by file.NewError for the updated share directory.
+*/
+func (context Error) Replace(fresh Error) {
+	context.files.Store(fresh.files.Load())
 }
 
 // ServeError serves the error file corresponding with the given status code.
@@ -55,6 +74,8 @@ func (context Error) ServeError(writer http.ResponseWriter,
 	var file http.File
 	var fileInfo os.FileInfo
 
+	files := context.files.Load().(errorFiles)
+
 	defer func() {
 		if file != nil {
 			defer file.Close()
@@ -97,7 +118,7 @@ func (context Error) ServeError(writer http.ResponseWriter,
 				log.Println(recovered)
 				debug.PrintStack()
 
-				file, err = context.fileSystem.Open(`unknown`)
+				file, err = files.fileSystem.Open(`unknown`)
 				if err != nil {
 					panic(err)
 				}
@@ -109,7 +130,7 @@ func (context Error) ServeError(writer http.ResponseWriter,
 			}
 		}()
 
-		file, err = context.fileSystem.Open(strconv.Itoa(code))
+		file, err = files.fileSystem.Open(strconv.Itoa(code))
 		if err != nil {
 			panic(err)
 		}
@@ -149,7 +170,8 @@ func (context Error) ServeMovedPermanently(writer http.ResponseWriter,
 		}
 	}()
 
-	if err := context.movedPermanently.Execute(&buffer, location); err != nil {
+	files := context.files.Load().(errorFiles)
+	if err := files.movedPermanently.Execute(&buffer, location); err != nil {
 		panic(err)
 	}
 }
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strconv"
 	"testing"
 )
@@ -46,24 +47,24 @@ func TestFileError(t *testing.T) {
 	t.Parallel()
 
 	t.Run(`test/301/na`, func(t *testing.T) {
-		fileError, err := NewError(`test/301/na`)
+		fileError, err := NewError(os.DirFS(`test/301/na`))
 
 		if err == nil {
 			t.Error(`expected an error, got nil`)
 		}
 
-		if (fileError != FileError{}) {
+		if (fileError != Error{}) {
 			t.Error(`expected zero value, got `, fileError)
 		}
 	})
 
 	t.Run(`test/301/invalid`, func(t *testing.T) {
-		var fileError FileError
+		var fileError Error
 
 		if !t.Run(`NewError`, func(t *testing.T) {
 			var err error
 
-			fileError, err = NewError(`test/301/invalid`)
+			fileError, err = NewError(os.DirFS(`test/301/invalid`))
 			if err != nil {
 				t.Error(err)
 			}
@@ -96,7 +97,7 @@ func TestFileError(t *testing.T) {
 		})
 	})
 
-	testFileServeError := func(t *testing.T, fileError FileError, code int,
+	testFileServeError := func(t *testing.T, fileError Error, code int,
 		expected string) {
 		t.Run(`WithContentEncoding`, func(t *testing.T) {
 			t.Parallel()
@@ -154,7 +155,7 @@ func TestFileError(t *testing.T) {
 		})
 	}
 
-	testDirectoryServeError := func(t *testing.T, fileError FileError, code int) {
+	testDirectoryServeError := func(t *testing.T, fileError Error, code int) {
 		t.Run(`WithContentEncoding`, func(t *testing.T) {
 			t.Parallel()
 
@@ -206,16 +207,16 @@ func TestFileError(t *testing.T) {
 	}
 
 	testUnknownFileServeError := func(t *testing.T,
-		fileError FileError) {
+		fileError Error) {
 		testFileServeError(t, fileError, http.StatusBadRequest,
 			`test/unknown/file/error/unknown`)
 	}
 
-	testUnknownDirectoryServeError := func(t *testing.T, fileError FileError) {
+	testUnknownDirectoryServeError := func(t *testing.T, fileError Error) {
 		testDirectoryServeError(t, fileError, http.StatusBadRequest)
 	}
 
-	testUnknownNAServeError := func(t *testing.T, fileError FileError) {
+	testUnknownNAServeError := func(t *testing.T, fileError Error) {
 		t.Run(`codeFile`, func(t *testing.T) {
 			t.Parallel()
 			testFileServeError(t, fileError, http.StatusNotFound,
@@ -260,7 +261,7 @@ func TestFileError(t *testing.T) {
 
 	for _, unknown := range [...]struct {
 		directory      string
-		testServeError func(t *testing.T, fileError FileError)
+		testServeError func(t *testing.T, fileError Error)
 	}{
 		{`test/unknown/file`, testUnknownFileServeError},
 		{`test/unknown/directory`, testUnknownDirectoryServeError},
@@ -271,11 +272,11 @@ func TestFileError(t *testing.T) {
 		t.Run(unknown.directory, func(t *testing.T) {
 			t.Parallel()
 
-			var fileError FileError
+			var fileError Error
 
 			if !t.Run(`NewError`, func(t *testing.T) {
 				var newError error
-				fileError, newError = NewError(unknown.directory)
+				fileError, newError = NewError(os.DirFS(unknown.directory))
 				if newError != nil {
 					t.Error(newError)
 				}
@@ -358,3 +359,27 @@ func TestFileError(t *testing.T) {
 		})
 	}
 }
+
+func TestErrorReplace(t *testing.T) {
+	t.Parallel()
+
+	fileError, err := NewError(os.DirFS(`test/unknown/na`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fresh, err := NewError(os.DirFS(`test/unknown/file`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copied := fileError
+	fileError.Replace(fresh)
+
+	recorder := httptest.NewRecorder()
+	if !copied.ServeError(recorder, http.StatusNotFound) {
+		t.Error(`invalid returned value; expected true, got false`)
+	}
+
+	assertBodyWithFile(t, recorder, `test/unknown/file/error/404`)
+}
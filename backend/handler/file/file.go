@@ -19,19 +19,76 @@
 package file
 
 import (
+	"github.com/kagucho/tsubonesystem3/unchunked"
+	"io"
+	"io/fs"
+	"mime"
 	"net/http"
 	"path"
+	"regexp"
+	"sync/atomic"
 )
 
+/*
+CacheControlImmutable is the value of Cache-Control field for files whose names
+include the hashes of their contents. Such files never change.
+
+RFC 8246 - HTTP Immutable Responses
+https://tools.ietf.org/html/rfc8246
+*/
+const CacheControlImmutable = `public, max-age=31536000, immutable`
+
+/*
+CacheControlShort is the value of Cache-Control field for files which may be
+updated without changing their names, such as index and private.
+*/
+const CacheControlShort = `public, max-age=300`
+
+/*
+fingerprinted matches the names of files which include the hashes of their
+contents. file-loader used by the webapp build names files [hash].[ext] by
+default.
+*/
+var fingerprinted = regexp.MustCompile(`(?:^|[.-])[0-9a-f]{16,}\.[^.]+$`)
+
+// precompressed is the list of the siblings compressed by the webapp build.
+var precompressed = [...]struct {
+	coding    string
+	extension string
+}{
+	{`br`, `.br`},
+	{`gzip`, `.gz`},
+}
+
 // File is a structure to keep the context of the file server.
 type File struct {
-	publicRoot string
-	fileError  Error
+	// share holds shareFS, replaced with Replace.
+	share     *atomic.Value
+	fileError Error
 }
 
-// New returns a new file server.
-func New(share string, fileError Error) File {
-	return File{path.Join(share, `public`), fileError}
+/*
+shareFS wraps fs.FS so that atomic.Value can hold any implementation of the
+interface.
+*/
+type shareFS struct {
+	fs.FS
+}
+
+// New returns a new file server serving public in the given share directory.
+func New(share fs.FS, fileError Error) File {
+	value := new(atomic.Value)
+	value.Store(shareFS{share})
+
+	return File{value, fileError}
+}
+
+/*
+Replace replaces the share directory with that of the given file.File, usually
+returned by file.New for the updated share directory.
+*/
+func (file File) Replace(fresh File) {
+	file.share.Store(fresh.share.Load())
 }
 
 type customizedResponseWriter struct {
@@ -52,6 +109,11 @@ func (writer customizedResponseWriter) WriteHeader(code int) {
 	if code < 400 {
 		writer.ResponseWriter.WriteHeader(code)
 	} else if !*writer.hijacked {
+		// The error file is neither compressed nor immutable.
+		header := writer.Header()
+		header.Del(`Cache-Control`)
+		header.Del(`Content-Encoding`)
+
 		*writer.hijacked =
 			writer.fileError.ServeError(writer.ResponseWriter, code)
 	}
@@ -83,11 +145,136 @@ func (file File) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 		}
 	}
 
+	if fingerprinted.MatchString(path.Base(relative)) {
+		writer.Header().Set(`Cache-Control`, CacheControlImmutable)
+	} else {
+		writer.Header().Set(`Cache-Control`, CacheControlShort)
+	}
+
 	hijacked := false
 	writerCustomized := customizedResponseWriter{
 		writer, &hijacked, file.fileError,
 	}
 
-	http.ServeFile(writerCustomized, request,
-		path.Join(file.publicRoot, relative))
+	ServeFile(writerCustomized, request, file.share.Load().(shareFS),
+		path.Join(`public`, relative))
+}
+
+/*
+ServeFile serves the named file in the given file system as http.ServeFileFS
+does, but serves its sibling compressed in advance instead if the request
+accepts it.
+*/
+func ServeFile(writer http.ResponseWriter, request *http.Request,
+	fsys fs.FS, name string) {
+	if !servePrecompressed(writer, request, fsys, name) {
+		http.ServeFileFS(writer, request, fsys, name)
+	}
+}
+
+func servePrecompressed(writer http.ResponseWriter, request *http.Request,
+	fsys fs.FS, name string) bool {
+	original, err := fs.Stat(fsys, name)
+	if err != nil || !original.Mode().IsRegular() {
+		return false
+	}
+
+	offered := make([]string, 0, len(precompressed))
+	extensions := make(map[string]string, len(precompressed))
+
+	for _, sibling := range precompressed {
+		/*
+			A sibling older than the original is stale. It happens when
+			the original on the disk overrides the embedded one.
+		*/
+		info, err := fs.Stat(fsys, name+sibling.extension)
+		if err == nil && info.Mode().IsRegular() &&
+			!info.ModTime().Before(original.ModTime()) {
+			offered = append(offered, sibling.coding)
+			extensions[sibling.coding] = sibling.extension
+		}
+	}
+
+	if len(offered) == 0 {
+		return false
+	}
+
+	header := writer.Header()
+
+	/*
+		RFC 7231 - Hypertext Transfer Protocol (HTTP/1.1): Semantics and Content
+		7.1.4.  Vary
+		https://tools.ietf.org/html/rfc7231#section-7.1.4
+	*/
+	if !varies(header) {
+		header.Add(`Vary`, `Accept-Encoding`)
+	}
+
+	/*
+		Any content-coding is acceptable without Accept-Encoding field, but
+		such a client is likely unable to decode them.
+	*/
+	if request.Header.Get(`Accept-Encoding`) == `` {
+		return false
+	}
+
+	coding := unchunked.Negotiate(request, offered...)
+	if coding == `` {
+		return false
+	}
+
+	// The type must be told from the original; the sibling is compressed.
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == `` {
+		contentType, err = sniff(fsys, name)
+		if err != nil {
+			return false
+		}
+	}
+
+	compressed, err := fsys.Open(name + extensions[coding])
+	if err != nil {
+		return false
+	}
+
+	defer compressed.Close()
+
+	seeker, ok := compressed.(io.ReadSeeker)
+	if !ok {
+		return false
+	}
+
+	header.Set(`Content-Encoding`, coding)
+	header.Set(`Content-Type`, contentType)
+	http.ServeContent(writer, request, name, original.ModTime(), seeker)
+
+	return true
+}
+
+// sniff returns the type of the named file as http.ServeContent tells.
+func sniff(fsys fs.FS, name string) (string, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return ``, err
+	}
+
+	defer file.Close()
+
+	var buffer [512]byte
+	length, err := io.ReadFull(file, buffer[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return ``, err
+	}
+
+	return http.DetectContentType(buffer[:length]), nil
+}
+
+func varies(header http.Header) bool {
+	for _, field := range header[`Vary`] {
+		if field == `Accept-Encoding` {
+			return true
+		}
+	}
+
+	return false
 }
@@ -22,6 +22,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 )
 
@@ -40,7 +41,7 @@ func assertBodyWithFile(t *testing.T, recorder *httptest.ResponseRecorder,
 func TestCustomizedResponseWriter(t *testing.T) {
 	t.Parallel()
 
-	fileError, err := NewError(`test/unknown/na`)
+	fileError, err := NewError(os.DirFS(`test/unknown/na`))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -130,13 +131,19 @@ func (file File) TestServeHTTP(t *testing.T) {
 	for _, test := range [...]struct {
 		file    string
 		lang    string
+		cache   string
 		request string
 	}{
-		{`test/unknown/file/public/index`, `ja`, `/`},
-		{`test/unknown/file/public/index.js`, ``, `/index.js`},
-		{`test/unknown/file/public/license`, `ja`, `/license`},
-		{`test/unknown/file/error/404`, `ja`, `/index`},
-		{`test/unknown/file/error/404`, `ja`, `/invalid`},
+		{`test/unknown/file/public/index`, `ja`, CacheControlShort, `/`},
+		{`test/unknown/file/public/index.js`, ``, CacheControlShort, `/index.js`},
+		{`test/unknown/file/public/license`, `ja`, CacheControlShort, `/license`},
+		{
+			`test/unknown/file/public/0123456789abcdef0123456789abcdef.txt`,
+			``, CacheControlImmutable,
+			`/0123456789abcdef0123456789abcdef.txt`,
+		},
+		{`test/unknown/file/error/404`, `ja`, ``, `/index`},
+		{`test/unknown/file/error/404`, `ja`, ``, `/invalid`},
 	} {
 		test := test
 
@@ -155,6 +162,51 @@ func (file File) TestServeHTTP(t *testing.T) {
 				t.Errorf(`invalid Content-Language field in header; expected %q, got %q`,
 					test.lang, result)
 			}
+
+			if result := recorder.HeaderMap.Get(`Cache-Control`); result != test.cache {
+				t.Errorf(`invalid Cache-Control field in header; expected %q, got %q`,
+					test.cache, result)
+			}
+		})
+	}
+
+	for _, test := range [...]struct {
+		description string
+		accepted    string
+		file        string
+		encoding    string
+	}{
+		{`Gzip`, `gzip, br`, `test/unknown/file/public/license.gz`, `gzip`},
+		{`Unacceptable`, `br`, `test/unknown/file/public/license`, ``},
+	} {
+		test := test
+
+		t.Run(`/license`+test.description, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(
+				`GET`, `http://kagucho.net/license`, nil)
+			request.Header.Set(`Accept-Encoding`, test.accepted)
+
+			file.ServeHTTP(recorder, request)
+
+			assertBodyWithFile(t, recorder, test.file)
+
+			if result := recorder.HeaderMap.Get(`Content-Encoding`); result != test.encoding {
+				t.Errorf(`invalid Content-Encoding field in header; expected %q, got %q`,
+					test.encoding, result)
+			}
+
+			if result := recorder.HeaderMap.Get(`Content-Type`); result != `text/html; charset=utf-8` {
+				t.Errorf(`invalid Content-Type field in header; expected "text/html; charset=utf-8", got %q`,
+					result)
+			}
+
+			if result := recorder.HeaderMap.Get(`Vary`); result != `Accept-Encoding` {
+				t.Errorf(`invalid Vary field in header; expected "Accept-Encoding", got %q`,
+					result)
+			}
 		})
 	}
 
@@ -196,12 +248,12 @@ func TestFile(t *testing.T) {
 	var file File
 
 	if !t.Run(`New`, func(t *testing.T) {
-		fileError, err := NewError(`test/unknown/file`)
+		fileError, err := NewError(os.DirFS(`test/unknown/file`))
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		file = New(`test/unknown/file`, fileError)
+		file = New(os.DirFS(`test/unknown/file`), fileError)
 	}) {
 		t.FailNow()
 	}
@@ -18,6 +18,7 @@
 package private
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/kagucho/tsubonesystem3/backend/db"
@@ -31,7 +32,7 @@ type graph struct {
 	URL        string
 }
 
-type graphFunc func(db db.DB, base string, routeQuery []string) graph
+type graphFunc func(ctx context.Context, db db.Store, base string, routeQuery []string) graph
 
 type property struct {
 	Property string
@@ -64,12 +65,12 @@ var graphFuncs = map[string]graphFunc{
 	`officer`: graphOfficer, `officers`: graphOfficers,
 }
 
-func graphClub(dbInstance db.DB, base string, routeQuery []string) graph {
+func graphClub(ctx context.Context, dbInstance db.Store, base string, routeQuery []string) graph {
 	var description string
 	var title string
 
 	id := parseQuery(routeQuery)[`id`]
-	name, err := dbInstance.QueryClubName(id)
+	name, err := dbInstance.QueryClubName(ctx, id)
 	switch err {
 	case db.ErrIncorrectIdentity:
 		description = `神楽坂一丁目通信局の不明な部門です。`
@@ -97,7 +98,7 @@ func graphClub(dbInstance db.DB, base string, routeQuery []string) graph {
 	}
 }
 
-func graphClubs(dbInstance db.DB, base string, routeQuery []string) graph {
+func graphClubs(ctx context.Context, dbInstance db.Store, base string, routeQuery []string) graph {
 	url := base + "#!clubs"
 
 	return graph{
@@ -112,11 +113,11 @@ func graphClubs(dbInstance db.DB, base string, routeQuery []string) graph {
 	}
 }
 
-func graphMember(dbInstance db.DB, base string, routeQuery []string) graph {
+func graphMember(ctx context.Context, dbInstance db.Store, base string, routeQuery []string) graph {
 	properties := make([]property, 0, 8)
 
 	id := parseQuery(routeQuery)[`id`]
-	memberGraph, err := dbInstance.QueryMemberGraph(id)
+	memberGraph, err := dbInstance.QueryMemberGraph(ctx, id)
 	switch err {
 	case db.ErrIncorrectIdentity:
 		properties = append(properties,
@@ -165,7 +166,7 @@ func graphMember(dbInstance db.DB, base string, routeQuery []string) graph {
 	return graph{properties, url}
 }
 
-func graphMembers(dbInstance db.DB, base string, routeQuery []string) graph {
+func graphMembers(ctx context.Context, dbInstance db.Store, base string, routeQuery []string) graph {
 	var description string
 	var err error
 	fragment := `#!members`
@@ -220,7 +221,7 @@ func graphMembers(dbInstance db.DB, base string, routeQuery []string) graph {
 			fragment += `?` + strings.Join(components, `&`)
 		}
 
-		count, err := dbInstance.QueryMembersCount(
+		count, err := dbInstance.QueryMembersCount(ctx,
 			entrancei, nickname, realname, status)
 		if err != nil {
 			panic(err)
@@ -245,12 +246,12 @@ func graphMembers(dbInstance db.DB, base string, routeQuery []string) graph {
 	}
 }
 
-func graphOfficer(dbInstance db.DB, base string, routeQuery []string) graph {
+func graphOfficer(ctx context.Context, dbInstance db.Store, base string, routeQuery []string) graph {
 	var description string
 	var title string
 
 	id := parseQuery(routeQuery)[`id`]
-	name, err := dbInstance.QueryOfficerName(id)
+	name, err := dbInstance.QueryOfficerName(ctx, id)
 	switch err {
 	case db.ErrIncorrectIdentity:
 		description = `神楽坂一丁目通信局の不明な役職です。`
@@ -278,7 +279,7 @@ func graphOfficer(dbInstance db.DB, base string, routeQuery []string) graph {
 	}
 }
 
-func graphOfficers(db db.DB, base string, routeQuery []string) graph {
+func graphOfficers(ctx context.Context, db db.Store, base string, routeQuery []string) graph {
 	url := base + `#!officers`
 
 	return graph{
@@ -293,7 +294,7 @@ func graphOfficers(db db.DB, base string, routeQuery []string) graph {
 	}
 }
 
-func graphDefault(db db.DB, base string, routeQuery []string) graph {
+func graphDefault(ctx context.Context, db db.Store, base string, routeQuery []string) graph {
 	return graph{
 		[]property{
 			{`og:description`, `神楽坂一丁目通信局内で利用しているWebサービスです。`},
@@ -23,35 +23,52 @@ import (
 	"github.com/kagucho/tsubonesystem3/backend/db"
 	"github.com/kagucho/tsubonesystem3/backend/handler/file"
 	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
-	"path"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // Private is a structure to hold the context of the private page hosting.
 type Private struct {
-	file      string
-	graph     *template.Template
-	db        db.DB
+	// files holds privateFiles, replaced with Replace.
+	files     *atomic.Value
+	db        db.Store
 	fileError file.Error
 }
 
-// New returns a new private.Private.
-func New(share string, db db.DB, fileError file.Error) (Private, error) {
-	graph, err := template.ParseFiles(path.Join(share, `graph`))
+type privateFiles struct {
+	share fs.FS
+	graph *template.Template
+}
+
+// New returns a new private.Private with the given share directory.
+func New(share fs.FS, db db.Store, fileError file.Error) (Private, error) {
+	graph, err := template.ParseFS(share, `graph`)
 	if err != nil {
 		return Private{}, err
 	}
 
-	return Private{path.Join(share, `public/private`), graph, db, fileError},
-		nil
+	files := new(atomic.Value)
+	files.Store(privateFiles{share, graph})
+
+	return Private{files, db, fileError}, nil
+}
+
+/*
+Replace replaces the files with those of the given private.Private, usually
+returned by private.New for the updated share directory.
+*/
+func (private Private) Replace(fresh Private) {
+	private.files.Store(fresh.files.Load())
 }
 
 func (private Private) ServeHTTP(writer http.ResponseWriter,
 	request *http.Request) {
+	files := private.files.Load().(privateFiles)
 	serve := func() {
 		private.fileError.ServeError(writer, http.StatusInternalServerError)
 	}
@@ -73,8 +90,10 @@ func (private Private) ServeHTTP(writer http.ResponseWriter,
 		escapedFragment := request.Form[`_escaped_fragment_`]
 		if len(escapedFragment) == 0 {
 			if request.URL.RawQuery == `` {
+				writer.Header().Set(`Cache-Control`, file.CacheControlShort)
 				serve = func() {
-					http.ServeFile(writer, request, private.file)
+					file.ServeFile(writer, request, files.share,
+						`public/private`)
 				}
 			} else {
 				request.URL.RawQuery = ``
@@ -95,8 +114,8 @@ func (private Private) ServeHTTP(writer http.ResponseWriter,
 			}
 
 			var buffer bytes.Buffer
-			if err := private.graph.Execute(
-				&buffer, graphFunc(private.db, base, routeQuery)); err != nil {
+			if err := files.graph.Execute(
+				&buffer, graphFunc(request.Context(), private.db, base, routeQuery)); err != nil {
 				panic(err)
 			}
 
@@ -1,3 +1,6 @@
+//go:build dbtest
+// +build dbtest
+
 /*
 	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
 
@@ -19,11 +22,15 @@ package private
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/db/dbtest"
 	"github.com/kagucho/tsubonesystem3/backend/handler/file"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"strconv"
 	"testing"
@@ -34,6 +41,29 @@ type queryTest struct {
 	expected graph
 }
 
+// brokenStore is db.Store whose queries used for graphs fail.
+type brokenStore struct {
+	db.Store
+}
+
+var errBroken = errors.New(`broken`)
+
+func (brokenStore) QueryClubName(context.Context, string) (string, error) {
+	return ``, errBroken
+}
+
+func (brokenStore) QueryMemberGraph(context.Context, string) (db.MemberGraph, error) {
+	return db.MemberGraph{}, errBroken
+}
+
+func (brokenStore) QueryMembersCount(context.Context, int, string, string, db.MemberStatus) (uint16, error) {
+	return 0, errBroken
+}
+
+func (brokenStore) QueryOfficerName(context.Context, string) (string, error) {
+	return ``, errBroken
+}
+
 func TestParseQuery(t *testing.T) {
 	for _, test := range [...]struct {
 		description string
@@ -55,13 +85,13 @@ func TestParseQuery(t *testing.T) {
 }
 
 func TestPrivate(t *testing.T) {
-	db, err := db.Prepare()
+	memory, err := dbtest.New()
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	t.Run(`Private`, func(t *testing.T) {
-		fileError, err := file.NewError(`test/valid`)
+		fileError, err := file.NewError(os.DirFS(`test/valid`))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -72,7 +102,7 @@ func TestPrivate(t *testing.T) {
 			var private Private
 
 			if !t.Run(`New`, func(t *testing.T) {
-				private, err = New(`test/valid`, db, fileError)
+				private, err = New(os.DirFS(`test/valid`), memory, fileError)
 				if err != nil {
 					t.Error(err)
 				}
@@ -111,7 +141,7 @@ func TestPrivate(t *testing.T) {
 
 							private.ServeHTTP(recorder, request)
 
-							body, err := ioutil.ReadFile(private.file)
+							body, err := ioutil.ReadFile(`test/valid/public/private`)
 							if err != nil {
 								t.Fatal(err)
 							}
@@ -247,7 +277,7 @@ func TestPrivate(t *testing.T) {
 			var private Private
 
 			if !t.Run(`New`, func(t *testing.T) {
-				private, err = New(`test/invalid`, db, fileError)
+				private, err = New(os.DirFS(`test/invalid`), memory, fileError)
 				if err != nil {
 					t.Error(err)
 				}
@@ -273,7 +303,7 @@ func TestPrivate(t *testing.T) {
 		t.Run(`na`, func(t *testing.T) {
 			t.Parallel()
 
-			private, err := New(`test/na`, db, fileError)
+			private, err := New(os.DirFS(`test/na`), memory, fileError)
 			if (private != Private{}) {
 				t.Error(`expected zero value, got `, private)
 			}
@@ -371,8 +401,8 @@ func TestPrivate(t *testing.T) {
 						`id=1stDisplayID`,
 						graph{
 							[]property{
-								{`og:description`, `神楽坂一丁目通信局の局員、1 !\%_1"#の詳細情報です。`},
-								{`og:title`, `TsuboneSystem 1 !\%_1"#の詳細情報`},
+								{`og:description`, `神楽坂一丁目通信局の局員、1 !\%_1'#の詳細情報です。`},
+								{`og:title`, `TsuboneSystem 1 !\%_1'#の詳細情報`},
 								{`og:profile:username`, `1stDisplayID`},
 								{`og:profile:gender`, `male`},
 								{`og:image`, `https://kagucho.net/favicon.ico`},
@@ -390,8 +420,8 @@ func TestPrivate(t *testing.T) {
 									> profile:username - string - A short unique string to identify them.
 									> profile:gender - enum(male, female) - Their gender.
 								*/
-								{`og:description`, `神楽坂一丁目通信局の局員、2 !%_1"#の詳細情報です。`},
-								{`og:title`, `TsuboneSystem 2 !%_1"#の詳細情報`},
+								{`og:description`, `神楽坂一丁目通信局の局員、2 !%_1'#の詳細情報です。`},
+								{`og:title`, `TsuboneSystem 2 !%_1'#の詳細情報`},
 								{`og:profile:username`, `2ndDisplayID`},
 								{`og:profile:gender`, `female`},
 								{`og:image`, `https://kagucho.net/favicon.ico`},
@@ -404,8 +434,8 @@ func TestPrivate(t *testing.T) {
 						`id=3rdDisplayID`,
 						graph{
 							[]property{
-								{`og:description`, `神楽坂一丁目通信局の局員、3 !\%*1"#の詳細情報です。`},
-								{`og:title`, `TsuboneSystem 3 !\%*1"#の詳細情報`},
+								{`og:description`, `神楽坂一丁目通信局の局員、3 !\%*1'#の詳細情報です。`},
+								{`og:title`, `TsuboneSystem 3 !\%*1'#の詳細情報`},
 								{`og:profile:username`, `3rdDisplayID`},
 								{`og:image`, `https://kagucho.net/favicon.ico`},
 								{`og:locale`, `ja_JP`},
@@ -586,7 +616,7 @@ func TestPrivate(t *testing.T) {
 					t.Run(query.query, func(t *testing.T) {
 						t.Parallel()
 
-						result := graphFunc(db, `https://kagucho.net/private`,
+						result := graphFunc(context.Background(), memory, `https://kagucho.net/private`,
 							[]string{route.route, query.query})
 						if !reflect.DeepEqual(result, query.expected) {
 							t.Error(`expected `, query.expected, `, got `, result)
@@ -610,17 +640,13 @@ func TestPrivate(t *testing.T) {
 				}, `https://kagucho.net/private`,
 			}
 
-			result := graphDefault(db, `https://kagucho.net/private`, nil)
+			result := graphDefault(context.Background(), memory, `https://kagucho.net/private`, nil)
 
 			if !reflect.DeepEqual(result, expected) {
 				t.Error(`expected `, expected, `, got `, result)
 			}
 		})
 
-		if err := db.Close(); err != nil {
-			t.Fatal(err)
-		}
-
 		t.Run(`dbError`, func(t *testing.T) {
 			t.Parallel()
 
@@ -637,7 +663,8 @@ func TestPrivate(t *testing.T) {
 					}()
 
 					graphFunc := graphFuncs[route]
-					graphFunc(db, `https://kagucho.net/private`, []string{route, ``})
+					graphFunc(context.Background(), brokenStore{memory}, `https://kagucho.net/private`,
+						[]string{route, ``})
 				})
 			}
 		})
@@ -18,10 +18,14 @@
 package mail
 
 import (
+	"errors"
 	"golang.org/x/net/idna"
 	"strings"
 )
 
+// ErrAddressWithoutDomain is an error telling an email address lacks "@".
+var ErrAddressWithoutDomain = errors.New(`address without domain`)
+
 func convertAddress(convert func(string) (string, error), address string) (string, error) {
 	if address == `` {
 		return ``, nil
@@ -29,6 +33,9 @@ func convertAddress(convert func(string) (string, error), address string) (strin
 
 	var err error
 	splitted := strings.SplitN(address, `@`, 2)
+	if len(splitted) < 2 {
+		return ``, ErrAddressWithoutDomain
+	}
 
 	splitted[1], err = convert(splitted[1])
 	if err != nil {
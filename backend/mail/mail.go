@@ -19,8 +19,9 @@
 package mail
 
 import (
+	"bytes"
 	htmlTemplate "html/template"
-	"log"
+	"io/fs"
 	"mime/multipart"
 	"mime/quotedprintable"
 	"net/mail"
@@ -28,15 +29,20 @@ import (
 	"os/exec"
 	"path"
 	"strings"
+	"sync/atomic"
 	textTemplate "text/template"
 	"time"
 )
 
 // Mail is a structure to hold the context to email. Initialize with mail.New.
 type Mail struct {
-	templates templates
+	// templates holds templates, replaced with Replace.
+	templates *atomic.Value
+	transport transport
 }
 
+type transport func(recipients []string, subject string, message []byte) error
+
 type template struct {
 	html *htmlTemplate.Template
 	text *textTemplate.Template
@@ -73,65 +79,58 @@ var htmlMIME = textproto.MIMEHeader{
 	contentTransferEncoding: contentTransferEncodingCommon,
 }
 
-// New returns a new mail.Mail.
-func New(share string) (Mail, error) {
+// New returns a new mail.Mail with the templates in the given share directory.
+func New(share fs.FS) (Mail, error) {
+	templates, err := newTemplates(share)
+	return newMail(templates, sendmail), err
+}
+
+func newMail(templates templates, transport transport) Mail {
+	value := new(atomic.Value)
+	value.Store(templates)
+
+	return Mail{value, transport}
+}
+
+/*
+Replace replaces the templates with those of the given mail.Mail, usually
+returned by mail.New for the updated share directory. The copies of mail.Mail
+share the replaced templates while emails being composed keep the old ones.
+*/
+func (context Mail) Replace(fresh Mail) {
+	context.templates.Store(fresh.templates.Load())
+}
+
+func newTemplates(share fs.FS) (templates, error) {
 	var templates templates
 	var err error
 
-	htmlBase := path.Join(share, `mail/html`)
-	textBase := path.Join(share, `mail/text`)
-
 	for index, file := range [...]string{
 		templateConfirmation: `confirmation`,
 		templateCreation:     `creation`,
 		templateInvitation:   `invitation`,
 		templateMessage:      `message`,
 	} {
-		templates[index].html, err = htmlTemplate.ParseFiles(path.Join(htmlBase, file))
+		templates[index].html, err = htmlTemplate.ParseFS(share, path.Join(`mail/html`, file))
 		if err != nil {
 			break
 		}
 
-		templates[index].text, err = textTemplate.ParseFiles(path.Join(textBase, file))
+		templates[index].text, err = textTemplate.ParseFS(share, path.Join(`mail/text`, file))
 		if err != nil {
 			break
 		}
 	}
 
-	return Mail{templates}, err
+	return templates, err
 }
 
-func (context Mail) send(host string, recipients []string, toGroup string, tos []mail.Address, subject string, template templateID, data interface{}) (returning error) {
+func (context Mail) send(host string, recipients []string, toGroup string, tos []mail.Address, subject string, template templateID, data interface{}) error {
 	const boundary = `Copyright(C)2017Kagucho.`
 
-	cmd := exec.Command(`sendmail`, strings.Join(recipients, `,`))
-
-	pipe, pipeErr := cmd.StdinPipe()
-	if pipeErr != nil {
-		return pipeErr
-	}
+	current := context.templates.Load().(templates)
 
-	if startErr := cmd.Start(); startErr != nil {
-		return startErr
-	}
-
-	defer func() {
-		if recovered := recover(); recovered != nil {
-			if killErr := cmd.Process.Kill(); killErr != nil {
-				log.Print(killErr)
-			}
-
-			if waitErr := cmd.Wait(); waitErr != nil {
-				log.Print(waitErr)
-			}
-
-			if recoveredErr, ok := recovered.(error); ok {
-				returning = recoveredErr
-			} else {
-				panic(recovered)
-			}
-		}
-	}()
+	var message bytes.Buffer
 
 	for _, data := range [...]string{
 		"Date: ",
@@ -143,75 +142,95 @@ func (context Mail) send(host string, recipients []string, toGroup string, tos [
 		}).String(),
 		lineEnding,
 	} {
-		if _, writeErr := pipe.Write([]byte(data)); writeErr != nil {
-			panic(writeErr)
-		}
+		message.WriteString(data)
 	}
 
-	if writeErr := writeTo(pipe, toGroup, tos); writeErr != nil {
-		panic(writeErr)
+	if writeErr := writeTo(&message, toGroup, tos); writeErr != nil {
+		return writeErr
 	}
 
 	if subject != `` {
-		if _, writeErr := pipe.Write([]byte(lineEnding)); writeErr != nil {
-			panic(writeErr)
-		}
+		message.WriteString(lineEnding)
 
-		if writeErr := writeSubject(pipe, subject); writeErr != nil {
-			panic(writeErr)
+		if writeErr := writeSubject(&message, subject); writeErr != nil {
+			return writeErr
 		}
 	}
 
-	if _, writeErr := pipe.Write([]byte(
-		lineEnding +
-			contentType + ": multipart/alternative; boundary=" + boundary + lineEnding +
-			"MIME-Version: 1.0" + lineEnding +
-			lineEnding)); writeErr != nil {
-		panic(writeErr)
-	}
+	message.WriteString(lineEnding +
+		contentType + ": multipart/alternative; boundary=" + boundary + lineEnding +
+		"MIME-Version: 1.0" + lineEnding +
+		lineEnding)
 
-	multipartWriter := multipart.NewWriter(pipe)
+	multipartWriter := multipart.NewWriter(&message)
 
 	boundaryErr := multipartWriter.SetBoundary(boundary)
 	if boundaryErr != nil {
-		panic(boundaryErr)
+		return boundaryErr
 	}
 
 	textPart, textPartErr := multipartWriter.CreatePart(textMIME)
 	if textPartErr != nil {
-		panic(textPartErr)
+		return textPartErr
 	}
 
 	textEncoding := quotedprintable.NewWriter(textPart)
-	textExecuteErr := context.templates[template].text.Execute(textEncoding, data)
+	textExecuteErr := current[template].text.Execute(textEncoding, data)
 
 	if closeErr := textEncoding.Close(); closeErr != nil {
-		panic(closeErr)
+		return closeErr
 	}
 
 	if textExecuteErr != nil {
-		panic(textExecuteErr)
+		return textExecuteErr
 	}
 
 	htmlPart, htmlPartErr := multipartWriter.CreatePart(htmlMIME)
 	if htmlPartErr != nil {
-		panic(htmlPartErr)
+		return htmlPartErr
 	}
 
 	htmlEncoding := quotedprintable.NewWriter(htmlPart)
-	htmlExecuteErr := context.templates[template].html.Execute(htmlEncoding, data)
+	htmlExecuteErr := current[template].html.Execute(htmlEncoding, data)
 
 	if closeErr := htmlEncoding.Close(); closeErr != nil {
-		panic(closeErr)
+		return closeErr
 	}
 
 	if htmlExecuteErr != nil {
-		panic(htmlExecuteErr)
+		return htmlExecuteErr
+	}
+
+	return context.transport(recipients, subject, message.Bytes())
+}
+
+/*
+sendmail delivers the given message to the given recipients with the sendmail
+command.
+*/
+func sendmail(recipients []string, subject string, message []byte) error {
+	cmd := exec.Command(`sendmail`, strings.Join(recipients, `,`))
+
+	pipe, pipeErr := cmd.StdinPipe()
+	if pipeErr != nil {
+		return pipeErr
+	}
+
+	if startErr := cmd.Start(); startErr != nil {
+		return startErr
+	}
+
+	_, writeErr := pipe.Write(message)
+	closeErr := pipe.Close()
+	waitErr := cmd.Wait()
+
+	if writeErr != nil {
+		return writeErr
 	}
 
-	if closeErr := pipe.Close(); closeErr != nil {
-		panic(closeErr)
+	if closeErr != nil {
+		return closeErr
 	}
 
-	return cmd.Wait()
+	return waitErr
 }
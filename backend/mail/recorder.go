@@ -0,0 +1,80 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package mail
+
+import (
+	"io/fs"
+	"sync"
+)
+
+// Recorded is a structure holding an email recorded by mail.Recorder.
+type Recorded struct {
+	Recipients []string
+	Subject    string
+	Message    []byte
+}
+
+/*
+Recorder is a structure to record emails instead of sending them. It is
+intended for tests.
+*/
+type Recorder struct {
+	mutex    sync.Mutex
+	recorded []Recorded
+}
+
+/*
+NewRecorder returns a new mail.Mail which records emails to the returned
+mail.Recorder instead of sending them.
+*/
+func NewRecorder(share fs.FS) (Mail, *Recorder, error) {
+	recorder := new(Recorder)
+
+	templates, err := newTemplates(share)
+
+	return newMail(templates, recorder.record), recorder, err
+}
+
+// Recorded returns the emails recorded so far.
+func (recorder *Recorder) Recorded() []Recorded {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	return append([]Recorded(nil), recorder.recorded...)
+}
+
+// Reset discards the recorded emails.
+func (recorder *Recorder) Reset() {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	recorder.recorded = nil
+}
+
+func (recorder *Recorder) record(recipients []string, subject string, message []byte) error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	recorder.recorded = append(recorder.recorded, Recorded{
+		append([]string(nil), recipients...),
+		subject,
+		append([]byte(nil), message...),
+	})
+
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+/*
+Package share provides the files shared by the backend, such as templates and
+the webapp. They are embedded in the executable, and a directory on the disk
+can override them.
+*/
+package share
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+/*
+embedded is the share directory built into the executable. Install the webapp
+into share/tsubonesystem3 of this package before building the executable.
+*/
+//go:embed all:tsubonesystem3
+var embedded embed.FS
+
+type overlay struct {
+	disk     fs.FS
+	embedded fs.FS
+}
+
+/*
+New returns the share directory. A file in the override directory on the disk
+takes precedence over the embedded file with the same name. The override
+directory is optional; an empty string or a directory which does not exist
+results in the embedded files only.
+*/
+func New(override string) (fs.FS, error) {
+	root, err := fs.Sub(embedded, `tsubonesystem3`)
+	if err != nil {
+		return nil, err
+	}
+
+	if override == `` {
+		return root, nil
+	}
+
+	info, err := os.Stat(override)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return root, nil
+		}
+
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: `open`, Path: override, Err: errors.New(`not a directory`)}
+	}
+
+	return overlay{os.DirFS(override), root}, nil
+}
+
+// Open opens the named file on the disk if present, or the embedded one.
+func (share overlay) Open(name string) (fs.File, error) {
+	file, err := share.disk.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return file, err
+	}
+
+	return share.embedded.Open(name)
+}
@@ -0,0 +1,87 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package share
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	embeddedReadme, err := embedded.ReadFile(`tsubonesystem3/README.TXT`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	override := t.TempDir()
+	overridingFile := filepath.Join(override, `README.TXT`)
+	if err := ioutil.WriteFile(overridingFile, []byte(`overridden`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(override, `extra`), []byte(`extra`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range [...]struct {
+		description string
+		override    string
+		readme      string
+		extra       bool
+	}{
+		{`Empty`, ``, string(embeddedReadme), false},
+		{`Absent`, filepath.Join(override, `absent`), string(embeddedReadme), false},
+		{`Present`, override, `overridden`, true},
+	} {
+		test := test
+
+		t.Run(test.description, func(t *testing.T) {
+			t.Parallel()
+
+			share, err := New(test.override)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			readme, err := fs.ReadFile(share, `README.TXT`)
+			if err != nil {
+				t.Error(err)
+			} else if string(readme) != test.readme {
+				t.Errorf(`invalid README.TXT; expected %q, got %q`,
+					test.readme, readme)
+			}
+
+			if _, err := fs.Stat(share, `extra`); (err == nil) != test.extra {
+				t.Errorf(`invalid presence of extra; expected %v, got error %v`,
+					test.extra, err)
+			}
+		})
+	}
+
+	t.Run(`NotDirectory`, func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := New(overridingFile); err == nil {
+			t.Error(`expected an error, got nil`)
+		}
+	})
+}
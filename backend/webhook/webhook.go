@@ -0,0 +1,372 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+/*
+Package webhook implements the delivery of the events to the outgoing webhooks
+configured by the management.
+*/
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/encoding"
+	"github.com/kagucho/tsubonesystem3/backend/event"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+Dispatcher is a structure delivering the events published by db.Store to the
+webhooks subscribing them. It should be initialized with webhook.New.
+*/
+type Dispatcher struct {
+	client *http.Client
+	store  db.Store
+	ctx    context.Context
+	cancel context.CancelFunc
+	group  sync.WaitGroup
+
+	// mutex protects ended and timers.
+	mutex  sync.Mutex
+	ended  bool
+	timers map[uint32]*time.Timer
+}
+
+/*
+payload is a structure marshalled as the body of a delivery. Date is the time
+the event is dispatched.
+*/
+type payload struct {
+	Date   encoding.Time `json:"date"`
+	Event  string        `json:"event"`
+	ID     uint64        `json:"id"`
+	Target string        `json:"target"`
+}
+
+// The headers of a delivery.
+const (
+	HeaderDelivery  = `X-TsuboneSystem-Delivery`
+	HeaderEvent     = `X-TsuboneSystem-Event`
+	HeaderSignature = `X-TsuboneSystem-Signature`
+)
+
+/*
+maxAttempts is the number of attempts after which a failing delivery is given
+up. It can be attempted again only with Redeliver.
+*/
+const maxAttempts = 8
+
+// timeout is the limit of the time to wait for the response of a webhook.
+const timeout = 16 * time.Second
+
+/*
+retryBase is the delay before the second attempt. The delay doubles for each
+following attempt. It is a variable so that tests can shorten it.
+*/
+var retryBase = time.Minute
+
+/*
+New returns a new webhook.Dispatcher delivering the events published by the
+given db.Store. It also resumes the pending deliveries recorded before.
+Resources will be holded until End gets called.
+*/
+func New(store db.Store) *Dispatcher {
+	dispatcher := Dispatcher{
+		client: &http.Client{Timeout: timeout},
+		store:  store,
+		timers: make(map[uint32]*time.Timer),
+	}
+
+	dispatcher.ctx, dispatcher.cancel = context.WithCancel(context.Background())
+
+	subscription := store.Events().Subscribe()
+
+	dispatcher.group.Add(1)
+	go dispatcher.listen(subscription)
+
+	for result := range store.QueryDeliveries(dispatcher.ctx, db.DeliveryFilter{Pending: true}) {
+		if result.Error != nil {
+			log.Print(result.Error)
+			break
+		}
+
+		dispatcher.schedule(result.ID, result.Next.Generic())
+	}
+
+	return &dispatcher
+}
+
+/*
+Sign returns the value of X-TsuboneSystem-Signature header for the given body
+signed with the given secret, which is the hexadecimal HMAC-SHA256 prefixed
+with "sha256=".
+
+RFC 2104 - HMAC: Keyed-Hashing for Message Authentication
+https://tools.ietf.org/html/rfc2104
+*/
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return `sha256=` + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+End stops the delivery and waits for the ongoing attempts. The pending
+deliveries will be resumed by webhook.New.
+*/
+func (dispatcher *Dispatcher) End() {
+	dispatcher.cancel()
+
+	dispatcher.mutex.Lock()
+	dispatcher.ended = true
+	for _, timer := range dispatcher.timers {
+		timer.Stop()
+	}
+	dispatcher.mutex.Unlock()
+
+	dispatcher.group.Wait()
+}
+
+/*
+Redeliver attempts the delivery identified by the given ID again immediately,
+even if it has been given up.
+
+It returns db.ErrIncorrectIdentity if the given ID is incorrect. Other errors
+tell db.Store is bad.
+*/
+func (dispatcher *Dispatcher) Redeliver(id uint32) error {
+	if _, err := dispatcher.store.QueryDelivery(dispatcher.ctx, id); err != nil {
+		return err
+	}
+
+	dispatcher.schedule(id, time.Now())
+
+	return nil
+}
+
+// attempt attempts the delivery identified by the given ID and records it.
+func (dispatcher *Dispatcher) attempt(id uint32) {
+	delivery, err := dispatcher.store.QueryDelivery(dispatcher.ctx, id)
+	if err != nil {
+		if err != db.ErrIncorrectIdentity {
+			log.Print(err)
+		}
+
+		return
+	}
+
+	webhook, err := dispatcher.store.QueryWebhook(dispatcher.ctx, delivery.Webhook)
+	if err != nil {
+		if err != db.ErrIncorrectIdentity {
+			log.Print(err)
+		}
+
+		return
+	}
+
+	status, err := dispatcher.post(webhook, delivery)
+	if dispatcher.ctx.Err() != nil {
+		// Leave the delivery pending to resume it later.
+		return
+	}
+
+	var message string
+	var next time.Time
+	if err != nil {
+		message = err.Error()
+	} else if status < 200 || status >= 300 {
+		message = http.StatusText(int(status))
+	}
+
+	if message != `` && int(delivery.Attempts)+1 < maxAttempts {
+		next = time.Now().Add(retryBase << delivery.Attempts)
+	}
+
+	if err := dispatcher.store.UpdateDelivery(id, status, message, next); err != nil {
+		if err != db.ErrIncorrectIdentity {
+			log.Print(err)
+		}
+
+		return
+	}
+
+	if !next.IsZero() {
+		dispatcher.schedule(id, next)
+	}
+}
+
+/*
+dispatch records the deliveries of the given event to the webhooks subscribing
+it, and attempts them.
+*/
+func (dispatcher *Dispatcher) dispatch(published event.Event) {
+	body, err := json.Marshal(payload{
+		encoding.NewTime(time.Now()), published.Type,
+		published.ID, published.Target,
+	})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	var webhooks []db.Webhook
+	for result := range dispatcher.store.QueryWebhooks(dispatcher.ctx) {
+		if result.Error != nil {
+			log.Print(result.Error)
+			return
+		}
+
+		if result.Subscribes(published.Type) {
+			webhooks = append(webhooks, result.Webhook)
+		}
+	}
+
+	now := time.Now()
+
+	for _, webhook := range webhooks {
+		id, err := dispatcher.store.InsertDelivery(webhook.ID,
+			published.Type, string(body))
+		if err != nil {
+			if err != db.ErrIncorrectIdentity {
+				log.Print(err)
+			}
+
+			continue
+		}
+
+		dispatcher.schedule(id, now)
+	}
+}
+
+/*
+listen dispatches the events delivered to the given subscription until End gets
+called. It subscribes again if the subscription gets closed because it falls
+behind, and waits for End if it gets closed because the bus is closed.
+*/
+func (dispatcher *Dispatcher) listen(subscription *event.Subscription) {
+	defer dispatcher.group.Done()
+
+	bus := dispatcher.store.Events()
+
+	for {
+		select {
+		case published, present := <-subscription.Events():
+			if !present {
+				// No more events come once the bus is closed.
+				if bus.Closed() {
+					<-dispatcher.ctx.Done()
+					return
+				}
+
+				log.Print(`webhook: dropped events falling behind`)
+				subscription = bus.Subscribe()
+				continue
+			}
+
+			dispatcher.dispatch(published)
+
+		case <-dispatcher.ctx.Done():
+			bus.Unsubscribe(subscription)
+			return
+		}
+	}
+}
+
+/*
+post posts the payload of the given delivery to the given webhook and returns
+the status code of the response.
+*/
+func (dispatcher *Dispatcher) post(webhook db.Webhook, delivery db.Delivery) (uint16, error) {
+	body := []byte(delivery.Payload)
+
+	request, err := http.NewRequest(`POST`, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	request = request.WithContext(dispatcher.ctx)
+	request.Header.Set(`Content-Type`, `application/json`)
+	request.Header.Set(`User-Agent`, `TsuboneSystem-Webhook`)
+	request.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(delivery.ID), 10))
+	request.Header.Set(HeaderEvent, delivery.Event)
+	request.Header.Set(HeaderSignature, Sign(webhook.Secret, body))
+
+	response, err := dispatcher.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	// Drain the body so that the connection can be reused.
+	if _, err := io.Copy(ioutil.Discard, io.LimitReader(response.Body, 65536)); err != nil {
+		log.Print(err)
+	}
+
+	return uint16(response.StatusCode), nil
+}
+
+/*
+schedule schedules the attempt of the delivery identified by the given ID at
+the given time, replacing the attempt scheduled before if any.
+*/
+func (dispatcher *Dispatcher) schedule(id uint32, at time.Time) {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	if dispatcher.ended {
+		return
+	}
+
+	if timer, present := dispatcher.timers[id]; present {
+		timer.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(at), func() {
+		dispatcher.mutex.Lock()
+		if dispatcher.ended || dispatcher.timers[id] != timer {
+			dispatcher.mutex.Unlock()
+			return
+		}
+
+		delete(dispatcher.timers, id)
+		dispatcher.group.Add(1)
+		dispatcher.mutex.Unlock()
+
+		defer dispatcher.group.Done()
+		dispatcher.attempt(id)
+	})
+
+	dispatcher.timers[id] = timer
+}
@@ -0,0 +1,156 @@
+//go:build dbtest
+// +build dbtest
+
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package webhook
+
+import (
+	"context"
+	"github.com/kagucho/tsubonesystem3/backend/db"
+	"github.com/kagucho/tsubonesystem3/backend/event"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	t.Parallel()
+
+	// RFC 4231 - Identifiers and Test Vectors for HMAC-SHA-224, HMAC-SHA-256,
+	// HMAC-SHA-384, and HMAC-SHA-512
+	// 4.3. Test Case 2
+	// https://tools.ietf.org/html/rfc4231#section-4.3
+	const expected = `sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843`
+
+	if signature := Sign(`Jefe`, []byte(`what do ya want for nothing?`)); signature != expected {
+		t.Errorf("expected %q, got %q", expected, signature)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	retryBase = time.Millisecond
+
+	type received struct {
+		header http.Header
+		body   string
+	}
+
+	receivedChan := make(chan received, 4)
+	statuses := make(chan int, 4)
+	statuses <- http.StatusInternalServerError
+	statuses <- http.StatusNoContent
+	statuses <- http.StatusNoContent
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		receivedChan <- received{request.Header, string(body)}
+		writer.WriteHeader(<-statuses)
+	}))
+	defer server.Close()
+
+	store := db.NewMemory()
+
+	subscribing, err := store.InsertWebhook(server.URL, `secret`, event.ClubCreated+` `+event.MemberConfirmed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.InsertWebhook(server.URL, `secret`, event.PartyCreated); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := New(store)
+	defer dispatcher.End()
+
+	store.Events().Publish(event.Event{Type: event.ClubCreated, Target: `club`})
+
+	var delivery db.Delivery
+	for attempt := 0; attempt < 2; attempt++ {
+		select {
+		case request := <-receivedChan:
+			if signature := request.header.Get(HeaderSignature); signature != Sign(`secret`, []byte(request.body)) {
+				t.Errorf("attempt %d: invalid signature %q", attempt, signature)
+			}
+
+			if kind := request.header.Get(HeaderEvent); kind != event.ClubCreated {
+				t.Errorf("attempt %d: expected event %q, got %q",
+					attempt, event.ClubCreated, kind)
+			}
+
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %d: timed out", attempt)
+		}
+	}
+
+	for deadline := time.Now().Add(time.Second); ; {
+		deliveries := store.QueryDeliveries(context.Background(), db.DeliveryFilter{})
+
+		result, present := <-deliveries
+		if !present {
+			t.Fatal(`expected a delivery, got none`)
+		}
+
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+
+		if _, present := <-deliveries; present {
+			t.Fatal(`expected only one delivery, got more`)
+		}
+
+		delivery = result.Delivery
+		if delivery.Attempts >= 2 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal(`timed out waiting for the delivery to be recorded`)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if delivery.Webhook != subscribing {
+		t.Errorf("expected webhook %d, got %d", subscribing, delivery.Webhook)
+	}
+
+	if delivery.Status != http.StatusNoContent || delivery.Error != `` || delivery.Next != nil {
+		t.Errorf("expected a successful delivery, got %#v", delivery)
+	}
+
+	if err := dispatcher.Redeliver(delivery.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-receivedChan:
+	case <-time.After(time.Second):
+		t.Fatal(`timed out waiting for the redelivery`)
+	}
+
+	if err := dispatcher.Redeliver(delivery.ID + 1); err != db.ErrIncorrectIdentity {
+		t.Errorf("expected %v, got %v", db.ErrIncorrectIdentity, err)
+	}
+}
@@ -39,6 +39,12 @@ func TestDBPasswordKey(t *testing.T) {
 	}
 }
 
+func TestDBTimeout(t *testing.T) {
+	if DBTimeout <= 0 {
+		t.Fail()
+	}
+}
+
 func TestListenNet(t *testing.T) {
 	if ListenNet == "" {
 		t.Fail()
@@ -17,6 +17,8 @@
 
 package configuration
 
+import "time"
+
 /*
 	DBDSN is the string of the DSN which refers to the databse for
 	TsuboneSystem.
@@ -26,6 +28,13 @@ package configuration
 */
 const DBDSN string = `root@unix(/var/lib/mysql/mysql.sock)/tsubonesystem`
 
+/*
+	DBTimeout is the duration after which a query to the database gets
+	canceled. It bounds the time a request can hold resources of the
+	database.
+*/
+const DBTimeout time.Duration = 16 * time.Second
+
 /*
 	DBPssswordKey is the string of the key to encrypt password in the
 	database. Its length should be 128 and it must be cryptographically
@@ -28,7 +28,7 @@ trap clean HUP INT QUIT KILL TERM EXIT
 temp=`mktemp -d`
 echo "mode: count" > $temp/sum.txt
 for package in $(go list ./...); do
-  go test -covermode=count -coverprofile=$temp/package.txt $package
+  go test -tags dbtest -covermode=count -coverprofile=$temp/package.txt $package
 
   if [ -f $temp/package.txt ]; then
     cat $temp/package.txt | tail -n +2 >> $temp/sum.txt
@@ -18,8 +18,9 @@
 package main
 
 import (
+	"context"
 	"github.com/kagucho/tsubonesystem3/backend"
-	"github.com/kagucho/tsubonesystem3/configuration"
+	"github.com/kagucho/tsubonesystem3/backend/config"
 	"log"
 	"net"
 	"net/http"
@@ -29,12 +30,17 @@ import (
 )
 
 func main() {
-	backend, backendErr := backend.New()
+	loaded, loadErr := config.Load()
+	if loadErr != nil {
+		log.Panic(loadErr)
+	}
+
+	backend, backendErr := backend.New(loaded)
 	if backendErr != nil {
 		log.Panic(backendErr)
 	}
 
-	listener, listenerErr := net.Listen(`tcp`, configuration.ListenAddress)
+	listener, listenerErr := net.Listen(`tcp`, loaded.ListenAddress)
 	if listenerErr != nil {
 		if endErr := backend.End(); endErr != nil {
 			log.Print(endErr)
@@ -44,18 +50,55 @@ func main() {
 	}
 
 	go func() {
-		signalChan := make(chan os.Signal, 2)
-		signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+		hangupChan := make(chan os.Signal, 1)
+		signal.Notify(hangupChan, syscall.SIGHUP)
+
+		for range hangupChan {
+			reloaded, loadErr := config.Load()
+			if loadErr != nil {
+				log.Print(loadErr)
+				continue
+			}
+
+			if reloadErr := backend.Reload(reloaded); reloadErr != nil {
+				log.Print(reloadErr)
+				continue
+			}
+
+			log.Print(`reloaded`)
+		}
+	}()
+
+	server := http.Server{Handler: backend}
+
+	// Drain closes the event streams, which Shutdown would wait for.
+	server.RegisterOnShutdown(backend.Drain)
+	signalChan := make(chan os.Signal, 2)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+	shutdownChan := make(chan struct{})
+
+	go func() {
 		<-signalChan
 
-		if closeErr := listener.Close(); closeErr != nil {
-			log.Print(closeErr)
+		ctx, cancel := context.WithTimeout(context.Background(), loaded.ShutdownTimeout)
+		defer cancel()
+
+		// Shutdown stops accepting and waits for the connections in flight.
+		if shutdownErr := server.Shutdown(ctx); shutdownErr != nil {
+			log.Print(shutdownErr)
 		}
 
-		if endErr := backend.End(); endErr != nil {
-			log.Print(endErr)
+		if shutdownErr := backend.Shutdown(ctx); shutdownErr != nil {
+			log.Print(shutdownErr)
 		}
+
+		close(shutdownChan)
 	}()
 
-	http.Serve(listener, backend)
+	if serveErr := server.Serve(listener); serveErr != http.ErrServerClosed {
+		log.Print(serveErr)
+		signalChan <- syscall.SIGTERM
+	}
+
+	<-shutdownChan
 }
@@ -18,8 +18,10 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/kagucho/tsubonesystem3/backend"
-	"github.com/kagucho/tsubonesystem3/configuration"
+	"github.com/kagucho/tsubonesystem3/backend/config"
 	"log"
 	"net"
 	"net/http/fcgi"
@@ -29,13 +31,18 @@ import (
 )
 
 func main() {
-	backend, backendErr := backend.New()
+	loaded, loadErr := config.Load()
+	if loadErr != nil {
+		log.Panic(loadErr)
+	}
+
+	backend, backendErr := backend.New(loaded)
 	if backendErr != nil {
 		log.Panic(backendErr)
 	}
 
-	listener, listenerErr := net.Listen(configuration.FcgiListenNet,
-		configuration.FcgiListenAddress)
+	listener, listenerErr := net.Listen(loaded.FcgiListenNet,
+		loaded.FcgiListenAddress)
 	if listenerErr != nil {
 		if endErr := backend.End(); endErr != nil {
 			log.Print(endErr)
@@ -44,6 +51,26 @@ func main() {
 		log.Panic(listenerErr)
 	}
 
+	go func() {
+		hangupChan := make(chan os.Signal, 1)
+		signal.Notify(hangupChan, syscall.SIGHUP)
+
+		for range hangupChan {
+			reloaded, loadErr := config.Load()
+			if loadErr != nil {
+				log.Print(loadErr)
+				continue
+			}
+
+			if reloadErr := backend.Reload(reloaded); reloadErr != nil {
+				log.Print(reloadErr)
+				continue
+			}
+
+			log.Print(`reloaded`)
+		}
+	}()
+
 	go func() {
 		signalChan := make(chan os.Signal, 2)
 		signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
@@ -52,11 +79,20 @@ func main() {
 		if closeErr := listener.Close(); closeErr != nil {
 			log.Print(closeErr)
 		}
-
-		if endErr := backend.End(); endErr != nil {
-			log.Print(endErr)
-		}
 	}()
 
-	fcgi.Serve(listener, backend)
+	if serveErr := fcgi.Serve(listener, backend); !errors.Is(serveErr, net.ErrClosed) {
+		log.Print(serveErr)
+	}
+
+	/*
+		The connections accepted so far keep serving the requests in flight
+		while the backend refuses new ones.
+	*/
+	ctx, cancel := context.WithTimeout(context.Background(), loaded.ShutdownTimeout)
+	defer cancel()
+
+	if shutdownErr := backend.Shutdown(ctx); shutdownErr != nil {
+		log.Print(shutdownErr)
+	}
 }
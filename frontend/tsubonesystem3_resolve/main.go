@@ -18,13 +18,19 @@
 package main
 
 import (
-	"github.com/kagucho/tsubonesystem3/configuration"
+	"github.com/kagucho/tsubonesystem3/backend/config"
 	"net"
 	"os"
 )
 
 func main() {
-	resolved, err := net.ResolveTCPAddr(``, configuration.ListenAddress)
+	config, err := config.Load()
+	if err != nil {
+		os.Stderr.WriteString(err.Error())
+		os.Exit(1)
+	}
+
+	resolved, err := net.ResolveTCPAddr(``, config.ListenAddress)
 	if err != nil {
 		os.Stderr.WriteString(err.Error())
 	}
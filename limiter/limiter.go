@@ -174,6 +174,6 @@ End releases the resources.
 After calling it, calling any functions bound to limiter will result in
 an unexpected result.
 */
-func (limiter Limiter) End() {
+func (limiter *Limiter) End() {
 	limiter.execution <- executionEnd
 }
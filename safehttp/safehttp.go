@@ -25,6 +25,7 @@ initialized with safehttp.New.
 */
 type ResponseWriter struct {
 	header  http.Header
+	status  int
 	wrapped http.ResponseWriter
 	written bool
 }
@@ -49,20 +50,53 @@ func (writer *ResponseWriter) Recover(callback func()) {
 	}
 }
 
+/*
+Status returns the status code written to the writer. It returns 0 if the header
+is not written.
+*/
+func (writer *ResponseWriter) Status() int {
+	return writer.status
+}
+
+/*
+Flush implements http.Flusher. It writes the header if not yet, and flushes the
+wrapped writer if it implements http.Flusher.
+*/
+func (writer *ResponseWriter) Flush() {
+	writer.prepare(http.StatusOK)
+
+	if flusher, ok := writer.wrapped.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+/*
+Stream forwards the opt-in into streaming to the wrapped writer if it implements
+Stream, like the one given by unchunked.Unchunked.
+*/
+func (writer *ResponseWriter) Stream() {
+	if streamer, ok := writer.wrapped.(interface {
+		Stream()
+	}); ok {
+		streamer.Stream()
+	}
+}
+
 // Write implements Write of http.ResponseWriter.
 func (writer *ResponseWriter) Write(bytes []byte) (int, error) {
-	writer.prepare()
+	writer.prepare(http.StatusOK)
 	return writer.wrapped.Write(bytes)
 }
 
 // WriteHeader implements WriteHeader of http.ResponseWriter.
 func (writer *ResponseWriter) WriteHeader(code int) {
-	writer.prepare()
+	writer.prepare(code)
 	writer.wrapped.WriteHeader(code)
 }
 
-func (writer *ResponseWriter) prepare() {
+func (writer *ResponseWriter) prepare(code int) {
 	if !writer.written {
+		writer.status = code
 		writer.written = true
 
 		header := writer.wrapped.Header()
@@ -56,20 +56,20 @@ SET sql_mode='ERROR_FOR_DIVISION_BY_ZERO,NO_AUTO_CREATE_USER,NO_ZERO_DATE,NO_ZER
 	8. different entrance
 */
 INSERT INTO `members` VALUES
-	(1, '1stDisplayID', '', X'212FCF817F21DC927E940DB25564E7CE16239AC28F910A8A92DB27FF78A9EF8F74C52792DCEC81704D490345998B5B26BD460D223664A25CD937BFE65AF9C1360CEF1BF4F2F0C4563E0DED64C363D7F563D715639AC464D93E791078592EE87A72DF0B74B79FFC2A2B3CD39EC246BADBFC78A70FDCFE29B661D94260C68ABD45B216DB25F7459EB3A97DE61A7A6F16C1DA54F538D972DF9B2A6D4179024ADDAC850560E066B72F67F259E7FD13D5ED104FFCD5EF87F4B55FEBAFD8351BC9E17B','1 !\\%_1\"#', '$&\\%_2\'(', 1901, '理学部第一部 数理情報科学科', '男', '1st@kagucho.net', '000-000-001'),
-	(2, '2ndDisplayID', '', X'E4F75C9CD3F14A462AD723F3A4030858B21411945DD99FDA8AC415B8F5990AE1F07C87C5B7DEB9737FFD301E6225735E80A5613670FF5D889EDDC1E782A15DD28D985C36AC427B2C52228BD7685C7DE1F527568334B8366BC2AF44ADB1A72728B1C11D1A08FC6E32B18E68A3DE8AFFC38DD3D2DE1371E0A3769B886011F59C7BE8EB77636CB643FF35A3ABE795CE77592F49AC08CFBC1629C1B4245FF21819EBB1F6CBC2F03CBD9401E704C855D29D7181E125A995CA2E2786DDD8472EA57951','2 !%_1\"#','$&\\%_2\'(', 1901, '', '女', '', '000-000-002'),
-	(3, '3rdDisplayID', '', X'CA340783B3603862994125C41B04DF6504F87F12E033FCCF40916FAEB5AC9EFAC7FBA31E22701595C85D9402B62CB61F00D844D943BF2E23BCF06BD0E72BC2A208C7537D966BD0D014E478521F6D287F67E0211833529D7F4E3CC3ADD0ABA1A7EC88827F39792CB3196F23AF35D1361D4A30A5B7C919E80E14E05BD50CAF4FEA1177F19B840E08588BE6F232CC07112051AE0276100EDAB6520F25B7490B18DCC90797780A63CCCEBFAE051B6B1A9A120F4AE4C9E6245CAC9FF4BAA90914366B','3 !\\%*1\"#','$&\\%_2\'(', 1901, '', '', '', '000-000-003'),
-	(4, '4thDisplayID', '', X'3A455FDC6C3088BB8DAD6AD3B7719749745025ECE574EFEA97A8E9C53C201509020FFB0803E964B3D73B14FF05213FF0F2B8B0120CCF65173511C8ABE6EFAE840C4CE5043DC5A9086F90074DF7A73768E231D731AF7CD991826EE7268C3CCA8E5EF29BEC68168BC138BC23BC8B118947F571947BD8C498D5A401D74F0C20E28BF7929D6CEBCF7D8E376C428B1A9C825FEDE945FAC5014D0AA6DD8B4F1C00CA98F53004BCC02DED695D6145CE13A6A4C6154262862186F1BC69769D3D5FE9FE12','4 !)_1\"#', '$&\\%_2\'(', 1901, '', '', '', ''),
-	(5, '5thDisplayID', '', X'E33E3AFD1F26E6F7CB96DB69861EA225364F69202AACE2029F1CF28117FFCA06390F6F34D4FC7B4773DC446C5D189854AE539A242098C3A9D4DB4367A2F88980D9383FC4F1C93EEC7BB79C5BE4FCB561708B880BC65335B633F73AA40CD571EB14E97FE363A5888B11A6E41E51B5CAEEE31218E15FB864B0C754DD61A1CDD8BB28AE2A8294680FDBC8FB3EBC4BB4E0806BB88110B4621D46E6FD87878CB9FE1362D685B31FFAA44F6D1B34955A275ADCDBDBBF30749B9719356DFF8B89DA031C','5 !\\%_1\"#', '$&%+2\'(', 1901, '', '', '', ''),
-	(6, '6thDisplayID', '', X'105432B1CF2B44105B8229A74BBD11448ED32CB28931ED8C62C716D264098A1C86ACCE6783A4C57714B6BB975995F3ED1CE258580E79C4C4EC1AF4849DA1B5A6021F68E2D18C6ADE983BB070942CF94BEB71B6BCBEB4B28DE6BD6DBBC8107EF4F93FD42986DA4AC00D6BF6A609A56FBC51933D4919A1F0498D9CF6B77DC8DA9AE108CC0142BE9F46B9511BB87F1D5FDA6671022A51825CEEB800F08683BD391EAA3D448D5257696B0B394C816C3E42025E77BB05D065574914CE108087005E87','6 !\\%_1\"#', '$&\\%+2\'(', 2155, '', '', '', ''),
-	(7, '7thDisplayID', 'ob', X'E59CD10BDF035F000B87478764CF7BB57B6AD0D04CE7AC0CBC7DE27840EC8045607BAED12C2D94458A15575000BBA135F9C4E77FE1F375A06FBB6BC83AF13DCA9A5484EE2F9CD29EBA5FC82C24F2FF57464E249569B9B93FF0520E15B7589CA1ADDD9C6990EAEDFC1EB8990116BCF01F59C34F212069FC359DC2734B983A4E98CC8B5F3109D001EBE8562406857F4A39AEC1FC8099DBA11320FAF73B55FBD2C59E9BF2FAEE0A71FAD7973D1CA2EDCFD7ED7FBAAD1D933E117B6D51611AF4AAD4','7 !\\%_1\"#', '$&,_2\'(', 1901, '', '', '', '');
+	(1, '1stDisplayID', '', X'212FCF817F21DC927E940DB25564E7CE16239AC28F910A8A92DB27FF78A9EF8F74C52792DCEC81704D490345998B5B26BD460D223664A25CD937BFE65AF9C1360CEF1BF4F2F0C4563E0DED64C363D7F563D715639AC464D93E791078592EE87A72DF0B74B79FFC2A2B3CD39EC246BADBFC78A70FDCFE29B661D94260C68ABD45B216DB25F7459EB3A97DE61A7A6F16C1DA54F538D972DF9B2A6D4179024ADDAC850560E066B72F67F259E7FD13D5ED104FFCD5EF87F4B55FEBAFD8351BC9E17B','1 !\\%_1\"#', '$&\\%_2\'(', 1901, '理学部第一部 数理情報科学科', '男', '1st@kagucho.net', '000-000-001', 1),
+	(2, '2ndDisplayID', '', X'E4F75C9CD3F14A462AD723F3A4030858B21411945DD99FDA8AC415B8F5990AE1F07C87C5B7DEB9737FFD301E6225735E80A5613670FF5D889EDDC1E782A15DD28D985C36AC427B2C52228BD7685C7DE1F527568334B8366BC2AF44ADB1A72728B1C11D1A08FC6E32B18E68A3DE8AFFC38DD3D2DE1371E0A3769B886011F59C7BE8EB77636CB643FF35A3ABE795CE77592F49AC08CFBC1629C1B4245FF21819EBB1F6CBC2F03CBD9401E704C855D29D7181E125A995CA2E2786DDD8472EA57951','2 !%_1\"#','$&\\%_2\'(', 1901, '', '女', '', '000-000-002', 1),
+	(3, '3rdDisplayID', '', X'CA340783B3603862994125C41B04DF6504F87F12E033FCCF40916FAEB5AC9EFAC7FBA31E22701595C85D9402B62CB61F00D844D943BF2E23BCF06BD0E72BC2A208C7537D966BD0D014E478521F6D287F67E0211833529D7F4E3CC3ADD0ABA1A7EC88827F39792CB3196F23AF35D1361D4A30A5B7C919E80E14E05BD50CAF4FEA1177F19B840E08588BE6F232CC07112051AE0276100EDAB6520F25B7490B18DCC90797780A63CCCEBFAE051B6B1A9A120F4AE4C9E6245CAC9FF4BAA90914366B','3 !\\%*1\"#','$&\\%_2\'(', 1901, '', '', '', '000-000-003', 1),
+	(4, '4thDisplayID', '', X'3A455FDC6C3088BB8DAD6AD3B7719749745025ECE574EFEA97A8E9C53C201509020FFB0803E964B3D73B14FF05213FF0F2B8B0120CCF65173511C8ABE6EFAE840C4CE5043DC5A9086F90074DF7A73768E231D731AF7CD991826EE7268C3CCA8E5EF29BEC68168BC138BC23BC8B118947F571947BD8C498D5A401D74F0C20E28BF7929D6CEBCF7D8E376C428B1A9C825FEDE945FAC5014D0AA6DD8B4F1C00CA98F53004BCC02DED695D6145CE13A6A4C6154262862186F1BC69769D3D5FE9FE12','4 !)_1\"#', '$&\\%_2\'(', 1901, '', '', '', '', 1),
+	(5, '5thDisplayID', '', X'E33E3AFD1F26E6F7CB96DB69861EA225364F69202AACE2029F1CF28117FFCA06390F6F34D4FC7B4773DC446C5D189854AE539A242098C3A9D4DB4367A2F88980D9383FC4F1C93EEC7BB79C5BE4FCB561708B880BC65335B633F73AA40CD571EB14E97FE363A5888B11A6E41E51B5CAEEE31218E15FB864B0C754DD61A1CDD8BB28AE2A8294680FDBC8FB3EBC4BB4E0806BB88110B4621D46E6FD87878CB9FE1362D685B31FFAA44F6D1B34955A275ADCDBDBBF30749B9719356DFF8B89DA031C','5 !\\%_1\"#', '$&%+2\'(', 1901, '', '', '', '', 1),
+	(6, '6thDisplayID', '', X'105432B1CF2B44105B8229A74BBD11448ED32CB28931ED8C62C716D264098A1C86ACCE6783A4C57714B6BB975995F3ED1CE258580E79C4C4EC1AF4849DA1B5A6021F68E2D18C6ADE983BB070942CF94BEB71B6BCBEB4B28DE6BD6DBBC8107EF4F93FD42986DA4AC00D6BF6A609A56FBC51933D4919A1F0498D9CF6B77DC8DA9AE108CC0142BE9F46B9511BB87F1D5FDA6671022A51825CEEB800F08683BD391EAA3D448D5257696B0B394C816C3E42025E77BB05D065574914CE108087005E87','6 !\\%_1\"#', '$&\\%+2\'(', 2155, '', '', '', '', 1),
+	(7, '7thDisplayID', 'ob', X'E59CD10BDF035F000B87478764CF7BB57B6AD0D04CE7AC0CBC7DE27840EC8045607BAED12C2D94458A15575000BBA135F9C4E77FE1F375A06FBB6BC83AF13DCA9A5484EE2F9CD29EBA5FC82C24F2FF57464E249569B9B93FF0520E15B7589CA1ADDD9C6990EAEDFC1EB8990116BCF01F59C34F212069FC359DC2734B983A4E98CC8B5F3109D001EBE8562406857F4A39AEC1FC8099DBA11320FAF73B55FBD2C59E9BF2FAEE0A71FAD7973D1CA2EDCFD7ED7FBAAD1D933E117B6D51611AF4AAD4','7 !\\%_1\"#', '$&,_2\'(', 1901, '', '', '', '', 1);
 
-INSERT INTO `clubs` VALUES (1, 'prog', 'Prog部', 2), (2, 'web', 'Web部', 1);
+INSERT INTO `clubs` VALUES (1, 'prog', 'Prog部', 2, 1), (2, 'web', 'Web部', 1, 1);
 INSERT INTO `club_member` VALUES (1,2),(2,1),(1,1);
 
 INSERT INTO `officers` VALUES
-	('president', '局長', 1, 'management,privacy'),
-	('vice', '副局長', 1, 'privacy');
+	('president', '局長', 1, 'management,privacy', 1),
+	('vice', '副局長', 1, 'privacy', 1);
 
 SET sql_mode=@saved_sql_mode;
 SET foreign_key_checks=@saved_foreign_key_checks;
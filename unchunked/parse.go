@@ -30,13 +30,16 @@ type codingIndex uint
 
 const (
 	codingAny codingIndex = iota
+	codingBrotli
 	codingGzip
 	codingIdentity
+	codingZstd
 	codingUnknown
 )
 
 var codings = []string{
-	codingAny: `*`, codingGzip: `GZIP`, codingIdentity: `IDENTITY`,
+	codingAny: `*`, codingBrotli: `BR`, codingGzip: `GZIP`,
+	codingIdentity: `IDENTITY`, codingZstd: `ZSTD`,
 }
 
 func parseCodings(field string, begin int) (codingIndex, int) {
@@ -83,6 +83,27 @@ func TestParseCodings(t *testing.T) {
 		*/
 		{`GZIP`, codingGzip},
 
+		/*
+			RFC 7932 - Brotli Compressed Data Format
+			13.  IANA Considerations
+			https://tools.ietf.org/html/rfc7932#section-13
+			> The "HTTP Content Coding Registry" has been updated with
+			> the registration below:
+			> Name: br
+		*/
+		{`BR`, codingBrotli},
+
+		/*
+			RFC 8878 - Zstandard Compression and the 'application/zstd'
+			Media Type
+			7.2.  Content Encoding
+			https://tools.ietf.org/html/rfc8878#section-7.2
+			> IANA has added the following entry to the "HTTP Content
+			> Coding Registry"
+			> Name: zstd
+		*/
+		{`ZSTD`, codingZstd},
+
 		/*
 			RFC 7231 - Hypertext Transfer Protocol (HTTP/1.1): Semantics and Content
 			5.3.4.  Accept-Encoding
@@ -0,0 +1,205 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package unchunked
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"mime"
+	"strings"
+)
+
+/*
+Policy is a structure describing how unchunked.Unchunked compresses responses.
+
+Levels associates the content-codings, "br", "gzip" and "zstd", with their
+compression levels as defined by their encoders. A coding absent from it is
+never used.
+
+A response whose body is smaller than MinSize bytes is not compressed unless
+it is streamed; the compression costs more than it saves for such a body.
+
+A response whose Content-Type matches one of Excluded is not compressed. An
+entry ending with a slash like "image/" matches any subtype.
+
+A response whose Content-Encoding or Content-Length is set by the handler is
+never compressed.
+*/
+type Policy struct {
+	Levels   map[string]int
+	MinSize  int
+	Excluded []string
+}
+
+/*
+encoder is an interface of the compressing writers of the content-codings. Flush
+writes the pending data, and Close writes the remaining data and the trailer.
+*/
+type encoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+/*
+DefaultPolicy is the policy of unchunked.New. The levels are moderate as the
+responses are compressed on the fly, and most of them are tiny JSON documents.
+*/
+var DefaultPolicy = Policy{
+	Levels: map[string]int{
+		`br`:   5,
+		`gzip`: gzip.DefaultCompression,
+		`zstd`: 3,
+	},
+	MinSize: 1024,
+	Excluded: []string{
+		`application/gzip`, `application/zip`, `application/zstd`,
+		`audio/`, `font/woff`, `font/woff2`, `image/gif`, `image/jpeg`,
+		`image/png`, `image/webp`, `video/`,
+	},
+}
+
+/*
+encoderPreference is the content-codings in the order of the preference of the
+server, used when the client prefers them equally.
+*/
+var encoderPreference = [...]codingIndex{codingZstd, codingBrotli, codingGzip}
+
+/*
+anyCoding is the content-coding which "*" or the absence of Accept-Encoding
+stands for. The others must be listed explicitly; a client saying so does not
+necessarily know newer codings like "br" and "zstd", which cannot be decoded
+by many of the deployed clients.
+*/
+const anyCoding = codingGzip
+
+/*
+newEncoders associates the content-codings with the functions returning their
+encoders writing to the given writer at the given level.
+*/
+var newEncoders = [...]func(io.Writer, int) (encoder, error){
+	codingBrotli: func(writer io.Writer, level int) (encoder, error) {
+		return brotli.NewWriterLevel(writer, level), nil
+	},
+	codingGzip: func(writer io.Writer, level int) (encoder, error) {
+		return gzip.NewWriterLevel(writer, level)
+	},
+	codingIdentity: nil,
+	codingZstd: func(writer io.Writer, level int) (encoder, error) {
+		/*
+			Each response is compressed by one goroutine; it is the
+			requests that are concurrent.
+		*/
+		return zstd.NewWriter(writer,
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	},
+}
+
+/*
+level returns the compression level of the given content-coding, and false if
+it is disabled.
+*/
+func (policy Policy) level(coding codingIndex) (int, bool) {
+	level, present := policy.Levels[strings.ToLower(codings[coding])]
+	return level, present
+}
+
+// excludes returns whether the given Content-Type is excluded from compression.
+func (policy Policy) excludes(contentType string) bool {
+	if contentType == `` {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, excluded := range policy.Excluded {
+		if mediaType == excluded ||
+			strings.HasSuffix(excluded, `/`) && strings.HasPrefix(mediaType, excluded) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+choose returns the content-coding to use for the given Accept-Encoding fields.
+It returns codingIdentity if no compression is acceptable. "*" and the absence
+of the fields only make anyCoding acceptable.
+
+RFC 7231 - Hypertext Transfer Protocol (HTTP/1.1): Semantics and Content
+5.3.4.  Accept-Encoding
+https://tools.ietf.org/html/rfc7231#section-5.3.4
+*/
+func (policy Policy) choose(accepted []string, present bool) (codingIndex, int) {
+	var qs [codingUnknown]uint
+	var listed [codingUnknown]bool
+
+	if present {
+		parse(accepted, func(coding codingIndex, q uint) {
+			if coding < codingUnknown {
+				qs[coding] = q
+				listed[coding] = true
+			}
+		})
+	} else {
+		// > If no Accept-Encoding field is in the request, any
+		// > content-coding is considered acceptable by the user agent.
+		qs[codingAny] = 1 << qInitIndex
+		listed[codingAny] = true
+	}
+
+	chosen := codingIdentity
+	chosenLevel := 0
+	chosenQ := uint(0)
+
+	for _, coding := range encoderPreference {
+		level, enabled := policy.level(coding)
+		if !enabled {
+			continue
+		}
+
+		var q uint
+		if listed[coding] {
+			q = qs[coding]
+		} else if listed[codingAny] && coding == anyCoding {
+			q = qs[codingAny]
+		} else {
+			continue
+		}
+
+		if q > chosenQ {
+			chosen = coding
+			chosenLevel = level
+			chosenQ = q
+		}
+	}
+
+	// The compression is preferred unless the client prefers the identity.
+	if chosenQ == 0 || chosenQ < qs[codingIdentity] {
+		return codingIdentity, 0
+	}
+
+	return chosen, chosenLevel
+}
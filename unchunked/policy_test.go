@@ -0,0 +1,95 @@
+/*
+	Copyright (C) 2017  Kagucho <kagucho.net@gmail.com>
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or (at
+	your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package unchunked
+
+import "testing"
+
+func TestPolicyChoose(t *testing.T) {
+	t.Parallel()
+
+	policy := Policy{Levels: map[string]int{`br`: 1, `gzip`: 2, `zstd`: 3}}
+
+	for _, test := range [...]struct {
+		description string
+		accepted    []string
+		expected    codingIndex
+		level       int
+	}{
+		{`Absent`, nil, codingGzip, 2},
+		{`Empty`, []string{``}, codingIdentity, 0},
+		{`Gzip`, []string{`gzip`}, codingGzip, 2},
+		{`Browser`, []string{`gzip, deflate, br`}, codingBrotli, 1},
+		{`PreferenceOfServer`, []string{`gzip, br, zstd`}, codingZstd, 3},
+		{`PreferenceOfClient`, []string{`zstd;q=0.5, gzip`}, codingGzip, 2},
+		{`Any`, []string{`*;q=0.5`}, codingGzip, 2},
+		{`AnyExcludingGzip`, []string{`gzip;q=0, *`}, codingIdentity, 0},
+		{`AnyWithBrotli`, []string{`br;q=0.5, *`}, codingGzip, 2},
+		{`AnyWithZstd`, []string{`zstd, *;q=0.5`}, codingZstd, 3},
+		{`Identity`, []string{`gzip;q=0.5, identity`}, codingIdentity, 0},
+		{`TieWithIdentity`, []string{`gzip, identity`}, codingGzip, 2},
+		{`Unacceptable`, []string{`*;q=0`}, codingIdentity, 0},
+	} {
+		test := test
+
+		t.Run(test.description, func(t *testing.T) {
+			t.Parallel()
+
+			coding, level := policy.choose(test.accepted, test.accepted != nil)
+			if coding != test.expected || level != test.level {
+				t.Errorf(`expected %v at %v, got %v at %v`,
+					test.expected, test.level, coding, level)
+			}
+		})
+	}
+
+	t.Run(`Disabled`, func(t *testing.T) {
+		t.Parallel()
+
+		gzipOnly := Policy{Levels: map[string]int{`gzip`: 1}}
+		if coding, _ := gzipOnly.choose([]string{`br, zstd`}, true); coding != codingIdentity {
+			t.Errorf(`expected %v, got %v`, codingIdentity, coding)
+		}
+	})
+}
+
+func TestPolicyExcludes(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range [...]struct {
+		contentType string
+		expected    bool
+	}{
+		{``, false},
+		{`application/json`, false},
+		{`image/svg+xml`, false},
+		{`image/png`, true},
+		{`video/mp4`, true},
+		{`font/woff2`, true},
+		{`application/ZIP; charset=binary`, true},
+	} {
+		test := test
+
+		t.Run(test.contentType, func(t *testing.T) {
+			t.Parallel()
+
+			if result := DefaultPolicy.excludes(test.contentType); result != test.expected {
+				t.Errorf(`expected %v, got %v`, test.expected, result)
+			}
+		})
+	}
+}
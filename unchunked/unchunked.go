@@ -19,74 +19,127 @@
 package unchunked
 
 import (
-	"compress/gzip"
 	"net/http"
+	"strings"
 )
 
 // Unchunked is a structure which implements an unchunked http.ResponseWriter.
 type Unchunked struct {
-	handle http.HandlerFunc
+	handle    http.HandlerFunc
+	threshold int
+	policy    Policy
 }
 
-// New returns a new unchunked.Unchunked.
+/*
+DefaultThreshold is the size of the body after which unchunked.New starts
+streaming a response opted in with unchunked.Stream.
+*/
+const DefaultThreshold = 65536
+
+// New returns a new unchunked.Unchunked with unchunked.DefaultThreshold.
 func New(handle http.HandlerFunc) Unchunked {
-	return Unchunked{handle}
+	return NewThreshold(handle, DefaultThreshold)
+}
+
+/*
+NewThreshold returns a new unchunked.Unchunked which starts streaming a response
+opted in with unchunked.Stream when its body, after compression if any, exceeds
+the given size in bytes. The other responses are always buffered to tell
+Content-Length.
+*/
+func NewThreshold(handle http.HandlerFunc, threshold int) Unchunked {
+	return Unchunked{handle, threshold, DefaultPolicy}
+}
+
+/*
+WithPolicy returns a copy of unchunked.Unchunked compressing responses with the
+given policy instead of unchunked.DefaultPolicy.
+*/
+func (unchunked Unchunked) WithPolicy(policy Policy) Unchunked {
+	unchunked.policy = policy
+	return unchunked
+}
+
+/*
+Stream opts the response written with the given writer into streaming. Its body
+is buffered to tell Content-Length only while it is small, and sent chunked once
+it exceeds the threshold. It returns false if the writer is not given by
+unchunked.Unchunked or a wrapper forwarding Stream.
+
+Flushing the writer with http.Flusher also starts streaming immediately,
+regardless of the threshold.
+*/
+func Stream(writer http.ResponseWriter) bool {
+	streamer, ok := writer.(interface {
+		Stream()
+	})
+	if ok {
+		streamer.Stream()
+	}
+
+	return ok
+}
+
+/*
+Negotiate returns the content-coding the request prefers among the offered ones,
+or an empty string if the identity is preferred. The content-codings are named
+as the keys of unchunked.Policy.Levels, and those not supported by
+unchunked.Policy are ignored.
+
+This lets a handler serving representations encoded in advance choose one in
+the same manner as unchunked.Unchunked.
+*/
+func Negotiate(request *http.Request, offered ...string) string {
+	levels := make(map[string]int, len(offered))
+	for _, coding := range offered {
+		levels[coding] = 0
+	}
+
+	accepted, present := request.Header[`Accept-Encoding`]
+	coding, _ := Policy{Levels: levels}.choose(accepted, present)
+	if coding == codingIdentity {
+		return ``
+	}
+
+	return strings.ToLower(codings[coding])
 }
 
 // ServeHTTP serves an unchunked response.
 func (unchunked Unchunked) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	var gzipEncode bool
-
-	if accepted, present := request.Header[`Accept-Encoding`]; present {
-		const unsetGzipQ = 2 << qInitIndex
-		gzipQ := uint(unsetGzipQ)
-		identityQ := uint(0)
-
-		parse(accepted, func(coding codingIndex, q uint) {
-			switch coding {
-			case codingAny:
-				if gzipQ == unsetGzipQ {
-					gzipQ = q
-				}
-
-			case codingGzip:
-				gzipQ = q
-
-			case codingIdentity:
-				identityQ = q
-			}
-		})
-
-		gzipEncode = gzipQ >= identityQ &&
-			gzipQ > 0 && gzipQ != unsetGzipQ
-	} else {
-		gzipEncode = true
+	accepted, present := request.Header[`Accept-Encoding`]
+	coding, level := unchunked.policy.choose(accepted, present)
+
+	/*
+		RFC 7231 - Hypertext Transfer Protocol (HTTP/1.1): Semantics and Content
+		7.1.4.  Vary
+		https://tools.ietf.org/html/rfc7231#section-7.1.4
+	*/
+	if len(unchunked.policy.Levels) > 0 {
+		writer.Header().Add(`Vary`, `Accept-Encoding`)
 	}
 
-	commonWriter := unchunkedResponseWriter{
-		writer, &unchunkedResponseWriterState{code: http.StatusOK},
+	identityWriter := identityUnchunkedResponseWriter{
+		unchunkedResponseWriter{
+			writer, &unchunkedResponseWriterState{
+				code: http.StatusOK, threshold: unchunked.threshold,
+			},
+		},
 	}
 
-	if gzipEncode {
-		writer.Header().Set(`Content-Encoding`, `gzip`)
-		gzipWriter := gzipUnchunkedResponseWriter{
-			unchunkedResponseWriter: commonWriter,
-		}
-
-		var err error
-		gzipWriter.gzip, err = gzip.NewWriterLevel(
-			&gzipWriter.unchunkedResponseWriter.state.buffer,
-			gzip.BestCompression)
-		if err != nil {
-			panic(err)
-		}
-
-		unchunked.handle(gzipWriter, request)
-		gzipWriter.finalize()
-	} else {
-		identityWriter := identityUnchunkedResponseWriter{commonWriter}
-
+	if coding == codingIdentity {
 		unchunked.handle(identityWriter, request)
 		identityWriter.finalize()
+
+		return
+	}
+
+	encodingWriter := encodingUnchunkedResponseWriter{
+		identityWriter,
+		&encodingState{
+			coding: coding, level: level, policy: &unchunked.policy,
+		},
 	}
+
+	unchunked.handle(encodingWriter, request)
+	encodingWriter.finalize()
 }
@@ -18,29 +18,26 @@
 package unchunked
 
 import (
+	"compress/gzip"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 )
 
-type helloHandler struct {
-	encoding *string
-}
-
-func (handler helloHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	*handler.encoding = writer.Header().Get(`Content-Encoding`)
+func helloHandler(writer http.ResponseWriter, request *http.Request) {
 	writer.Write([]byte{'\n'})
 }
 
 func TestUnchunked(t *testing.T) {
 	t.Parallel()
 
-	encoding := ``
-	handler := helloHandler{encoding: &encoding}
 	var unchunked Unchunked
 
 	if !t.Run(`New`, func(t *testing.T) {
-		unchunked = New(handler)
+		unchunked = New(helloHandler).WithPolicy(Policy{
+			Levels: map[string]int{`gzip`: gzip.BestCompression},
+		})
 	}) {
 		t.FailNow()
 	}
@@ -125,15 +122,23 @@ func TestUnchunked(t *testing.T) {
 
 				if test.gzip {
 					const expectedEncoding = `gzip`
-					if *handler.encoding != expectedEncoding {
-						t.Errorf(`invalid Content-Encoding in field when Write was called; expected %q, got %q`,
-							expectedEncoding, handler.encoding)
+					if result := recorder.HeaderMap.Get(`Content-Encoding`); result != expectedEncoding {
+						t.Errorf(`invalid Content-Encoding field in header; expected %q, got %q`,
+							expectedEncoding, result)
 					}
 
-					const expectedBody = "\x1F\x8B\x08\x00\x00\x09\x6E\x88\x02\xFF\xE2\x02\x04\x00\x00\xFF\xFF\x93\x06\xD7\x32\x01\x00\x00\x00"
-					if result := recorder.Body.String(); result != expectedBody {
-						t.Errorf(`invalid body; expected %X, got %X`,
-							expectedBody, result)
+					reader, err := gzip.NewReader(recorder.Body)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					body, err := ioutil.ReadAll(reader)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if string(body) != "\n" {
+						t.Errorf(`invalid body; expected "\n", got %q`, body)
 					}
 				} else {
 					const expectedBody = "\n"
@@ -146,3 +151,33 @@ func TestUnchunked(t *testing.T) {
 		}
 	})
 }
+
+func TestNegotiate(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range [...]struct {
+		description string
+		accepted    string
+		offered     []string
+		expected    string
+	}{
+		{`None`, `gzip, br`, nil, ``},
+		{`Gzip`, `gzip, br`, []string{`gzip`}, `gzip`},
+		{`Brotli`, `gzip, br`, []string{`br`, `gzip`}, `br`},
+		{`Unacceptable`, `gzip`, []string{`br`}, ``},
+		{`Unsupported`, `deflate`, []string{`deflate`}, ``},
+	} {
+		test := test
+
+		t.Run(test.description, func(t *testing.T) {
+			t.Parallel()
+
+			request := httptest.NewRequest(`GET`, `http://kagucho.net/`, nil)
+			request.Header.Set(`Accept-Encoding`, test.accepted)
+
+			if result := Negotiate(request, test.offered...); result != test.expected {
+				t.Errorf(`expected %q, got %q`, test.expected, result)
+			}
+		})
+	}
+}
@@ -19,15 +19,23 @@ package unchunked
 
 import (
 	"bytes"
-	"compress/gzip"
-	"io"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
+/*
+unchunkedResponseWriterState is the state of an unchunked response. streaming
+tells the handler flushed the response, giving up Content-Length, and the
+header is already written. stream tells the handler opted in with
+unchunked.Stream so that the body exceeding threshold will be flushed.
+*/
 type unchunkedResponseWriterState struct {
-	buffer bytes.Buffer
-	code   int
+	buffer    bytes.Buffer
+	code      int
+	stream    bool
+	streaming bool
+	threshold int
 }
 
 type unchunkedResponseWriter struct {
@@ -39,12 +47,70 @@ type identityUnchunkedResponseWriter struct {
 	unchunkedResponseWriter
 }
 
-type gzipUnchunkedResponseWriter struct {
-	unchunkedResponseWriter
-	gzip *gzip.Writer
+/*
+encodingUnchunkedResponseWriter is a writer compressing the body with the
+content-coding chosen for the request. It holds the body until it is decided
+whether to compress it, which requires MinSize of the policy and the header
+given by the handler. It writes as identityUnchunkedResponseWriter if it decides
+not to compress.
+*/
+type encodingUnchunkedResponseWriter struct {
+	identityUnchunkedResponseWriter
+	encoding *encodingState
+}
+
+/*
+encodingState is the state of the compression of a response. raw is the body
+held until decided gets true. encoder is nil if it is decided not to compress.
+*/
+type encodingState struct {
+	coding  codingIndex
+	level   int
+	policy  *Policy
+	raw     bytes.Buffer
+	decided bool
+	encoder encoder
+}
+
+/*
+flush writes the header if not yet and the buffered body, and flushes the
+wrapped writer. The response will be chunked as it lacks Content-Length.
+*/
+func (writer unchunkedResponseWriter) flush() {
+	if !writer.state.streaming {
+		writer.ResponseWriter.WriteHeader(writer.state.code)
+		writer.state.streaming = true
+	}
+
+	writer.state.buffer.WriteTo(writer.ResponseWriter)
+
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+/*
+spill flushes the buffered body if the response is opted in with
+unchunked.Stream and the body exceeds the threshold.
+*/
+func (writer unchunkedResponseWriter) spill() {
+	if writer.state.stream &&
+		writer.state.buffer.Len() > writer.state.threshold {
+		writer.flush()
+	}
+}
+
+// Stream opts the response into streaming. See unchunked.Stream.
+func (writer unchunkedResponseWriter) Stream() {
+	writer.state.stream = true
 }
 
 func (writer unchunkedResponseWriter) finalize() {
+	if writer.state.streaming {
+		writer.state.buffer.WriteTo(writer.ResponseWriter)
+		return
+	}
+
 	writer.ResponseWriter.Header().Set(`Content-Length`,
 		strconv.Itoa(writer.state.buffer.Len()))
 	writer.ResponseWriter.WriteHeader(writer.state.code)
@@ -52,15 +118,15 @@ func (writer unchunkedResponseWriter) finalize() {
 }
 
 func (writer identityUnchunkedResponseWriter) Write(body []byte) (int, error) {
-	var virtualWriter io.Writer
-	if writer.Header().Get(`Content-Length`) == `` {
-		virtualWriter = &writer.state.buffer
-	} else {
-		virtualWriter = writer.ResponseWriter
+	if writer.Header().Get(`Content-Length`) != `` {
 		writer.state.code = 0
+		return writer.ResponseWriter.Write(body)
 	}
 
-	return virtualWriter.Write(body)
+	written, err := writer.state.buffer.Write(body)
+	writer.spill()
+
+	return written, err
 }
 
 func (writer identityUnchunkedResponseWriter) WriteHeader(code int) {
@@ -72,21 +138,129 @@ func (writer identityUnchunkedResponseWriter) WriteHeader(code int) {
 	}
 }
 
+/*
+Flush implements http.Flusher. It lets the handler stream the response at the
+cost of Content-Length, for example for Server-Sent Events.
+*/
+func (writer identityUnchunkedResponseWriter) Flush() {
+	if writer.state.code == 0 {
+		if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		return
+	}
+
+	writer.unchunkedResponseWriter.flush()
+}
+
 func (writer identityUnchunkedResponseWriter) finalize() {
 	if writer.unchunkedResponseWriter.state.code != 0 {
 		writer.unchunkedResponseWriter.finalize()
 	}
 }
 
-func (writer gzipUnchunkedResponseWriter) Write(body []byte) (int, error) {
-	return writer.gzip.Write(body)
+func (writer encodingUnchunkedResponseWriter) Write(body []byte) (int, error) {
+	state := writer.encoding
+	if !state.decided {
+		written, err := state.raw.Write(body)
+		if state.raw.Len() > 0 && state.raw.Len() >= state.policy.MinSize {
+			writer.decide(false)
+			writer.spill()
+		}
+
+		return written, err
+	}
+
+	if state.encoder == nil {
+		return writer.identityUnchunkedResponseWriter.Write(body)
+	}
+
+	written, err := state.encoder.Write(body)
+	writer.spill()
+
+	return written, err
+}
+
+func (writer encodingUnchunkedResponseWriter) WriteHeader(code int) {
+	if writer.encoding.decided && writer.encoding.encoder == nil {
+		writer.identityUnchunkedResponseWriter.WriteHeader(code)
+	} else {
+		writer.state.code = code
+	}
+}
+
+/*
+Flush implements http.Flusher. It decides to compress the body regardless of
+its size, and flushes the compressed data of the body written so far.
+*/
+func (writer encodingUnchunkedResponseWriter) Flush() {
+	if !writer.encoding.decided {
+		writer.decide(true)
+	}
+
+	if writer.encoding.encoder == nil {
+		writer.identityUnchunkedResponseWriter.Flush()
+		return
+	}
+
+	if err := writer.encoding.encoder.Flush(); err != nil {
+		panic(err)
+	}
+
+	writer.unchunkedResponseWriter.flush()
 }
 
-func (writer gzipUnchunkedResponseWriter) WriteHeader(code int) {
-	writer.state.code = code
+/*
+decide decides whether to compress the body, and writes the body held so far.
+The size of the body is not considered if the given force is true.
+*/
+func (writer encodingUnchunkedResponseWriter) decide(force bool) {
+	state := writer.encoding
+	state.decided = true
+
+	header := writer.Header()
+	if (force || state.raw.Len() > 0 && state.raw.Len() >= state.policy.MinSize) &&
+		header.Get(`Content-Encoding`) == `` &&
+		header.Get(`Content-Length`) == `` &&
+		!state.policy.excludes(header.Get(`Content-Type`)) {
+		var err error
+		state.encoder, err = newEncoders[state.coding](&writer.state.buffer, state.level)
+		if err != nil {
+			panic(err)
+		}
+
+		header.Set(`Content-Encoding`, strings.ToLower(codings[state.coding]))
+
+		if _, err := state.encoder.Write(state.raw.Bytes()); err != nil {
+			panic(err)
+		}
+	} else {
+		if header.Get(`Content-Length`) != `` {
+			writer.identityUnchunkedResponseWriter.WriteHeader(writer.state.code)
+		}
+
+		if _, err := writer.identityUnchunkedResponseWriter.Write(state.raw.Bytes()); err != nil {
+			panic(err)
+		}
+	}
+
+	state.raw = bytes.Buffer{}
 }
 
-func (writer gzipUnchunkedResponseWriter) finalize() {
-	writer.gzip.Close()
+func (writer encodingUnchunkedResponseWriter) finalize() {
+	if !writer.encoding.decided {
+		writer.decide(false)
+	}
+
+	if writer.encoding.encoder == nil {
+		writer.identityUnchunkedResponseWriter.finalize()
+		return
+	}
+
+	if err := writer.encoding.encoder.Close(); err != nil {
+		panic(err)
+	}
+
 	writer.unchunkedResponseWriter.finalize()
 }
@@ -19,8 +19,10 @@ package unchunked
 
 import (
 	"compress/gzip"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 )
 
@@ -120,21 +122,95 @@ func TestUnchunkedResponseWriter(t *testing.T) {
 			testCommon(t, false)
 		})
 	})
-}
 
-func TestGzipUnchunkedResponseWriter(t *testing.T) {
-	recorder := httptest.NewRecorder()
+	t.Run(`Flush`, func(t *testing.T) {
+		t.Parallel()
+
+		recorder := httptest.NewRecorder()
+
+		unchunked := identityUnchunkedResponseWriter{
+			unchunkedResponseWriter{
+				recorder,
+				&unchunkedResponseWriterState{
+					code: http.StatusOK,
+				},
+			},
+		}
+
+		unchunked.WriteHeader(http.StatusAccepted)
+		unchunked.Write([]byte{'1'})
+		unchunked.Flush()
+
+		if recorder.Code != http.StatusAccepted {
+			t.Errorf(`invalid status code; expected %v, got %v`,
+				http.StatusAccepted, recorder.Code)
+		}
+
+		if !recorder.Flushed {
+			t.Error(`expected flushed`)
+		}
+
+		if result := recorder.Body.String(); result != `1` {
+			t.Errorf(`invalid body; expected "1", got %q`, result)
+		}
 
-	unchunked := gzipUnchunkedResponseWriter{
-		unchunkedResponseWriter: unchunkedResponseWriter{
-			recorder,
-			&unchunkedResponseWriterState{code: http.StatusOK},
+		unchunked.Write([]byte{'2'})
+		unchunked.finalize()
+
+		if result := recorder.HeaderMap.Get(`Content-Length`); result != `` {
+			t.Errorf(`invalid Content-Length field in header; expected none, got %q`,
+				result)
+		}
+
+		if result := recorder.Body.String(); result != `12` {
+			t.Errorf(`invalid body; expected "12", got %q`, result)
+		}
+	})
+}
+
+/*
+newTestEncodingWriter returns encodingUnchunkedResponseWriter compressing with
+gzip at the best level, writing to the given recorder.
+*/
+func newTestEncodingWriter(recorder *httptest.ResponseRecorder, policy Policy) encodingUnchunkedResponseWriter {
+	return encodingUnchunkedResponseWriter{
+		identityUnchunkedResponseWriter{
+			unchunkedResponseWriter{
+				recorder,
+				&unchunkedResponseWriterState{code: http.StatusOK},
+			},
+		},
+		&encodingState{
+			coding: codingGzip, level: gzip.BestCompression,
+			policy: &policy,
 		},
 	}
+}
+
+func testGunzip(t *testing.T, recorder *httptest.ResponseRecorder) string {
+	if result := recorder.HeaderMap.Get(`Content-Encoding`); result != `gzip` {
+		t.Errorf(`invalid Content-Encoding field in header; expected "gzip", got %q`,
+			result)
+	}
 
-	unchunked.gzip, _ = gzip.NewWriterLevel(
-		&unchunked.unchunkedResponseWriter.state.buffer,
-		gzip.BestCompression)
+	reader, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+func TestEncodingUnchunkedResponseWriter(t *testing.T) {
+	t.Parallel()
+
+	recorder := httptest.NewRecorder()
+	unchunked := newTestEncodingWriter(recorder, Policy{})
 
 	unchunked.WriteHeader(http.StatusBadRequest)
 	unchunked.Write([]byte{'\n'})
@@ -145,15 +221,179 @@ func TestGzipUnchunkedResponseWriter(t *testing.T) {
 			http.StatusBadRequest, recorder.Code)
 	}
 
-	const expectedLength = `25`
+	expectedLength := strconv.Itoa(recorder.Body.Len())
 	if result := recorder.HeaderMap.Get(`Content-Length`); result != expectedLength {
 		t.Errorf(`invalid Content-Length field in header; expected %q, got %q`,
 			expectedLength, result)
 	}
 
-	const expectedBody = "\x1F\x8B\x08\x00\x00\x09\x6E\x88\x02\xFF\xE2\x02\x04\x00\x00\xFF\xFF\x93\x06\xD7\x32\x01\x00\x00\x00"
-	if result := recorder.Body.String(); result != expectedBody {
-		t.Errorf(`invalid body; expected %X, got %X`,
-			expectedBody, result)
+	if result := testGunzip(t, recorder); result != "\n" {
+		t.Errorf(`invalid body; expected "\n", got %q`, result)
+	}
+}
+
+func TestEncodingUnchunkedResponseWriterFlush(t *testing.T) {
+	t.Parallel()
+
+	recorder := httptest.NewRecorder()
+
+	// Flushing compresses the body regardless of MinSize.
+	unchunked := newTestEncodingWriter(recorder, Policy{MinSize: 1024})
+
+	unchunked.Write([]byte{'1'})
+	unchunked.Flush()
+
+	if !recorder.Flushed {
+		t.Error(`expected flushed`)
+	}
+
+	flushed := recorder.Body.Len()
+	if flushed == 0 {
+		t.Error(`expected the flushed body`)
+	}
+
+	unchunked.Write([]byte{'2'})
+	unchunked.finalize()
+
+	if recorder.Body.Len() <= flushed {
+		t.Error(`expected the rest of the body`)
+	}
+
+	if result := recorder.HeaderMap.Get(`Content-Length`); result != `` {
+		t.Errorf(`invalid Content-Length field in header; expected none, got %q`,
+			result)
+	}
+
+	if result := testGunzip(t, recorder); result != `12` {
+		t.Errorf(`invalid body; expected "12", got %q`, result)
+	}
+}
+
+func TestEncodingUnchunkedResponseWriterPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range [...]struct {
+		description string
+		header      map[string]string
+		body        string
+		compressed  bool
+	}{
+		{`Large`, nil, `1234`, true},
+		{`Small`, nil, `123`, false},
+		{`Excluded`, map[string]string{`Content-Type`: `image/png`}, `1234`, false},
+		{`ExcludedSubtype`, map[string]string{`Content-Type`: `video/webm`}, `1234`, false},
+		{`Included`, map[string]string{`Content-Type`: `application/json; charset=utf-8`}, `1234`, true},
+		{`Encoded`, map[string]string{`Content-Encoding`: `br`}, `1234`, false},
+		{`WithContentLength`, map[string]string{`Content-Length`: `4`}, `1234`, false},
+	} {
+		test := test
+
+		t.Run(test.description, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			unchunked := newTestEncodingWriter(recorder, Policy{
+				MinSize:  4,
+				Excluded: []string{`image/png`, `video/`},
+			})
+
+			for key, value := range test.header {
+				unchunked.Header().Set(key, value)
+			}
+
+			for index := range test.body {
+				unchunked.Write([]byte{test.body[index]})
+			}
+
+			unchunked.finalize()
+
+			if test.compressed {
+				if result := testGunzip(t, recorder); result != test.body {
+					t.Errorf(`invalid body; expected %q, got %q`,
+						test.body, result)
+				}
+
+				return
+			}
+
+			expectedEncoding := test.header[`Content-Encoding`]
+			if result := recorder.HeaderMap.Get(`Content-Encoding`); result != expectedEncoding {
+				t.Errorf(`invalid Content-Encoding field in header; expected %q, got %q`,
+					expectedEncoding, result)
+			}
+
+			expectedLength := strconv.Itoa(len(test.body))
+			if result := recorder.HeaderMap.Get(`Content-Length`); result != expectedLength {
+				t.Errorf(`invalid Content-Length field in header; expected %q, got %q`,
+					expectedLength, result)
+			}
+
+			if result := recorder.Body.String(); result != test.body {
+				t.Errorf(`invalid body; expected %q, got %q`,
+					test.body, result)
+			}
+		})
+	}
+}
+
+func TestUnchunkedResponseWriterStream(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range [...]struct {
+		description string
+		stream      bool
+		body        string
+		streamed    bool
+	}{
+		{`Small`, true, `12`, false},
+		{`Large`, true, `12345`, true},
+		{`NotOptedIn`, false, `12345`, false},
+	} {
+		test := test
+
+		t.Run(test.description, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+
+			unchunked := identityUnchunkedResponseWriter{
+				unchunkedResponseWriter{
+					recorder,
+					&unchunkedResponseWriterState{
+						code: http.StatusOK, threshold: 4,
+					},
+				},
+			}
+
+			if test.stream && !Stream(unchunked) {
+				t.Fatal(`expected the writer to support streaming`)
+			}
+
+			for index := range test.body {
+				unchunked.Write([]byte{test.body[index]})
+			}
+
+			if recorder.Flushed != test.streamed {
+				t.Errorf(`invalid flushed state; expected %v, got %v`,
+					test.streamed, recorder.Flushed)
+			}
+
+			unchunked.finalize()
+
+			expectedLength := strconv.Itoa(len(test.body))
+			if test.streamed {
+				expectedLength = ``
+			}
+
+			if result := recorder.HeaderMap.Get(`Content-Length`); result != expectedLength {
+				t.Errorf(`invalid Content-Length field in header; expected %q, got %q`,
+					expectedLength, result)
+			}
+
+			if result := recorder.Body.String(); result != test.body {
+				t.Errorf(`invalid body; expected %q, got %q`,
+					test.body, result)
+			}
+		})
 	}
 }
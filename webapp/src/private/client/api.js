@@ -44,11 +44,18 @@ function ajax(uri, method, token, data) {
 	const deferred = $.Deferred();
 	let uploadProgress = {loaded: 0, total: 0};
 
+	/*
+		RFC 7807 - Problem Details for HTTP APIs
+		https://tools.ietf.org/html/rfc7807
+		Validation errors are served as problems listing the invalid
+		fields instead of the errors conforming to RFC 6749.
+	*/
 	jqXHR.then(deferred.resolve,
 		xhr => deferred.reject(
 			xhr == 0 ?
 				"network_error" :
-				xhr.responseJSON && xhr.responseJSON.error));
+				xhr.responseJSON && (xhr.responseJSON.error ||
+					xhr.responseJSON["invalid-params"] && "invalid_params")));
 
 	xhr.upload.onprogress = deferred.notify;
 
@@ -65,6 +72,7 @@ export const error = id => ({
 	/* eslint-disable camelcase */
 	network_error:     "TsuboneSystemへの経路上に問題が発生しました。ネットワーク接続などを確認してください。",
 	invalid_grant:     "あんた誰?って言われちゃいました。もう一度サインインしてください。",
+	invalid_params:    "入力内容に誤りがあります。確認してください。",
 	not_found:         "見つからないってよ",
 	too_many_requests: "残念！！やりすぎです。ちょっと待ってください。",
 	server_error:      "サーバー側のエラーです。がびーん。",
@@ -129,6 +137,38 @@ export const getTokenWithRefreshToken =
 export const getClub =
 	(token, id) => ajax("/api/v0/club/" + id, "GET", token);
 
+/**
+	getHistory returns the history of the record of the given subject
+	identified with the given ID.
+	@function
+	@param {!String} token - The access token.
+	@param {!String} subject - "club", "member", "officer" or "party".
+	@param {!String} id - The ID, or the name of a party.
+	@returns {!module:private/promise} A promise resolved with the history.
+*/
+export const getHistory = (token, subject, id) =>
+	ajax("/api/v0/" + subject + "/" + encodeURIComponent(id) + "/history", "GET", token);
+
+/**
+	getAudit returns the entries of the audit log matching the given filter.
+	@function
+	@param {!String} token - The access token.
+	@param {!Object} filter - The filter, which may have actor, route, since
+	and until.
+	@returns {!module:private/promise} A promise resolved with the entries.
+*/
+export const getAudit =
+	(token, filter) => ajax("/api/v0/audit", "GET", token, filter);
+
+/**
+	verifyAudit returns the result of verifying the chain of the audit log.
+	@function
+	@param {!String} token - The access token.
+	@returns {!module:private/promise} A promise resolved with the result.
+*/
+export const verifyAudit =
+	token => ajax("/api/v0/audit/verify", "GET", token);
+
 /**
 	clubList returns the clubs.
 	@function
@@ -217,7 +257,7 @@ export function getMember(token, id) {
 }
 
 /**
-	memberDelete deletes a member identified with the given ID.
+	memberDelete archives a member identified with the given ID.
 	@function
 	@param {!String} token - The access token.
 	@param {!String} id - The ID.
@@ -226,6 +266,27 @@ export function getMember(token, id) {
 export const deleteMember =
 	(token, id) => ajax("/api/v0/member/" + id, "DELETE", token);
 
+/**
+	restoreDeletedMember restores an archived member identified with the given
+	ID.
+	@function
+	@param {!String} token - The access token.
+	@param {!String} id - The ID.
+	@returns {!module:private/promise} A promise describing the result.
+*/
+export const restoreDeletedMember =
+	(token, id) => ajax("/api/v0/deleted_member/" + id, "POST", token);
+
+/**
+	purgeDeletedMember erases an archived member identified with the given ID.
+	@function
+	@param {!String} token - The access token.
+	@param {!String} id - The ID.
+	@returns {!module:private/promise} A promise describing the result.
+*/
+export const purgeDeletedMember =
+	(token, id) => ajax("/api/v0/deleted_member/" + id, "DELETE", token);
+
 /**
 	TODO
 */
@@ -236,9 +297,12 @@ export const getMembersMails =
 	members returns the members. TODO
 	@function
 	@param {!String} token - The access token.
+	@param {?Object} query - The query, which may have affiliation, club,
+	confirmed, entrance, nickname, ob, realname and sort.
 	@returns {!module:private/promise} A promise resolved with the members.
 */
-export const getMembers = token => ajax("/api/v0/members", "GET", token);
+export const getMembers =
+	(token, query) => ajax("/api/v0/members", "GET", token, query);
 
 /**
 	officerDetail returns the details of the officer identified with the
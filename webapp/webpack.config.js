@@ -159,4 +159,22 @@ if (process.env.NODE_ENV == "production") {
 			warnings:      true,
 		})
 	);
+
+	/*
+		Compress files in the public directory in advance. The backend
+		serves the siblings instead of compressing them for each request.
+	*/
+	const test = /^(?!\.\.\/)/;
+
+	module.exports.plugins.push(
+		new (require("brotli-webpack-plugin"))({
+			asset: "[path].br[query]",
+			test,
+		}),
+		new (require("compression-webpack-plugin"))({
+			asset:     "[path].gz[query]",
+			algorithm: "gzip",
+			test,
+		})
+	);
 }